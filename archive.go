@@ -0,0 +1,122 @@
+package taring
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// ArchiveReader reads the tar stream inside a compressed archive.
+type ArchiveReader struct {
+	*tar.Reader
+	file io.Closer
+	dr   io.ReadCloser
+}
+
+// OpenArchiveAt opens the archive at path with its tar stream positioned at
+// offset, which must be where an entry's header starts. When compressed
+// isn't zero, it's where the compressed member starting with that entry
+// starts in the file, which is seeked to, as for entries written
+// WithSeekableEntries. Otherwise everything before offset is decompressed
+// and discarded, since compressed streams can't seek. An archive split in
+// volumes is read from the volume where compressed is, on to the last.
+func OpenArchiveAt(path string, offset, compressed int64) (*ArchiveReader, error) {
+	file, err := OpenVolumes(path, compressed)
+	if err != nil {
+		return nil, err
+	}
+	if compressed != 0 {
+		// the member starts with the entry
+		offset = 0
+	}
+	dr, err := decompress(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("decompressing %q, %v", path, err)
+	}
+	if _, err := io.CopyN(ioutil.Discard, dr, offset); err != nil {
+		_ = dr.Close()
+		_ = file.Close()
+		return nil, fmt.Errorf("seeking to offset %d of %q, %v", offset, path, err)
+	}
+	return &ArchiveReader{Reader: tar.NewReader(dr), file: file, dr: dr}, nil
+}
+
+// OpenVolumes opens the archive at path or, when there's none, the volumes
+// it was split in with WithSplitSize, joined back in order, positioned at
+// offset.
+func OpenVolumes(path string, offset int64) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err == nil {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("seeking to %d in %q, %v", offset, path, err)
+		}
+		return f, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if _, serr := os.Stat(VolumePath(path, 1)); serr != nil {
+		return nil, err
+	}
+	vr := &volumeReader{}
+	for n := 1; ; n++ {
+		name := VolumePath(path, n)
+		f, err := os.Open(name)
+		if os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			_ = vr.Close()
+			return nil, err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			_ = f.Close()
+			_ = vr.Close()
+			return nil, err
+		}
+		// the volumes before the one offset is in are skipped
+		if offset >= info.Size() && len(vr.files) == 0 {
+			offset -= info.Size()
+			_ = f.Close()
+			continue
+		}
+		if len(vr.files) == 0 {
+			if _, err := f.Seek(offset, io.SeekStart); err != nil {
+				_ = f.Close()
+				return nil, fmt.Errorf("seeking to %d in %q, %v", offset, name, err)
+			}
+		}
+		vr.files = append(vr.files, f)
+	}
+	readers := make([]io.Reader, len(vr.files))
+	for i, f := range vr.files {
+		readers[i] = f
+	}
+	vr.Reader = io.MultiReader(readers...)
+	return vr, nil
+}
+
+// volumeReader reads the volumes of an archive one after the other.
+type volumeReader struct {
+	io.Reader
+	files []*os.File
+}
+
+func (v *volumeReader) Close() error {
+	for _, f := range v.files {
+		_ = f.Close()
+	}
+	return nil
+}
+
+func (a *ArchiveReader) Close() error {
+	drErr := a.dr.Close()
+	if err := a.file.Close(); err != nil {
+		return err
+	}
+	return drErr
+}
@@ -0,0 +1,586 @@
+package taring
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/crowdmob/goamz/aws"
+	"github.com/crowdmob/goamz/s3"
+	"github.com/dustin/go-humanize"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Archiver archives what's under an S3 path into a tar/gzip file, along
+// with a manifest of what it holds. It's made with New.
+type Archiver struct {
+	auth        aws.Auth
+	region      aws.Region
+	source      string
+	store       Store
+	destination string
+	job         string
+
+	keepLast        int
+	keepDays        int
+	tagArchived     Tag
+	skipTagged      bool
+	catalog         string
+	excludeManifest string
+	skipUnchanged   bool
+	dryRun          bool
+	splitSize       int64
+	filters         []func(ObjectInfo) bool
+	include         []Glob
+	exclude         []Glob
+	concurrency     int
+	container       func(w io.Writer) ArchiveWriter
+	compressor      Compressor
+	entryRatios     bool
+	seekable        bool
+	manifestCSV     bool
+	checkETags      bool
+	topLargest      int
+	slowBelow       float64
+	slowRestarts    int
+	retryAttempts   int
+	retryBackoff    time.Duration
+	transport       []func(http.RoundTripper) http.RoundTripper
+	credentials     func(ctx context.Context) (aws.Auth, error)
+	azureAccount    string
+	azureKey        string
+
+	beforeListing func(ctx context.Context, archive string) error
+	progress      *JobProgress
+	hooks         hooks
+	log           Logger
+
+	stateMu sync.Mutex
+	state   jobState
+	// snapshotted is whether the run was snapshotted, and so may be resumed
+	snapshotted bool
+}
+
+// Run archives the source. The summary it returns is never nil, even when
+// the run fails.
+func (a *Archiver) Run(ctx context.Context) (*RunSummary, error) {
+	return a.summarize(func(sum *RunSummary) error { return a.archive(ctx, sum) })
+}
+
+// RunTo archives the source into w rather than the destination, keeping
+// nothing about the archive the way Stream does, and summarizes the run
+// the way Run does.
+func (a *Archiver) RunTo(ctx context.Context, w io.Writer) (*RunSummary, error) {
+	return a.summarize(func(sum *RunSummary) error {
+		r, err := a.newRun(ctx, sum, "")
+		if err != nil {
+			return err
+		}
+		return r.writeTo(ctx, w)
+	})
+}
+
+// summarize makes a run with fn, then summarizes it.
+func (a *Archiver) summarize(fn func(sum *RunSummary) error) (*RunSummary, error) {
+	sum := &RunSummary{
+		Job:     a.job,
+		Source:  a.source,
+		Started: time.Now(),
+	}
+	a.progress.setHooks(a.hooks)
+	err := fn(sum)
+	if err == nil {
+		a.resetState()
+	}
+	a.progress.Summarize(sum)
+	for _, fc := range sum.Failures {
+		example := fc.Examples[0]
+		a.log.Errorf("%d objects failed as %s, e.g. %q: %s", fc.Count, fc.Class, example.Key, example.Error)
+	}
+	sum.Finish(err)
+	return sum, err
+}
+
+// Stats returns the counters of the run under way, or of the last one.
+// It's safe to call from any goroutine.
+func (a *Archiver) Stats() Stats { return a.progress.Stats() }
+
+// Stream archives the source into the returned reader rather than a file,
+// as it's read. A failed run fails the read. Nothing is kept about the
+// archive, so the destination and what depends on it are ignored: no
+// manifest, catalog, retention or check for changes. Objects are tagged
+// once all of the archive is written.
+func (a *Archiver) Stream(ctx context.Context) (io.ReadCloser, error) {
+	r, err := a.newRun(ctx, &RunSummary{Job: a.job, Source: a.source, Started: time.Now()}, "")
+	if err != nil {
+		return nil, err
+	}
+	a.progress.setHooks(a.hooks)
+	pr, pw := io.Pipe()
+	go func() {
+		err := r.writeTo(ctx, pw)
+		if err == nil {
+			a.resetState()
+		}
+		_ = pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+// Archive archives what's under prefix in store into dst, the way Stream
+// does, with the options given.
+func Archive(ctx context.Context, store Store, prefix string, dst io.Writer, opts ...Option) error {
+	src := (&url.URL{Scheme: "store", Path: "/" + strings.TrimPrefix(prefix, "/")}).String()
+	rc, err := New(src, "", append([]Option{WithStore(store)}, opts...)...).Stream(ctx)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	_, err = io.Copy(dst, rc)
+	return err
+}
+
+// run is a run of an archiver, from one source to one archive.
+type run struct {
+	*Archiver
+	sum     *RunSummary
+	tarDst  string
+	bktURL  *url.URL
+	bktName string
+	bktPath string
+	creds   func(ctx context.Context) (aws.Auth, error)
+	store   Store
+	tagger  *objectTagger
+}
+
+// newRun prepares a run to the archive at tarDst, which is empty when
+// there's none.
+func (a *Archiver) newRun(ctx context.Context, sum *RunSummary, tarDst string) (*run, error) {
+	if a.skipTagged && a.tagArchived.Key == "" {
+		return nil, errors.New("need a tag to skip")
+	}
+	bktURL, err := url.Parse(a.source)
+	if err != nil {
+		return nil, fmt.Errorf("not a URL, %v", err)
+	}
+	creds := staticAuth(a.auth)
+	if a.credentials != nil {
+		creds = renewingAuth(a.credentials)
+	}
+	if _, err := creds(ctx); err != nil {
+		return nil, err
+	}
+	r := &run{
+		Archiver: a,
+		sum:      sum,
+		tarDst:   tarDst,
+		bktURL:   bktURL,
+		bktName:  bktURL.Host,
+		bktPath:  strings.TrimPrefix(bktURL.Path, "/"),
+		creds:    creds,
+		store:    a.store,
+		tagger:   newObjectTagger(&s3Client{auth: creds, region: a.region, bucket: bktURL.Host, client: a.httpClient()}),
+	}
+	if r.store == nil {
+		if r.store, err = a.sourceStore(bktURL, creds); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// sourceStore is the store of the source's bucket, by the scheme of its
+// URL. Creds gives the credentials of S3.
+func (a *Archiver) sourceStore(u *url.URL, creds func(ctx context.Context) (aws.Auth, error)) (Store, error) {
+	if u.Scheme != "s3" && (a.skipTagged || a.tagArchived.Key != "") {
+		return nil, fmt.Errorf("need S3 to tag objects, can't tag those of %s://", u.Scheme)
+	}
+	switch u.Scheme {
+	case "gs":
+		return NewGCSStore(a.httpClient(), u.Host), nil
+	case "az", "wasb", "wasbs":
+		// wasb://container@account.blob.core.windows.net/path names
+		// the account, az://container/path leaves it to the credentials
+		container, account := u.Host, a.azureAccount
+		if u.User != nil {
+			container, account = u.User.Username(), strings.SplitN(u.Host, ".", 2)[0]
+		}
+		if account == "" {
+			return nil, errors.New("need an Azure storage account")
+		}
+		return NewAzureStore(a.httpClient(), account, a.azureKey, container)
+	}
+	return newS3Store(creds, a.region, u.Host, a.httpClient()), nil
+}
+
+// bucket is the bucket of S3 called name, with the credentials of the run
+// as they are now: those of goamz are fixed once it's made.
+func (r *run) bucket(ctx context.Context, name string) (*s3.Bucket, error) {
+	auth, err := r.creds(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s3.New(auth, r.region).Bucket(name), nil
+}
+
+// partialSuffix marks an archive that's still being written.
+const partialSuffix = ".partial"
+
+func (a *Archiver) archive(ctx context.Context, sum *RunSummary) error {
+	log, progress := a.log, a.progress
+	if a.keepLast > 0 || a.keepDays > 0 {
+		if a.splitSize > 0 {
+			return errors.New("an archive split in volumes can't be pruned")
+		}
+		if err := checkRetention(a.destination, a.job); err != nil {
+			return err
+		}
+	}
+	if _, ok := a.compressor.(Gzip); a.seekable && !ok {
+		return errors.New("need gzip to compress entries on their own")
+	}
+
+	resumed := a.resuming()
+	tarDst := a.resumeArchive(ExpandArchivePath(a.destination, a.job, sum.Started), sum.Started)
+	progress.update(func(s *ProgressSnapshot) { s.Archive = tarDst })
+	sum.Archive = tarDst
+
+	r, err := a.newRun(ctx, sum, tarDst)
+	if err != nil {
+		return err
+	}
+	keys, listingFingerprint, err := r.listKeys(ctx)
+	if err != nil || sum.Status == StatusUnchanged {
+		return err
+	}
+	if a.dryRun {
+		r.listed(keys)
+		return nil
+	}
+
+	// what a resumed run wrote is set aside, to carry over to the new
+	// archive what made it to disk
+	var previous string
+	resumable := r.splitSize <= 0 && !strings.HasPrefix(tarDst, "s3://")
+	if resumed && resumable {
+		previous = tarDst + partialSuffix + resumedSuffix
+		if err := os.Rename(tarDst+partialSuffix, previous); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("setting aside the partial archive, %v", err)
+		}
+	}
+
+	w, commit, abort, err := r.openArchive(ctx, tarDst)
+	if err != nil {
+		return fmt.Errorf("creating archive, %v", err)
+	}
+	out := &countWriter{w: w}
+	contents, stream, err := r.write(ctx, out, keys, previous)
+	if err == nil {
+		err = commit()
+	}
+	if err != nil {
+		// a failed run that may be resumed leaves what it wrote for the
+		// resumed run to carry over
+		if f, ok := w.(*os.File); ok && resumable && a.wasSnapshotted() {
+			_ = f.Close()
+		} else {
+			abort()
+		}
+		return err
+	}
+	if previous != "" {
+		_ = os.Remove(previous)
+	}
+	sum.ArchiveBytes = out.n
+	if vw, ok := w.(*volumeWriter); ok {
+		sum.Volumes = vw.names
+		log.Infof("saved compressed tar of %q to %d volumes of %q", r.bktURL.String(), len(vw.names), tarDst)
+	} else {
+		log.Infof("saved compressed tar of %q to %q", r.bktURL.String(), tarDst)
+	}
+
+	manifest := newManifest(r.bktURL.String(), tarDst, listingFingerprint, contents)
+	for i, size := range stream.sizes {
+		manifest.Objects[i].CompressedSize = size
+	}
+	if err := r.saveManifest(ctx, ManifestPath(tarDst), manifest); err != nil {
+		return fmt.Errorf("writing manifest, %v", err)
+	}
+	if a.manifestCSV {
+		if err := r.saveManifestCSV(ctx, ManifestCSVPath(tarDst), manifest); err != nil {
+			return fmt.Errorf("writing CSV manifest, %v", err)
+		}
+	}
+
+	progress.SetPhase("finishing")
+	if a.catalog != "" {
+		log.Infof("recording %d objects in catalog %q", len(contents), a.catalog)
+		volumes := 1
+		if len(sum.Volumes) > 0 {
+			volumes = len(sum.Volumes)
+		}
+		if err := catalogArchive(a.catalog, r.bktURL, tarDst, volumes, r.splitSize, contents, stream); err != nil {
+			return fmt.Errorf("recording archive in catalog, %v", err)
+		}
+	}
+
+	if a.keepLast > 0 || a.keepDays > 0 {
+		pruned, err := r.prune(ctx)
+		for _, archive := range pruned {
+			log.Infof("pruned old archive %q", archive)
+		}
+		if err != nil {
+			return fmt.Errorf("pruning old archives, %v", err)
+		}
+	}
+
+	if err := r.tag(ctx, contents); err != nil {
+		return err
+	}
+	progress.SetPhase("done")
+	return nil
+}
+
+// openArchive starts the archive at tarDst, a local path or an `s3://` URL
+// uploaded to as it's written, split in volumes when asked to. The archive
+// is only where it goes once it's committed, and nothing of it is left once
+// it's aborted.
+func (r *run) openArchive(ctx context.Context, tarDst string) (w io.Writer, commit func() error, abort func(), err error) {
+	if r.splitSize <= 0 {
+		return r.openVolume(ctx, tarDst)
+	}
+	vw := &volumeWriter{
+		archive: tarDst,
+		size:    r.splitSize,
+		open: func(name string) (io.Writer, func() error, func(), error) {
+			return r.openVolume(ctx, name)
+		},
+	}
+	return vw, vw.commit, vw.abort, nil
+}
+
+// openVolume starts a single file of the archive at tarDst.
+func (r *run) openVolume(ctx context.Context, tarDst string) (w io.Writer, commit func() error, abort func(), err error) {
+	if strings.HasPrefix(tarDst, "s3://") {
+		u, err := url.Parse(tarDst)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		bucket := func(ctx context.Context) (*s3.Bucket, error) { return r.bucket(ctx, u.Host) }
+		mw, err := r.newMultipartWriter(ctx, bucket, strings.TrimPrefix(u.Path, "/"))
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("uploading to %q, %s", tarDst, DescribeS3Error(err))
+		}
+		commit = func() error {
+			if err := mw.Close(); err != nil {
+				return fmt.Errorf("uploading archive to %q, %s", tarDst, DescribeS3Error(err))
+			}
+			return nil
+		}
+		return mw, commit, func() { _ = mw.Abort() }, nil
+	}
+
+	partial := tarDst + partialSuffix
+	f, err := os.OpenFile(partial, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, filePerms)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	commit = func() error {
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("writing archive to %q, %v", partial, err)
+		}
+		return os.Rename(partial, tarDst)
+	}
+	abort = func() {
+		_ = f.Close()
+		_ = os.Remove(partial)
+	}
+	return f, commit, abort, nil
+}
+
+// writeTo archives into w, keeping nothing about the archive.
+func (r *run) writeTo(ctx context.Context, w io.Writer) error {
+	keys, _, err := r.listKeys(ctx)
+	if err != nil {
+		return err
+	}
+	if r.dryRun {
+		r.listed(keys)
+		return nil
+	}
+	out := &countWriter{w: w}
+	contents, _, err := r.write(ctx, out, keys, "")
+	if err != nil {
+		return err
+	}
+	r.sum.ArchiveBytes = out.n
+	if err := r.tag(ctx, contents); err != nil {
+		return err
+	}
+	r.progress.SetPhase("done")
+	return nil
+}
+
+// listed summarizes a dry run with the keys it would have archived.
+func (r *run) listed(keys []ObjectInfo) {
+	sum := r.sum
+	sum.Objects, sum.Bytes = len(keys), totalSize(keys)
+	sum.Prefixes = listedPrefixes(r.bktPath, keys)
+	sum.Status = StatusDryRun
+	r.log.Infof("dry run, would archive %d keys (%s)", sum.Objects, humanize.Bytes(uint64(sum.Bytes)))
+	r.progress.SetPhase("done")
+}
+
+// listKeys lists what's to be archived, by key, along with the fingerprint
+// of the listing. When nothing changed since the previous archive, the run
+// is marked unchanged and there's nothing to archive.
+func (r *run) listKeys(ctx context.Context) ([]ObjectInfo, string, error) {
+	log, progress, sum := r.log, r.progress, r.sum
+
+	if r.beforeListing != nil && !r.dryRun {
+		if err := r.beforeListing(ctx, r.tarDst); err != nil {
+			return nil, "", err
+		}
+	}
+
+	keys, resumed := r.resumedKeys()
+	if resumed {
+		log.Infof("resuming with the %d keys listed before", len(keys))
+		progress.update(func(s *ProgressSnapshot) { s.KeysListed = len(keys) })
+	} else {
+		progress.SetPhase("listing")
+		log.Infof("Listing bucket %q.", r.bktName)
+
+		var err error
+		keys, err = r.list(ctx, r.store, r.bktPath)
+		if err != nil {
+			return nil, "", fmt.Errorf("couldn't list %q: %v", r.bktPath, err)
+		}
+		log.Infof("listed %d keys (%s)", len(keys), humanize.Bytes(uint64(totalSize(keys))))
+		if len(r.filters)+len(r.include)+len(r.exclude) > 0 {
+			before := len(keys)
+			keys = r.filter(r.bktPath, keys)
+			log.Infof("filtered out %d keys", before-len(keys))
+		}
+		r.saveKeys(keys)
+	}
+	listingFingerprint := fingerprint(keys)
+
+	if r.skipUnchanged && r.tarDst != "" {
+		prev, err := previousManifest(r.destination, r.job, r.tarDst)
+		switch {
+		case err != nil:
+			return nil, "", fmt.Errorf("reading previous manifest, %v", err)
+		case prev == nil:
+			log.Infof("no previous manifest, archiving")
+		case prev.Fingerprint == listingFingerprint:
+			log.Infof("no changes since %q, nothing to archive", prev.Archive)
+			progress.SetPhase("unchanged")
+			sum.Status = StatusUnchanged
+			return nil, listingFingerprint, nil
+		default:
+			log.Infof("changes since %q, archiving", prev.Archive)
+		}
+	}
+
+	if r.excludeManifest != "" {
+		auth, err := r.creds(ctx)
+		if err != nil {
+			return nil, "", fmt.Errorf("loading manifest to exclude, %v", err)
+		}
+		prev, err := LoadManifest(ctx, r.excludeManifest, s3.New(auth, r.region))
+		if err != nil {
+			return nil, "", fmt.Errorf("loading manifest to exclude, %v", err)
+		}
+		before := len(keys)
+		keys = excludeArchived(keys, prev)
+		log.Infof("excluding %d keys already in %q", before-len(keys), r.excludeManifest)
+	}
+
+	if r.topLargest > 0 {
+		sum.Largest = largestKeys(keys, r.topLargest)
+		log.Infof("%d largest objects:", len(sum.Largest))
+		for _, key := range sum.Largest {
+			log.Infof("\t%10s  %s", humanize.Bytes(uint64(key.Size)), key.Key)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+
+	// by key rather than in the order they're fetched, so that the same
+	// objects make the same archive
+	keys = append([]ObjectInfo(nil), keys...)
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Key < keys[j].Key })
+	return keys, listingFingerprint, nil
+}
+
+// write fetches the keys and archives them into w as they come, returning
+// what was archived and the stream it went through, which knows where
+// every entry went. What the partial archive at previous holds whole, if
+// there's one, is carried over rather than fetched.
+func (r *run) write(ctx context.Context, w io.Writer, keys []ObjectInfo, previous string) ([]S3Content, *archiveStream, error) {
+	var skip func(key string) (bool, error)
+	if r.skipTagged {
+		skip = func(key string) (bool, error) { return r.tagger.HasTag(ctx, key, r.tagArchived) }
+	}
+
+	stream, err := newArchiveStream(w, r.container, r.compressor, r.entryRatios, r.seekable, r.progress)
+	if err != nil {
+		return nil, nil, err
+	}
+	var carried []S3Content
+	if previous != "" {
+		if carried, err = r.carryOver(ctx, stream, previous, keys); err != nil {
+			return nil, nil, fmt.Errorf("carrying over %q, %v", previous, err)
+		}
+		keys = keys[len(carried):]
+	}
+
+	r.progress.update(func(s *ProgressSnapshot) {
+		s.Phase = "fetching"
+		s.KeysTotal = len(keys)
+		s.BytesTotal = totalSize(keys)
+	})
+	r.log.Infof("fetching and archiving %d keys, %d at a time", len(keys), r.window())
+	contents, err := r.fetchAll(ctx, r.store, r.bktPath, keys, skip, func(object *S3Content) error {
+		if err := stream.add(ctx, object); err != nil {
+			return err
+		}
+		r.saveWritten(len(stream.offsets))
+		return nil
+	})
+	if err != nil {
+		stream.abandon()
+		return nil, nil, fmt.Errorf("couldn't archive %q: %w", r.bktPath, err)
+	}
+	contents = append(carried, contents...)
+	if err := stream.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	r.sum.Objects = len(contents)
+	for _, object := range contents {
+		r.sum.Bytes += object.Size
+	}
+	return contents, stream, nil
+}
+
+// tag tags the archived objects, if asked to.
+func (r *run) tag(ctx context.Context, contents []S3Content) error {
+	if r.tagArchived.Key == "" {
+		return nil
+	}
+	r.log.Infof("tagging %d objects with %q", len(contents), r.tagArchived)
+	if err := r.tagAll(ctx, r.tagger, r.tagArchived, contents); err != nil {
+		return fmt.Errorf("tagging archived objects, %w", err)
+	}
+	return nil
+}
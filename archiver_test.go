@@ -0,0 +1,1002 @@
+package taring_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/aybabtme/taring"
+	"github.com/aybabtme/taring/taringtest"
+	"github.com/crowdmob/goamz/aws"
+	"github.com/crowdmob/goamz/s3"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+// fixture is what the tests archive, from s3://bucket/data/.
+var fixture = map[string]string{
+	"data/readme.txt":           "hello",
+	"data/logs/2016/01/app.log": "started\nstopped\n",
+	"data/logs/2016/02/app.log": "started\n",
+	"data/logs/index":           "2016/01\n2016/02\n",
+	"data/empty":                "",
+	"elsewhere/ignored":         "not archived",
+}
+
+func newStore() *taringtest.Store {
+	// small pages, so listing takes several
+	store := &taringtest.Store{PageSize: 2}
+	lastMod := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+	for key, data := range fixture {
+		store.Put(key, []byte(data), lastMod)
+	}
+	return store
+}
+
+func TestRunGolden(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dst := filepath.Join(dir, "archive.tar.gz")
+	store := newStore()
+	sum, err := taring.New("s3://bucket/data/", dst,
+		taring.WithStore(store),
+		taring.WithConcurrency(2),
+	).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Status != taring.StatusSucceeded {
+		t.Fatalf("want status %q, got %q", taring.StatusSucceeded, sum.Status)
+	}
+	if want := len(fixture) - 1; sum.Objects != want {
+		t.Errorf("want %d objects, got %d", want, sum.Objects)
+	}
+
+	m, err := taring.ReadManifest(taring.ManifestPath(dst))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Archive = filepath.Base(m.Archive)
+	m.Created = time.Time{}
+	got, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "manifest.json", got)
+
+	f, err := os.Open(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	checkArchive(t, f, m)
+}
+
+func TestStream(t *testing.T) {
+	rc, err := taring.New("s3://bucket/data/", "",
+		taring.WithStore(newStore()),
+	).Stream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	want, err := taring.ReadManifest(filepath.Join("testdata", "golden", "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkArchive(t, rc, want)
+}
+
+func TestArchive(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	if err := taring.Archive(context.Background(), newStore(), "data/", buf); err != nil {
+		t.Fatal(err)
+	}
+	want, err := taring.ReadManifest(filepath.Join("testdata", "golden", "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkArchive(t, buf, want)
+}
+
+func TestArchiveZip(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	err := taring.Archive(context.Background(), newStore(), "data/", buf,
+		taring.WithContainer(taring.NewZipWriter),
+		taring.WithCompressor(taring.Uncompressed{}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := len(fixture) - 1; len(zr.File) != want {
+		t.Errorf("want %d files, got %d", want, len(zr.File))
+	}
+	lastMod := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, f := range zr.File {
+		key := "data/" + f.Name
+		if f.Method != zip.Deflate {
+			t.Errorf("%q: want deflated, got method %d", f.Name, f.Method)
+		}
+		if !f.Modified.Equal(lastMod) {
+			t.Errorf("%q: want modified at %v, got %v", f.Name, lastMod, f.Modified)
+		}
+		if !strings.Contains(f.Comment, taring.PAXKey+"="+key) {
+			t.Errorf("%q: want the key in the comment, got %q", f.Name, f.Comment)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != fixture[key] {
+			t.Errorf("%q: want content %q, got %q", f.Name, fixture[key], data)
+		}
+	}
+}
+
+func TestUntar(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	if err := taring.Archive(context.Background(), newStore(), "data/", buf); err != nil {
+		t.Fatal(err)
+	}
+	dst := &taringtest.Store{}
+	written, err := taring.Untar(context.Background(), buf, dst, "restored/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := len(fixture) - 1; len(written) != want {
+		t.Errorf("want %d objects, got %d", want, len(written))
+	}
+	lastMod := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, obj := range written {
+		rc, err := dst.Open(context.Background(), obj.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := "data/" + strings.TrimPrefix(obj.Key, "restored/")
+		if string(data) != fixture[key] {
+			t.Errorf("%q: want content %q, got %q", obj.Key, fixture[key], data)
+		}
+		if got, want := dst.Meta(obj.Key)[taring.MetaModTime], strconv.FormatInt(lastMod.Unix(), 10); got != want {
+			t.Errorf("%q: want mtime %q, got %q", obj.Key, want, got)
+		}
+	}
+}
+
+func TestObjectMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := newStore()
+	meta := taring.ObjectMeta{
+		ContentType:     "text/csv",
+		ContentEncoding: "identity",
+		User:            map[string]string{"owner": "ops", "retention": "7y"},
+	}
+	if err := store.Upload(context.Background(), "data/report.csv", strings.NewReader("a,b\n"), 4, meta); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(dir, "archive.tar.gz")
+	if _, err := taring.New("s3://bucket/data/", archive, taring.WithStore(store)).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	archived, err := ioutil.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		taring.PAXContentType:     meta.ContentType,
+		taring.PAXContentEncoding: meta.ContentEncoding,
+	}
+	for name, value := range meta.User {
+		want[taring.PAXUserMeta+name] = value
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(archived))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		for record, value := range want {
+			got, ok := hdr.PAXRecords[record]
+			if hdr.Name == "report.csv" && got != value {
+				t.Errorf("%q: want %s=%q, got %q", hdr.Name, record, value, got)
+			} else if hdr.Name != "report.csv" && ok {
+				t.Errorf("%q: want no %s, got %q", hdr.Name, record, got)
+			}
+		}
+	}
+
+	m, err := taring.ReadManifest(taring.ManifestPath(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range m.Objects {
+		if obj.Key == "data/report.csv" && (obj.ContentType != meta.ContentType || obj.ContentEncoding != meta.ContentEncoding) {
+			t.Errorf("want the content type and encoding in the manifest, got %+v", obj)
+		}
+	}
+
+	dst := &taringtest.Store{}
+	if _, err := taring.Untar(context.Background(), bytes.NewReader(archived), dst, "restored/"); err != nil {
+		t.Fatal(err)
+	}
+	rc, err := dst.Open(context.Background(), "restored/report.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	restored := rc.(taring.MetaReader).Meta()
+	if restored.ContentType != meta.ContentType || restored.ContentEncoding != meta.ContentEncoding {
+		t.Errorf("want %+v restored, got %+v", meta, restored)
+	}
+	for name, value := range meta.User {
+		if got := restored.User[name]; got != value {
+			t.Errorf("want %s=%q restored, got %q", name, value, got)
+		}
+	}
+}
+
+func TestRunCountsRetries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := newStore()
+	store.FailOpen("data/readme.txt", &s3.Error{StatusCode: 503, Code: "SlowDown"}, &s3.Error{StatusCode: 500, Code: "InternalError"})
+	progress := new(taring.JobProgress)
+	sum, err := taring.New("s3://bucket/data/", filepath.Join(dir, "archive.tar.gz"),
+		taring.WithStore(store),
+		taring.WithProgress(progress),
+		taring.WithRetries(3, time.Millisecond),
+	).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Retries != 2 || sum.SlowRestarts != 0 {
+		t.Errorf("want 2 retries and no slow restarts in the summary, got %d and %d", sum.Retries, sum.SlowRestarts)
+	}
+	if snap := progress.Snapshot(); snap.Retries != 2 || snap.SlowRestarts != 0 {
+		t.Errorf("want 2 retries and no slow restarts in the progress, got %d and %d", snap.Retries, snap.SlowRestarts)
+	}
+	data, err := json.Marshal(sum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"retries":2,"slow_restarts":0`) {
+		t.Errorf("want the counters in the summary file, got %s", data)
+	}
+}
+
+func TestDescribeS3Error(t *testing.T) {
+	s3err := &s3.Error{StatusCode: 403, Code: "AccessDenied", Message: "Access Denied", RequestId: "REQ", HostId: "HOST"}
+	for _, tt := range []struct {
+		err  error
+		want string
+	}{
+		{s3err, "AccessDenied: Access Denied (request id REQ, host id HOST)"},
+		{fmt.Errorf("listing, %w", s3err), "listing, Access Denied (request id REQ, host id HOST)"},
+		{&taring.KeyError{Key: "a", Op: taring.OpFetch, Err: s3err}, `failed to fetch "a": AccessDenied: Access Denied (request id REQ, host id HOST)`},
+		{errors.New("boom"), "boom"},
+	} {
+		if got := taring.DescribeS3Error(tt.err); got != tt.want {
+			t.Errorf("%#v: want %q, got %q", tt.err, tt.want, got)
+		}
+	}
+}
+
+func TestRunWrappedFailures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i, tt := range []struct {
+		err       error
+		class     string
+		requestID string
+	}{
+		{fmt.Errorf("opening, %w", &s3.Error{StatusCode: 403, Code: "AccessDenied", RequestId: "REQ"}), "forbidden", "REQ"},
+		{&taring.KeyError{Key: "data/readme.txt", Op: taring.OpFetch, Err: &s3.Error{StatusCode: 404, Code: "NoSuchKey"}}, "not_found", ""},
+		{fmt.Errorf("reading, %w", io.ErrUnexpectedEOF), "network", ""},
+		{fmt.Errorf("waiting, %w", context.DeadlineExceeded), "timeout", ""},
+		{fmt.Errorf("checking, %w", &taring.ChecksumError{Key: "data/readme.txt"}), "checksum", ""},
+	} {
+		store := newStore()
+		store.FailOpen("data/readme.txt", tt.err)
+		sum, _ := taring.New("s3://bucket/data/", filepath.Join(dir, strconv.Itoa(i)+".tar.gz"),
+			taring.WithStore(store),
+			taring.WithRetries(1, time.Millisecond),
+		).Run(context.Background())
+		if len(sum.Failures) != 1 || sum.Failures[0].Class != tt.class {
+			t.Errorf("%v: want one %s failure, got %+v", tt.err, tt.class, sum.Failures)
+			continue
+		}
+		if got := sum.Failures[0].Examples[0].RequestID; got != tt.requestID {
+			t.Errorf("%v: want request id %q, got %q", tt.err, tt.requestID, got)
+		}
+	}
+}
+
+func TestRunResumed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	archive := filepath.Join(dir, "archive.tar.gz")
+
+	// the first run fails on the last key, once it wrote the others
+	store := newStore()
+	store.FailOpen("data/readme.txt", &s3.Error{StatusCode: 404, Code: "NoSuchKey"})
+	var first *taring.Archiver
+	first = taring.New("s3://bucket/data/", archive,
+		taring.WithStore(store),
+		taring.WithConcurrency(1),
+		taring.WithOnObjectDone(func(taring.ActiveFetch) { _, _ = first.Snapshot() }),
+	)
+	if _, err := first.Run(context.Background()); err == nil {
+		t.Fatal("want the first run to fail")
+	}
+	snapshot, err := first.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(archive + ".partial"); err != nil {
+		t.Fatalf("want the partial archive kept, got %v", err)
+	}
+
+	second := taring.New("s3://bucket/data/", archive, taring.WithStore(store))
+	if err := second.ResumeFrom(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for key := range fixture {
+		want := 1
+		switch key {
+		case "data/readme.txt":
+			want = 2
+		case "elsewhere/ignored":
+			want = 0
+		}
+		if got := store.Opens(key); got != want {
+			t.Errorf("%q: want %d opens, got %d", key, want, got)
+		}
+	}
+	if names, _ := filepath.Glob(archive + ".partial*"); len(names) != 0 {
+		t.Errorf("want nothing left of the partial archive, got %v", names)
+	}
+	m, err := taring.ReadManifest(taring.ManifestPath(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	checkArchive(t, f, m)
+}
+
+func TestRunNotFound(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := newStore()
+	store.FailOpen("data/readme.txt", &s3.Error{StatusCode: 404, Code: "NoSuchKey"})
+	sum, err := taring.New("s3://bucket/data/", filepath.Join(dir, "archive.tar.gz"),
+		taring.WithStore(store),
+	).Run(context.Background())
+	if err == nil {
+		t.Fatal("want the run to fail")
+	}
+	var keyErr *taring.KeyError
+	if !errors.As(err, &keyErr) || keyErr.Key != "data/readme.txt" || keyErr.Op != taring.OpFetch {
+		t.Errorf("want a failed fetch of %q, got %v", "data/readme.txt", err)
+	}
+	if sum.Status != taring.StatusFailed {
+		t.Errorf("want status %q, got %q", taring.StatusFailed, sum.Status)
+	}
+	if len(sum.Failures) != 1 || sum.Failures[0].Class != "not_found" {
+		t.Fatalf("want one not_found failure, got %+v", sum.Failures)
+	}
+	if key := sum.Failures[0].Examples[0].Key; key != "data/readme.txt" {
+		t.Errorf("want the failure of %q, got %q", "data/readme.txt", key)
+	}
+	// nothing is left of the archive it was writing
+	if names, _ := filepath.Glob(filepath.Join(dir, "*")); len(names) != 0 {
+		t.Errorf("want no archive, got %v", names)
+	}
+}
+
+func TestRunRetries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := newStore()
+	internal := &s3.Error{StatusCode: 500, Code: "InternalError"}
+	store.FailOpen("data/readme.txt", internal, internal)
+	sum, err := taring.New("s3://bucket/data/", filepath.Join(dir, "archive.tar.gz"),
+		taring.WithStore(store),
+		taring.WithRetries(3, time.Millisecond),
+	).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := store.Opens("data/readme.txt"); got != 3 {
+		t.Errorf("want 3 attempts, got %d", got)
+	}
+	if len(sum.Failures) != 0 {
+		t.Errorf("want no failures, got %+v", sum.Failures)
+	}
+}
+
+// corruptingStore gives corrupted content of a key as many times as asked.
+type corruptingStore struct {
+	*taringtest.Store
+	key   string
+	times int
+}
+
+func (s *corruptingStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	if key == s.key && s.times > 0 {
+		s.times--
+		return ioutil.NopCloser(strings.NewReader("corrupted")), nil
+	}
+	return s.Store.Open(ctx, key)
+}
+
+func TestRunETagChecks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := &corruptingStore{Store: newStore(), key: "data/readme.txt", times: 2}
+	archive := filepath.Join(dir, "archive.tar.gz")
+	sum, err := taring.New("s3://bucket/data/", archive,
+		taring.WithStore(store),
+		taring.WithRetries(3, time.Millisecond),
+		taring.WithETagChecks(),
+	).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.times != 0 || len(sum.Failures) != 0 {
+		t.Errorf("want corrupted fetches retried, got %+v", sum.Failures)
+	}
+	f, err := os.Open(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	m, err := taring.ReadManifest(taring.ManifestPath(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkArchive(t, f, m)
+
+	store = &corruptingStore{Store: newStore(), key: "data/readme.txt", times: 3}
+	sum, _ = taring.New("s3://bucket/data/", filepath.Join(dir, "corrupted.tar.gz"),
+		taring.WithStore(store),
+		taring.WithRetries(3, time.Millisecond),
+		taring.WithETagChecks(),
+	).Run(context.Background())
+	if len(sum.Failures) != 1 || sum.Failures[0].Class != "checksum" {
+		t.Errorf("want a checksum failure, got %+v", sum.Failures)
+	}
+}
+
+// flippingStore gives the content of a key with its first byte changed.
+type flippingStore struct {
+	*taringtest.Store
+	key string
+}
+
+func (s *flippingStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	rc, err := s.Store.Open(ctx, key)
+	if err != nil || key != s.key {
+		return rc, err
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	data[0]++
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func TestRunETagChecksEncrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// S3 says how these are encrypted, and their ETags aren't MD5s
+	store := newLargeStore()
+	store.Encrypt("data/readme.txt", "X-Amz-Server-Side-Encryption", "aws:kms")
+	store.Encrypt("data/logs/index", "X-Amz-Server-Side-Encryption-Customer-Algorithm", "AES256")
+	store.Encrypt("data/a-large", "X-Amz-Server-Side-Encryption", "aws:kms")
+	srv := httptest.NewServer(taringtest.S3Handler("bucket", store))
+	defer srv.Close()
+
+	sum, err := taring.New("s3://bucket/data/", filepath.Join(dir, "archive.tar.gz"),
+		taring.WithAuth(aws.Auth{AccessKey: "access", SecretKey: "secret"}, taring.CustomS3Endpoint(aws.USEast, srv.URL, true)),
+		taring.WithRetries(3, time.Millisecond),
+		taring.WithETagChecks(),
+	).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Retries != 0 || len(sum.Failures) != 0 {
+		t.Errorf("want encrypted objects archived without checks, got %d retries and %+v", sum.Retries, sum.Failures)
+	}
+}
+
+func TestRunStreamsLargeObjects(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// above what's read ahead, so it's streamed into the archive
+	large := bytes.Repeat([]byte("0123456789abcdef"), 9<<20/16)
+	store := newStore()
+	store.Put("data/large.bin", large, time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC))
+	archive := filepath.Join(dir, "archive.tar.gz")
+	sum, err := taring.New("s3://bucket/data/", archive,
+		taring.WithStore(store),
+		taring.WithETagChecks(),
+	).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Objects != 6 {
+		t.Errorf("want 6 objects archived, got %d", sum.Objects)
+	}
+	f, err := os.Open(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(zr)
+	var got []byte
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name == "large.bin" {
+			if got, err = ioutil.ReadAll(tr); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if !bytes.Equal(got, large) {
+		t.Errorf("want the large object archived whole, got %d bytes of %d", len(got), len(large))
+	}
+
+	sum, err = taring.New("s3://bucket/data/", filepath.Join(dir, "corrupted.tar.gz"),
+		taring.WithStore(&flippingStore{Store: store, key: "data/large.bin"}),
+		taring.WithETagChecks(),
+	).Run(context.Background())
+	if err == nil {
+		t.Error("want the run to fail for a corrupted large object")
+	}
+	if len(sum.Failures) != 1 || sum.Failures[0].Class != "checksum" {
+		t.Errorf("want a checksum failure, got %+v", sum.Failures)
+	}
+}
+
+// checkArchive reads a tar/gzip archive and checks it holds what the
+// manifest says, in order, with what the fixture has in it.
+func checkArchive(t *testing.T, r io.Reader, m *taring.Manifest) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(zr)
+	for i := 0; ; i++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			if i != len(m.Objects) {
+				t.Errorf("want %d entries, got %d", len(m.Objects), i)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i >= len(m.Objects) {
+			t.Fatalf("unexpected entry %q", hdr.Name)
+		}
+		obj := m.Objects[i]
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case hdr.Name != obj.Name:
+			t.Errorf("entry %d: want name %q, got %q", i, obj.Name, hdr.Name)
+		case hdr.PAXRecords[taring.PAXKey] != obj.Key:
+			t.Errorf("%q: want key %q, got %q", hdr.Name, obj.Key, hdr.PAXRecords[taring.PAXKey])
+		case hdr.PAXRecords[taring.PAXETag] != obj.ETag:
+			t.Errorf("%q: want etag %q, got %q", hdr.Name, obj.ETag, hdr.PAXRecords[taring.PAXETag])
+		case hdr.Size != obj.Size:
+			t.Errorf("%q: want size %d, got %d", hdr.Name, obj.Size, hdr.Size)
+		case !bytes.Equal(data, []byte(fixture[obj.Key])):
+			t.Errorf("%q: want content %q, got %q", hdr.Name, fixture[obj.Key], data)
+		}
+	}
+}
+
+// golden compares got to the golden file of that name, or rewrites it with
+// -update.
+func golden(t *testing.T, name string, got []byte) {
+	path := filepath.Join("testdata", "golden", name)
+	if *update {
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(want, got) {
+		t.Errorf("%s differs from golden file, run with -update if that's expected\nwant:\n%s\ngot:\n%s", name, want, got)
+	}
+}
+
+func TestRunDryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	archive := filepath.Join(dir, "bucket.tar.gz")
+	a := taring.New("s3://bucket/data/", archive, taring.WithStore(newStore()), taring.WithDryRun())
+	sum, err := a.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Status != taring.StatusDryRun {
+		t.Errorf("want status %q, got %q", taring.StatusDryRun, sum.Status)
+	}
+	if want := len(fixture) - 1; sum.Objects != want {
+		t.Errorf("want %d objects, got %d", want, sum.Objects)
+	}
+	if len(sum.Prefixes) != 2 || sum.Prefixes[0].Prefix != "logs/" || sum.Prefixes[0].Objects != 3 {
+		t.Errorf("want logs/ then the top level, got %+v", sum.Prefixes)
+	}
+	if _, err := os.Stat(archive); !os.IsNotExist(err) {
+		t.Errorf("want no archive written, got %v", err)
+	}
+}
+
+func TestRunTo(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	a := taring.New("s3://bucket/data/", "unused.tar.gz", taring.WithStore(newStore()))
+	sum, err := a.RunTo(context.Background(), buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Archive != "" {
+		t.Errorf("want no archive file, got %q", sum.Archive)
+	}
+	if sum.ArchiveBytes != int64(buf.Len()) {
+		t.Errorf("want %d archive bytes, got %d", buf.Len(), sum.ArchiveBytes)
+	}
+	if want := len(fixture) - 1; sum.Objects != want {
+		t.Errorf("want %d objects, got %d", want, sum.Objects)
+	}
+	if _, err := os.Stat("unused.tar.gz"); !os.IsNotExist(err) {
+		t.Errorf("want nothing written to the destination, got %v", err)
+	}
+	gzr, err := gzip.NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gzr)
+	for {
+		if _, err := tr.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRunSplitSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	archive := filepath.Join(dir, "bucket.tar.gz")
+	a := taring.New("s3://bucket/data/", archive, taring.WithStore(newStore()), taring.WithSplitSize(100))
+	sum, err := a.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sum.Volumes) < 2 {
+		t.Fatalf("want several volumes, got %q", sum.Volumes)
+	}
+	var joined []byte
+	for i, volume := range sum.Volumes {
+		if want := taring.VolumePath(archive, i+1); volume != want {
+			t.Errorf("want volume %q, got %q", want, volume)
+		}
+		data, err := ioutil.ReadFile(volume)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) > 100 || len(data) < 100 && i < len(sum.Volumes)-1 {
+			t.Errorf("want volume %q of 100 bytes, got %d", volume, len(data))
+		}
+		joined = append(joined, data...)
+	}
+	if int64(len(joined)) != sum.ArchiveBytes {
+		t.Errorf("want %d archive bytes, got %d", len(joined), sum.ArchiveBytes)
+	}
+	if _, err := os.Stat(archive); !os.IsNotExist(err) {
+		t.Errorf("want no unsplit archive, got %v", err)
+	}
+	for _, offset := range []int64{0, 50, 100} {
+		vr, err := taring.OpenVolumes(archive, offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(vr)
+		_ = vr.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, joined[offset:]) {
+			t.Errorf("want the volumes joined from %d, got %d bytes", offset, len(data))
+		}
+	}
+	gzr, err := gzip.NewReader(bytes.NewReader(joined))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gzr)
+	n := 0
+	for ; ; n++ {
+		if _, err := tr.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := len(fixture) - 1; n != want {
+		t.Errorf("want %d entries, got %d", want, n)
+	}
+}
+
+func TestRunSplitCatalog(t *testing.T) {
+	for _, seekable := range []bool{false, true} {
+		dir, err := ioutil.TempDir("", "taring")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		archive := filepath.Join(dir, "archive.tar.gz")
+		db := filepath.Join(dir, "taring.db")
+		opts := []taring.Option{taring.WithStore(newStore()), taring.WithCatalog(db), taring.WithSplitSize(100)}
+		if seekable {
+			opts = append(opts, taring.WithSeekableEntries())
+		}
+		sum, err := taring.New("s3://bucket/data/", archive, opts...).Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		catalog, err := taring.OpenCatalog(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var last taring.CatalogEntry
+		restore := func(entry taring.CatalogEntry) {
+			arch, err := taring.OpenArchiveAt(entry.Archive, entry.Offset, entry.Compressed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer arch.Close()
+			if hdr, err := arch.Next(); err != nil || hdr.Name != entry.Member {
+				t.Fatalf("want %q, got %v", entry.Member, err)
+			}
+			data, err := ioutil.ReadAll(arch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != fixture[entry.Key] {
+				t.Errorf("want %q restored from volume %d, got %q", entry.Key, entry.Volume, data)
+			}
+		}
+		for key := range fixture {
+			if !strings.HasPrefix(key, "data/") {
+				continue
+			}
+			entries, err := catalog.Lookup("s3://bucket/"+key, "")
+			if err != nil || len(entries) != 1 {
+				t.Fatalf("want %q in the catalog once, got %+v, %v", key, entries, err)
+			}
+			entry := entries[0]
+			if entry.Volume < 1 || entry.Volume > len(sum.Volumes) || !seekable && entry.Volume != 1 {
+				t.Errorf("want %q in one of the %d volumes, got %d", key, len(sum.Volumes), entry.Volume)
+			}
+			if entry.Volume > last.Volume {
+				last = entry
+			}
+			restore(entry)
+		}
+		_ = catalog.Close()
+		if !seekable {
+			continue
+		}
+		if last.Volume < 2 {
+			t.Fatalf("want an entry past the first volume, got %+v", last)
+		}
+		// only the volumes from the entry's on are read, so wiping those
+		// before changes nothing
+		for n := 1; n < last.Volume; n++ {
+			if err := ioutil.WriteFile(taring.VolumePath(archive, n), make([]byte, 100), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		restore(last)
+	}
+}
+
+func TestRunSeekableEntries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	archive := filepath.Join(dir, "archive.tar.gz")
+	db := filepath.Join(dir, "taring.db")
+	_, err = taring.New("s3://bucket/data/", archive,
+		taring.WithStore(newStore()),
+		taring.WithCatalog(db),
+		taring.WithSeekableEntries(),
+	).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog, err := taring.OpenCatalog(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := catalog.Lookup("s3://bucket/data/readme.txt", "")
+	_ = catalog.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Compressed == 0 {
+		t.Fatalf("want the last entry to start a gzip member of its own, got %+v", entries)
+	}
+	entry := entries[0]
+
+	idx, err := taring.IndexArchive(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ie, ok := idx.Lookup("readme.txt"); !ok || ie.Compressed != entry.Compressed || ie.Offset != entry.Offset {
+		t.Errorf("want the index to find the member the catalog knows, %d at %d, got %+v", entry.Compressed, entry.Offset, ie)
+	}
+
+	// a restore only reads from the entry's member on, so wiping all
+	// that's before it changes nothing
+	data, err := ioutil.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(0); i < entry.Compressed; i++ {
+		data[i] = 0
+	}
+	if err := ioutil.WriteFile(archive, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for name, open := range map[string]func() (io.Reader, func() error, error){
+		"catalog": func() (io.Reader, func() error, error) {
+			arch, err := taring.OpenArchiveAt(archive, entry.Offset, entry.Compressed)
+			if err != nil {
+				return nil, nil, err
+			}
+			hdr, err := arch.Next()
+			if err != nil {
+				return nil, nil, err
+			}
+			if hdr.Name != entry.Member {
+				t.Errorf("want %q at the member, got %q", entry.Member, hdr.Name)
+			}
+			return arch, arch.Close, nil
+		},
+		"index": func() (io.Reader, func() error, error) {
+			ie, _ := idx.Lookup("readme.txt")
+			r := idx.Open(ie)
+			return r, r.Close, nil
+		},
+	} {
+		r, closer, err := open()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, err := ioutil.ReadAll(r)
+		_ = closer()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if string(got) != fixture["data/readme.txt"] {
+			t.Errorf("%s: want %q, got %q", name, fixture["data/readme.txt"], got)
+		}
+	}
+}
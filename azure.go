@@ -0,0 +1,248 @@
+package taring
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// azureVersion is the version of the Blob Storage API asked for.
+	azureVersion = "2020-04-08"
+	// azureListMax is how many blobs are asked for per page.
+	azureListMax = 5000
+)
+
+// AzureStore is a container of Azure Blob Storage, read through its REST
+// API. Requests are signed with the account's shared key, if any.
+type AzureStore struct {
+	// Endpoint is the URL of the account's blob service, Azure's unless
+	// it's set, e.g. to an emulator.
+	Endpoint string
+
+	client    *http.Client
+	account   string
+	key       []byte
+	container string
+}
+
+// NewAzureStore uses a container of an Azure storage account as a store,
+// with the account's base64 key, or none for a public container. Requests
+// are made with client, or the default client when it's nil.
+func NewAzureStore(client *http.Client, account, key, container string) (*AzureStore, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	s := &AzureStore{
+		Endpoint:  "https://" + account + ".blob.core.windows.net",
+		client:    client,
+		account:   account,
+		container: container,
+	}
+	if key != "" {
+		var err error
+		if s.key, err = base64.StdEncoding.DecodeString(key); err != nil {
+			return nil, fmt.Errorf("decoding key of account %q, %v", account, err)
+		}
+	}
+	return s, nil
+}
+
+// AzureError is an error Azure answered with.
+type AzureError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *AzureError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("azure: %d %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("azure: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+type azureBlob struct {
+	Name       string `xml:"Name"`
+	Properties struct {
+		LastModified  string `xml:"Last-Modified"`
+		ETag          string `xml:"Etag"`
+		ContentLength int64  `xml:"Content-Length"`
+	} `xml:"Properties"`
+}
+
+// ListPage lists what's directly under prefix, after marker.
+func (s *AzureStore) ListPage(ctx context.Context, prefix, marker string) (ListPage, error) {
+	q := url.Values{
+		"restype":    {"container"},
+		"comp":       {"list"},
+		"prefix":     {prefix},
+		"delimiter":  {"/"},
+		"maxresults": {strconv.Itoa(azureListMax)},
+	}
+	if marker != "" {
+		q.Set("marker", marker)
+	}
+	resp, err := s.do(ctx, http.MethodGet, s.containerURL()+"?"+q.Encode())
+	if err != nil {
+		return ListPage{}, err
+	}
+	defer resp.Body.Close()
+	var list struct {
+		Blobs []azureBlob `xml:"Blobs>Blob"`
+		// prefixes are right under the listed one
+		Prefixes   []string `xml:"Blobs>BlobPrefix>Name"`
+		NextMarker string   `xml:"NextMarker"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return ListPage{}, fmt.Errorf("decoding answer of Azure, %v", err)
+	}
+	page := ListPage{
+		Objects: make([]ObjectInfo, len(list.Blobs)),
+		Folders: list.Prefixes,
+		Next:    list.NextMarker,
+	}
+	for i, blob := range list.Blobs {
+		lastMod, err := http.ParseTime(blob.Properties.LastModified)
+		if err != nil {
+			return ListPage{}, fmt.Errorf("failed to parse time of %q: %v", blob.Properties.LastModified, err)
+		}
+		page.Objects[i] = ObjectInfo{
+			Key:          blob.Name,
+			Size:         blob.Properties.ContentLength,
+			ETag:         blob.Properties.ETag,
+			LastModified: lastMod,
+		}
+	}
+	return page, nil
+}
+
+// Open reads the content of a blob, along with its metadata.
+func (s *AzureStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.blobURL(key))
+	if err != nil {
+		return nil, err
+	}
+	meta := headerObjectMeta(resp.Header, "X-Ms-Meta-")
+	meta.VersionID = resp.Header.Get("X-Ms-Version-Id")
+	return &metaReadCloser{
+		ReadCloser: resp.Body,
+		meta:       meta,
+	}, nil
+}
+
+// Stat describes a blob from its properties.
+func (s *AzureStore) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	resp, err := s.do(ctx, http.MethodHead, s.blobURL(key))
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer resp.Body.Close()
+	info := ObjectInfo{
+		Key:  key,
+		Size: resp.ContentLength,
+		ETag: resp.Header.Get("ETag"),
+	}
+	if lastMod := resp.Header.Get("Last-Modified"); lastMod != "" {
+		if info.LastModified, err = http.ParseTime(lastMod); err != nil {
+			return ObjectInfo{}, fmt.Errorf("failed to parse time of %q: %v", lastMod, err)
+		}
+	}
+	return info, nil
+}
+
+func (s *AzureStore) containerURL() string {
+	return strings.TrimSuffix(s.Endpoint, "/") + "/" + url.PathEscape(s.container)
+}
+
+func (s *AzureStore) blobURL(key string) string {
+	segments := strings.Split(key, "/")
+	for i, seg := range segments {
+		segments[i] = url.PathEscape(seg)
+	}
+	return s.containerURL() + "/" + strings.Join(segments, "/")
+}
+
+// do makes a signed request, turning an answer that isn't a success into
+// an AzureError.
+func (s *AzureStore) do(ctx context.Context, method, u string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", azureVersion)
+	if s.key != nil {
+		req.Header.Set("Authorization", "SharedKey "+s.account+":"+s.sign(req))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	azErr := &AzureError{
+		StatusCode: resp.StatusCode,
+		Code:       resp.Header.Get("x-ms-error-code"),
+		Message:    http.StatusText(resp.StatusCode),
+	}
+	var body struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if xml.Unmarshal(data, &body) == nil && body.Message != "" {
+		azErr.Code, azErr.Message = body.Code, strings.TrimSpace(body.Message)
+	}
+	return nil, azErr
+}
+
+// sign is the Shared Key signature of a request without a body.
+func (s *AzureStore) sign(req *http.Request) string {
+	var headers []string
+	for name := range req.Header {
+		if name := strings.ToLower(name); strings.HasPrefix(name, "x-ms-") {
+			headers = append(headers, name)
+		}
+	}
+	sort.Strings(headers)
+
+	var b strings.Builder
+	// the verb, then the standard headers, which are all empty here
+	b.WriteString(req.Method + "\n" + strings.Repeat("\n", 11))
+	for _, name := range headers {
+		b.WriteString(name + ":" + req.Header.Get(name) + "\n")
+	}
+	b.WriteString("/" + s.account + req.URL.EscapedPath())
+	q := req.URL.Query()
+	names := make([]string, 0, len(q))
+	for name := range q {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values := q[name]
+		sort.Strings(values)
+		b.WriteString("\n" + strings.ToLower(name) + ":" + strings.Join(values, ","))
+	}
+
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(b.String()))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,172 @@
+package taring_test
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"github.com/aybabtme/taring"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeAzure serves the blobs of a container over the REST API of Azure
+// Blob Storage, a page of one blob or prefix at a time.
+func fakeAzure(t *testing.T, container string, blobs map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "SharedKey account:") {
+			t.Errorf("want a request signed by the account, got %q", r.Header.Get("Authorization"))
+		}
+		q := r.URL.Query()
+		switch {
+		case r.URL.Path == "/"+container && q.Get("comp") == "list":
+			prefix := q.Get("prefix")
+			var entries []string
+			seen := make(map[string]bool)
+			for key := range blobs {
+				if !strings.HasPrefix(key, prefix) {
+					continue
+				}
+				entry := key
+				if i := strings.Index(key[len(prefix):], "/"); i >= 0 {
+					entry = key[:len(prefix)+i+1]
+				}
+				if !seen[entry] {
+					seen[entry] = true
+					entries = append(entries, entry)
+				}
+			}
+			sort.Strings(entries)
+			i, _ := strconv.Atoi(q.Get("marker"))
+			_, _ = io.WriteString(w, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs>`)
+			if i < len(entries) {
+				var name strings.Builder
+				_ = xml.EscapeText(&name, []byte(entries[i]))
+				if strings.HasSuffix(entries[i], "/") {
+					_, _ = io.WriteString(w, "<BlobPrefix><Name>"+name.String()+"</Name></BlobPrefix>")
+				} else {
+					_, _ = io.WriteString(w, "<Blob><Name>"+name.String()+"</Name><Properties>"+
+						"<Last-Modified>Tue, 01 Mar 2016 12:00:00 GMT</Last-Modified>"+
+						"<Etag>0x8D</Etag>"+
+						"<Content-Length>"+strconv.Itoa(len(blobs[entries[i]]))+"</Content-Length>"+
+						"</Properties></Blob>")
+				}
+			}
+			_, _ = io.WriteString(w, "</Blobs><NextMarker>")
+			if i+1 < len(entries) {
+				_, _ = io.WriteString(w, strconv.Itoa(i+1))
+			}
+			_, _ = io.WriteString(w, "</NextMarker></EnumerationResults>")
+		case strings.HasPrefix(r.URL.Path, "/"+container+"/"):
+			data, ok := blobs[strings.TrimPrefix(r.URL.Path, "/"+container+"/")]
+			if !ok {
+				w.Header().Set("x-ms-error-code", "BlobNotFound")
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = io.WriteString(w, `<?xml version="1.0" encoding="utf-8"?><Error><Code>BlobNotFound</Code><Message>The specified blob does not exist.</Message></Error>`)
+				return
+			}
+			_, _ = io.WriteString(w, data)
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+}
+
+func TestAzureStore(t *testing.T) {
+	srv := fakeAzure(t, "container", fixture)
+	defer srv.Close()
+	store, err := taring.NewAzureStore(srv.Client(), "account", "c2VjcmV0", "container")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Endpoint = srv.URL
+
+	var keys []string
+	for _, key := range listKeys(t, store, "data/") {
+		keys = append(keys, key.Key)
+		rc, err := store.Open(context.Background(), key.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != fixture[key.Key] || key.Size != int64(len(data)) {
+			t.Errorf("%q: want %q, got %q of size %d", key.Key, fixture[key.Key], data, key.Size)
+		}
+	}
+	var want []string
+	for key := range fixture {
+		if strings.HasPrefix(key, "data/") {
+			want = append(want, key)
+		}
+	}
+	sort.Strings(want)
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != strings.Join(want, ",") {
+		t.Errorf("want keys %q, got %q", want, keys)
+	}
+
+	_, err = store.Open(context.Background(), "data/missing")
+	var azErr *taring.AzureError
+	if !errors.As(err, &azErr) || azErr.Code != "BlobNotFound" {
+		t.Errorf("want a BlobNotFound from Azure, got %v", err)
+	}
+}
+
+// listKeys lists everything under prefix, folders included.
+func listKeys(t *testing.T, store taring.Lister, prefix string) []taring.ObjectInfo {
+	var keys []taring.ObjectInfo
+	for marker := ""; ; {
+		page, err := store.ListPage(context.Background(), prefix, marker)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, page.Objects...)
+		for _, folder := range page.Folders {
+			keys = append(keys, listKeys(t, store, folder)...)
+		}
+		if marker = page.Next; marker == "" {
+			return keys
+		}
+	}
+}
+
+func TestAzureCatalog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db := filepath.Join(dir, "taring.db")
+	_, err = taring.New("wasb://container@account.blob.core.windows.net/data/", filepath.Join(dir, "archive.tar.gz"),
+		taring.WithStore(newStore()),
+		taring.WithCatalog(db),
+	).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog, err := taring.OpenCatalog(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer catalog.Close()
+	entries, err := catalog.Lookup("wasb://container@account.blob.core.windows.net/data/readme.txt", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Member != "readme.txt" {
+		t.Errorf("want the blob catalogued under its wasb:// URL, with its container, got %+v", entries)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"math"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// metrics are compared between the runs of two results. They're all better
+// when lower. Those with a value per file have their samples tested for a
+// significant change; the others are measured once per run.
+var metrics = []struct {
+	Name    string
+	Format  func(v float64) string
+	Value   func(run runInfo) float64
+	PerFile func(file fileInfo) float64
+}{
+	{
+		Name:   "time",
+		Format: func(v float64) string { return time.Duration(v * float64(time.Second)).String() },
+		Value:  func(run runInfo) float64 { return run.Seconds },
+	},
+	{
+		Name:   "archive size",
+		Format: formatBytes,
+		Value:  func(run runInfo) float64 { return float64(run.ArchiveBytes) },
+	},
+	{
+		Name:   "peak memory",
+		Format: formatBytes,
+		Value:  func(run runInfo) float64 { return float64(run.PeakMem) },
+	},
+	{
+		Name:    "memory per file",
+		Format:  formatBytes,
+		PerFile: func(file fileInfo) float64 { return float64(file.Effective) },
+	},
+	{
+		Name:    "allocs per file",
+		Format:  func(v float64) string { return fmt.Sprintf("%.0f", v) },
+		PerFile: func(file fileInfo) float64 { return float64(file.Allocs) },
+	},
+	{
+		Name:   "GCs",
+		Format: func(v float64) string { return fmt.Sprintf("%.0f", v) },
+		Value:  func(run runInfo) float64 { return float64(lastFile(run).NumGC) },
+	},
+	{
+		Name:   "GC pauses",
+		Format: func(v float64) string { return time.Duration(v).String() },
+		Value:  func(run runInfo) float64 { return float64(lastFile(run).PauseNs) },
+	},
+}
+
+func formatBytes(v float64) string { return humanize.Bytes(uint64(v)) }
+
+func lastFile(run runInfo) fileInfo {
+	if len(run.Files) == 0 {
+		return fileInfo{}
+	}
+	return run.Files[len(run.Files)-1]
+}
+
+// delta is how a metric of a run changed between two results.
+type delta struct {
+	Run, Metric string
+	Old, New    float64
+	Format      func(v float64) string
+	// Change is in percent of the old value.
+	Change float64
+	// P is the p-value of the change, NaN when the metric has a single
+	// sample.
+	P float64
+}
+
+// significant tells if the change can be told apart from noise.
+func (d delta) significant(alpha float64) bool { return math.IsNaN(d.P) || d.P < alpha }
+
+// compareCmd compares two results saved with -out as JSON, and fails when
+// the new one regressed past a threshold.
+func compareCmd(args []string) {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	threshold := fs.Float64("fail-threshold", 0, "exit with status 1 when a metric got worse by more than this many percents, e.g. `5`, if significantly so; zero never fails")
+	alpha := fs.Float64("alpha", 0.05, "p-value under which a change of a metric measured per file is significant")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: bench compare [flags] <old.json> <new.json>\n\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	old, err := readReport(fs.Arg(0))
+	if err != nil {
+		fatalf("couldn't read results %q: %v", fs.Arg(0), err)
+	}
+	cur, err := readReport(fs.Arg(1))
+	if err != nil {
+		fatalf("couldn't read results %q: %v", fs.Arg(1), err)
+	}
+	if old.Pipeline != cur.Pipeline || old.N != cur.N || old.TotalBytes != cur.TotalBytes {
+		infof("the results aren't of the same workload, %s of %d files (%s) against %s of %d files (%s)",
+			old.Pipeline, old.N, humanize.Bytes(uint64(old.TotalBytes)),
+			cur.Pipeline, cur.N, humanize.Bytes(uint64(cur.TotalBytes)))
+	}
+	if old.Host.Name != cur.Host.Name || old.Host.NumCPU != cur.Host.NumCPU {
+		infof("the results are from different hosts, %s with %d CPUs against %s with %d CPUs",
+			old.Host.Name, old.Host.NumCPU, cur.Host.Name, cur.Host.NumCPU)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "RUN\tMETRIC\tOLD\tNEW\tDELTA\tP")
+	var regressed []delta
+	for _, d := range compareReports(old, cur) {
+		p := "~"
+		if !math.IsNaN(d.P) {
+			p = fmt.Sprintf("%.3f", d.P)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%+.1f%%\t%s\n", d.Run, d.Metric, d.Format(d.Old), d.Format(d.New), d.Change, p)
+		if *threshold > 0 && d.Change > *threshold && d.significant(*alpha) {
+			regressed = append(regressed, d)
+		}
+	}
+	_ = tw.Flush()
+
+	if len(regressed) > 0 {
+		for _, d := range regressed {
+			elog.Printf("%s: %s got %.1f%% worse, more than %g%%", d.Run, d.Metric, d.Change, *threshold)
+		}
+		os.Exit(1)
+	}
+}
+
+// compareReports compares the metrics of the runs both results have, by
+// name.
+func compareReports(old, cur *report) []delta {
+	olds := make(map[string]runInfo, len(old.Runs))
+	for _, run := range old.Runs {
+		olds[run.Name] = run
+	}
+	var deltas []delta
+	for _, run := range cur.Runs {
+		prev, ok := olds[run.Name]
+		if !ok {
+			infof("run %q is only in the new results", run.Name)
+			continue
+		}
+		delete(olds, run.Name)
+		for _, m := range metrics {
+			d := delta{Run: run.Name, Metric: m.Name, Format: m.Format, P: math.NaN()}
+			if m.PerFile != nil {
+				a, b := fileSamples(prev, m.PerFile), fileSamples(run, m.PerFile)
+				d.Old, d.New = mean(a), mean(b)
+				d.P = mannWhitney(a, b)
+			} else {
+				d.Old, d.New = m.Value(prev), m.Value(run)
+			}
+			d.Change = change(d.Old, d.New)
+			deltas = append(deltas, d)
+		}
+	}
+	for name := range olds {
+		infof("run %q is only in the old results", name)
+	}
+	return deltas
+}
+
+func fileSamples(run runInfo, value func(file fileInfo) float64) []float64 {
+	samples := make([]float64, len(run.Files))
+	for i, file := range run.Files {
+		samples[i] = value(file)
+	}
+	return samples
+}
+
+func mean(xs []float64) float64 {
+	if len(xs) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, x := range xs {
+		sum += x
+	}
+	return sum / float64(len(xs))
+}
+
+// change is how much new differs from old, in percent of old.
+func change(old, new float64) float64 {
+	switch {
+	case old == new:
+		return 0
+	case old == 0:
+		return math.Inf(1)
+	}
+	return (new - old) / old * 100
+}
+
+// mannWhitney is the two-sided p-value of the Mann-Whitney U test that a and
+// b come from the same distribution, by its normal approximation, which
+// holds for more than a handful of samples.
+func mannWhitney(a, b []float64) float64 {
+	n1, n2 := float64(len(a)), float64(len(b))
+	if n1 == 0 || n2 == 0 {
+		return math.NaN()
+	}
+	type sample struct {
+		v     float64
+		fromA bool
+	}
+	all := make([]sample, 0, len(a)+len(b))
+	for _, v := range a {
+		all = append(all, sample{v, true})
+	}
+	for _, v := range b {
+		all = append(all, sample{v, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].v < all[j].v })
+
+	// ties share the mean of their ranks
+	var rankA float64
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].fromA {
+				rankA += rank
+			}
+		}
+		i = j
+	}
+
+	u := rankA - n1*(n1+1)/2
+	sigma := math.Sqrt(n1 * n2 * (n1 + n2 + 1) / 12)
+	if sigma == 0 {
+		return 1
+	}
+	z := (u - n1*n2/2) / sigma
+	return math.Erfc(math.Abs(z) / math.Sqrt2)
+}
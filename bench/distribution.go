@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	mathrand "math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// zipfSteps is how many sizes a zipf distribution draws from, the largest
+// being the one given.
+const zipfSteps = 1000
+
+// distributions draw the sizes of the files to tar, by name, given a size
+// that scales them and the parameters of the distribution, which default to
+// the ones listed.
+var distributions = map[string]struct {
+	params map[string]float64
+	check  func(params map[string]float64) error
+	sizes  func(r *mathrand.Rand, n, size int, params map[string]float64) []int
+}{
+	// every file is of the size
+	"uniform": {
+		params: map[string]float64{},
+		check:  func(map[string]float64) error { return nil },
+		sizes: func(_ *mathrand.Rand, n, size int, _ map[string]float64) []int {
+			sizes := make([]int, n)
+			for i := range sizes {
+				sizes[i] = size
+			}
+			return sizes
+		},
+	},
+	// most files are tiny and a few are up to the size, P(k) being
+	// proportional to (v+k)^-s for a file of size*(k+1)/zipfSteps
+	"zipf": {
+		params: map[string]float64{"s": 1.2, "v": 1},
+		check: func(params map[string]float64) error {
+			switch {
+			case params["s"] <= 1:
+				return fmt.Errorf("s must be more than 1, got %g", params["s"])
+			case params["v"] < 1:
+				return fmt.Errorf("v must be at least 1, got %g", params["v"])
+			}
+			return nil
+		},
+		sizes: func(r *mathrand.Rand, n, size int, params map[string]float64) []int {
+			z := mathrand.NewZipf(r, params["s"], params["v"], zipfSteps-1)
+			sizes := make([]int, n)
+			for i := range sizes {
+				sizes[i] = atLeastOne(float64(size) * float64(z.Uint64()+1) / zipfSteps)
+			}
+			return sizes
+		},
+	},
+	// files are of the size times e^(sigma*N(0,1)), so half of them are
+	// smaller than the size and the rest have a long tail
+	"lognormal": {
+		params: map[string]float64{"sigma": 1.5},
+		check: func(params map[string]float64) error {
+			if params["sigma"] <= 0 {
+				return fmt.Errorf("sigma must be more than 0, got %g", params["sigma"])
+			}
+			return nil
+		},
+		sizes: func(r *mathrand.Rand, n, size int, params map[string]float64) []int {
+			sizes := make([]int, n)
+			for i := range sizes {
+				sizes[i] = atLeastOne(float64(size) * math.Exp(params["sigma"]*r.NormFloat64()))
+			}
+			return sizes
+		},
+	},
+}
+
+func atLeastOne(size float64) int {
+	if size < 1 {
+		return 1
+	}
+	return int(size)
+}
+
+// parseDistribution reads a distribution and its parameters, given as
+// `name` or `name:param=value,...`.
+func parseDistribution(spec string) (string, map[string]float64, error) {
+	name, args := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		name, args = spec[:i], spec[i+1:]
+	}
+	dist, ok := distributions[name]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown distribution %q, must be one of %s", name, distributionNames())
+	}
+	params := make(map[string]float64, len(dist.params))
+	for param, value := range dist.params {
+		params[param] = value
+	}
+	if args != "" {
+		for _, arg := range strings.Split(args, ",") {
+			kv := strings.SplitN(arg, "=", 2)
+			param := strings.TrimSpace(kv[0])
+			if _, ok := dist.params[param]; !ok {
+				return "", nil, fmt.Errorf("%s has no parameter %q", name, param)
+			}
+			if len(kv) != 2 {
+				return "", nil, fmt.Errorf("parameter %q of %s needs a value", param, name)
+			}
+			value, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+			if err != nil {
+				return "", nil, fmt.Errorf("parameter %q of %s must be a number, %v", param, name, err)
+			}
+			params[param] = value
+		}
+	}
+	if err := dist.check(params); err != nil {
+		return "", nil, fmt.Errorf("bad parameters for %s, %v", name, err)
+	}
+	return name, params, nil
+}
+
+func distributionNames() string {
+	names := make([]string, 0, len(distributions))
+	for name := range distributions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
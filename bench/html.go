@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"html/template"
+	"image/color"
+	"math"
+	"os"
+	"strings"
+)
+
+// htmlMargin is the room left around the plot for the axes, in pixels.
+const htmlMargin = 70
+
+// htmlTicks is how many ticks each axis has.
+const htmlTicks = 5
+
+var htmlPlot = template.Must(template.New("plot").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+svg text { font-size: 11px; }
+circle { fill-opacity: 0; stroke-opacity: 0; }
+circle:hover { fill-opacity: 1; stroke-opacity: 1; }
+.legend span { display: inline-block; margin-right: 1.5em; }
+.legend i { display: inline-block; width: 1em; height: 3px; vertical-align: middle; margin-right: .3em; }
+</style>
+</head>
+<body>
+<h3>{{.Title}}</h3>
+<svg width="{{.Width}}" height="{{.Height}}">
+{{range .XTicks}}<line x1="{{.Pos}}" y1="{{$.Top}}" x2="{{.Pos}}" y2="{{$.Bottom}}" stroke="#eee"/>
+<text x="{{.Pos}}" y="{{$.Bottom}}" dy="16" text-anchor="middle">{{.Label}}</text>
+{{end}}{{range .YTicks}}<line x1="{{$.Left}}" y1="{{.Pos}}" x2="{{$.Right}}" y2="{{.Pos}}" stroke="#eee"/>
+<text x="{{$.Left}}" y="{{.Pos}}" dx="-6" dy="4" text-anchor="end">{{.Label}}</text>
+{{end}}<line x1="{{.Left}}" y1="{{.Bottom}}" x2="{{.Right}}" y2="{{.Bottom}}" stroke="black"/>
+<line x1="{{.Left}}" y1="{{.Top}}" x2="{{.Left}}" y2="{{.Bottom}}" stroke="black"/>
+<text x="{{.MidX}}" y="{{.Height}}" dy="-8" text-anchor="middle">{{.XLabel}}</text>
+<text transform="translate(14 {{.MidY}}) rotate(-90)" text-anchor="middle">{{.YLabel}}</text>
+{{range .Series}}<polyline fill="none" stroke="{{.Color}}" stroke-width="1.5" points="{{.Points}}"/>
+{{$color := .Color}}{{range .Dots}}<circle cx="{{.X}}" cy="{{.Y}}" r="4" fill="{{$color}}" stroke="{{$color}}"><title>{{.Tip}}</title></circle>
+{{end}}{{end}}</svg>
+<p class="legend">{{range .Series}}<span><i style="background: {{.Color}}"></i>{{.Name}}</span>{{end}}</p>
+{{range .Notes}}<p>{{.}}</p>
+{{end}}</body>
+</html>
+`))
+
+type htmlTick struct {
+	Pos   float64
+	Label string
+}
+
+type htmlDot struct {
+	X, Y float64
+	Tip  string
+}
+
+type htmlSeries struct {
+	Name   string
+	Color  template.CSS
+	Points string
+	Dots   []htmlDot
+}
+
+// writeHTMLPlot renders a chart as a page of its own, where hovering a
+// point tells its values.
+func writeHTMLPlot(filename string, c chart, width, height float64) error {
+	minX, maxX := math.Inf(1), math.Inf(-1)
+	minY, maxY := 0.0, math.Inf(-1)
+	for _, s := range c.Series {
+		for _, xy := range s.XYs {
+			minX, maxX = math.Min(minX, xy.X), math.Max(maxX, xy.X)
+			minY, maxY = math.Min(minY, xy.Y), math.Max(maxY, xy.Y)
+		}
+	}
+	if math.IsInf(minX, 1) {
+		minX, maxX, maxY = 0, 1, 1
+	}
+	if maxX == minX {
+		maxX = minX + 1
+	}
+	if maxY == minY {
+		maxY = minY + 1
+	}
+
+	left, right := float64(htmlMargin), width-htmlMargin/2
+	top, bottom := float64(htmlMargin/2), height-htmlMargin
+	x := func(v float64) float64 { return left + (v-minX)/(maxX-minX)*(right-left) }
+	y := func(v float64) float64 { return bottom - (v-minY)/(maxY-minY)*(bottom-top) }
+	yLabel := func(v float64) string {
+		if c.YBytes {
+			return humanize.Bytes(uint64(v))
+		}
+		return fmt.Sprintf("%.3g", v)
+	}
+
+	page := struct {
+		Title, XLabel, YLabel    string
+		Width, Height            float64
+		Left, Right, Top, Bottom float64
+		MidX, MidY               float64
+		XTicks, YTicks           []htmlTick
+		Series                   []htmlSeries
+		Notes                    []string
+	}{
+		Title: c.Title, XLabel: c.XLabel, YLabel: c.YLabel,
+		Width: width, Height: height,
+		Left: left, Right: right, Top: top, Bottom: bottom,
+		MidX: (left + right) / 2, MidY: (top + bottom) / 2,
+		Notes: c.Notes,
+	}
+	for i := 0; i <= htmlTicks; i++ {
+		xv := minX + (maxX-minX)*float64(i)/htmlTicks
+		yv := minY + (maxY-minY)*float64(i)/htmlTicks
+		page.XTicks = append(page.XTicks, htmlTick{Pos: x(xv), Label: fmt.Sprintf("%.3g", xv)})
+		page.YTicks = append(page.YTicks, htmlTick{Pos: y(yv), Label: yLabel(yv)})
+	}
+	for _, s := range c.Series {
+		hs := htmlSeries{Name: s.Name, Color: cssColor(s.Color)}
+		points := make([]string, len(s.XYs))
+		for i, xy := range s.XYs {
+			points[i] = fmt.Sprintf("%.1f,%.1f", x(xy.X), y(xy.Y))
+			hs.Dots = append(hs.Dots, htmlDot{
+				X:   x(xy.X),
+				Y:   y(xy.Y),
+				Tip: fmt.Sprintf("%s\n%s: %.3g\n%s: %s", s.Name, c.XLabel, xy.X, c.YLabel, yLabel(xy.Y)),
+			})
+		}
+		hs.Points = strings.Join(points, " ")
+		page.Series = append(page.Series, hs)
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := htmlPlot.Execute(f, page); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func cssColor(c color.Color) template.CSS {
+	if c == nil {
+		return "black"
+	}
+	r, g, b, _ := c.RGBA()
+	return template.CSS(fmt.Sprintf("rgb(%d, %d, %d)", r>>8, g>>8, b>>8))
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"github.com/dustin/go-humanize"
+	"io"
+	"time"
+)
+
+// network is how a simulated source delivers each object, as S3 would
+// over a link. Its zero value delivers them as fast as memory does.
+type network struct {
+	// Latency is how long an object takes to start coming.
+	Latency time.Duration
+	// Bandwidth is how many bytes per second an object comes at, or
+	// unlimited when zero.
+	Bandwidth uint64
+}
+
+func (n network) simulated() bool { return n.Latency > 0 || n.Bandwidth > 0 }
+
+// rate is the bandwidth of the network, for humans.
+func (n network) rate() string {
+	if n.Bandwidth == 0 {
+		return "unlimited"
+	}
+	return humanize.Bytes(n.Bandwidth) + "/s"
+}
+
+// open delivers the data of an object through the network.
+func (n network) open(data []byte) io.Reader {
+	return &slowReader{r: bytes.NewReader(data), net: n}
+}
+
+// slowReader holds back reads to keep to the latency and bandwidth of a
+// network.
+type slowReader struct {
+	r     io.Reader
+	net   network
+	start time.Time
+	read  uint64
+}
+
+func (s *slowReader) Read(p []byte) (int, error) {
+	if s.start.IsZero() {
+		time.Sleep(s.net.Latency)
+		s.start = time.Now()
+	}
+	n, err := s.r.Read(p)
+	s.read += uint64(n)
+	if s.net.Bandwidth > 0 {
+		due := time.Duration(float64(s.read) / float64(s.net.Bandwidth) * float64(time.Second))
+		if wait := due - time.Since(s.start); wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+	return n, err
+}
@@ -9,7 +9,10 @@ import (
 	"github.com/aybabtme/benchkit"
 	"github.com/dustin/go-humanize"
 	"image/color"
+	"path/filepath"
 	"runtime"
+	"sort"
+	"strings"
 )
 
 var darkIdx = 0
@@ -58,22 +61,163 @@ var datalines = []struct {
 	},
 }
 
-func makePlot(n int, size string, results *benchkit.MemResult) (*plot.Plot, error) {
+// chart is what's plotted, whatever it's rendered with.
+type chart struct {
+	Title  string
+	XLabel string
+	YLabel string
+	// YBytes tells that the Y values are sizes.
+	YBytes bool
+	Series []series
+	// Notes are added to the legend, without a line.
+	Notes []string
+}
+
+// series is a line of a chart.
+type series struct {
+	Name  string
+	XYs   plotter.XYs
+	Width float64
+	Color color.Color
+	// Points draws every point of the line.
+	Points bool
+}
+
+func memChart(n int, workload, pipeline string, results *benchkit.MemResult) chart {
+	c := chart{
+		Title:  fmt.Sprintf("Effective memory usage of %s for %d files (%s)", pipeline, n, workload),
+		XLabel: "Number of files in archive",
+		YLabel: "Memory usage",
+		YBytes: true,
+	}
+	for _, data := range datalines {
+		c.Series = append(c.Series, series{
+			Name:  data.Name,
+			XYs:   mapResult(data.Filter, results.AfterEach),
+			Width: data.Width,
+			Color: data.Color,
+		})
+	}
+	return c
+}
+
+// compareChart charts the effective memory usage of the same workload
+// through different pipelines.
+func compareChart(n int, workload, pipeline string, runs []benchRun) chart {
+	c := chart{
+		Title:  fmt.Sprintf("Effective memory usage of %s for %d files (%s), buffered or streamed", pipeline, n, workload),
+		XLabel: "Number of files in archive",
+		YLabel: "Memory usage",
+		YBytes: true,
+	}
+	effective := func(mem *runtime.MemStats) float64 { return float64(mem.Sys - mem.HeapReleased) }
+	for _, run := range runs {
+		c.Series = append(c.Series, series{
+			Name:  run.Name,
+			XYs:   mapResult(effective, run.Mem.AfterEach),
+			Width: 0.5,
+			Color: pickDark(),
+		})
+	}
+	return c
+}
+
+// levelsChart charts the size of the archive against the time it took, one
+// point per compression level.
+func levelsChart(n int, workload, pipeline string, runs []benchRun) chart {
+	c := chart{
+		Title:  fmt.Sprintf("Size against time of %s for %d files (%s), by level", pipeline, n, workload),
+		XLabel: "Seconds",
+		YLabel: "Archive size",
+		YBytes: true,
+	}
+	xys := make(plotter.XYs, len(runs))
+	for i, run := range runs {
+		xys[i].X = run.Took.Seconds()
+		xys[i].Y = float64(run.ArchiveBytes)
+		c.Notes = append(c.Notes, fmt.Sprintf("level %d: %.2fs, %s", run.Level, run.Took.Seconds(), humanize.Bytes(uint64(run.ArchiveBytes))))
+	}
+	sort.Slice(xys, func(i, j int) bool { return xys[i].X < xys[j].X })
+	c.Series = append(c.Series, series{Name: pipeline, XYs: xys, Width: 0.5, Color: pickDark(), Points: true})
+	return c
+}
+
+// gcMetrics are charted for every run, each on a chart of its own.
+var gcMetrics = []struct {
+	Name   string
+	Suffix string
+	YLabel string
+	// Value is measured after a file, from the start of the run and from
+	// before the file.
+	Value func(start, before, after *runtime.MemStats) float64
+}{
+	{
+		Name:   "Total GC pauses",
+		Suffix: "gcpause",
+		YLabel: "Milliseconds paused",
+		Value: func(start, _, after *runtime.MemStats) float64 {
+			return float64(after.PauseTotalNs-start.PauseTotalNs) / 1e6
+		},
+	},
+	{
+		Name:   "Number of GCs",
+		Suffix: "numgc",
+		YLabel: "Completed GC cycles",
+		Value:  func(start, _, after *runtime.MemStats) float64 { return float64(after.NumGC - start.NumGC) },
+	},
+	{
+		Name:   "Allocations per file",
+		Suffix: "allocs",
+		YLabel: "Allocations",
+		Value:  func(_, before, after *runtime.MemStats) float64 { return float64(after.Mallocs - before.Mallocs) },
+	},
+}
+
+// gcCharts chart how the GC behaved during each run, by file, along with
+// the suffix to save each chart with.
+func gcCharts(n int, workload, pipeline string, runs []benchRun) ([]chart, []string) {
+	var (
+		charts   []chart
+		suffixes []string
+	)
+	for _, metric := range gcMetrics {
+		c := chart{
+			Title:  fmt.Sprintf("%s of %s for %d files (%s)", metric.Name, pipeline, n, workload),
+			XLabel: "Number of files in archive",
+			YLabel: metric.YLabel,
+		}
+		for _, run := range runs {
+			xys := make(plotter.XYs, len(run.Mem.AfterEach))
+			for i, after := range run.Mem.AfterEach {
+				xys[i].X = float64(i)
+				xys[i].Y = metric.Value(run.Mem.Start, run.Mem.BeforeEach[i], after)
+			}
+			c.Series = append(c.Series, series{Name: run.Name, XYs: xys, Width: 0.5, Color: pickDark()})
+		}
+		charts = append(charts, c)
+		suffixes = append(suffixes, metric.Suffix)
+	}
+	return charts, suffixes
+}
+
+func makePlot(c chart) (*plot.Plot, error) {
 
 	p, err := plot.New()
 	if err != nil {
 		return nil, err
 	}
 
-	p.Title.Text = fmt.Sprintf("Effective memory usage of archive/tar for %d files (%s each)", n, size)
-	p.Y.Label.Text = "Memory usage"
-	p.Y.Tick.Marker = readableBytes(p.Y.Tick.Marker)
-	p.X.Label.Text = fmt.Sprintf("Number of %s files in archive", size)
+	p.Title.Text = c.Title
+	p.Y.Label.Text = c.YLabel
+	if c.YBytes {
+		p.Y.Tick.Marker = readableBytes(p.Y.Tick.Marker)
+	}
+	p.X.Label.Text = c.XLabel
 
 	p.Add(plotter.NewGrid())
 
-	for _, data := range datalines {
-		line, err := plotter.NewLine(mapResult(data.Filter, results.AfterEach))
+	for _, data := range c.Series {
+		line, err := plotter.NewLine(data.XYs)
 		if err != nil {
 			return nil, err
 		}
@@ -81,11 +225,45 @@ func makePlot(n int, size string, results *benchkit.MemResult) (*plot.Plot, erro
 		line.Color = data.Color
 		p.Add(line)
 		p.Legend.Add(data.Name, line)
+		if data.Points {
+			scatter, err := plotter.NewScatter(data.XYs)
+			if err != nil {
+				return nil, err
+			}
+			scatter.Color = data.Color
+			p.Add(scatter)
+		}
+	}
+	for _, note := range c.Notes {
+		p.Legend.Add(note)
 	}
 
 	return p, nil
 }
 
+// savePlot renders a chart to filename, in the format its extension says.
+// An `.html` page is rendered by hand, the rest by plotinum.
+func savePlot(c chart, filename string, width, height float64) {
+	infof("saving plot to %q...", filename)
+
+	if strings.ToLower(filepath.Ext(filename)) == ".html" {
+		if err := writeHTMLPlot(filename, c, width, height); err != nil {
+			fatalf("couldn't save plot to %q: %v", filename, err)
+		}
+		return
+	}
+
+	p, err := makePlot(c)
+	if err != nil {
+		fatalf("couldn't plot results: %v", err)
+	}
+	w := vg.Points(width).Inches()
+	h := vg.Points(height).Inches()
+	if err := p.Save(w, h, filename); err != nil {
+		fatalf("couldn't save plot to %q: %v", filename, err)
+	}
+}
+
 func mapResult(f func(mem *runtime.MemStats) float64, mems []*runtime.MemStats) plotter.XYs {
 	xys := make(plotter.XYs, len(mems))
 	for i, mem := range mems {
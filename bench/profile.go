@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// startProfiling profiles the CPU to cpuPath, if any, until the returned
+// func is called, which then writes a heap profile to memPath, if any.
+func startProfiling(cpuPath, memPath string) (stop func()) {
+	var cpuFile *os.File
+	if cpuPath != "" {
+		f, err := os.Create(cpuPath)
+		if err != nil {
+			fatalf("couldn't create CPU profile: %v", err)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			fatalf("couldn't start CPU profile: %v", err)
+		}
+		cpuFile = f
+	}
+
+	return func() {
+		if cpuFile != nil {
+			pprof.StopCPUProfile()
+			if err := cpuFile.Close(); err != nil {
+				fatalf("couldn't write CPU profile: %v", err)
+			}
+			infof("wrote CPU profile to %q", cpuPath)
+		}
+		if memPath != "" {
+			if err := writeHeapProfile(memPath); err != nil {
+				fatalf("couldn't write heap profile: %v", err)
+			}
+			infof("wrote heap profile to %q", memPath)
+		}
+	}
+}
+
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	runtime.GC() // up to date statistics
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// report is what a benchmark measured, as saved with -out.
+type report struct {
+	Pipeline string `json:"pipeline"`
+	N        int    `json:"n"`
+	// Size is the size of each file, or what scales their sizes.
+	Size int64 `json:"size"`
+	// Distribution is how the sizes of files were drawn, unless they came
+	// from a workload or a source.
+	Distribution string `json:"distribution,omitempty"`
+	Workload     string `json:"workload,omitempty"`
+	// Source is the bucket that was archived, if any.
+	Source string `json:"source,omitempty"`
+	// Latency, in seconds, and Bandwidth, in bytes per second, are those of
+	// the simulated source, if any.
+	Latency   float64 `json:"latency,omitempty"`
+	Bandwidth uint64  `json:"bandwidth,omitempty"`
+	// Seed generated the files, if they were.
+	Seed       int64     `json:"seed,omitempty"`
+	TotalBytes int64     `json:"total_bytes"`
+	Date       time.Time `json:"date"`
+	Host       hostInfo  `json:"host"`
+	Runs       []runInfo `json:"runs"`
+}
+
+// hostInfo tells results from different machines apart.
+type hostInfo struct {
+	Name   string `json:"name"`
+	GOOS   string `json:"goos"`
+	GOARCH string `json:"goarch"`
+	NumCPU int    `json:"num_cpu"`
+	Go     string `json:"go"`
+}
+
+type runInfo struct {
+	Name         string     `json:"name"`
+	Level        int        `json:"level,omitempty"`
+	Seconds      float64    `json:"seconds"`
+	ArchiveBytes int64      `json:"archive_bytes"`
+	PeakMem      uint64     `json:"peak_mem"`
+	Files        []fileInfo `json:"files"`
+}
+
+// fileInfo is the memory in use after a file was written, and how the GC
+// had behaved since the run started.
+type fileInfo struct {
+	HeapAlloc uint64 `json:"heap_alloc"`
+	HeapSys   uint64 `json:"heap_sys"`
+	Sys       uint64 `json:"sys"`
+	Effective uint64 `json:"effective"`
+	NumGC     uint32 `json:"num_gc"`
+	PauseNs   uint64 `json:"gc_pause_total_ns"`
+	// Allocs is how many allocations writing the file took.
+	Allocs uint64 `json:"allocs"`
+}
+
+func newReport(pipeline string, n int, size int64, dist string, total int64, runs []benchRun) *report {
+	host, _ := os.Hostname()
+	r := &report{
+		Pipeline:     pipeline,
+		N:            n,
+		Size:         size,
+		Distribution: dist,
+		TotalBytes:   total,
+		Date:         time.Now().UTC(),
+		Host: hostInfo{
+			Name:   host,
+			GOOS:   runtime.GOOS,
+			GOARCH: runtime.GOARCH,
+			NumCPU: runtime.NumCPU(),
+			Go:     runtime.Version(),
+		},
+	}
+	for _, run := range runs {
+		info := runInfo{
+			Name:         run.Name,
+			Level:        run.Level,
+			Seconds:      run.Took.Seconds(),
+			ArchiveBytes: run.ArchiveBytes,
+			PeakMem:      peakMem(run.Mem),
+			Files:        make([]fileInfo, len(run.Mem.AfterEach)),
+		}
+		for i, mem := range run.Mem.AfterEach {
+			info.Files[i] = fileInfo{
+				HeapAlloc: mem.HeapAlloc,
+				HeapSys:   mem.HeapSys,
+				Sys:       mem.Sys,
+				Effective: mem.Sys - mem.HeapReleased,
+				NumGC:     mem.NumGC - run.Mem.Start.NumGC,
+				PauseNs:   mem.PauseTotalNs - run.Mem.Start.PauseTotalNs,
+				Allocs:    mem.Mallocs - run.Mem.BeforeEach[i].Mallocs,
+			}
+		}
+		r.Runs = append(r.Runs, info)
+	}
+	return r
+}
+
+// reportFormat is the format results are saved in at path, if it's known.
+func reportFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return "json"
+	case ".csv":
+		return "csv"
+	}
+	return ""
+}
+
+// readReport reads a report saved as JSON.
+func readReport(path string) (*report, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := new(report)
+	if err := json.NewDecoder(f).Decode(r); err != nil {
+		return nil, fmt.Errorf("decoding results, %v", err)
+	}
+	return r, nil
+}
+
+// writeReport saves a report to path, as JSON or as CSV with a row per
+// file of each run.
+func writeReport(path string, r *report) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if reportFormat(path) == "json" {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+		return f.Close()
+	}
+
+	w := csv.NewWriter(f)
+	_ = w.Write([]string{
+		"pipeline", "run", "level", "seconds", "archive_bytes", "peak_mem",
+		"file", "heap_alloc", "heap_sys", "sys", "effective",
+		"num_gc", "gc_pause_total_ns", "allocs",
+	})
+	u := func(v uint64) string { return strconv.FormatUint(v, 10) }
+	for _, run := range r.Runs {
+		for i, file := range run.Files {
+			_ = w.Write([]string{
+				r.Pipeline, run.Name, strconv.Itoa(run.Level),
+				strconv.FormatFloat(run.Seconds, 'f', -1, 64),
+				strconv.FormatInt(run.ArchiveBytes, 10), u(run.PeakMem),
+				strconv.Itoa(i), u(file.HeapAlloc), u(file.HeapSys), u(file.Sys), u(file.Effective),
+				u(uint64(file.NumGC)), u(file.PauseNs), u(file.Allocs),
+			})
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/aybabtme/benchkit"
+	"github.com/aybabtme/taring"
+	"github.com/crowdmob/goamz/aws"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// s3Bench benchmarks the archiver of taring against a bucket, from fetching
+// the objects to compressing the archive.
+type s3Bench struct {
+	source string
+	prefix string
+	auth   aws.Auth
+	region aws.Region
+	store  taring.Store
+}
+
+func newS3Bench(source, access, secret, region string) (*s3Bench, error) {
+	u, err := url.Parse(source)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf("%q isn't of the form s3://bucketname/path", source)
+	}
+	r, ok := aws.Regions[region]
+	if !ok {
+		return nil, fmt.Errorf("unknown AWS region %q", region)
+	}
+	auth := aws.Auth{AccessKey: access, SecretKey: secret}
+	return &s3Bench{
+		source: source,
+		prefix: strings.TrimPrefix(u.Path, "/"),
+		auth:   auth,
+		region: r,
+		store:  taring.NewS3Store(auth, r, u.Host),
+	}, nil
+}
+
+// list lists the objects of the source as a run does, for the benchmark to
+// know what it's measuring.
+func (b *s3Bench) list(ctx context.Context) ([]fileSpec, error) {
+	return listFiles(ctx, b.store, b.prefix)
+}
+
+func listFiles(ctx context.Context, store taring.Lister, prefix string) ([]fileSpec, error) {
+	var (
+		specs   []fileSpec
+		folders []string
+		marker  string
+	)
+	for {
+		page, err := store.ListPage(ctx, prefix, marker)
+		if err != nil {
+			return nil, fmt.Errorf("listing %q, %s", prefix, taring.DescribeS3Error(err))
+		}
+		for _, obj := range page.Objects {
+			specs = append(specs, fileSpec{Name: obj.Key, Size: int(obj.Size)})
+		}
+		folders = append(folders, page.Folders...)
+		if page.Next == "" {
+			break
+		}
+		marker = page.Next
+	}
+	for _, folder := range folders {
+		sub, err := listFiles(ctx, store, folder)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, sub...)
+	}
+	return specs, nil
+}
+
+// run archives the n objects of the source to dst. The memory is taken
+// each time an object is fetched, in the order they're fetched.
+func (b *s3Bench) run(ctx context.Context, n int, dst io.Writer, compressor taring.Compressor, bench benchkit.BenchKit) error {
+	if compressor == nil {
+		compressor = uncompressed{}
+	}
+	each := bench.Each()
+	var (
+		mu      sync.Mutex
+		fetched int
+	)
+	// next is done with the current object and starts measuring the next
+	next := func() {
+		each.After(fetched)
+		fetched++
+		if fetched < n {
+			each.Before(fetched)
+		}
+	}
+
+	bench.Setup()
+	arch := taring.New(b.source, "",
+		taring.WithAuth(b.auth, b.region),
+		taring.WithStore(b.store),
+		taring.WithCompressor(compressor),
+		taring.WithOnObjectDone(func(taring.ActiveFetch) {
+			mu.Lock()
+			defer mu.Unlock()
+			if fetched < n {
+				next()
+			}
+		}),
+	)
+	bench.Starting()
+	each.Before(0)
+	rc, err := arch.Stream(ctx)
+	if err == nil {
+		if _, err = io.Copy(dst, rc); err != nil {
+			err = fmt.Errorf("archiving %q, %v", b.source, err)
+		}
+		_ = rc.Close()
+	}
+	// objects that failed or went away since they were listed are
+	// measured as they are at the end
+	mu.Lock()
+	for fetched < n {
+		next()
+	}
+	mu.Unlock()
+	bench.Teardown()
+	return err
+}
+
+// uncompressed leaves the archive as it is, for runs without a -codec.
+type uncompressed struct{}
+
+func (uncompressed) NewWriter(w io.Writer) io.WriteCloser { return nopWriteCloser{w} }
+func (uncompressed) Extension() string                    { return "" }
+func (uncompressed) ContentType() string                  { return "application/x-tar" }
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
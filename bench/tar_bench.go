@@ -3,19 +3,25 @@ package main
 import (
 	"archive/tar"
 	"bytes"
-	"code.google.com/p/plotinum/vg"
+	"context"
 	"flag"
 	"fmt"
 	"github.com/aybabtme/benchkit"
 	"github.com/aybabtme/color/brush"
+	"github.com/aybabtme/taring"
+	"github.com/crowdmob/goamz/aws"
 	"github.com/dustin/go-humanize"
 	"github.com/dustin/randbo"
 	"io"
 	"io/ioutil"
 	"log"
+	mathrand "math/rand"
 	"os"
+	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -24,6 +30,25 @@ var (
 	elog      = log.New(os.Stderr, brush.Red("[fatal] ").String(), 0)
 )
 
+// codecs are the compressors the tar stream can go through, by name.
+var codecs = map[string]taring.Compressor{
+	"gzip":  taring.Gzip{},
+	"zstd":  taring.Zstd{},
+	"xz":    taring.Xz{},
+	"lz4":   taring.Lz4{},
+	"pgzip": taring.Gzip{Workers: runtime.NumCPU()},
+}
+
+// leveled are the codecs that have levels, with the range of their levels.
+var leveled = map[string]struct {
+	min, max int
+	at       func(level int) taring.Compressor
+}{
+	"gzip":  {1, 9, func(level int) taring.Compressor { return taring.Gzip{Level: level} }},
+	"zstd":  {1, 22, func(level int) taring.Compressor { return taring.Zstd{Level: level} }},
+	"pgzip": {1, 9, func(level int) taring.Compressor { return taring.Gzip{Level: level, Workers: runtime.NumCPU()} }},
+}
+
 func init() {
 	log.SetFlags(0)
 	log.SetPrefix(brush.Blue("[info] ").String())
@@ -48,20 +73,59 @@ func effectMem(mem *runtime.MemStats) string {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "compare" {
+		compareCmd(os.Args[2:])
+		return
+	}
+
 	var (
-		n        int
-		sizeStr  string
-		discard  bool
-		plotfile string
-		plottype string
-		height   float64
-		width    float64
+		n         int
+		sizeStr   string
+		distSpec  string
+		workPath  string
+		latency   time.Duration
+		bwStr     string
+		source    string
+		awsAccess string
+		awsSecret string
+		awsRegion string
+		seed      int64
+		cpuPath   string
+		memPath   string
+		discard   bool
+		codec     string
+		compare   bool
+		levels    string
+		out       string
+		count     int
+		benchstat bool
+		plotfile  string
+		plottype  string
+		height    float64
+		width     float64
 	)
 	flag.IntVar(&n, "n", 0, "number of files to tar")
-	flag.StringVar(&sizeStr, "size", "0", "size of files to tar")
+	flag.StringVar(&sizeStr, "size", "0", "size of files to tar, or what scales their sizes with -distribution")
+	flag.StringVar(&distSpec, "distribution", "uniform", "how the sizes of files are drawn, as `name[:param=value,...]`: uniform files are all of -size; zipf files are mostly tiny, up to -size, with parameters s and v; lognormal files are around -size with a long tail, with parameter sigma")
+	flag.StringVar(&workPath, "workload", "", "tar files of the names and sizes of a bucket instead, as listed by a manifest or by `taring list -csv`, e.g. `listing.csv`")
+	flag.DurationVar(&latency, "latency", 0, "simulate a source that takes this long to start sending each file, as S3 would")
+	flag.StringVar(&bwStr, "bandwidth", "", "simulate a source that sends each file at most at this rate per second, e.g. `10MB`")
+	flag.StringVar(&source, "source", "", "benchmark archiving an actual bucket the way taring does, from fetching to compressing, instead of tarring generated files, e.g. `s3://bucketname/path`")
+	flag.StringVar(&awsAccess, "aws-access", "", "an AWS access key, with -source")
+	flag.StringVar(&awsSecret, "aws-secret", "", "an AWS secret key, with -source")
+	flag.StringVar(&awsRegion, "aws-region", aws.USEast.Name, "an AWS region string, with -source")
+	flag.Int64Var(&seed, "seed", 0, "seed the sizes and data of generated files with this, to generate the same ones again; zero picks one")
+	flag.StringVar(&cpuPath, "cpuprofile", "", "a path to write a CPU profile of the benchmark to")
+	flag.StringVar(&memPath, "memprofile", "", "a path to write a heap profile to once the benchmark is done")
 	flag.BoolVar(&discard, "discard", false, "tar the file to /dev/null instead of a memory buffer")
+	flag.StringVar(&codec, "codec", "", "compress the tar stream with this codec on its way to the sink, as a run does, e.g. `gzip`")
+	flag.BoolVar(&compare, "compare", false, "run the workload through a pipeline that buffers the whole tar stream before writing it, as runs used to, and through one that streams it, and compare them")
+	flag.StringVar(&levels, "levels", "", "comma separated compression levels of `-codec` to sweep, e.g. `1,3,6,9`, plotting the size of the archive against the time it took")
+	flag.StringVar(&out, "out", "", "a path to also save the raw results to, as JSON or CSV depending on its extension, e.g. `results.json`")
+	flag.BoolVar(&benchstat, "benchstat", false, "print the results on stdout in the format of `go test -bench`, for benchstat")
+	flag.IntVar(&count, "count", 1, "how many times to run the benchmark, for benchstat to have samples; only the first run is plotted and saved")
 	flag.StringVar(&plotfile, "plotfile", "tar", "prefix of the plot file")
-	flag.StringVar(&plottype, "plottype", "svg", "extension of the plot to produce")
+	flag.StringVar(&plottype, "plottype", "svg", "extension of the plot to produce: `svg`, `png` or `pdf`, or `html` for a page where hovering a point tells its values")
 	flag.Float64Var(&height, "height", 600, "height of the plot to generate")
 	flag.Float64Var(&width, "width", 800, "width of the plot to generate")
 	flag.Parse()
@@ -71,52 +135,327 @@ func main() {
 		fatalFlag("flag -size must be a valid byte size: %v", err)
 	}
 
+	var link network
+	link.Latency = latency
+	if bwStr != "" {
+		link.Bandwidth, err = humanize.ParseBytes(bwStr)
+		if err != nil {
+			fatalFlag("flag -bandwidth must be a valid byte size: %v", err)
+		}
+	}
+
 	switch {
-	case n <= 0:
+	case latency < 0:
+		fatalFlag("flag -latency can't be negative")
+	case source != "" && (workPath != "" || link.simulated() || compare):
+		fatalFlag("flag -source archives a bucket as taring does, it can't go with -workload, -latency, -bandwidth or -compare")
+	case (workPath != "" || source != "") && (n != 0 || size != 0 || distSpec != "uniform"):
+		fatalFlag("flags -workload and -source tell the files to tar, they can't go with -n, -size or -distribution")
+	case workPath == "" && source == "" && n <= 0:
 		fatalFlag("flag -n must specify at least 1 file to tar")
-	case size <= 0:
+	case workPath == "" && source == "" && size <= 0:
 		fatalFlag("flag -size must specify at least 1 byte per file")
+	case count <= 0:
+		fatalFlag("flag -count must be at least 1")
 	}
-
-	var dst io.Writer
-	if discard {
-		dst = ioutil.Discard
-	} else {
-		dst = bytes.NewBuffer(nil)
+	dist, params, err := parseDistribution(distSpec)
+	if err != nil {
+		fatalFlag("flag -distribution is invalid: %v", err)
+	}
+	compressor, ok := codecs[codec]
+	if codec != "" && !ok {
+		fatalFlag("flag -codec must be one of %s", codecNames())
+	}
+	var sweep []int
+	if levels != "" {
+		lc, ok := leveled[codec]
+		if !ok {
+			fatalFlag("flag -levels needs a -codec with levels, %q has none", codec)
+		}
+		for _, lvl := range strings.Split(levels, ",") {
+			level, err := strconv.Atoi(strings.TrimSpace(lvl))
+			if err != nil {
+				fatalFlag("flag -levels must be a list of numbers: %v", err)
+			}
+			if level < lc.min || level > lc.max {
+				fatalFlag("flag -levels must be from %d to %d for %s, got %d", lc.min, lc.max, codec, level)
+			}
+			sweep = append(sweep, level)
+		}
+	}
+	if out != "" && reportFormat(out) == "" {
+		fatalFlag("flag -out must end in .json or .csv, got %q", out)
+	}
+	pipeline := "archive/tar"
+	if codec != "" {
+		pipeline += "+" + codec
+		plotfile += "_" + codec
+	}
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	var (
+		specs                       []fileSpec
+		bkt                         *s3Bench
+		workload, suffix, benchName string
+	)
+	switch {
+	case source != "":
+		bkt, err = newS3Bench(source, awsAccess, awsSecret, awsRegion)
+		if err != nil {
+			fatalFlag("flag -source is invalid: %v", err)
+		}
+		infof("listing %q...", source)
+		specs, err = bkt.list(context.Background())
+		if err != nil {
+			fatalf("couldn't list %q: %v", source, err)
+		}
+		if len(specs) == 0 {
+			fatalf("there's nothing to archive at %q", source)
+		}
+		n = len(specs)
+		workload = "from " + source
+		suffix = fmt.Sprintf("n%d_s3", n)
+		benchName = fmt.Sprintf("n=%d/source=s3", n)
+	case workPath != "":
+		specs, err = readWorkload(workPath)
+		if err != nil {
+			fatalf("couldn't read workload %q: %v", workPath, err)
+		}
+		n = len(specs)
+		base := strings.TrimSuffix(filepath.Base(workPath), filepath.Ext(workPath))
+		workload = "as in " + filepath.Base(workPath)
+		suffix = fmt.Sprintf("n%d_%s", n, base)
+		benchName = fmt.Sprintf("n=%d/workload=%s", n, base)
+	default:
+		rng := mathrand.New(mathrand.NewSource(seed))
+		specs = numberedFiles(distributions[dist].sizes(rng, n, int(size), params))
+		workload = fmt.Sprintf("%s each", sizeStr)
+		suffix = fmt.Sprintf("n%d_size%s", n, sizeStr)
+		benchName = fmt.Sprintf("n=%d/size=%s", n, strings.Replace(sizeStr, " ", "", -1))
+		if dist != "uniform" {
+			workload = fmt.Sprintf("%s sizes of scale %s", distSpec, sizeStr)
+			suffix += "_" + dist
+			benchName += "/dist=" + dist
+		}
+	}
+	total := totalSize(specs)
+	if link.simulated() {
+		benchName += fmt.Sprintf("/latency=%v/bandwidth=%s", link.Latency, strings.Replace(link.rate(), " ", "", -1))
 	}
 
 	infof("starting benchmark")
-	infof("\t- with %d generated tar files", n)
-	infof("\t- each file of size %s", humanize.Bytes(size))
+	switch {
+	case source != "":
+		infof("\t- archiving the %d objects at %q, %s in all, as taring does", n, source, humanize.Bytes(uint64(total)))
+	case workPath != "":
+		infof("\t- with %d generated tar files", n)
+		infof("\t- names and sizes from %q, %s in all", workPath, humanize.Bytes(uint64(total)))
+	case dist == "uniform":
+		infof("\t- with %d generated tar files", n)
+		infof("\t- each file of size %s", humanize.Bytes(size))
+	default:
+		infof("\t- with %d generated tar files", n)
+		infof("\t- sizes drawn from %s, of scale %s, %s in all", distSpec, humanize.Bytes(size), humanize.Bytes(uint64(total)))
+	}
+	if source == "" {
+		infof("\t- generated with -seed=%d", seed)
+	}
+	if link.simulated() {
+		infof("\t- from a source %v away, sending each file at %s", link.Latency, link.rate())
+	}
+	infof("\t- through %s", pipeline)
 	if discard {
 		infof("\t- writing to /dev/null")
 	} else {
-		infof("\t- writing to memory buffer (will grow to %s)", humanize.Bytes(size*uint64(n)))
+		infof("\t- writing to memory buffer (will grow to %s)", humanize.Bytes(uint64(total)))
+	}
+	// work is what's measured, through a pipeline
+	work := func(compressor taring.Compressor, buffer bool) func(dst io.Writer, bench benchkit.BenchKit) error {
+		if bkt != nil {
+			return func(dst io.Writer, bench benchkit.BenchKit) error {
+				return bkt.run(context.Background(), n, dst, compressor, bench)
+			}
+		}
+		return func(dst io.Writer, bench benchkit.BenchKit) error {
+			return benchmarkTar(specs, seed, link, dst, compressor, buffer, bench)
+		}
+	}
+	measure := func() []benchRun {
+		var runs []benchRun
+		switch {
+		case len(sweep) > 0:
+			for _, level := range sweep {
+				run := runBenchmark(fmt.Sprintf("%s level %d", codec, level), n, discard, work(leveled[codec].at(level), false))
+				run.Level = level
+				run.Variant = fmt.Sprintf("level=%d", level)
+				infof("%s: %v, %s (%.1f%% of input)", run.Name, run.Took,
+					humanize.Bytes(uint64(run.ArchiveBytes)), 100*float64(run.ArchiveBytes)/float64(total))
+				runs = append(runs, run)
+			}
+		case compare:
+			for _, mode := range []string{buffered, streamed} {
+				infof("%s pipeline:", mode)
+				run := runBenchmark(mode, n, discard, work(compressor, mode == buffered))
+				run.Variant = mode
+				infof("done %s: %v (%s/s), peak effectMem=%s", mode, run.Took,
+					humanize.Bytes(uint64(float64(total)/run.Took.Seconds())),
+					humanize.Bytes(peakMem(run.Mem)))
+				runs = append(runs, run)
+			}
+		default:
+			run := runBenchmark(pipeline, n, discard, work(compressor, false))
+			infof("done benchmark: %v (%s/s)", run.Took, humanize.Bytes(uint64(float64(total)/run.Took.Seconds())))
+			runs = append(runs, run)
+		}
+		if benchstat {
+			for _, run := range runs {
+				fmt.Println(benchstatLine(pipeline, benchName, total, run))
+			}
+		}
+		return runs
 	}
-	start := time.Now()
-	results := doBenchmark(n, int(size), dst)
-	infof("done benchmark: %v", time.Since(start))
+
+	stopProfiling := startProfiling(cpuPath, memPath)
+	runs := measure()
+	for i := 1; i < count; i++ {
+		infof("repeating, %d/%d", i+1, count)
+		measure()
+	}
+	stopProfiling()
 
 	infof("plotting...")
-	p, err := makePlot(n, sizeStr, results)
-	if err != nil {
-		fatalf("couldn't plot results: %v", err)
+	prefix := plotfile
+	switch {
+	case len(sweep) > 0:
+		prefix += "_levels"
+		savePlot(levelsChart(n, workload, pipeline, runs), fmt.Sprintf("%s_%s.%s", prefix, suffix, plottype), width, height)
+	case compare:
+		prefix += "_compare"
+		savePlot(compareChart(n, workload, pipeline, runs), fmt.Sprintf("%s_%s.%s", prefix, suffix, plottype), width, height)
+	default:
+		savePlot(memChart(n, workload, pipeline, runs[0].Mem), fmt.Sprintf("%s_%s.%s", prefix, suffix, plottype), width, height)
+	}
+	charts, suffixes := gcCharts(n, workload, pipeline, runs)
+	for i, c := range charts {
+		savePlot(c, fmt.Sprintf("%s_%s_%s.%s", prefix, suffixes[i], suffix, plottype), width, height)
 	}
 
-	filename := fmt.Sprintf("%s_n%d_size%s.%s", plotfile, n, sizeStr, plottype)
-	infof("saving plot to %q...", filename)
+	if out != "" {
+		infof("saving results to %q...", out)
+		r := newReport(pipeline, n, int64(size), distSpec, total, runs)
+		r.Latency, r.Bandwidth = link.Latency.Seconds(), link.Bandwidth
+		if source == "" {
+			r.Seed = seed
+		}
+		switch {
+		case source != "":
+			r.Distribution, r.Source = "", source
+		case workPath != "":
+			r.Distribution, r.Workload = "", workPath
+		}
+		if err := writeReport(out, r); err != nil {
+			fatalf("couldn't save results to %q: %v", out, err)
+		}
+	}
+}
+
+// benchRun is one run of the workload.
+type benchRun struct {
+	Name string
+	// Variant tells runs of the same benchmark apart, e.g. `level=6`.
+	Variant string
+	// Level is the compression level, when sweeping levels.
+	Level int
+	Took  time.Duration
+	// ArchiveBytes is how much was written to the sink.
+	ArchiveBytes int64
+	Mem          *benchkit.MemResult
+}
 
-	w := vg.Points(width).Inches()
-	h := vg.Points(height).Inches()
-	if err := p.Save(w, h, filename); err != nil {
-		fatalf("couldn't save plot to %q: %v", filename, err)
+// benchstatLine describes a run the way `go test -bench` does. A run is
+// one op: writing all the files, of total bytes.
+func benchstatLine(pipeline, workload string, total int64, run benchRun) string {
+	name := "BenchmarkTar/" + strings.TrimPrefix(pipeline, "archive/")
+	if run.Variant != "" {
+		name += "/" + run.Variant
 	}
+	name += "/" + workload
+	if procs := runtime.GOMAXPROCS(0); procs > 1 {
+		name += fmt.Sprintf("-%d", procs)
+	}
+	start, end := run.Mem.Start, run.Mem.Teardown
+	return fmt.Sprintf("%s\t1\t%d ns/op\t%.2f MB/s\t%d B/op\t%d allocs/op\t%d peak-B\t%d archive-B\t%d gcs/op\t%d gc-pause-ns/op",
+		name, run.Took.Nanoseconds(),
+		float64(total)/1e6/run.Took.Seconds(),
+		end.TotalAlloc-start.TotalAlloc, end.Mallocs-start.Mallocs,
+		peakMem(run.Mem), run.ArchiveBytes,
+		end.NumGC-start.NumGC, end.PauseTotalNs-start.PauseTotalNs)
+}
+
+// runBenchmark runs the workload once, after collecting the garbage of the
+// previous runs.
+func runBenchmark(name string, n int, discard bool, work func(dst io.Writer, bench benchkit.BenchKit) error) benchRun {
+	runtime.GC()
+	sink := &countWriter{w: newSink(discard)}
+	start := time.Now()
+	mem := doBenchmark(n, sink, work)
+	return benchRun{Name: name, Took: time.Since(start), ArchiveBytes: sink.n, Mem: mem}
+}
+
+// Pipelines the tar stream can be written to the sink with.
+const (
+	// streamed writes entries through to the sink as they're made.
+	streamed = "streamed"
+	// buffered writes all the tar stream in memory before the sink.
+	buffered = "buffered"
+)
+
+// countWriter counts what goes through it.
+type countWriter struct {
+	w io.Writer
+	n int64
 }
 
-func doBenchmark(n, size int, dst io.Writer) *benchkit.MemResult {
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func newSink(discard bool) io.Writer {
+	if discard {
+		return ioutil.Discard
+	}
+	return bytes.NewBuffer(nil)
+}
+
+// peakMem is the most memory a benchmark effectively used at any point.
+func peakMem(results *benchkit.MemResult) uint64 {
+	var peak uint64
+	mems := append([]*runtime.MemStats{results.Start, results.Teardown}, results.AfterEach...)
+	for _, mem := range mems {
+		if used := mem.Sys - mem.HeapReleased; used > peak {
+			peak = used
+		}
+	}
+	return peak
+}
+
+func codecNames() string {
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// doBenchmark measures the memory work takes to write n files to dst.
+func doBenchmark(n int, dst io.Writer, work func(dst io.Writer, bench benchkit.BenchKit) error) *benchkit.MemResult {
 	memkit, results := benchkit.Memory(n)
 
-	if err := benchmarkTar(n, size, dst, memkit); err != nil {
+	if err := work(dst, memkit); err != nil {
 		fatalf("failed to benchmark: %v", err)
 	}
 
@@ -135,23 +474,59 @@ func doBenchmark(n, size int, dst io.Writer) *benchkit.MemResult {
 	return results
 }
 
-func benchmarkTar(n, size int, dst io.Writer, bench benchkit.BenchKit) error {
+// benchmarkTar writes the files, generated from seed, in a tar stream to
+// dst, as they come through the link, compressed when there's a compressor. With buffer, all of the tar stream is written
+// in memory before going to the compressor.
+func benchmarkTar(specs []fileSpec, seed int64, link network, dst io.Writer, compressor taring.Compressor, buffer bool, bench benchkit.BenchKit) error {
 	bench.Setup()
-	files := GenTarFiles(n, size)
+	files := GenTarFiles(specs, randbo.NewFrom(mathrand.NewSource(seed)))
 	bench.Starting()
-	err := tarify(dst, files, bench.Each())
+	err := writeTar(dst, files, link, compressor, buffer, bench.Each())
 	bench.Teardown()
 	return err
 }
 
-func tarify(w io.Writer, objects []TarFile, each benchkit.BenchEach) error {
+func writeTar(dst io.Writer, files []TarFile, link network, compressor taring.Compressor, buffer bool, each benchkit.BenchEach) error {
+	var cw io.WriteCloser
+	if compressor != nil {
+		cw = compressor.NewWriter(dst)
+		dst = cw
+	}
+	if !buffer {
+		if err := tarify(dst, files, link, each); err != nil {
+			return err
+		}
+	} else {
+		buf := bytes.NewBuffer(nil)
+		if err := tarify(buf, files, link, each); err != nil {
+			return err
+		}
+		if _, err := io.Copy(dst, buf); err != nil {
+			return fmt.Errorf("writing tar buffer, %v", err)
+		}
+	}
+	if cw != nil {
+		if err := cw.Close(); err != nil {
+			return fmt.Errorf("closing compressor, %v", err)
+		}
+	}
+	return nil
+}
+
+func tarify(w io.Writer, objects []TarFile, link network, each benchkit.BenchEach) error {
 	tarw := tar.NewWriter(w)
 	for i, object := range objects {
 		each.Before(i)
 		if err := tarw.WriteHeader(object.TarHeader()); err != nil {
 			return fmt.Errorf("writing header of %q, %v", object.Name, err)
 		}
-		if _, err := tarw.Write(object.Data.Bytes()); err != nil {
+		var err error
+		if link.simulated() {
+			_, err = io.Copy(tarw, link.open(object.Data.Bytes()))
+		} else {
+			_, err = tarw.Write(object.Data.Bytes())
+		}
+		if err != nil {
 			return fmt.Errorf("writing content of %q, %v", object.Name, err)
 		}
 		each.After(i)
@@ -162,21 +537,19 @@ func tarify(w io.Writer, objects []TarFile, each benchkit.BenchEach) error {
 	return nil
 }
 
-var rand = randbo.NewFast()
-
-func GenTarFiles(n, size int) []TarFile {
-	files := make([]TarFile, n)
-	for i := range files {
-		files[i] = GenTarFile(i, size)
+func GenTarFiles(specs []fileSpec, rand io.Reader) []TarFile {
+	files := make([]TarFile, len(specs))
+	for i, spec := range specs {
+		files[i] = GenTarFile(spec.Name, spec.Size, rand)
 	}
 	return files
 }
 
-func GenTarFile(id, size int) TarFile {
+func GenTarFile(name string, size int, rand io.Reader) TarFile {
 	data := make([]byte, size)
-	_, _ = rand.Read(data)
+	_, _ = io.ReadFull(rand, data)
 	return TarFile{
-		Name:    strconv.Itoa(id),
+		Name:    name,
 		LastMod: time.Now(),
 		Data:    *bytes.NewBuffer(data),
 	}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/aybabtme/taring"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// fileSpec is a file to generate and tar.
+type fileSpec struct {
+	Name string
+	Size int
+}
+
+// numberedFiles names files of the sizes after their position.
+func numberedFiles(sizes []int) []fileSpec {
+	specs := make([]fileSpec, len(sizes))
+	for i, size := range sizes {
+		specs[i] = fileSpec{Name: strconv.Itoa(i), Size: size}
+	}
+	return specs
+}
+
+func totalSize(specs []fileSpec) int64 {
+	var total int64
+	for _, spec := range specs {
+		total += int64(spec.Size)
+	}
+	return total
+}
+
+// readWorkload reads the files of a bucket from what taring exported of
+// it: a manifest, or the CSV `taring list -csv` prints.
+func readWorkload(path string) ([]fileSpec, error) {
+	var specs []fileSpec
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		m, err := taring.ReadManifest(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range m.Objects {
+			name := obj.Name
+			if name == "" {
+				name = obj.Key
+			}
+			specs = append(specs, fileSpec{Name: name, Size: int(obj.Size)})
+		}
+	case ".csv":
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		specs, err = readListing(f)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("can't tell what %q is, must be a manifest ending in .json or a listing ending in .csv", path)
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("%q lists no files", path)
+	}
+	return specs, nil
+}
+
+// readListing reads the names and sizes of a `taring list -csv`.
+func readListing(r io.Reader) ([]fileSpec, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("reading listing, %v", err)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	nameCol, sizeCol := -1, -1
+	for i, col := range rows[0] {
+		switch strings.ToLower(col) {
+		case "name":
+			nameCol = i
+		case "size":
+			sizeCol = i
+		}
+	}
+	if nameCol < 0 || sizeCol < 0 {
+		return nil, fmt.Errorf("listing needs a name and a size column, got %v", rows[0])
+	}
+	specs := make([]fileSpec, 0, len(rows)-1)
+	for i, row := range rows[1:] {
+		size, err := strconv.Atoi(row[sizeCol])
+		if err != nil {
+			return nil, fmt.Errorf("size of line %d isn't a number, %v", i+2, err)
+		}
+		specs = append(specs, fileSpec{Name: row[nameCol], Size: size})
+	}
+	return specs, nil
+}
@@ -0,0 +1,216 @@
+package taring
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"net/url"
+	"time"
+)
+
+var (
+	archivesBucket = []byte("archives")
+	objectsBucket  = []byte("objects")
+)
+
+// ArchiveRecord describes one archive produced by a run.
+type ArchiveRecord struct {
+	Path    string    `json:"path"`
+	Source  string    `json:"source"`
+	Created time.Time `json:"created"`
+	Objects int       `json:"objects"`
+	Volumes int       `json:"volumes"`
+}
+
+// CatalogEntry records where an object went. The ETag stands in as the
+// object's checksum, and VersionID tells the versions of an object apart
+// in stores keeping several. Offset is the position of the entry's tar header in
+// the uncompressed tar stream of the archive and, for archives written
+// WithSeekableEntries, Compressed that of the gzip member starting with it
+// in the archive's file. For an archive split in volumes, both count from
+// the start of the first volume and Volume is the first one holding the
+// entry, which is the first volume when the entry can't be seeked to.
+type CatalogEntry struct {
+	Source  string    `json:"source"`
+	Key     string    `json:"key"`
+	ETag    string    `json:"etag"`
+	Size    int64     `json:"size"`
+	LastMod time.Time `json:"last_modified"`
+	// VersionID is empty for stores that don't keep versions.
+	VersionID string `json:"version_id,omitempty"`
+	Archive   string `json:"archive"`
+	Volume    int    `json:"volume"`
+	Member    string `json:"member"`
+	Offset    int64  `json:"offset"`
+	// Compressed is zero when the entry can't be seeked to.
+	Compressed int64 `json:"compressed,omitempty"`
+}
+
+// Catalog is a local database of which objects went into which archives,
+// kept across runs.
+type Catalog struct {
+	db *bolt.DB
+}
+
+// OpenCatalog opens the catalog at path, creating it if needed.
+func OpenCatalog(path string) (*Catalog, error) {
+	db, err := bolt.Open(path, filePerms, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening catalog %q, %v", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(archivesBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(objectsBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("preparing catalog %q, %v", path, err)
+	}
+	return &Catalog{db: db}, nil
+}
+
+// Close releases the catalog.
+func (c *Catalog) Close() error { return c.db.Close() }
+
+// Record saves an archive and the entries that went into it. Entries of an
+// object are kept for every archive it was ever written to.
+func (c *Catalog) Record(arch ArchiveRecord, entries []CatalogEntry) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		archData, err := json.Marshal(arch)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(archivesBucket).Put([]byte(arch.Path), archData); err != nil {
+			return err
+		}
+
+		objects := tx.Bucket(objectsBucket)
+		for _, entry := range entries {
+			var known []CatalogEntry
+			if data := objects.Get([]byte(entry.Source)); data != nil {
+				if err := json.Unmarshal(data, &known); err != nil {
+					return fmt.Errorf("decoding catalog entries of %q, %v", entry.Source, err)
+				}
+			}
+			known = append(withoutArchive(known, entry.Archive), entry)
+			data, err := json.Marshal(known)
+			if err != nil {
+				return err
+			}
+			if err := objects.Put([]byte(entry.Source), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Forget drops an archive and the entries that went into it, such as once
+// it's removed.
+func (c *Catalog) Forget(archive string) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(archivesBucket).Delete([]byte(archive)); err != nil {
+			return err
+		}
+		objects := tx.Bucket(objectsBucket)
+		kept := make(map[string][]CatalogEntry)
+		err := objects.ForEach(func(k, v []byte) error {
+			var entries []CatalogEntry
+			if err := json.Unmarshal(v, &entries); err != nil {
+				return fmt.Errorf("decoding catalog entries of %q, %v", k, err)
+			}
+			if left := withoutArchive(entries, archive); len(left) != len(entries) {
+				kept[string(k)] = left
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// the bucket can't change while it's iterated over
+		for source, entries := range kept {
+			if len(entries) == 0 {
+				if err := objects.Delete([]byte(source)); err != nil {
+					return err
+				}
+				continue
+			}
+			data, err := json.Marshal(entries)
+			if err != nil {
+				return err
+			}
+			if err := objects.Put([]byte(source), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Lookup returns every known entry of an object, oldest first: those of
+// versionID or, when it's empty, those of every version.
+func (c *Catalog) Lookup(source, versionID string) ([]CatalogEntry, error) {
+	var entries []CatalogEntry
+	err := c.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(objectsBucket).Get([]byte(source))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &entries)
+	})
+	if err != nil || versionID == "" {
+		return entries, err
+	}
+	kept := entries[:0]
+	for _, entry := range entries {
+		if entry.VersionID == versionID {
+			kept = append(kept, entry)
+		}
+	}
+	return kept, nil
+}
+
+// Walk calls fn with every entry of every object in the catalog, objects
+// in key order.
+func (c *Catalog) Walk(fn func(entry CatalogEntry) error) error {
+	return c.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(objectsBucket).ForEach(func(k, v []byte) error {
+			var entries []CatalogEntry
+			if err := json.Unmarshal(v, &entries); err != nil {
+				return fmt.Errorf("decoding catalog entries of %q, %v", k, err)
+			}
+			for _, entry := range entries {
+				if err := fn(entry); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// withoutArchive drops the entries written to archive, so that rewriting an
+// archive at the same path replaces what was known about it.
+func withoutArchive(entries []CatalogEntry, archive string) []CatalogEntry {
+	kept := entries[:0]
+	for _, entry := range entries {
+		if entry.Archive != archive {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// objectSource is the URL of key in the bucket of the source src, which
+// the catalog knows the object by: s3://bucket/key, gs://bucket/key,
+// az://container/key or wasb://container@account.blob.core.windows.net/key.
+func objectSource(src *url.URL, key string) string {
+	host := src.Host
+	if src.User != nil {
+		host = src.User.Username() + "@" + host
+	}
+	return src.Scheme + "://" + host + "/" + key
+}
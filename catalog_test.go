@@ -0,0 +1,130 @@
+package taring_test
+
+import (
+	"context"
+	"github.com/aybabtme/taring"
+	"github.com/aybabtme/taring/taringtest"
+	"github.com/crowdmob/goamz/aws"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunCatalog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db := filepath.Join(dir, "taring.db")
+	first, second := filepath.Join(dir, "first.tar.gz"), filepath.Join(dir, "second.tar.gz")
+	// the first archive is written twice, which replaces what's known of it
+	for _, archive := range []string{first, second, first} {
+		_, err := taring.New("s3://bucket/data/", archive, taring.WithStore(newStore()), taring.WithCatalog(db)).Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	catalog, err := taring.OpenCatalog(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer catalog.Close()
+	entries, err := catalog.Lookup("s3://bucket/data/logs/2016/01/app.log", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Archive != second || entries[1].Archive != first {
+		t.Fatalf("want the object in the second archive, then the first, got %+v", entries)
+	}
+	entry := entries[1]
+	if entry.Key != "data/logs/2016/01/app.log" || entry.Member != "logs/2016/01/app.log" || entry.Size != int64(len(fixture[entry.Key])) {
+		t.Errorf("want the object's key, member and size, got %+v", entry)
+	}
+	if entries, _ := catalog.Lookup("s3://bucket/elsewhere/ignored", ""); len(entries) != 0 {
+		t.Errorf("want nothing known of what wasn't archived, got %+v", entries)
+	}
+
+	var walked []string
+	err = catalog.Walk(func(entry taring.CatalogEntry) error {
+		if entry.Archive == first {
+			walked = append(walked, entry.Source)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for key := range fixture {
+		if strings.HasPrefix(key, "data/") {
+			want = append(want, "s3://bucket/"+key)
+		}
+	}
+	sort.Strings(want)
+	if strings.Join(walked, ",") != strings.Join(want, ",") {
+		t.Errorf("want %q walked in order, got %q", want, walked)
+	}
+}
+
+func TestRunCatalogVersions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a versioned bucket, whose readme is rewritten between the runs
+	store := newStore()
+	srv := httptest.NewServer(taringtest.S3Handler("bucket", store))
+	defer srv.Close()
+	db := filepath.Join(dir, "taring.db")
+	archives := []string{filepath.Join(dir, "first.tar.gz"), filepath.Join(dir, "second.tar.gz")}
+	versions := []string{"v1", "v2"}
+	for i, archive := range archives {
+		if i > 0 {
+			store.Put("data/readme.txt", []byte("hello again"), time.Now())
+		}
+		store.Version("data/readme.txt", versions[i])
+		_, err := taring.New("s3://bucket/data/", archive,
+			taring.WithAuth(aws.Auth{AccessKey: "access", SecretKey: "secret"}, taring.CustomS3Endpoint(aws.USEast, srv.URL, true)),
+			taring.WithCatalog(db),
+		).Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	catalog, err := taring.OpenCatalog(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer catalog.Close()
+	entries, err := catalog.Lookup("s3://bucket/data/readme.txt", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].VersionID != "v1" || entries[1].VersionID != "v2" {
+		t.Fatalf("want both versions of the object, got %+v", entries)
+	}
+	entries, err = catalog.Lookup("s3://bucket/data/readme.txt", "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Archive != archives[0] {
+		t.Errorf("want the first version in the first archive, got %+v", entries)
+	}
+	if entries, _ := catalog.Lookup("s3://bucket/data/readme.txt", "v3"); len(entries) != 0 {
+		t.Errorf("want nothing known of a version that wasn't archived, got %+v", entries)
+	}
+	if entries, _ := catalog.Lookup("s3://bucket/data/empty", ""); len(entries) != 2 || entries[0].VersionID != "" {
+		t.Errorf("want no version for objects without one, got %+v", entries)
+	}
+}
@@ -0,0 +1,89 @@
+package taring
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ChecksumError is an object whose content doesn't have the MD5 its ETag
+// says it has.
+type ChecksumError struct {
+	Key  string
+	ETag string
+	MD5  string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("content of %q has MD5 %s, not its ETag %s", e.Key, e.MD5, e.ETag)
+}
+
+// etagMD5 is the MD5 an ETag is the hex of, which it only is for objects
+// uploaded in a single part and not encrypted with KMS or a customer key.
+func etagMD5(etag string) (string, bool) {
+	etag = strings.Trim(etag, `"`)
+	if len(etag) != 2*md5.Size || strings.Contains(etag, "-") {
+		return "", false
+	}
+	if _, err := hex.DecodeString(etag); err != nil {
+		return "", false
+	}
+	return strings.ToLower(etag), true
+}
+
+// checkETag checks the content of an object has the MD5 of its ETag, when
+// it's one. Other ETags, such as those of objects uploaded in several
+// parts, can't be checked and pass: the MD5 of each part of those isn't
+// known without the sizes of the parts, which S3 doesn't tell.
+func checkETag(key ObjectInfo, data []byte) error {
+	sum := md5.Sum(data)
+	return checkMD5(key, sum[:])
+}
+
+// checkMD5 is checkETag for content whose MD5 is sum.
+func checkMD5(key ObjectInfo, sum []byte) error {
+	want, ok := etagMD5(key.ETag)
+	if !ok {
+		return nil
+	}
+	if got := hex.EncodeToString(sum); got != want {
+		return &ChecksumError{Key: key.Key, ETag: key.ETag, MD5: got}
+	}
+	return nil
+}
+
+// encryptedHeaders tells if S3 answered with an object encrypted with KMS
+// or with a customer key, whose ETag isn't its MD5 even when it looks like
+// one.
+func encryptedHeaders(h http.Header) bool {
+	return strings.HasPrefix(h.Get("X-Amz-Server-Side-Encryption"), "aws:kms") ||
+		h.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm") != ""
+}
+
+// etagCheckable tells if the content of an object opened as rc can be
+// checked against its ETag, which it can't when its store knows it's
+// encrypted in a way that makes its ETag something else.
+func etagCheckable(rc io.Reader) bool {
+	mr, ok := rc.(*metaReadCloser)
+	return !ok || !mr.encrypted
+}
+
+// encryptionTeller is a store that can tell if an object is encrypted in a
+// way that makes its ETag something else than its MD5.
+type encryptionTeller interface {
+	encrypted(ctx context.Context, key string) (bool, error)
+}
+
+// storeEncrypted tells if store says key is encrypted with KMS or a
+// customer key. Stores that can't tell never say it is.
+func storeEncrypted(ctx context.Context, store Fetcher, key string) (bool, error) {
+	et, ok := store.(encryptionTeller)
+	if !ok {
+		return false, nil
+	}
+	return et.encrypted(ctx, key)
+}
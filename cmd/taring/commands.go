@@ -0,0 +1,248 @@
+package main
+
+import (
+	"archive/tar"
+	"crypto/md5"
+	"encoding/csv"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"github.com/aybabtme/taring"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// commands are the subcommands of taring. Without one, taring archives a
+// bucket.
+var commands = map[string]func(args []string){
+	"find":           findCmd,
+	"list":           listCmd,
+	"mount":          mountCmd,
+	"restore-object": restoreObjectCmd,
+	"run":            runCmd,
+	"schedule":       scheduleCmd,
+	"serve":          serveCmd,
+	"server":         serverCmd,
+	"untar":          untarCmd,
+	"verify":         verifyCmd,
+	"watch":          watchCmd,
+}
+
+func findCmd(args []string) {
+	fs := flag.NewFlagSet("find", flag.ExitOnError)
+	catalogPath := fs.String("catalog", "taring.db", "a path to the catalog database to search")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: taring find [flags] <pattern>\n\n")
+		fmt.Fprintf(os.Stderr, "A pattern matches keys, or full `s3://bucket/key` URLs if it starts with `s3://`.\n\n")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+
+	if fs.NArg() != 1 {
+		fatalFlagSet(fs, "need exactly one pattern to search for.\n")
+	}
+	pattern := fs.Arg(0)
+	if _, err := path.Match(pattern, ""); err != nil {
+		fatalFlagSet(fs, "need a valid pattern, %v.\n", err)
+	}
+
+	catalog, err := taring.OpenCatalog(*catalogPath)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	defer func() { _ = catalog.Close() }()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOURCE\tVERSION\tARCHIVE\tVOLUME\tMEMBER\tOFFSET")
+	found := 0
+	err = catalog.Walk(func(entry taring.CatalogEntry) error {
+		subject := entry.Key
+		if strings.Contains(pattern, "://") {
+			subject = entry.Source
+		}
+		if ok, _ := path.Match(pattern, subject); !ok {
+			return nil
+		}
+		found++
+		version := entry.VersionID
+		if version == "" {
+			version = "-"
+		}
+		_, err := fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%d\n", entry.Source, version, entry.Archive, entry.Volume, entry.Member, entry.Offset)
+		return err
+	})
+	if err != nil {
+		fatalf("searching catalog %q, %v", *catalogPath, err)
+	}
+	if err := tw.Flush(); err != nil {
+		fatalf("printing results, %v", err)
+	}
+	infof("%d entries matching %q", found, pattern)
+}
+
+// restoreObjectArgs are what restore-object is given.
+type restoreObjectArgs struct {
+	catalog   string
+	dst       string
+	versionID string
+	objects   []string
+}
+
+// parseRestoreObject parses the flags of restore-object, which can come
+// before or after the object.
+func parseRestoreObject(args []string) (*flag.FlagSet, restoreObjectArgs) {
+	var ra restoreObjectArgs
+	fs := flag.NewFlagSet("restore-object", flag.ExitOnError)
+	fs.StringVar(&ra.catalog, "catalog", "taring.db", "a path to the catalog database to look the object up in")
+	fs.StringVar(&ra.dst, "o", "", "a path to write the object to, defaults to the object's base name")
+	fs.StringVar(&ra.versionID, "version-id", "", "the version of the object to restore, of a versioned bucket, defaults to the most recently archived")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: taring restore-object [flags] s3://bucket/key\n\nObjects of gs://, az:// and wasb:// sources are given the same way.\n\n")
+		fs.PrintDefaults()
+	}
+	ra.objects = parseInterspersed(fs, args)
+	return fs, ra
+}
+
+func restoreObjectCmd(args []string) {
+	fs, ra := parseRestoreObject(args)
+	if len(ra.objects) != 1 {
+		fatalFlagSet(fs, "need exactly one object to restore.\n")
+	}
+	source := ra.objects[0]
+	if !strings.Contains(source, "://") {
+		fatalFlagSet(fs, "need an object of the form `s3://bucket/key`, or gs://, az:// or wasb://, got %q.\n", source)
+	}
+	if ra.dst == "" {
+		ra.dst = path.Base(source)
+	}
+
+	catalog, err := taring.OpenCatalog(ra.catalog)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	entries, err := catalog.Lookup(source, ra.versionID)
+	_ = catalog.Close()
+	if err != nil {
+		fatalf("looking up %q in catalog %q, %v", source, ra.catalog, err)
+	}
+	if len(entries) == 0 && ra.versionID != "" {
+		fatalf("version %q of %q isn't in any archive known to catalog %q", ra.versionID, source, ra.catalog)
+	}
+	if len(entries) == 0 {
+		fatalf("%q isn't in any archive known to catalog %q", source, ra.catalog)
+	}
+	// the last entry is the most recent archive holding the object
+	entry := entries[len(entries)-1]
+
+	infof("restoring %q from %q (member %q at offset %d)", source, entry.Archive, entry.Member, entry.Offset)
+	if err := restoreEntry(entry, ra.dst); err != nil {
+		fatalf("restoring %q, %v", source, err)
+	}
+	infof("restored %q to %q", source, ra.dst)
+}
+
+func restoreEntry(entry taring.CatalogEntry, dst string) error {
+	arch, err := taring.OpenArchiveAt(entry.Archive, entry.Offset, entry.Compressed)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = arch.Close() }()
+
+	hdr, err := arch.Next()
+	if err != nil {
+		return fmt.Errorf("reading header at offset %d, %v", entry.Offset, err)
+	}
+	if hdr.Name != entry.Member {
+		return fmt.Errorf("expected member %q at offset %d, found %q; was the archive rewritten?", entry.Member, entry.Offset, hdr.Name)
+	}
+
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, filePerms)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, arch); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("extracting %q, %v", hdr.Name, err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, hdr.ModTime, hdr.ModTime)
+}
+
+func listCmd(args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	asCSV := fs.Bool("csv", false, "print the entries as CSV")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: taring list [flags] <archive.tar.gz>\n\n")
+		fs.PrintDefaults()
+	}
+	pos := parseInterspersed(fs, args)
+	if len(pos) != 1 {
+		fatalFlagSet(fs, "need exactly one archive to list.\n")
+	}
+	archive := pos[0]
+
+	arch, err := taring.OpenArchiveAt(archive, 0, 0)
+	if err != nil {
+		fatalf("opening archive, %v", err)
+	}
+	defer func() { _ = arch.Close() }()
+
+	columns := []string{"name", "size", "mtime", "md5", "source_key"}
+	var printRow func(row []string) error
+	var flush func() error
+	if *asCSV {
+		cw := csv.NewWriter(os.Stdout)
+		printRow, flush = cw.Write, func() error { cw.Flush(); return cw.Error() }
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		printRow = func(row []string) error {
+			_, err := fmt.Fprintln(tw, strings.Join(row, "\t"))
+			return err
+		}
+		flush = tw.Flush
+		for i, col := range columns {
+			columns[i] = strings.ToUpper(col)
+		}
+	}
+	if err := printRow(columns); err != nil {
+		fatalf("printing entries, %v", err)
+	}
+
+	for {
+		hdr, err := arch.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fatalf("reading archive %q, %v", archive, err)
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		sum := md5.New()
+		if _, err := io.Copy(sum, arch); err != nil {
+			fatalf("reading %q from archive %q, %v", hdr.Name, archive, err)
+		}
+		err = printRow([]string{
+			hdr.Name,
+			strconv.FormatInt(hdr.Size, 10),
+			hdr.ModTime.UTC().Format(time.RFC3339),
+			hex.EncodeToString(sum.Sum(nil)),
+			hdr.PAXRecords[taring.PAXKey],
+		})
+		if err != nil {
+			fatalf("printing entries, %v", err)
+		}
+	}
+	if err := flush(); err != nil {
+		fatalf("printing entries, %v", err)
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRestoreObject(t *testing.T) {
+	for _, args := range [][]string{
+		{"s3://bucket/key", "-o", "out"},
+		{"-o", "out", "s3://bucket/key"},
+	} {
+		_, ra := parseRestoreObject(args)
+		if want := []string{"s3://bucket/key"}; !reflect.DeepEqual(ra.objects, want) {
+			t.Errorf("%q: want objects %q, got %q", args, want, ra.objects)
+		}
+		if ra.dst != "out" {
+			t.Errorf("%q: want to restore to %q, got %q", args, "out", ra.dst)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/crowdmob/goamz/aws"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// loadJobConfig reads a job from a YAML file, whose keys are those of the
+// JSON API (`source`, `destination`, `keep_last`, ...).
+func loadJobConfig(path string) (JobConfig, error) {
+	cfg := JobConfig{AWSRegion: aws.USEast.Name}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("decoding job config %q, %v", path, err)
+	}
+	return cfg, nil
+}
+
+// profilesFile holds named jobs, and settings they all share:
+//
+//	defaults:
+//	  aws_region: eu-west-1
+//	jobs:
+//	  nightly-logs:
+//	    source: s3://mybucket/logs/
+//	    destination: /backups/{job}-{time}.tar.gz
+type profilesFile struct {
+	Defaults map[string]interface{}            `yaml:"defaults"`
+	Jobs     map[string]map[string]interface{} `yaml:"jobs"`
+}
+
+// loadProfile fills cfg with the defaults of the profiles file, then with
+// the settings of the named job. Settings absent from the file are left
+// as they were. The job's name is used as `job` unless it sets one.
+func loadProfile(path, name string, cfg *JobConfig) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var file profilesFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return fmt.Errorf("decoding config %q, %v", path, err)
+	}
+	job, ok := file.Jobs[name]
+	if !ok {
+		names := make([]string, 0, len(file.Jobs))
+		for n := range file.Jobs {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("no job %q in config %q, known jobs: %s", name, path, strings.Join(names, ", "))
+	}
+	cfg.Job = name
+	for _, settings := range []map[string]interface{}{file.Defaults, job} {
+		// round trip through YAML so settings are checked like a job file's
+		data, err := yaml.Marshal(settings)
+		if err != nil {
+			return err
+		}
+		if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+			return fmt.Errorf("decoding job %q of config %q, %v", name, path, err)
+		}
+	}
+	return nil
+}
+
+func runCmd(args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	configPath := fs.String("config", "taring.yaml", "a path to the YAML config defining the jobs")
+	var opts runOptions
+	opts.register(fs)
+	var flagCfg JobConfig
+	flagCfg.register(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: taring run [flags] <job>\n\n")
+		fmt.Fprintf(os.Stderr, "Runs a job of the config. Flags given override the job's settings.\n\n")
+		fs.PrintDefaults()
+	}
+	pos := parseInterspersed(fs, args)
+	if len(pos) != 1 {
+		fatalFlagSet(fs, "need exactly one job to run.\n")
+	}
+	name := pos[0]
+
+	// start from the defaults of the flags, then the config, then the flags
+	// that were given
+	var cfg JobConfig
+	merged := flag.NewFlagSet("run", flag.ContinueOnError)
+	cfg.register(merged)
+	if err := loadProfile(*configPath, name, &cfg); err != nil {
+		fatalf("%v", err)
+	}
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if merged.Lookup(f.Name) == nil || err != nil {
+			return
+		}
+		err = merged.Set(f.Name, f.Value.String())
+	})
+	if err != nil {
+		fatalFlagSet(fs, "%v.\n", err)
+	}
+	if err := cfg.validate(); err != nil {
+		fatalf("invalid job %q, %v", name, err)
+	}
+
+	runMain(cfg, opts)
+}
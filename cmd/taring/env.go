@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix prefixes the environment variables flags can be set from.
+const envPrefix = "TARING_"
+
+// envName is the environment variable a flag can be set from, e.g.
+// TARING_S3_PATH for -s3-path.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// applyEnv sets the flags that weren't given on the command line from
+// their environment variable, if it's set. Flags win over the environment,
+// which wins over defaults.
+func applyEnv(fs *flag.FlagSet) error {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || given[f.Name] {
+			return
+		}
+		name := envName(f.Name)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid value %q for %s, %v", value, name, setErr)
+		}
+	})
+	return err
+}
+
+// parseFlags parses the arguments of a subcommand, along with the logging
+// flags, then completes them from the environment.
+func parseFlags(fs *flag.FlagSet, args []string) {
+	registerLogFlags(fs)
+	_ = fs.Parse(args)
+	if err := applyEnv(fs); err != nil {
+		fatalFlagSet(fs, "%v.\n", err)
+	}
+	applyLogFlags()
+}
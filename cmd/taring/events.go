@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aybabtme/taring"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types.
+const (
+	eventKeyStarted = "key_started"
+	eventKeyDone    = "key_done"
+	eventKeyFailed  = "key_failed"
+	eventRunSummary = "run_summary"
+)
+
+// Event is a line of the NDJSON event stream.
+type Event struct {
+	Type     string             `json:"type"`
+	Time     time.Time          `json:"time"`
+	Key      string             `json:"key,omitempty"`
+	Size     int64              `json:"size,omitempty"`
+	Bytes    int64              `json:"bytes,omitempty"`
+	Duration string             `json:"duration,omitempty"`
+	Error    string             `json:"error,omitempty"`
+	Summary  *taring.RunSummary `json:"summary,omitempty"`
+}
+
+// openEvents opens where events go: `fd:N` for an inherited file
+// descriptor, `unix:path` for a Unix socket, or a file path.
+func openEvents(spec string) (io.WriteCloser, error) {
+	switch {
+	case strings.HasPrefix(spec, "fd:"):
+		fd, err := strconv.Atoi(strings.TrimPrefix(spec, "fd:"))
+		if err != nil || fd < 0 {
+			return nil, fmt.Errorf("not a file descriptor, %q", spec)
+		}
+		return os.NewFile(uintptr(fd), spec), nil
+	case strings.HasPrefix(spec, "unix:"):
+		return net.Dial("unix", strings.TrimPrefix(spec, "unix:"))
+	default:
+		return os.OpenFile(spec, os.O_WRONLY|os.O_CREATE|os.O_APPEND, filePerms)
+	}
+}
+
+// eventWriter writes events as NDJSON. A nil *eventWriter writes nothing.
+// It gives up on the first failure rather than failing the run.
+type eventWriter struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	failed bool
+}
+
+func newEventWriter(w io.Writer) *eventWriter {
+	return &eventWriter{enc: json.NewEncoder(w)}
+}
+
+// hooks write an event as the fetch of every key starts and ends.
+func (e *eventWriter) hooks() []taring.Option {
+	ended := func(f taring.ActiveFetch, err error) {
+		ev := Event{Type: eventKeyDone, Key: f.Key, Size: f.Size, Bytes: f.Read, Duration: time.Since(f.Started).String()}
+		if err != nil {
+			ev.Type, ev.Error = eventKeyFailed, taring.DescribeS3Error(err)
+		}
+		e.emit(ev)
+	}
+	return []taring.Option{
+		taring.WithOnObjectStart(func(f taring.ActiveFetch) {
+			e.emit(Event{Type: eventKeyStarted, Key: f.Key, Size: f.Size})
+		}),
+		taring.WithOnObjectDone(func(f taring.ActiveFetch) { ended(f, nil) }),
+		taring.WithOnObjectError(ended),
+	}
+}
+
+func (e *eventWriter) emit(ev Event) {
+	if e == nil {
+		return
+	}
+	ev.Time = time.Now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.failed {
+		return
+	}
+	if err := e.enc.Encode(ev); err != nil {
+		e.failed = true
+		errorf("writing events, %v; no more will be written", err)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"github.com/aybabtme/taring"
+	"github.com/aybabtme/taring/taringtest"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// archivedKeys runs the job of cfg over store and returns the keys that
+// went into the archive.
+func archivedKeys(t *testing.T, cfg JobConfig, store *taringtest.Store) []string {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	srv := httptest.NewServer(taringtest.S3Handler("bucket", store))
+	defer srv.Close()
+
+	cfg.AWSAccess, cfg.AWSSecret, cfg.AWSRegion = "access", "secret", "us-east-1"
+	cfg.S3Endpoint, cfg.S3PathStyle = srv.URL, true
+	cfg.Source = "s3://bucket/data/"
+	cfg.Destination = filepath.Join(dir, "archive.tar.gz")
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.archiver(nil).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	m, err := taring.ReadManifest(taring.ManifestPath(cfg.Destination))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, obj := range m.Objects {
+		keys = append(keys, obj.Key)
+	}
+	return keys
+}
+
+func TestRegexFilters(t *testing.T) {
+	store := new(taringtest.Store)
+	now := time.Now()
+	store.Put("data/readme.txt", []byte("hello"), now)
+	store.Put("data/logs/2016/01/app.log", []byte("january"), now)
+	store.Put("data/logs/2016/02/app.log", []byte("february"), now)
+	store.Put("data/logs/2016/02/web.log", []byte("february"), now)
+
+	cfg := JobConfig{
+		MatchRegex: `\.log$`,
+		SkipRegex:  `/2016/02/app\.`,
+	}
+	want := []string{"data/logs/2016/01/app.log", "data/logs/2016/02/web.log"}
+	if got := archivedKeys(t, cfg, store); !reflect.DeepEqual(want, got) {
+		t.Errorf("want keys %q, got %q", want, got)
+	}
+}
+
+func TestModifiedFilters(t *testing.T) {
+	store := new(taringtest.Store)
+	now := time.Now()
+	store.Put("data/old.txt", []byte("old"), now.AddDate(0, 0, -10))
+	store.Put("data/week.txt", []byte("week"), now.AddDate(0, 0, -5))
+	store.Put("data/recent.txt", []byte("recent"), now.Add(-time.Hour))
+
+	cfg := JobConfig{ModifiedAfter: "7d", ModifiedBefore: "72h"}
+	want := []string{"data/week.txt"}
+	if got := archivedKeys(t, cfg, store); !reflect.DeepEqual(want, got) {
+		t.Errorf("want keys %q, got %q", want, got)
+	}
+}
+
+func TestParseTimeBound(t *testing.T) {
+	now := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	for in, want := range map[string]time.Time{
+		"":                     {},
+		"2024-06-01T08:30:00Z": time.Date(2024, 6, 1, 8, 30, 0, 0, time.UTC),
+		"2024-06-01":           time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+		"72h":                  time.Date(2024, 6, 7, 12, 0, 0, 0, time.UTC),
+		"30d":                  time.Date(2024, 5, 11, 12, 0, 0, 0, time.UTC),
+	} {
+		got, err := parseTimeBound(in, now)
+		if err != nil {
+			t.Errorf("%q: %v", in, err)
+		} else if !got.Equal(want) {
+			t.Errorf("%q: want %v, got %v", in, want, got)
+		}
+	}
+	for _, in := range []string{"yesterday", "-3d", "-1h"} {
+		if _, err := parseTimeBound(in, now); err == nil {
+			t.Errorf("%q: want an error", in)
+		}
+	}
+}
+
+func TestSizeFilters(t *testing.T) {
+	store := new(taringtest.Store)
+	now := time.Now()
+	store.Put("data/logs/", nil, now)
+	store.Put("data/small.txt", []byte("hello"), now)
+	store.Put("data/big.txt", make([]byte, 2048), now)
+
+	cfg := JobConfig{MinSize: "1B", MaxSize: "1KB"}
+	want := []string{"data/small.txt"}
+	if got := archivedKeys(t, cfg, store); !reflect.DeepEqual(want, got) {
+		t.Errorf("want keys %q, got %q", want, got)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for in, want := range map[string]int64{
+		"":     0,
+		"1B":   1,
+		"10MB": 10 * 1000 * 1000,
+		"2MiB": 2 << 20,
+	} {
+		got, err := parseSize(in)
+		if err != nil {
+			t.Errorf("%q: %v", in, err)
+		} else if got != want {
+			t.Errorf("%q: want %d, got %d", in, want, got)
+		}
+	}
+	if _, err := parseSize("lots"); err == nil {
+		t.Error("want an error for a size that isn't one")
+	}
+}
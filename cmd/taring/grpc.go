@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/status"
+	"reflect"
+	"time"
+)
+
+// The gRPC job service exchanges the same messages as the REST API. They
+// are encoded as JSON rather than protobuf, so clients must call with the
+// `json` content-subtype (grpc.CallContentSubtype("json") in Go). Methods:
+//
+//	/taring.Jobs/Submit  SubmitRequest -> JobStatus
+//	/taring.Jobs/Get     JobRequest    -> JobStatus
+//	/taring.Jobs/List    ListRequest   -> ListResponse
+//	/taring.Jobs/Cancel  JobRequest    -> JobStatus
+//	/taring.Jobs/Watch   JobRequest    -> stream of JobStatus, until the job ends
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                               { return "json" }
+
+// SubmitRequest asks for a job to be run.
+type SubmitRequest struct {
+	Config JobConfig `json:"config"`
+}
+
+// JobRequest designates a job.
+type JobRequest struct {
+	ID string `json:"id"`
+}
+
+// ListRequest asks for all the jobs.
+type ListRequest struct{}
+
+// ListResponse holds all the jobs.
+type ListResponse struct {
+	Jobs []JobStatus `json:"jobs"`
+}
+
+// JobService is the gRPC job service.
+type JobService interface {
+	Submit(ctx context.Context, req *SubmitRequest) (*JobStatus, error)
+	Get(ctx context.Context, req *JobRequest) (*JobStatus, error)
+	List(ctx context.Context, req *ListRequest) (*ListResponse, error)
+	Cancel(ctx context.Context, req *JobRequest) (*JobStatus, error)
+	Watch(req *JobRequest, stream grpc.ServerStream) error
+}
+
+// watchInterval is how often progress is sampled for watchers.
+const watchInterval = time.Second
+
+type grpcJobService struct {
+	jobs *jobServer
+}
+
+func newGRPCServer(jobs *jobServer) *grpc.Server {
+	srv := grpc.NewServer()
+	srv.RegisterService(&jobServiceDesc, &grpcJobService{jobs: jobs})
+	return srv
+}
+
+func (g *grpcJobService) Submit(ctx context.Context, req *SubmitRequest) (*JobStatus, error) {
+	job, err := g.jobs.submit(req.Config)
+	if err == errDraining {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	} else if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	st := job.status()
+	return &st, nil
+}
+
+func (g *grpcJobService) Get(ctx context.Context, req *JobRequest) (*JobStatus, error) {
+	job, err := g.find(req.ID)
+	if err != nil {
+		return nil, err
+	}
+	st := job.status()
+	return &st, nil
+}
+
+func (g *grpcJobService) List(ctx context.Context, req *ListRequest) (*ListResponse, error) {
+	return &ListResponse{Jobs: g.jobs.list()}, nil
+}
+
+func (g *grpcJobService) Cancel(ctx context.Context, req *JobRequest) (*JobStatus, error) {
+	job, err := g.find(req.ID)
+	if err != nil {
+		return nil, err
+	}
+	job.cancel()
+	select {
+	case <-job.done:
+	case <-ctx.Done():
+		return nil, status.Error(codes.Canceled, ctx.Err().Error())
+	}
+	st := job.status()
+	return &st, nil
+}
+
+// Watch sends the status of the job whenever it changes, and a last time
+// once the job has ended.
+func (g *grpcJobService) Watch(req *JobRequest, stream grpc.ServerStream) error {
+	job, err := g.find(req.ID)
+	if err != nil {
+		return err
+	}
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	var last *JobStatus
+	for {
+		st := job.status()
+		if last == nil || !reflect.DeepEqual(*last, st) {
+			if err := stream.SendMsg(&st); err != nil {
+				return err
+			}
+			last = &st
+		}
+		if st.State != jobRunning {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-job.done:
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+func (g *grpcJobService) find(id string) (*serverJob, error) {
+	job, ok := g.jobs.get(id)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no job %q", id)
+	}
+	return job, nil
+}
+
+var jobServiceDesc = grpc.ServiceDesc{
+	ServiceName: "taring.Jobs",
+	HandlerType: (*JobService)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Submit", Handler: unaryHandler("Submit", func() interface{} { return new(SubmitRequest) },
+			func(s JobService, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.Submit(ctx, req.(*SubmitRequest))
+			})},
+		{MethodName: "Get", Handler: unaryHandler("Get", func() interface{} { return new(JobRequest) },
+			func(s JobService, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.Get(ctx, req.(*JobRequest))
+			})},
+		{MethodName: "List", Handler: unaryHandler("List", func() interface{} { return new(ListRequest) },
+			func(s JobService, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.List(ctx, req.(*ListRequest))
+			})},
+		{MethodName: "Cancel", Handler: unaryHandler("Cancel", func() interface{} { return new(JobRequest) },
+			func(s JobService, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.Cancel(ctx, req.(*JobRequest))
+			})},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			ServerStreams: true,
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				req := new(JobRequest)
+				if err := stream.RecvMsg(req); err != nil {
+					return err
+				}
+				return srv.(JobService).Watch(req, stream)
+			},
+		},
+	},
+}
+
+// unaryHandler adapts a method of JobService to what grpc expects of
+// generated code.
+func unaryHandler(
+	method string,
+	newReq func() interface{},
+	call func(s JobService, ctx context.Context, req interface{}) (interface{}, error),
+) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		req := newReq()
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return call(srv.(JobService), ctx, req)
+		}
+		info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/taring.Jobs/" + method}
+		return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return call(srv.(JobService), ctx, req)
+		})
+	}
+}
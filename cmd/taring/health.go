@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// health answers the liveness and readiness probes of a daemon, at
+// `/healthz` and `/readyz`.
+type health struct {
+	// stall is how long without a heartbeat before the daemon is deemed
+	// stuck, zero if it doesn't send heartbeats
+	stall time.Duration
+
+	mu       sync.Mutex
+	notReady string
+	beat     time.Time
+}
+
+func newHealth(stall time.Duration) *health {
+	return &health{stall: stall, notReady: "starting", beat: time.Now()}
+}
+
+// setReady marks the daemon ready to work.
+func (h *health) setReady() { h.setNotReady("") }
+
+// setNotReady marks the daemon unable to work for the given reason.
+func (h *health) setNotReady(reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.notReady = reason
+}
+
+// heartbeat records that the daemon is making progress.
+func (h *health) heartbeat() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.beat = time.Now()
+}
+
+func (h *health) register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", h.handleHealthz)
+	mux.HandleFunc("/readyz", h.handleReadyz)
+}
+
+func (h *health) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	since := time.Since(h.beat)
+	h.mu.Unlock()
+	if h.stall > 0 && since > h.stall {
+		writeError(w, http.StatusServiceUnavailable, fmt.Errorf("no progress in %v", since))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (h *health) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	reason := h.notReady
+	h.mu.Unlock()
+	if reason != "" {
+		writeError(w, http.StatusServiceUnavailable, errors.New(reason))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/aybabtme/taring"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// runHook runs a command line through the shell. Placeholders like
+// `{archive}` are replaced by their shell-quoted value.
+func runHook(cmdline string, vars map[string]string, env []string) error {
+	for name, value := range vars {
+		cmdline = strings.Replace(cmdline, "{"+name+"}", shellQuote(value), -1)
+	}
+	infof("running %q", cmdline)
+
+	stderr := new(bytes.Buffer)
+	cmd := exec.Command("sh", "-c", cmdline)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%q failed, %v: %s", cmdline, err, msg)
+		}
+		return fmt.Errorf("%q failed, %v", cmdline, err)
+	}
+	if stderr.Len() != 0 {
+		_, _ = os.Stderr.Write(stderr.Bytes())
+	}
+	return nil
+}
+
+// runPostCmd runs the post-run command with the run's paths as
+// placeholders and its summary in the environment.
+func runPostCmd(cmdline string, sum *taring.RunSummary) error {
+	summary, err := json.Marshal(sum)
+	if err != nil {
+		return err
+	}
+	vars := map[string]string{
+		"archive":  sum.Archive,
+		"manifest": taring.ManifestPath(sum.Archive),
+	}
+	env := []string{
+		"TARING_JOB=" + sum.Job,
+		"TARING_SOURCE=" + sum.Source,
+		"TARING_ARCHIVE=" + sum.Archive,
+		"TARING_MANIFEST=" + taring.ManifestPath(sum.Archive),
+		"TARING_STATUS=" + sum.Status,
+		"TARING_DURATION=" + sum.Duration,
+		"TARING_OBJECTS=" + strconv.Itoa(sum.Objects),
+		"TARING_BYTES=" + strconv.FormatInt(sum.Bytes, 10),
+		"TARING_ARCHIVE_BYTES=" + strconv.FormatInt(sum.ArchiveBytes, 10),
+		"TARING_SUMMARY=" + string(summary),
+	}
+	return runHook(cmdline, vars, env)
+}
+
+// runPreCmd runs the pre-run command, with the archive about to be written
+// as a placeholder.
+func runPreCmd(cmdline string, cfg JobConfig, archive string) error {
+	vars := map[string]string{"archive": archive}
+	env := []string{
+		"TARING_JOB=" + cfg.Job,
+		"TARING_SOURCE=" + cfg.Source,
+		"TARING_ARCHIVE=" + archive,
+	}
+	return runHook(cmdline, vars, env)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
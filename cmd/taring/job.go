@@ -0,0 +1,665 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/aybabtme/taring"
+	"github.com/crowdmob/goamz/aws"
+	"github.com/dustin/go-humanize"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JobConfig is everything needed to archive a bucket path.
+type JobConfig struct {
+	AWSAccess   string `json:"aws_access,omitempty" yaml:"aws_access,omitempty"`
+	AWSSecret   string `json:"aws_secret,omitempty" yaml:"aws_secret,omitempty"`
+	AWSRegion   string `json:"aws_region,omitempty" yaml:"aws_region,omitempty"`
+	AWSProfile  string `json:"aws_profile,omitempty" yaml:"aws_profile,omitempty"`
+	S3Endpoint  string `json:"s3_endpoint,omitempty" yaml:"s3_endpoint,omitempty"`
+	S3PathStyle bool   `json:"s3_path_style,omitempty" yaml:"s3_path_style,omitempty"`
+
+	AssumeRoleARN   string `json:"assume_role_arn,omitempty" yaml:"assume_role_arn,omitempty"`
+	ExternalID      string `json:"external_id,omitempty" yaml:"external_id,omitempty"`
+	RoleSessionName string `json:"role_session_name,omitempty" yaml:"role_session_name,omitempty"`
+	MFASerial       string `json:"mfa_serial,omitempty" yaml:"mfa_serial,omitempty"`
+	MFAToken        string `json:"mfa_token,omitempty" yaml:"mfa_token,omitempty"`
+
+	GCSToken string `json:"gcs_token,omitempty" yaml:"gcs_token,omitempty"`
+
+	AzureAccount string `json:"azure_account,omitempty" yaml:"azure_account,omitempty"`
+	AzureKey     string `json:"azure_key,omitempty" yaml:"azure_key,omitempty"`
+
+	Source          string `json:"source" yaml:"source"`
+	Plugin          string `json:"plugin,omitempty" yaml:"plugin,omitempty"`
+	Destination     string `json:"destination" yaml:"destination"`
+	Format          string `json:"format,omitempty" yaml:"format,omitempty"`
+	Compress        string `json:"compress,omitempty" yaml:"compress,omitempty"`
+	CompressWorkers int    `json:"compress_workers,omitempty" yaml:"compress_workers,omitempty"`
+	Job             string `json:"job,omitempty" yaml:"job,omitempty"`
+	SplitSize       string `json:"split_size,omitempty" yaml:"split_size,omitempty"`
+
+	KeepLast int `json:"keep_last,omitempty" yaml:"keep_last,omitempty"`
+	KeepDays int `json:"keep_days,omitempty" yaml:"keep_days,omitempty"`
+
+	TagArchived     string `json:"tag_archived,omitempty" yaml:"tag_archived,omitempty"`
+	SkipTagged      bool   `json:"skip_tagged,omitempty" yaml:"skip_tagged,omitempty"`
+	Catalog         string `json:"catalog,omitempty" yaml:"catalog,omitempty"`
+	ExcludeManifest string `json:"exclude_manifest,omitempty" yaml:"exclude_manifest,omitempty"`
+	SkipUnchanged   bool   `json:"skip_unchanged,omitempty" yaml:"skip_unchanged,omitempty"`
+	DryRun          bool   `json:"dry_run,omitempty" yaml:"dry_run,omitempty"`
+	EntryRatios     bool   `json:"entry_ratios,omitempty" yaml:"entry_ratios,omitempty"`
+	Seekable        bool   `json:"seekable,omitempty" yaml:"seekable,omitempty"`
+	ManifestCSV     bool   `json:"manifest_csv,omitempty" yaml:"manifest_csv,omitempty"`
+	SkipETagChecks  bool   `json:"skip_etag_checks,omitempty" yaml:"skip_etag_checks,omitempty"`
+	TopLargest      int    `json:"top_largest,omitempty" yaml:"top_largest,omitempty"`
+	Concurrency     int    `json:"concurrency,omitempty" yaml:"concurrency,omitempty"`
+	MaxAttempts     int    `json:"max_attempts,omitempty" yaml:"max_attempts,omitempty"`
+	SlowBelow       string `json:"slow_below,omitempty" yaml:"slow_below,omitempty"`
+	SlowRestarts    int    `json:"slow_restarts,omitempty" yaml:"slow_restarts,omitempty"`
+
+	Include []string `json:"include,omitempty" yaml:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty" yaml:"exclude,omitempty"`
+
+	MatchRegex string `json:"match_regex,omitempty" yaml:"match_regex,omitempty"`
+	SkipRegex  string `json:"skip_regex,omitempty" yaml:"skip_regex,omitempty"`
+
+	ModifiedAfter  string `json:"modified_after,omitempty" yaml:"modified_after,omitempty"`
+	ModifiedBefore string `json:"modified_before,omitempty" yaml:"modified_before,omitempty"`
+
+	MinSize string `json:"min_size,omitempty" yaml:"min_size,omitempty"`
+	MaxSize string `json:"max_size,omitempty" yaml:"max_size,omitempty"`
+
+	PreCmd  string `json:"pre_cmd,omitempty" yaml:"pre_cmd,omitempty"`
+	PostCmd string `json:"post_cmd,omitempty" yaml:"post_cmd,omitempty"`
+
+	WebhookURL    string `json:"webhook_url,omitempty" yaml:"webhook_url,omitempty"`
+	WebhookSecret string `json:"webhook_secret,omitempty" yaml:"webhook_secret,omitempty"`
+
+	SlackWebhook string `json:"slack_webhook,omitempty" yaml:"slack_webhook,omitempty"`
+
+	NotifyEmail  string `json:"notify_email,omitempty" yaml:"notify_email,omitempty"`
+	SMTPAddr     string `json:"smtp_addr,omitempty" yaml:"smtp_addr,omitempty"`
+	SMTPUser     string `json:"smtp_user,omitempty" yaml:"smtp_user,omitempty"`
+	SMTPPassword string `json:"smtp_password,omitempty" yaml:"smtp_password,omitempty"`
+	SMTPFrom     string `json:"smtp_from,omitempty" yaml:"smtp_from,omitempty"`
+
+	StatsdAddr string `json:"statsd_addr,omitempty" yaml:"statsd_addr,omitempty"`
+	StatsdTags string `json:"statsd_tags,omitempty" yaml:"statsd_tags,omitempty"`
+}
+
+func (c *JobConfig) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.AWSSecret, "aws-secret", "", "an AWS secret key")
+	fs.StringVar(&c.AWSAccess, "aws-access", "", "an AWS access key")
+	fs.StringVar(&c.AWSRegion, "aws-region", aws.USEast.Name, "an AWS region string")
+	fs.StringVar(&c.AWSProfile, "aws-profile", "", "a `profile` of the shared credentials file to take the AWS keys from, as the AWS CLI's `--profile`")
+	fs.StringVar(&c.S3Endpoint, "s3-endpoint", "", "the `URL` of an S3-compatible server to use instead of AWS, e.g. MinIO or Ceph RGW; any `aws-region` goes then")
+	fs.BoolVar(&c.S3PathStyle, "s3-path-style", false, "address buckets as `endpoint/bucket` rather than `bucket.endpoint`, as most S3-compatible servers need")
+	fs.StringVar(&c.AssumeRoleARN, "assume-role-arn", "", "the `ARN` of an IAM role to assume through STS, e.g. to read a bucket of another account")
+	fs.StringVar(&c.ExternalID, "external-id", "", "the external ID the trust policy of `assume-role-arn` requires, if any")
+	fs.StringVar(&c.RoleSessionName, "role-session-name", "taring", "the session name to assume `assume-role-arn` under, as CloudTrail shows it")
+	fs.StringVar(&c.MFASerial, "mfa-serial", "", "the serial number or `ARN` of the MFA device to authenticate with, for accounts that require MFA")
+	fs.StringVar(&c.MFAToken, "mfa-token", "", "the current code of `mfa-serial`, asked for on the terminal when not given")
+	fs.StringVar(&c.GCSToken, "gcs-token", "", "an OAuth2 access token to read from GCS with, e.g. from `gcloud auth print-access-token`; public buckets need none")
+	fs.StringVar(&c.AzureAccount, "azure-account", "", "an Azure storage account to read `az://` sources from")
+	fs.StringVar(&c.AzureKey, "azure-key", "", "the base64 key of the Azure storage account; public containers need none")
+	fs.StringVar(&c.Source, "s3-path", "", "a URL of the form `s3://bucketname/path/to/files`, `gs://bucketname/path/to/files` for Google Cloud Storage, or `az://container/path/to/files` for Azure Blob Storage")
+	fs.StringVar(&c.Plugin, "plugin", "", "a shell command serving the source over the plugin protocol, instead of reading it from S3; `s3-path` can then be of any `scheme://`")
+	fs.StringVar(&c.Destination, "tar-path", "bucket.tar.gz", "a path to save the TAR of what's at `s3-path`, `{job}` and `{time}` are replaced by the job name and the time of the run; `-` writes it to stdout, and an `s3://bucket/key` URL uploads it as it's written")
+	fs.StringVar(&c.Format, "format", "tar", "the format of the archive, `tar` for a gzipped tar or `zip` for a zip of deflated files")
+	fs.StringVar(&c.Compress, "compress", "gzip", "how to compress the tar archive, one of "+compressorNames()+"; name the `tar-path` after it, e.g. `.tar.zst`")
+	fs.IntVar(&c.CompressWorkers, "compress-workers", 1, "how many cores gzip compresses with, in parallel when more than one")
+	fs.StringVar(&c.Job, "job", "", "a name for this archiving job, used in `tar-path` and to find its previous archives")
+	fs.StringVar(&c.SplitSize, "split-size", "", "split the archive in volumes of at most this `size`, e.g. `4GB`, named after `tar-path` with .001, .002, ... appended")
+	fs.IntVar(&c.KeepLast, "keep-last", 0, "after a successful run, only keep this many of the job's most recent archives")
+	fs.IntVar(&c.KeepDays, "keep-days", 0, "after a successful run, remove the job's archives older than this many days")
+	fs.StringVar(&c.TagArchived, "tag-archived", "", "after a successful run, tag every archived object with `key=value`")
+	fs.BoolVar(&c.SkipTagged, "skip-tagged", false, "skip objects already bearing the `tag-archived` tag")
+	fs.StringVar(&c.Catalog, "catalog", "", "a path to a catalog database recording what went into which archive")
+	fs.StringVar(&c.ExcludeManifest, "exclude-manifest", "", "a path or `s3://` URL to a previous manifest, objects it holds with the same ETag are skipped")
+	fs.BoolVar(&c.SkipUnchanged, "skip-unchanged", false, "don't archive anything if the keys and ETags are the same as in the previous archive's manifest")
+	fs.BoolVar(&c.DryRun, "dry-run", false, "only list and filter the objects, and print how many and how large they are by top-level prefix, without fetching anything")
+	fs.Var((*listFlag)(&c.Include), "include", "a `glob` of the objects to archive, relative to `s3-path`, e.g. `2024/**/*.json`; one without a slash matches the last part of a name, and `**` any number of parts. Repeat it or separate globs with commas to include objects matching any")
+	fs.Var((*listFlag)(&c.Exclude), "exclude", "a `glob` of the objects not to archive even if included, e.g. `*.tmp`. Repeat it or separate globs with commas to exclude objects matching any")
+	fs.StringVar(&c.MatchRegex, "match-regex", "", "a `regexp` of the full keys of the objects to archive, e.g. `^logs/.*-(web|api)\\.log$`")
+	fs.StringVar(&c.SkipRegex, "skip-regex", "", "a `regexp` of the full keys of the objects not to archive even if they match `match-regex`")
+	fs.StringVar(&c.ModifiedAfter, "modified-after", "", "only archive objects last modified at or after this `time`, in RFC3339 like `2024-06-01T00:00:00Z`, as a date like `2024-06-01`, or as a duration before the run like `72h` or `30d`")
+	fs.StringVar(&c.ModifiedBefore, "modified-before", "", "only archive objects last modified before this `time`, given as for `modified-after`")
+	fs.StringVar(&c.MinSize, "min-size", "", "only archive objects of at least this `size`, e.g. `1B` to leave out empty marker objects")
+	fs.StringVar(&c.MaxSize, "max-size", "", "only archive objects of at most this `size`, e.g. `10GB` to leave out giant artifacts")
+	fs.BoolVar(&c.EntryRatios, "entry-ratios", false, "record the compressed size of every object in the manifest, at a small cost in compression")
+	fs.BoolVar(&c.Seekable, "seekable", false, "compress every object on its own, so that restore-object, mount and serve seek to it rather than decompress all before it, at a small cost in compression")
+	fs.BoolVar(&c.ManifestCSV, "manifest-csv", false, "also save the manifest as CSV next to the archive, with a .manifest.csv suffix")
+	fs.BoolVar(&c.SkipETagChecks, "skip-etag-checks", false, "don't check that objects have the MD5 of their ETag when fetched; objects uploaded in several parts, or encrypted with KMS or a customer key, are never checked")
+	fs.IntVar(&c.TopLargest, "top-largest", 10, "how many of the largest objects to log before fetching and list in the summary")
+	fs.IntVar(&c.Concurrency, "concurrency", 16, "how many objects to fetch at a time, which is also how many are held in memory while they wait to be archived")
+	fs.IntVar(&c.MaxAttempts, "max-attempts", 4, "how many times to try listing a page or fetching an object when S3 fails in a way that may not last, backing off in between; 1 never retries")
+	fs.StringVar(&c.SlowBelow, "slow-below", "", "a speed per second, e.g. `50KB`, under which a fetch is reported slow")
+	fs.IntVar(&c.SlowRestarts, "slow-restarts", 0, "how many times to abort a slow fetch and start it over")
+	fs.StringVar(&c.PreCmd, "pre-cmd", "", "a shell command to run before listing, the run is aborted if it fails; `{archive}` is replaced by the archive's path")
+	fs.StringVar(&c.PostCmd, "post-cmd", "", "a shell command to run after a successful run, `{archive}` and `{manifest}` are replaced by their paths and the summary is in TARING_* environment variables")
+	fs.StringVar(&c.WebhookURL, "webhook-url", "", "a URL to POST the run summary to when the run ends")
+	fs.StringVar(&c.SlackWebhook, "slack-webhook", "", "a Slack incoming webhook URL to post the run summary to when the run ends")
+	fs.StringVar(&c.NotifyEmail, "notify-email", "", "comma separated addresses to email the run summary to when the run ends")
+	fs.StringVar(&c.SMTPAddr, "smtp-addr", "", "the `host:port` of the SMTP server to send email through")
+	fs.StringVar(&c.SMTPUser, "smtp-user", "", "a user to authenticate with the SMTP server")
+	fs.StringVar(&c.SMTPPassword, "smtp-password", "", "a password to authenticate with the SMTP server")
+	fs.StringVar(&c.SMTPFrom, "smtp-from", "taring@localhost", "the address emails are sent from")
+	fs.StringVar(&c.WebhookSecret, "webhook-secret", "", "a key to sign webhook payloads with, as an HMAC-SHA256 in the `X-Taring-Signature` header")
+	fs.StringVar(&c.StatsdAddr, "statsd-addr", "", "the `host:port` of a statsd or dogstatsd server to send the run's metrics to")
+	fs.StringVar(&c.StatsdTags, "statsd-tags", "", "comma separated `key:value` tags to add to the metrics, besides the job and bucket")
+}
+
+// compressors are what `compress` can name.
+var compressors = map[string]taring.Compressor{
+	"gzip": taring.Gzip{},
+	"zstd": taring.Zstd{},
+	"xz":   taring.Xz{},
+	"lz4":  taring.Lz4{},
+}
+
+func compressorNames() string {
+	names := make([]string, 0, len(compressors))
+	for name := range compressors {
+		names = append(names, "`"+name+"`")
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// knownSchemes are those of the sources taring reads without a plugin.
+var knownSchemes = map[string]bool{"s3": true, "gs": true, "az": true, "wasb": true, "wasbs": true}
+
+// stdoutPath is the `tar-path` that writes the archive to stdout.
+const stdoutPath = "-"
+
+func (c *JobConfig) validate() error {
+	_, regionOk := aws.Regions[c.AWSRegion]
+	var scheme string // of a source read without a plugin
+	if c.Plugin == "" {
+		scheme = strings.SplitN(c.Source, "://", 2)[0]
+	}
+	switch {
+	case c.AWSAccess != "" && c.AWSSecret == "":
+		return errors.New("need an AWS secret key")
+	case c.AWSAccess == "" && c.AWSSecret != "":
+		return errors.New("need an AWS access key")
+	case c.AWSProfile != "" && c.AWSAccess != "":
+		return errors.New("need either AWS keys or a profile, `aws-profile` can't be used with `aws-access`")
+	case !regionOk && c.S3Endpoint == "":
+		return fmt.Errorf("need a valid AWS region, %q is not a valid one", c.AWSRegion)
+	case c.AssumeRoleARN == "" && c.ExternalID != "":
+		return errors.New("need a role to assume, `external-id` requires `assume-role-arn`")
+	case c.MFASerial == "" && c.MFAToken != "":
+		return errors.New("need an MFA device, `mfa-token` requires `mfa-serial`")
+	case c.AssumeRoleARN != "" && !strings.HasPrefix(c.AssumeRoleARN, "arn:"):
+		return fmt.Errorf("need a role ARN of the form `arn:aws:iam::account:role/name`, got %q", c.AssumeRoleARN)
+	case c.Source == "":
+		return errors.New("need bucket path to read from")
+	case scheme != "" && !knownSchemes[scheme]:
+		return fmt.Errorf("need a bucket path of the form `s3://bucketname/path`, `gs://bucketname/path` or `az://container/path`, got %q", c.Source)
+	case scheme == "az" && c.AzureAccount == "":
+		return errors.New("need an Azure storage account, `az://` sources require `azure-account`")
+	case scheme != "" && scheme != "s3" && c.TagArchived != "":
+		return fmt.Errorf("need S3 to tag objects, `tag-archived` can't be used with a `%s://` source", scheme)
+	case c.Plugin != "" && !strings.Contains(c.Source, "://"):
+		return fmt.Errorf("need a source path of the form `scheme://name/path`, got %q", c.Source)
+	case c.Plugin != "" && c.TagArchived != "":
+		return errors.New("need S3 to tag objects, `tag-archived` can't be used with `plugin`")
+	case c.Destination == "":
+		return errors.New("need filepath to write TAR archive to")
+	case c.Format != "" && c.Format != "tar" && c.Format != "zip":
+		return fmt.Errorf("need a `format` of `tar` or `zip`, got %q", c.Format)
+	case c.Compress != "" && compressors[c.Compress] == nil:
+		return fmt.Errorf("need a `compress` of %s, got %q", compressorNames(), c.Compress)
+	case c.CompressWorkers < 0:
+		return errors.New("need a positive number of workers, `compress-workers` can't be negative")
+	case c.CompressWorkers > 1 && (c.Format == "zip" || c.Compress != "" && c.Compress != "gzip"):
+		return errors.New("need gzip to compress in parallel, `compress-workers` only applies to `compress=gzip`")
+	case c.EntryRatios && c.Compress == "xz":
+		return errors.New("need a compressor that can flush to tell entries apart, `entry-ratios` can't be used with `compress=xz`")
+	case c.Seekable && (c.Format == "zip" || c.Compress != "" && c.Compress != "gzip"):
+		return errors.New("need a tar archive compressed with gzip, `seekable` only applies to `compress=gzip`")
+	case c.Format == "zip" && c.Compress != "" && c.Compress != "gzip":
+		return errors.New("need a tar archive to compress, zip compresses its files itself")
+	case c.Format == "zip" && c.Catalog != "":
+		return errors.New("need a tar archive to restore from, `catalog` can't be used with `format=zip`")
+	case c.SkipTagged && c.TagArchived == "":
+		return errors.New("need a tag to skip, `skip-tagged` requires `tag-archived`")
+	case c.SlowRestarts < 0:
+		return errors.New("need a positive number of restarts, `slow-restarts` can't be negative")
+	case c.SlowRestarts > 0 && c.SlowBelow == "":
+		return errors.New("need a speed to tell slow fetches, `slow-restarts` requires `slow-below`")
+	case c.Concurrency < 0:
+		return errors.New("need a positive number of objects to fetch at a time, `concurrency` can't be negative")
+	case c.MaxAttempts < 0:
+		return errors.New("need a positive number of attempts, `max-attempts` can't be negative")
+	case c.TopLargest < 0:
+		return errors.New("need a positive number of largest objects to report, `top-largest` can't be negative")
+	case c.KeepLast < 0 || c.KeepDays < 0:
+		return errors.New("need a positive retention, `keep-last` and `keep-days` can't be negative")
+	case c.Destination == stdoutPath && (c.Catalog != "" || c.SkipUnchanged || c.ManifestCSV):
+		return errors.New("need an archive file, `catalog`, `skip-unchanged` and `manifest-csv` can't be used when writing to stdout")
+	case strings.HasPrefix(c.Destination, "s3://") && c.SkipUnchanged:
+		return errors.New("need local archives, `skip-unchanged` can't be used when uploading to S3")
+	case c.SplitSize != "" && (c.Destination == stdoutPath || c.KeepLast > 0 || c.KeepDays > 0):
+		return errors.New("need a single archive file, `split-size` can't be used with stdout, `keep-last` or `keep-days`")
+	case (c.KeepLast > 0 || c.KeepDays > 0) && !strings.Contains(c.Destination, taring.TimePlaceholder):
+		return fmt.Errorf("need a %s placeholder in `tar-path` to keep several archives", taring.TimePlaceholder)
+	case (c.KeepLast > 0 || c.KeepDays > 0) && (c.Job == "" || !strings.Contains(c.Destination, taring.JobPlaceholder)):
+		return fmt.Errorf("need a `job` and a %s placeholder in `tar-path` to only prune the job's own archives", taring.JobPlaceholder)
+	case (c.KeepLast > 0 || c.KeepDays > 0) && strings.Contains(c.Destination, "://") && !strings.HasPrefix(c.Destination, "s3://"):
+		return fmt.Errorf("need a local or s3:// `tar-path` to prune archives, got %q", c.Destination)
+	case c.WebhookSecret != "" && c.WebhookURL == "":
+		return errors.New("need a webhook to sign, `webhook-secret` requires `webhook-url`")
+	case c.NotifyEmail != "" && c.SMTPAddr == "":
+		return errors.New("need an SMTP server to send email, `notify-email` requires `smtp-addr`")
+	}
+	if c.TagArchived != "" {
+		if _, err := taring.ParseTag(c.TagArchived); err != nil {
+			return fmt.Errorf("need a valid tag, %v", err)
+		}
+	}
+	if c.S3Endpoint != "" {
+		if u, err := url.Parse(c.S3Endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("need an `s3-endpoint` of the form `https://host:port`, got %q", c.S3Endpoint)
+		}
+	}
+	if _, err := parseSpeed(c.SlowBelow); err != nil {
+		return fmt.Errorf("need a valid `slow-below`, %v", err)
+	}
+	if _, err := parseGlobs(c.Include); err != nil {
+		return fmt.Errorf("need valid `include` globs, %v", err)
+	}
+	if _, err := parseGlobs(c.Exclude); err != nil {
+		return fmt.Errorf("need valid `exclude` globs, %v", err)
+	}
+	if _, err := regexp.Compile(c.MatchRegex); err != nil {
+		return fmt.Errorf("need a valid `match-regex`, %v", err)
+	}
+	if _, err := regexp.Compile(c.SkipRegex); err != nil {
+		return fmt.Errorf("need a valid `skip-regex`, %v", err)
+	}
+	now := time.Now()
+	after, err := parseTimeBound(c.ModifiedAfter, now)
+	if err != nil {
+		return fmt.Errorf("need a valid `modified-after`, %v", err)
+	}
+	before, err := parseTimeBound(c.ModifiedBefore, now)
+	if err != nil {
+		return fmt.Errorf("need a valid `modified-before`, %v", err)
+	}
+	if !after.IsZero() && !before.IsZero() && !after.Before(before) {
+		return errors.New("need a time window, `modified-after` must be before `modified-before`")
+	}
+	minSize, err := parseSize(c.MinSize)
+	if err != nil {
+		return fmt.Errorf("need a valid `min-size`, %v", err)
+	}
+	maxSize, err := parseSize(c.MaxSize)
+	if err != nil {
+		return fmt.Errorf("need a valid `max-size`, %v", err)
+	}
+	if c.MaxSize != "" && minSize > maxSize {
+		return errors.New("need a range of sizes, `min-size` can't be over `max-size`")
+	}
+	if splitSize, err := parseSize(c.SplitSize); err != nil {
+		return fmt.Errorf("need a valid `split-size`, %v", err)
+	} else if c.SplitSize != "" && splitSize == 0 {
+		return errors.New("need volumes of some size, `split-size` can't be zero")
+	}
+	return nil
+}
+
+// parseSize parses a size such as 10MB. Empty is zero.
+func parseSize(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	size, err := humanize.ParseBytes(s)
+	if err != nil {
+		return 0, fmt.Errorf("not a size, %q: %v", s, err)
+	}
+	return int64(size), nil
+}
+
+// parseTimeBound parses a time in RFC3339, a date, or a duration before
+// now such as 72h or 30d. Empty is the zero time.
+func parseTimeBound(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil || days < 0 {
+			return time.Time{}, fmt.Errorf("not a time, %q", s)
+		}
+		return now.AddDate(0, 0, -days), nil
+	}
+	ago, err := time.ParseDuration(s)
+	if err != nil || ago < 0 {
+		return time.Time{}, fmt.Errorf("not a time, %q", s)
+	}
+	return now.Add(-ago), nil
+}
+
+// listFlag is a flag that can be repeated, or given comma separated
+// values.
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, ",") }
+
+func (l *listFlag) Set(s string) error {
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+func parseGlobs(patterns []string) ([]taring.Glob, error) {
+	globs := make([]taring.Glob, 0, len(patterns))
+	for _, p := range patterns {
+		g, err := taring.ParseGlob(p)
+		if err != nil {
+			return nil, err
+		}
+		globs = append(globs, g)
+	}
+	return globs, nil
+}
+
+// parseSpeed parses a speed such as 50KB, per second. Empty is zero.
+func parseSpeed(s string) (float64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	rate, err := humanize.ParseBytes(s)
+	if err != nil {
+		return 0, fmt.Errorf("not a speed, %q: %v", s, err)
+	}
+	return float64(rate), nil
+}
+
+// awsAuth is the keys of the config or of its profile, or else the credentials
+// of the role the process runs as, exchanged for those of the role to assume
+// if any.
+func (c *JobConfig) awsAuth(ctx context.Context) (aws.Auth, error) {
+	return c.credentials()(ctx)
+}
+
+// credentials gets what awsAuth is, anew on every call but for an MFA
+// session, which lasts as long as STS allows.
+func (c *JobConfig) credentials() func(ctx context.Context) (aws.Auth, error) {
+	base := taring.InstanceCredentials(nil)
+	if c.AWSProfile != "" {
+		base = taring.SharedCredentials("", c.AWSProfile)
+	}
+	if c.AWSAccess != "" {
+		auth := aws.Auth{AccessKey: c.AWSAccess, SecretKey: c.AWSSecret}
+		base = func(context.Context) (aws.Auth, error) { return auth, nil }
+	}
+	if c.MFASerial != "" {
+		base = c.mfaSession(base)
+	}
+	if c.AssumeRoleARN == "" {
+		return base
+	}
+	role := taring.Role{ARN: c.AssumeRoleARN, ExternalID: c.ExternalID, SessionName: c.RoleSessionName}
+	return taring.AssumeRole(role, base, c.region(), nil)
+}
+
+// mfaSessions are the MFA sessions of the process, by keys and device, so
+// scheduled runs share them rather than each ask for a code.
+var mfaSessions = struct {
+	sync.Mutex
+	byDevice map[string]func(ctx context.Context) (aws.Auth, error)
+}{byDevice: make(map[string]func(ctx context.Context) (aws.Auth, error))}
+
+// mfaSession is the MFA session of the config's device, started as base
+// credentials with the config's code and then with those asked for on the
+// terminal.
+func (c *JobConfig) mfaSession(base func(ctx context.Context) (aws.Auth, error)) func(ctx context.Context) (aws.Auth, error) {
+	mfaSessions.Lock()
+	defer mfaSessions.Unlock()
+	device := c.AWSAccess + "/" + c.MFASerial
+	if session, ok := mfaSessions.byDevice[device]; ok {
+		return session
+	}
+	// the session is shared by jobs running at once, which ask for codes
+	// one at a time, and only the first uses the given one
+	var mu sync.Mutex
+	given := c.MFAToken
+	token := func() (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if given != "" {
+			code := given
+			given = ""
+			return code, nil
+		}
+		return promptMFA(c.MFASerial)
+	}
+	session := taring.MFASession(c.MFASerial, token, base, c.region(), nil)
+	mfaSessions.byDevice[device] = session
+	return session
+}
+
+// promptMFA asks for a code of the MFA device serial on the terminal.
+func promptMFA(serial string) (string, error) {
+	if fi, err := os.Stdin.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return "", errors.New("no terminal to ask for one on, give `mfa-token`")
+	}
+	fmt.Fprintf(os.Stderr, "MFA code for %s: ", serial)
+	code, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(code), nil
+}
+
+// region is where S3 is. The config must be valid.
+func (c *JobConfig) region() aws.Region {
+	region, ok := aws.Regions[c.AWSRegion]
+	if !ok {
+		region = aws.Region{Name: c.AWSRegion}
+	}
+	if c.S3Endpoint != "" {
+		return taring.CustomS3Endpoint(region, c.S3Endpoint, c.S3PathStyle)
+	}
+	if c.S3PathStyle {
+		region.S3BucketEndpoint = ""
+	}
+	return region
+}
+
+// archiver sets up an archiver for what the config describes. The config
+// must be valid.
+func (c *JobConfig) archiver(progress *taring.JobProgress, hooks ...taring.Option) *taring.Archiver {
+	auth := aws.Auth{
+		AccessKey: c.AWSAccess,
+		SecretKey: c.AWSSecret,
+	}
+	tag, _ := taring.ParseTag(c.TagArchived)
+	slowBelow, _ := parseSpeed(c.SlowBelow)
+	include, _ := parseGlobs(c.Include)
+	exclude, _ := parseGlobs(c.Exclude)
+	opts := []taring.Option{
+		taring.WithAuth(auth, c.region()),
+		taring.WithJob(c.Job),
+		taring.WithRetention(c.KeepLast, c.KeepDays),
+		taring.WithTagArchived(tag, c.SkipTagged),
+		taring.WithCatalog(c.Catalog),
+		taring.WithExcludeManifest(c.ExcludeManifest),
+		taring.WithTopLargest(c.TopLargest),
+		taring.WithConcurrency(c.Concurrency),
+		taring.WithRetries(c.MaxAttempts, 0),
+		taring.WithSlowFetches(slowBelow, c.SlowRestarts),
+		taring.WithInclude(include...),
+		taring.WithExclude(exclude...),
+		taring.WithProgress(progress),
+		taring.WithLogger(logger{}),
+	}
+	usesS3 := c.Plugin == "" && strings.HasPrefix(c.Source, "s3://") || strings.HasPrefix(c.Destination, "s3://")
+	if c.AssumeRoleARN != "" || c.MFASerial != "" || c.AWSProfile != "" || c.AWSAccess == "" && usesS3 {
+		opts = append(opts, taring.WithCredentials(c.credentials()))
+	}
+	if c.GCSToken != "" && strings.HasPrefix(c.Source, "gs://") {
+		opts = append(opts, taring.WithTransportMiddleware(bearerToken(c.GCSToken)))
+	}
+	if c.AzureAccount != "" || c.AzureKey != "" {
+		opts = append(opts, taring.WithAzure(c.AzureAccount, c.AzureKey))
+	}
+	if c.Format == "zip" {
+		opts = append(opts, taring.WithContainer(taring.NewZipWriter), taring.WithCompressor(taring.Uncompressed{}))
+	} else if c.Compress == "gzip" && c.CompressWorkers > 1 {
+		opts = append(opts, taring.WithCompressor(taring.Gzip{Workers: c.CompressWorkers}))
+	} else if c.Compress != "" {
+		opts = append(opts, taring.WithCompressor(compressors[c.Compress]))
+	}
+	if c.MatchRegex != "" {
+		match := regexp.MustCompile(c.MatchRegex)
+		opts = append(opts, taring.WithFilter(func(obj taring.ObjectInfo) bool { return match.MatchString(obj.Key) }))
+	}
+	if c.SkipRegex != "" {
+		skip := regexp.MustCompile(c.SkipRegex)
+		opts = append(opts, taring.WithFilter(func(obj taring.ObjectInfo) bool { return !skip.MatchString(obj.Key) }))
+	}
+	// relative to the start of every run
+	now := time.Now()
+	if after, _ := parseTimeBound(c.ModifiedAfter, now); !after.IsZero() {
+		opts = append(opts, taring.WithFilter(func(obj taring.ObjectInfo) bool { return !obj.LastModified.Before(after) }))
+	}
+	if before, _ := parseTimeBound(c.ModifiedBefore, now); !before.IsZero() {
+		opts = append(opts, taring.WithFilter(func(obj taring.ObjectInfo) bool { return obj.LastModified.Before(before) }))
+	}
+	if minSize, _ := parseSize(c.MinSize); minSize > 0 {
+		opts = append(opts, taring.WithFilter(func(obj taring.ObjectInfo) bool { return obj.Size >= minSize }))
+	}
+	if c.MaxSize != "" {
+		maxSize, _ := parseSize(c.MaxSize)
+		opts = append(opts, taring.WithFilter(func(obj taring.ObjectInfo) bool { return obj.Size <= maxSize }))
+	}
+	if c.DryRun {
+		opts = append(opts, taring.WithDryRun())
+	}
+	if c.SkipUnchanged {
+		opts = append(opts, taring.WithSkipUnchanged())
+	}
+	if c.EntryRatios {
+		opts = append(opts, taring.WithEntryRatios())
+	}
+	if c.Seekable {
+		opts = append(opts, taring.WithSeekableEntries())
+	}
+	if c.ManifestCSV {
+		opts = append(opts, taring.WithManifestCSV())
+	}
+	if !c.SkipETagChecks {
+		opts = append(opts, taring.WithETagChecks())
+	}
+	if splitSize, _ := parseSize(c.SplitSize); splitSize > 0 {
+		opts = append(opts, taring.WithSplitSize(splitSize))
+	}
+	if c.PreCmd != "" {
+		opts = append(opts, taring.WithBeforeListing(func(_ context.Context, archive string) error {
+			progress.SetPhase("pre-cmd")
+			if err := runPreCmd(c.PreCmd, *c, archive); err != nil {
+				return fmt.Errorf("running pre-cmd, %v", err)
+			}
+			return nil
+		}))
+	}
+	return taring.New(c.Source, c.Destination, append(opts, hooks...)...)
+}
+
+// bearerToken authorizes requests with an OAuth2 access token.
+func bearerToken(token string) func(http.RoundTripper) http.RoundTripper {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			req = req.Clone(req.Context())
+			req.Header.Set("Authorization", "Bearer "+token)
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (fn roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return fn(req) }
+
+// runJob archives what the config describes, then sends the notifications
+// the config asks for. The config must be valid. Once the run ends, its
+// summary is saved next to its archive, even when it failed. The hooks are told how the run
+// goes.
+func runJob(ctx context.Context, cfg JobConfig, progress *taring.JobProgress, hooks ...taring.Option) (*taring.RunSummary, error) {
+	if progress == nil {
+		progress = new(taring.JobProgress)
+	}
+	if cfg.Plugin != "" {
+		store := pluginStore(cfg.Plugin)
+		defer func() {
+			if err := store.Close(); err != nil {
+				errorf("stopping plugin, %v", err)
+			}
+		}()
+		hooks = append([]taring.Option{taring.WithStore(store)}, hooks...)
+	}
+	var (
+		a   = cfg.archiver(progress, hooks...)
+		sum *taring.RunSummary
+		err error
+	)
+	if cfg.Destination == stdoutPath {
+		sum, err = a.RunTo(ctx, os.Stdout)
+	} else {
+		sum, err = a.Run(ctx)
+	}
+	if sum.Status == taring.StatusDryRun {
+		return sum, err
+	}
+	if err == nil && sum.Status == taring.StatusSucceeded && cfg.PostCmd != "" {
+		progress.SetPhase("post-cmd")
+		if err = runPostCmd(cfg.PostCmd, sum); err != nil {
+			err = fmt.Errorf("running post-cmd, %v", err)
+		}
+		progress.Summarize(sum)
+		sum.Finish(err)
+	}
+	// failed runs are summarized too, but an unchanged bucket wrote nothing
+	// to be next to
+	if sum.Status != taring.StatusUnchanged {
+		if err := a.SaveSummary(ctx, sum); err != nil {
+			errorf("saving run summary, %v", err)
+		}
+	}
+	notify(cfg, sum)
+	emitMetrics(cfg, sum)
+	return sum, err
+}
+
+// pluginStore serves a source with a plugin run through the shell. What the
+// plugin says on its stderr goes to ours.
+func pluginStore(cmdline string) *taring.PluginStore {
+	cmd := exec.Command("sh", "-c", cmdline)
+	cmd.Stderr = os.Stderr
+	return taring.NewPluginStore(cmd)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aybabtme/taring"
+	"sync"
+	"time"
+)
+
+// With `-log-format json`, log lines are written as JSON objects, one per
+// line, with the phase of the run and, for objects, their key, bytes and
+// how long they took.
+var (
+	toJSON bool
+
+	jsonMu    sync.Mutex
+	jsonPhase string
+)
+
+// jsonLine is a log line in the JSON format.
+type jsonLine struct {
+	Time     time.Time `json:"time"`
+	Level    string    `json:"level"`
+	Msg      string    `json:"msg"`
+	Job      string    `json:"job,omitempty"`
+	Source   string    `json:"source,omitempty"`
+	Phase    string    `json:"phase,omitempty"`
+	Key      string    `json:"key,omitempty"`
+	Bytes    int64     `json:"bytes,omitempty"`
+	Duration string    `json:"duration,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+func logJSON(level, format string, args ...interface{}) {
+	writeLogLine(jsonLine{Level: level, Msg: fmt.Sprintf(format, args...)})
+}
+
+// writeLogLine writes a log line, along with the job and source the journal
+// would get and the phase of the run.
+func writeLogLine(line jsonLine) {
+	line.Time = time.Now()
+	journalMu.Lock()
+	line.Job, line.Source = journalFields["TARING_JOB"], journalFields["TARING_SOURCE"]
+	journalMu.Unlock()
+
+	jsonMu.Lock()
+	defer jsonMu.Unlock()
+	line.Phase = jsonPhase
+	data, err := json.Marshal(line)
+	if err != nil {
+		elog.Printf("[json] %s", line.Msg)
+		return
+	}
+	_, _ = logOutput.Write(append(data, '\n'))
+}
+
+// jsonLogHooks log every change of phase, and every object fetched with
+// `-verbose` or that failed.
+func jsonLogHooks() []taring.Option {
+	ended := func(f taring.ActiveFetch, err error) {
+		line := jsonLine{Level: "verbose", Msg: "fetched", Key: f.Key, Bytes: f.Read, Duration: time.Since(f.Started).String()}
+		if err != nil {
+			line.Level, line.Msg, line.Error = "error", "fetch failed", taring.DescribeS3Error(err)
+		} else if logLevel < levelVerbose {
+			return
+		}
+		writeLogLine(line)
+	}
+	return []taring.Option{
+		taring.WithOnProgress(func(snap taring.ProgressSnapshot) {
+			jsonMu.Lock()
+			changed := snap.Phase != jsonPhase
+			jsonPhase = snap.Phase
+			jsonMu.Unlock()
+			if changed && logLevel >= levelInfo {
+				logJSON("info", "phase started")
+			}
+		}),
+		taring.WithOnObjectDone(func(f taring.ActiveFetch) { ended(f, nil) }),
+		taring.WithOnObjectError(ended),
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/aybabtme/taring"
+	"github.com/aybabtme/taring/taringtest"
+	"github.com/crowdmob/goamz/s3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJSONLogHooks(t *testing.T) {
+	out, level := logOutput, logLevel
+	defer func() { logOutput, logLevel, jsonPhase = out, level, "" }()
+	buf := new(bytes.Buffer)
+	logOutput, logLevel = buf, levelVerbose
+
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := new(taringtest.Store)
+	store.Put("data/readme.txt", []byte("hello"), time.Now())
+	store.Put("data/broken.txt", []byte("broken"), time.Now())
+	store.FailOpen("data/broken.txt", &s3.Error{StatusCode: 500, Code: "InternalError"})
+
+	opts := append([]taring.Option{
+		taring.WithStore(store),
+		taring.WithRetries(1, time.Millisecond),
+	}, jsonLogHooks()...)
+	_, _ = taring.New("s3://bucket/data/", filepath.Join(dir, "archive.tar.gz"), opts...).Run(context.Background())
+
+	var fetched, failed, phases int
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var line jsonLine
+		if err := dec.Decode(&line); err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case line.Msg == "phase started" && line.Phase != "":
+			phases++
+		case line.Msg == "fetched" && line.Key == "data/readme.txt" && line.Bytes == 5:
+			fetched++
+		case line.Level == "error" && line.Key == "data/broken.txt" && line.Error != "":
+			failed++
+		}
+	}
+	if phases == 0 || fetched != 1 || failed != 1 {
+		t.Errorf("want phases, 1 fetched and 1 failed line, got %d phases, %d fetched and %d failed in:\n%s", phases, fetched, failed, buf)
+	}
+}
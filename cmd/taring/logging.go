@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"gopkg.in/natefinch/lumberjack.v2"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"time"
+)
+
+// Log levels, from the quietest.
+const (
+	levelQuiet = iota
+	levelInfo
+	levelVerbose
+	levelDebug
+)
+
+var (
+	logLevel = levelInfo
+	// useColor is whether log lines are colored, by default only when
+	// they go to a terminal.
+	useColor = isTerminal(os.Stderr) && os.Getenv("NO_COLOR") == ""
+
+	// logOutput is where log lines go.
+	logOutput io.Writer = os.Stderr
+
+	quietFlag, verboseFlag, debugFlag, noColorFlag, syslogFlag bool
+
+	// logFormat is how log lines are written, `text` or `json`.
+	logFormat string
+
+	logFile     lumberjack.Logger
+	logRotation time.Duration
+)
+
+// registerLogFlags adds the flags controlling logging to a command's flags.
+// applyLogFlags must be called once they're parsed.
+func registerLogFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&quietFlag, "quiet", false, "only log errors")
+	fs.BoolVar(&verboseFlag, "verbose", false, "also log every key listed, fetched or skipped")
+	fs.BoolVar(&debugFlag, "debug", false, "log everything, implies `verbose`")
+	fs.BoolVar(&noColorFlag, "no-color", false, "never color log lines, as when NO_COLOR is set")
+	fs.StringVar(&logFormat, "log-format", "text", "how to write log lines, `text` or `json` for a JSON object per line with the level, phase, key, bytes, duration and error")
+	fs.StringVar(&logFile.Filename, "log-file", "", "a path to log to instead of stderr, rotated and compressed as it grows")
+	fs.IntVar(&logFile.MaxSize, "log-max-size", 100, "the size in megabytes at which the log file is rotated")
+	fs.DurationVar(&logRotation, "log-rotate-every", 0, "also rotate the log file this often, e.g. `24h`")
+	fs.IntVar(&logFile.MaxBackups, "log-max-backups", 0, "how many rotated log files to keep, 0 keeps them all")
+	fs.IntVar(&logFile.MaxAge, "log-max-age", 0, "how many days to keep rotated log files, 0 keeps them forever")
+	fs.BoolVar(&syslogFlag, "log-syslog", false, "log to syslog instead of stderr")
+	fs.StringVar(&syslogFacility, "syslog-facility", "user", "the syslog facility to log with, e.g. `daemon` or `local0`")
+	fs.StringVar(&syslogTag, "syslog-tag", "taring", "the tag of syslog lines")
+	fs.StringVar(&syslogAddr, "syslog-addr", "", "the `host:port` of a syslog server to log to over UDP, instead of the local one")
+}
+
+func applyLogFlags() {
+	switch {
+	case debugFlag:
+		logLevel = levelDebug
+	case verboseFlag:
+		logLevel = levelVerbose
+	case quietFlag:
+		logLevel = levelQuiet
+	}
+	if noColorFlag {
+		useColor = false
+	}
+	switch logFormat {
+	case "text":
+	case "json":
+		toJSON, useColor = true, false
+	default:
+		fatalf("unknown log format %q, need `text` or `json`", logFormat)
+	}
+	if logFile.Filename != "" {
+		logFile.Compress = true
+		logFile.LocalTime = true
+		logOutput = &logFile
+		log.SetOutput(logOutput)
+		elog.SetOutput(logOutput)
+		useColor = false
+		// the lines asked to go to the file don't go to the journal, even
+		// when stderr does
+		toJournal = false
+		if logRotation > 0 {
+			go rotateLogFile(logRotation)
+		}
+	}
+	if syslogFlag {
+		if err := openSyslog(); err != nil {
+			fatalf("%v", err)
+		}
+	}
+}
+
+// rotateLogFile rotates the log file every period, on top of rotating it
+// when it's too large.
+func rotateLogFile(period time.Duration) {
+	for range time.Tick(period) {
+		if err := logFile.Rotate(); err != nil {
+			errorf("rotating log file, %v", err)
+		}
+	}
+}
+
+var ansiEscape = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// logger hands what the library logs to infof and friends.
+type logger struct{}
+
+func (logger) Errorf(format string, args ...interface{})   { errorf(format, args...) }
+func (logger) Infof(format string, args ...interface{})    { infof(format, args...) }
+func (logger) Verbosef(format string, args ...interface{}) { verbosef(format, args...) }
+func (logger) Debugf(format string, args ...interface{})   { debugf(format, args...) }
+
+// paint is the colored text, or the text without its colors when logs
+// aren't colored.
+func paint(colored fmt.Stringer) string {
+	if useColor {
+		return colored.String()
+	}
+	return ansiEscape.ReplaceAllString(colored.String(), "")
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/aybabtme/taring"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// statsdPrefix is prepended to the name of every metric.
+const statsdPrefix = "taring."
+
+// emitMetrics pushes the outcome of a run to a statsd server, tagged
+// dogstatsd style with the job, the bucket and the configured tags.
+// Failing to emit doesn't fail the run.
+func emitMetrics(cfg JobConfig, sum *taring.RunSummary) {
+	if cfg.StatsdAddr == "" {
+		return
+	}
+	var tags []string
+	if cfg.Job != "" {
+		tags = append(tags, "job:"+cfg.Job)
+	}
+	if u, err := url.Parse(cfg.Source); err == nil {
+		tags = append(tags, "bucket:"+u.Host)
+	}
+	for _, tag := range strings.Split(cfg.StatsdTags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	m := &statsdBatch{tags: tags}
+	m.add("run.count", "1", "c", "status:"+sum.Status)
+	m.add("run.duration", fmt.Sprint(int64(sum.Ended.Sub(sum.Started)/time.Millisecond)), "ms")
+	m.add("run.retries", fmt.Sprint(sum.Retries), "c")
+	m.add("run.slow_restarts", fmt.Sprint(sum.SlowRestarts), "c")
+	if sum.Status == taring.StatusSucceeded {
+		m.add("run.objects", fmt.Sprint(sum.Objects), "g")
+		m.add("run.bytes", fmt.Sprint(sum.Bytes), "g")
+		m.add("run.archive_bytes", fmt.Sprint(sum.ArchiveBytes), "g")
+		m.add("run.last_success", fmt.Sprint(sum.Ended.Unix()), "g")
+	}
+	if err := m.send(cfg.StatsdAddr); err != nil {
+		errorf("emitting metrics to statsd %q, %v", cfg.StatsdAddr, err)
+	}
+}
+
+// statsdBatch is metrics sent together in a single datagram.
+type statsdBatch struct {
+	tags []string
+	buf  bytes.Buffer
+}
+
+func (b *statsdBatch) add(name, value, kind string, tags ...string) {
+	fmt.Fprintf(&b.buf, "%s%s:%s|%s", statsdPrefix, name, value, kind)
+	if tags = append(tags, b.tags...); len(tags) != 0 {
+		fmt.Fprintf(&b.buf, "|#%s", strings.Join(tags, ","))
+	}
+	b.buf.WriteByte('\n')
+}
+
+func (b *statsdBatch) send(addr string) error {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write(bytes.TrimSuffix(b.buf.Bytes(), []byte("\n")))
+	return err
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"context"
+	"flag"
+	"fmt"
+	"github.com/aybabtme/taring"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func mountCmd(args []string) {
+	fset := flag.NewFlagSet("mount", flag.ExitOnError)
+	fset.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: taring mount <archive.tar.gz> <mountpoint>\n\n")
+		fset.PrintDefaults()
+	}
+	parseFlags(fset, args)
+	if fset.NArg() != 2 {
+		fatalFlagSet(fset, "need an archive and a mountpoint.\n")
+	}
+	archive, mountpoint := fset.Arg(0), fset.Arg(1)
+
+	infof("indexing %q...", archive)
+	start := time.Now()
+	idx, err := taring.IndexArchive(archive)
+	if err != nil {
+		fatalf("indexing archive, %v", err)
+	}
+	infof("indexed %d entries in %v", len(idx.Entries), time.Since(start))
+	root, hidden := newArchiveFS(idx)
+	for _, name := range hidden {
+		errorf("not mounting %q, its path is taken by another entry", name)
+	}
+
+	conn, err := fuse.Mount(mountpoint,
+		fuse.ReadOnly(),
+		fuse.FSName("taring"),
+		fuse.Subtype("taringfs"),
+	)
+	if err != nil {
+		fatalf("mounting %q, %v", mountpoint, err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigc
+		infof("unmounting %q", mountpoint)
+		if err := fuse.Unmount(mountpoint); err != nil {
+			errorf("unmounting %q, %v", mountpoint, err)
+		}
+	}()
+
+	infof("serving %q at %q, interrupt to unmount", archive, mountpoint)
+	if err := fs.Serve(conn, root); err != nil {
+		fatalf("serving %q, %v", mountpoint, err)
+	}
+}
+
+// archiveFS exposes the entries of an archive as a read-only tree.
+type archiveFS struct {
+	root *archiveDir
+}
+
+// newArchiveFS builds the tree of the entries of idx. An entry whose path
+// is taken by another one, such as `a` along with `a/b` since `a` can't be
+// both a file and a directory, is left out, and returned in hidden.
+func newArchiveFS(idx *taring.ArchiveIndex) (afs *archiveFS, hidden []string) {
+	inode := uint64(1)
+	next := func() uint64 { inode++; return inode }
+
+	root := &archiveDir{inode: 1, children: make(map[string]fs.Node)}
+entries:
+	for _, entry := range idx.Entries {
+		parts := strings.Split(strings.Trim(entry.Name, "/"), "/")
+		dir := root
+		for _, part := range parts[:len(parts)-1] {
+			var child *archiveDir
+			switch c := dir.children[part].(type) {
+			case *archiveDir:
+				child = c
+			case nil:
+				child = &archiveDir{inode: next(), mtime: entry.ModTime, children: make(map[string]fs.Node)}
+				dir.children[part] = child
+			default:
+				hidden = append(hidden, entry.Name)
+				continue entries
+			}
+			if entry.ModTime.After(child.mtime) {
+				child.mtime = entry.ModTime
+			}
+			dir = child
+		}
+		name := parts[len(parts)-1]
+		if _, ok := dir.children[name].(*archiveDir); ok {
+			hidden = append(hidden, entry.Name)
+			continue
+		}
+		dir.children[name] = &archiveFile{inode: next(), idx: idx, entry: entry}
+	}
+	return &archiveFS{root: root}, hidden
+}
+
+func (a *archiveFS) Root() (fs.Node, error) { return a.root, nil }
+
+type archiveDir struct {
+	inode    uint64
+	mtime    time.Time
+	children map[string]fs.Node
+}
+
+func (d *archiveDir) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Inode = d.inode
+	a.Mode = os.ModeDir | 0555
+	a.Mtime = d.mtime
+	return nil
+}
+
+func (d *archiveDir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	if child, ok := d.children[name]; ok {
+		return child, nil
+	}
+	return nil, fuse.ENOENT
+}
+
+func (d *archiveDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	dirents := make([]fuse.Dirent, 0, len(d.children))
+	for name, child := range d.children {
+		switch c := child.(type) {
+		case *archiveDir:
+			dirents = append(dirents, fuse.Dirent{Inode: c.inode, Type: fuse.DT_Dir, Name: name})
+		case *archiveFile:
+			dirents = append(dirents, fuse.Dirent{Inode: c.inode, Type: fuse.DT_File, Name: name})
+		}
+	}
+	sort.Slice(dirents, func(i, j int) bool { return dirents[i].Name < dirents[j].Name })
+	return dirents, nil
+}
+
+type archiveFile struct {
+	inode uint64
+	idx   *taring.ArchiveIndex
+	entry taring.IndexEntry
+}
+
+func (f *archiveFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Inode = f.inode
+	a.Mode = 0444
+	a.Size = uint64(f.entry.Size)
+	a.Mtime = f.entry.ModTime
+	return nil
+}
+
+func (f *archiveFile) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	resp.Flags |= fuse.OpenKeepCache
+	return &archiveHandle{r: f.idx.Open(f.entry)}, nil
+}
+
+// archiveHandle is one open of a file; each has its own reader so that
+// concurrent sequential reads don't keep reopening the archive.
+type archiveHandle struct {
+	r *taring.EntryReader
+}
+
+func (h *archiveHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	buf := make([]byte, req.Size)
+	n, err := h.r.ReadAt(buf, req.Offset)
+	if err != nil && n == 0 && req.Offset < h.r.Size() {
+		errorf("reading %q at %d, %v", h.r.Entry().Name, req.Offset, err)
+		return fuse.EIO
+	}
+	resp.Data = buf[:n]
+	return nil
+}
+
+func (h *archiveHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	return h.r.Close()
+}
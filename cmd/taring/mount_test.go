@@ -0,0 +1,62 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"github.com/aybabtme/taring"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestArchiveFSCollisions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	archive := filepath.Join(dir, "archive.tar.gz")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := gzip.NewWriter(f)
+	tw := tar.NewWriter(zw)
+	// `a` is both a file and a directory
+	for _, name := range []string{"a/b", "a", "e/f", "a/c"} {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: 1}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := taring.IndexArchive(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	afs, hidden := newArchiveFS(idx)
+	if want := []string{"a/b", "a/c"}; !reflect.DeepEqual(hidden, want) {
+		t.Errorf("want %q left out, got %q", want, hidden)
+	}
+	root := afs.root.children
+	if _, ok := root["a"].(*archiveFile); !ok {
+		t.Errorf("want a file at a, got %T", root["a"])
+	}
+	if _, ok := root["e"].(*archiveDir); !ok {
+		t.Errorf("want a directory at e, got %T", root["e"])
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/aybabtme/taring"
+	"github.com/dustin/go-humanize"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// notify sends the summary of a run wherever the config asks for. Failing
+// to notify doesn't fail the run.
+func notify(cfg JobConfig, sum *taring.RunSummary) {
+	if cfg.WebhookURL != "" {
+		if err := postWebhook(cfg.WebhookURL, cfg.WebhookSecret, sum); err != nil {
+			errorf("notifying webhook, %v", err)
+		}
+	}
+	if cfg.SlackWebhook != "" {
+		if err := postSlack(cfg.SlackWebhook, sum); err != nil {
+			errorf("notifying Slack, %v", err)
+		}
+	}
+	if cfg.NotifyEmail != "" {
+		if err := sendEmail(cfg, sum); err != nil {
+			errorf("notifying by email, %v", err)
+		}
+	}
+}
+
+const webhookAttempts = 5
+
+// webhookBackoff is how long to wait before retrying a webhook the first
+// time, doubled after each attempt.
+var webhookBackoff = time.Second
+
+// postWebhook POSTs the summary as JSON, retrying with exponential backoff.
+// With a secret, the body is signed in the `X-Taring-Signature` header as
+// `sha256=<hex HMAC-SHA256 of the body>`.
+func postWebhook(url, secret string, sum *taring.RunSummary) error {
+	body, err := json.Marshal(sum)
+	if err != nil {
+		return err
+	}
+	var signature string
+	if secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		_, _ = mac.Write(body)
+		signature = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	if err := postJSON(url, signature, body); err != nil {
+		return err
+	}
+	infof("notified webhook %q", url)
+	return nil
+}
+
+// postJSON POSTs a JSON body, retrying with exponential backoff.
+func postJSON(url, signature string, body []byte) error {
+	client := &http.Client{Timeout: 30 * time.Second}
+	backoff := webhookBackoff
+	for attempt := 1; ; attempt++ {
+		err := postWebhookOnce(client, url, signature, body)
+		if err == nil {
+			return nil
+		}
+		if attempt == webhookAttempts {
+			return fmt.Errorf("giving up after %d attempts, %v", attempt, err)
+		}
+		errorf("posting to %q (attempt %d/%d), retrying in %v: %v", url, attempt, webhookAttempts, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func postWebhookOnce(client *http.Client, url, signature string, body []byte) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "taring")
+	if signature != "" {
+		req.Header.Set("X-Taring-Signature", signature)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// sendEmail mails the summary of the run, and its error if it failed.
+func sendEmail(cfg JobConfig, sum *taring.RunSummary) error {
+	var to []string
+	for _, addr := range strings.Split(cfg.NotifyEmail, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			to = append(to, addr)
+		}
+	}
+
+	var auth smtp.Auth
+	if cfg.SMTPUser != "" {
+		host, _, err := net.SplitHostPort(cfg.SMTPAddr)
+		if err != nil {
+			return fmt.Errorf("invalid SMTP address %q, %v", cfg.SMTPAddr, err)
+		}
+		auth = smtp.PlainAuth("", cfg.SMTPUser, cfg.SMTPPassword, host)
+	}
+
+	msg := new(bytes.Buffer)
+	err := emailTmpl.Execute(msg, struct {
+		From    string
+		To      string
+		Date    string
+		Summary *taring.RunSummary
+	}{cfg.SMTPFrom, strings.Join(to, ", "), time.Now().Format(time.RFC1123Z), sum})
+	if err != nil {
+		return err
+	}
+	if err := smtp.SendMail(cfg.SMTPAddr, auth, cfg.SMTPFrom, to, bytes.Replace(msg.Bytes(), []byte("\n"), []byte("\r\n"), -1)); err != nil {
+		return err
+	}
+	infof("emailed run summary to %s", strings.Join(to, ", "))
+	return nil
+}
+
+var emailTmpl = template.Must(template.New("email").Parse(`From: {{.From}}
+To: {{.To}}
+Date: {{.Date}}
+Subject: [taring] {{.Summary.Status}}: {{.Summary.Source}}
+Content-Type: text/plain; charset=utf-8
+
+Archiving {{.Summary.Source}} {{.Summary.Status}}.
+
+Job:      {{.Summary.Job}}
+Archive:  {{.Summary.Archive}}
+Started:  {{.Summary.Started}}
+Duration: {{.Summary.Duration}}
+Objects:  {{.Summary.Objects}}
+Bytes:    {{.Summary.Bytes}} ({{.Summary.ArchiveBytes}} compressed)
+{{if .Summary.Error}}
+Error:
+
+{{.Summary.Error}}
+{{end}}`))
+
+type slackMessage struct {
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments,omitempty"`
+}
+
+type slackAttachment struct {
+	Color  string       `json:"color"`
+	Text   string       `json:"text,omitempty"`
+	Fields []slackField `json:"fields"`
+}
+
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+// postSlack posts a short summary to a Slack incoming webhook. Failures
+// lead with the error; successes with what was archived.
+func postSlack(url string, sum *taring.RunSummary) error {
+	var msg slackMessage
+	switch sum.Status {
+	case taring.StatusFailed:
+		msg = slackMessage{
+			Text: fmt.Sprintf(":x: archiving `%s` failed after %s", sum.Source, sum.Duration),
+			Attachments: []slackAttachment{{
+				Color: "danger",
+				Text:  "```" + sum.Error + "```",
+				Fields: []slackField{
+					{Title: "Job", Value: sum.Job, Short: true},
+					{Title: "Archive", Value: sum.Archive, Short: true},
+				},
+			}},
+		}
+	case taring.StatusUnchanged:
+		msg = slackMessage{
+			Text: fmt.Sprintf("archiving `%s` skipped, nothing changed", sum.Source),
+		}
+	default:
+		msg = slackMessage{
+			Text: fmt.Sprintf(":white_check_mark: archived `%s` in %s", sum.Source, sum.Duration),
+			Attachments: []slackAttachment{{
+				Color: "good",
+				Fields: []slackField{
+					{Title: "Archive", Value: sum.Archive},
+					{Title: "Objects", Value: strconv.Itoa(sum.Objects), Short: true},
+					{Title: "Size", Value: fmt.Sprintf("%s (%s compressed)", humanize.Bytes(uint64(sum.Bytes)), humanize.Bytes(uint64(sum.ArchiveBytes))), Short: true},
+				},
+			}},
+		}
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if err := postJSON(url, "", body); err != nil {
+		return err
+	}
+	infof("notified Slack")
+	return nil
+}
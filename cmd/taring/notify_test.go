@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/aybabtme/taring"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPostWebhook(t *testing.T) {
+	defer func(backoff time.Duration) { webhookBackoff = backoff }(webhookBackoff)
+	webhookBackoff = time.Millisecond
+
+	var (
+		mu       sync.Mutex
+		attempts int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts++
+		attempt := attempts
+		mu.Unlock()
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		mac := hmac.New(sha256.New, []byte("secret"))
+		_, _ = mac.Write(body)
+		if want, got := "sha256="+hex.EncodeToString(mac.Sum(nil)), r.Header.Get("X-Taring-Signature"); got != want {
+			t.Errorf("want signature %q, got %q", want, got)
+		}
+		var sum taring.RunSummary
+		if err := json.Unmarshal(body, &sum); err != nil || sum.Source != "s3://bucket/data/" {
+			t.Errorf("want the summary of the run, got %s (%v)", body, err)
+		}
+		// fails twice before taking it
+		if attempt <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	sum := &taring.RunSummary{Source: "s3://bucket/data/", Status: taring.StatusSucceeded}
+	if err := postWebhook(srv.URL, "secret", sum); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 3 {
+		t.Errorf("want 3 attempts, got %d", attempts)
+	}
+}
+
+func TestPostWebhookGivesUp(t *testing.T) {
+	defer func(backoff time.Duration) { webhookBackoff = backoff }(webhookBackoff)
+	webhookBackoff = time.Millisecond
+
+	var (
+		mu       sync.Mutex
+		attempts int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts++
+		mu.Unlock()
+		if r.Header.Get("X-Taring-Signature") != "" {
+			t.Error("want no signature without a secret")
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	if err := postWebhook(srv.URL, "", &taring.RunSummary{}); err == nil {
+		t.Fatal("want the webhook to fail")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != webhookAttempts {
+		t.Errorf("want %d attempts, got %d", webhookAttempts, attempts)
+	}
+}
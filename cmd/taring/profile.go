@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	_ "net/http/pprof" // registers the /debug/pprof handlers
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// profiling is what the profiling flags ask for.
+type profiling struct {
+	cpuPath string
+	memPath string
+	addr    string
+}
+
+func (p *profiling) register(fs *flag.FlagSet) {
+	fs.StringVar(&p.cpuPath, "cpuprofile", "", "a path to write a CPU profile of the whole run to")
+	fs.StringVar(&p.memPath, "memprofile", "", "a path to write a heap profile to at the end of the run")
+	fs.StringVar(&p.addr, "pprof-addr", "", "an address to serve `/debug/pprof` on, e.g. `localhost:6060`")
+}
+
+// start begins profiling. The returned func ends it and writes the
+// profiles, it must be called before exiting.
+func (p *profiling) start() (stop func()) {
+	if p.addr != "" {
+		go func() {
+			infof("serving pprof on %q", p.addr)
+			if err := http.ListenAndServe(p.addr, http.DefaultServeMux); err != nil {
+				errorf("serving pprof on %q, %v", p.addr, err)
+			}
+		}()
+	}
+
+	var cpuFile *os.File
+	if p.cpuPath != "" {
+		f, err := os.Create(p.cpuPath)
+		if err != nil {
+			fatalf("creating CPU profile, %v", err)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			fatalf("starting CPU profile, %v", err)
+		}
+		cpuFile = f
+	}
+
+	return func() {
+		if cpuFile != nil {
+			pprof.StopCPUProfile()
+			if err := cpuFile.Close(); err != nil {
+				errorf("writing CPU profile, %v", err)
+			}
+			infof("wrote CPU profile to %q", p.cpuPath)
+		}
+		if p.memPath != "" {
+			if err := writeHeapProfile(p.memPath); err != nil {
+				errorf("writing heap profile, %v", err)
+			} else {
+				infof("wrote heap profile to %q", p.memPath)
+			}
+		}
+	}
+}
+
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	runtime.GC() // up to date statistics
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aybabtme/taring"
+	"github.com/dustin/go-humanize"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// barInterval is how often the progress bar is redrawn on a terminal.
+	barInterval = 200 * time.Millisecond
+	barWidth    = 30
+)
+
+// isTerminal tells whether f is a terminal rather than a file or a pipe.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// reportProgress shows how a job goes, as told by the returned hook, until
+// the returned func is called: as a progress bar redrawn in place when
+// stderr is a terminal and bar is true, or as a heartbeat log line every
+// interval otherwise. A zero interval logs nothing but the bar.
+func reportProgress(heartbeat time.Duration, bar bool) (hook taring.Option, stop func()) {
+	var (
+		mu     sync.Mutex
+		latest taring.ProgressSnapshot
+	)
+	hook = taring.WithOnProgress(func(snap taring.ProgressSnapshot) {
+		mu.Lock()
+		latest = snap
+		mu.Unlock()
+	})
+	interactive := bar && isTerminal(os.Stderr) && !toJSON && !toJournal && !toSyslog && logOutput == os.Stderr
+	if logLevel < levelInfo || (!interactive && heartbeat <= 0) {
+		return hook, func() {}
+	}
+	interval := heartbeat
+	if interactive {
+		interval = barInterval
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		rate := new(rateMeter)
+		for {
+			select {
+			case <-ticker.C:
+				mu.Lock()
+				snap := latest
+				mu.Unlock()
+				rate.add(time.Now(), snap.BytesFetched)
+				if interactive {
+					fmt.Fprintf(os.Stderr, "\r\033[K%s", progressLine(snap, rate.perSecond(), true))
+				} else if snap.Phase != "" {
+					infof("%s", progressLine(snap, rate.perSecond(), false))
+				}
+			case <-done:
+				if interactive {
+					fmt.Fprint(os.Stderr, "\r\033[K")
+				}
+				return
+			}
+		}
+	}()
+	return hook, func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// progressLine describes a snapshot: objects and bytes done out of their
+// totals, throughput and time left.
+func progressLine(snap taring.ProgressSnapshot, rate float64, bar bool) string {
+	if snap.Phase != "fetching" || snap.BytesTotal == 0 {
+		return snap.String()
+	}
+	done := float64(snap.BytesFetched) / float64(snap.BytesTotal)
+	if done > 1 {
+		done = 1
+	}
+	eta := "?"
+	if rate > 0 {
+		left := time.Duration(float64(snap.BytesTotal-snap.BytesFetched) / rate * float64(time.Second))
+		eta = left.Round(time.Second).String()
+	}
+	line := fmt.Sprintf("%d/%d objects  %s/%s  %s/s  ETA %s",
+		snap.KeysFetched, snap.KeysTotal,
+		humanize.Bytes(uint64(snap.BytesFetched)), humanize.Bytes(uint64(snap.BytesTotal)),
+		humanize.Bytes(uint64(rate)), eta)
+	if !bar {
+		return fmt.Sprintf("fetching %.0f%%, %s", done*100, line)
+	}
+	filled := int(done * barWidth)
+	return fmt.Sprintf("[%s%s] %3.0f%%  %s", strings.Repeat("=", filled), strings.Repeat(" ", barWidth-filled), done*100, line)
+}
+
+// rateWindow is how far back throughput is measured.
+const rateWindow = 10 * time.Second
+
+// rateMeter measures the recent throughput of a growing byte count.
+type rateMeter struct {
+	samples []rateSample
+}
+
+type rateSample struct {
+	at    time.Time
+	bytes int64
+}
+
+func (r *rateMeter) add(at time.Time, bytes int64) {
+	r.samples = append(r.samples, rateSample{at, bytes})
+	for len(r.samples) > 2 && at.Sub(r.samples[0].at) > rateWindow {
+		r.samples = r.samples[1:]
+	}
+}
+
+// perSecond is the bytes per second over the window, zero until known.
+func (r *rateMeter) perSecond() float64 {
+	if len(r.samples) < 2 {
+		return 0
+	}
+	first, last := r.samples[0], r.samples[len(r.samples)-1]
+	elapsed := last.at.Sub(first.at).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(last.bytes-first.bytes) / elapsed
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+func scheduleCmd(args []string) {
+	fs := flag.NewFlagSet("schedule", flag.ExitOnError)
+	spec := fs.String("cron", "", "a cron expression of when to run the job, e.g. `0 2 * * *`")
+	configPath := fs.String("config", "", "a path to the YAML config of the job to run, or defining the named job")
+	historyPath := fs.String("history", "", "a path to append a JSON line to for every run of the job")
+	var prof profiling
+	prof.register(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: taring schedule -cron='0 2 * * *' -config=job.yaml\n")
+		fmt.Fprintf(os.Stderr, "       taring schedule -cron='0 2 * * *' -config=taring.yaml <job>\n\n")
+		fs.PrintDefaults()
+	}
+	pos := parseInterspersed(fs, args)
+
+	switch {
+	case *spec == "":
+		fatalFlagSet(fs, "need a cron expression.\n")
+	case *configPath == "":
+		fatalFlagSet(fs, "need a job config.\n")
+	case len(pos) > 1:
+		fatalFlagSet(fs, "need at most one job to schedule.\n")
+	}
+	if _, err := cron.ParseStandard(*spec); err != nil {
+		fatalFlagSet(fs, "need a valid cron expression, %v.\n", err)
+	}
+	var cfg JobConfig
+	var err error
+	if len(pos) == 1 {
+		cfg.register(flag.NewFlagSet(pos[0], flag.ContinueOnError))
+		err = loadProfile(*configPath, pos[0], &cfg)
+	} else {
+		cfg, err = loadJobConfig(*configPath)
+	}
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if err := cfg.validate(); err != nil {
+		fatalf("invalid job config %q, %v", *configPath, err)
+	}
+
+	stopProfiling := prof.start()
+	defer stopProfiling()
+	sched := newScheduledJob(cfg, *historyPath)
+	c := cron.New()
+	if _, err := c.AddFunc(*spec, sched.run); err != nil {
+		fatalf("scheduling job, %v", err)
+	}
+	c.Start()
+	infof("scheduled %q to %q at %q", cfg.Source, cfg.Destination, *spec)
+	stopNotify := notifySystemd(sched.statusLine)
+	defer stopNotify()
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+	<-sigc
+	infof("stopping scheduler, waiting for running jobs")
+	sched.cancel()
+	<-c.Stop().Done()
+}
+
+// RunRecord is the outcome of one scheduled run.
+type RunRecord struct {
+	Job      string    `json:"job"`
+	Started  time.Time `json:"started"`
+	Duration string    `json:"duration"`
+	State    string    `json:"state"`
+	Archive  string    `json:"archive,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// historySize is how many runs are remembered in memory.
+const historySize = 100
+
+// scheduledJob runs a job each time it's triggered, unless the previous run
+// is still going.
+type scheduledJob struct {
+	cfg         JobConfig
+	historyPath string
+	ctx         context.Context
+	cancel      context.CancelFunc
+
+	mu      sync.Mutex
+	running bool
+	history []RunRecord
+}
+
+func newScheduledJob(cfg JobConfig, historyPath string) *scheduledJob {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &scheduledJob{cfg: cfg, historyPath: historyPath, ctx: ctx, cancel: cancel}
+}
+
+func (s *scheduledJob) run() {
+	s.mu.Lock()
+	if s.running {
+		s.mu.Unlock()
+		errorf("previous run of %q still going, skipping this one", s.cfg.Source)
+		s.record(RunRecord{Job: s.cfg.Job, Started: time.Now(), State: "skipped"})
+		return
+	}
+	s.running = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.running = false
+		s.mu.Unlock()
+	}()
+
+	infof("starting scheduled run of %q", s.cfg.Source)
+	sum, err := runJob(s.ctx, s.cfg, nil)
+
+	rec := RunRecord{
+		Job:      s.cfg.Job,
+		Started:  sum.Started,
+		Duration: sum.Duration,
+		State:    jobSucceeded,
+		Archive:  sum.Archive,
+	}
+	if err != nil {
+		rec.State, rec.Error = jobFailed, err.Error()
+		errorf("scheduled run of %q failed after %s, %v", s.cfg.Source, rec.Duration, err)
+	} else {
+		infof("scheduled run of %q done in %s", s.cfg.Source, rec.Duration)
+	}
+	s.record(rec)
+}
+
+// statusLine summarizes the schedule for the service manager.
+func (s *scheduledJob) statusLine() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return "running " + s.cfg.Source
+	}
+	if len(s.history) == 0 {
+		return "waiting for the first run"
+	}
+	last := s.history[len(s.history)-1]
+	return fmt.Sprintf("waiting, last run %s at %s", last.State, last.Started.Format(time.RFC3339))
+}
+
+func (s *scheduledJob) record(rec RunRecord) {
+	s.mu.Lock()
+	s.history = append(s.history, rec)
+	if len(s.history) > historySize {
+		s.history = s.history[len(s.history)-historySize:]
+	}
+	var failed int
+	for _, r := range s.history {
+		if r.State == jobFailed {
+			failed++
+		}
+	}
+	n := len(s.history)
+	s.mu.Unlock()
+	infof("%d of the last %d runs failed", failed, n)
+
+	if s.historyPath == "" {
+		return
+	}
+	if err := appendJSONLine(s.historyPath, rec); err != nil {
+		errorf("recording run in history %q, %v", s.historyPath, err)
+	}
+}
+
+func appendJSONLine(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, filePerms)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
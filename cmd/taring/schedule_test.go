@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/aybabtme/taring/taringtest"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestScheduledJobSkipsWhileRunning(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := new(taringtest.Store)
+	store.Put("data/readme.txt", []byte("hello"), time.Now())
+	srv := httptest.NewServer(taringtest.S3Handler("bucket", store))
+	defer srv.Close()
+
+	// the first run holds on in its pre-cmd until it's released
+	release := filepath.Join(dir, "release")
+	cfg := JobConfig{
+		AWSAccess:   "access",
+		AWSSecret:   "secret",
+		AWSRegion:   "us-east-1",
+		S3Endpoint:  srv.URL,
+		S3PathStyle: true,
+		Job:         "nightly",
+		Source:      "s3://bucket/data/",
+		Destination: filepath.Join(dir, "archive.tar.gz"),
+		PreCmd:      "while [ ! -e " + shellQuote(release) + " ]; do sleep 0.01; done",
+	}
+	history := filepath.Join(dir, "history.jsonl")
+	sched := newScheduledJob(cfg, history)
+	defer sched.cancel()
+
+	first := make(chan struct{})
+	go func() {
+		defer close(first)
+		sched.run()
+	}()
+	for !strings.HasPrefix(sched.statusLine(), "running") {
+		time.Sleep(time.Millisecond)
+	}
+
+	// runs triggered meanwhile are skipped
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sched.run()
+		}()
+	}
+	wg.Wait()
+	if err := ioutil.WriteFile(release, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	<-first
+
+	f, err := os.Open(history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var states []string
+	for scanner := bufio.NewScanner(f); scanner.Scan(); {
+		var rec RunRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Job != "nightly" {
+			t.Errorf("want a record of job %q, got %q", "nightly", rec.Job)
+		}
+		states = append(states, rec.State)
+	}
+	if want := []string{"skipped", "skipped", jobSucceeded}; strings.Join(states, ",") != strings.Join(want, ",") {
+		t.Errorf("want runs %v, got %v", want, states)
+	}
+	if line := sched.statusLine(); !strings.HasPrefix(line, "waiting, last run "+jobSucceeded) {
+		t.Errorf("want to wait after a successful run, got %q", line)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/aybabtme/taring"
+	"github.com/dustin/go-humanize"
+	"html/template"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+func serveCmd(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := fs.String("listen", ":8080", "the address to serve the archive on")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: taring serve [flags] <archive.tar.gz>\n\n")
+		fs.PrintDefaults()
+	}
+	pos := parseInterspersed(fs, args)
+	if len(pos) != 1 {
+		fatalFlagSet(fs, "need exactly one archive to serve.\n")
+	}
+	archive := pos[0]
+
+	infof("indexing %q...", archive)
+	start := time.Now()
+	idx, err := taring.IndexArchive(archive)
+	if err != nil {
+		fatalf("indexing archive, %v", err)
+	}
+	infof("indexed %d entries in %v", len(idx.Entries), time.Since(start))
+
+	infof("serving %q on %q", archive, *listen)
+	if err := http.ListenAndServe(*listen, newArchiveServer(idx)); err != nil {
+		fatalf("serving on %q, %v", *listen, err)
+	}
+}
+
+// parseInterspersed parses flags that may come after positional arguments,
+// returning the positional arguments. Flags are completed from the
+// environment, and include the logging flags.
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	registerLogFlags(fs)
+	var pos []string
+	for {
+		_ = fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			if err := applyEnv(fs); err != nil {
+				fatalFlagSet(fs, "%v.\n", err)
+			}
+			applyLogFlags()
+			return pos
+		}
+		pos = append(pos, args[0])
+		args = args[1:]
+	}
+}
+
+const filesPrefix = "/files/"
+
+func newArchiveServer(idx *taring.ArchiveIndex) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		prefix := r.URL.Query().Get("prefix")
+		var entries []taring.IndexEntry
+		for _, entry := range idx.Entries {
+			if strings.HasPrefix(entry.Name, prefix) {
+				entries = append(entries, entry)
+			}
+		}
+		err := listingTmpl.Execute(w, struct {
+			Archive string
+			Prefix  string
+			Entries []taring.IndexEntry
+		}{idx.Path, prefix, entries})
+		if err != nil {
+			errorf("rendering listing, %v", err)
+		}
+	})
+	mux.HandleFunc(filesPrefix, func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, filesPrefix)
+		entry, ok := idx.Lookup(name)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		er := idx.Open(entry)
+		defer func() { _ = er.Close() }()
+		infof("serving %q to %s", name, r.RemoteAddr)
+		// ServeContent takes care of range requests by seeking the entry
+		http.ServeContent(w, r, entry.Name, entry.ModTime, er)
+	})
+	return mux
+}
+
+var listingTmpl = template.Must(template.New("listing").Funcs(template.FuncMap{
+	"bytes": func(n int64) string { return humanize.Bytes(uint64(n)) },
+	"date":  func(t time.Time) string { return t.UTC().Format(time.RFC3339) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Archive}}</title></head>
+<body>
+<h1>{{.Archive}}</h1>
+<form method="get" action="/">
+  <input type="text" name="prefix" value="{{.Prefix}}" placeholder="filter by prefix">
+  <input type="submit" value="filter">
+</form>
+<p>{{len .Entries}} entries</p>
+<table>
+<tr><th>name</th><th>size</th><th>modified</th></tr>
+{{range .Entries}}<tr><td><a href="/files/{{.Name}}">{{.Name}}</a></td><td>{{bytes .Size}}</td><td>{{date .ModTime}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
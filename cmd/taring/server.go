@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/aybabtme/taring"
+	"github.com/crowdmob/goamz/aws"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+func serverCmd(args []string) {
+	fs := flag.NewFlagSet("server", flag.ExitOnError)
+	listen := fs.String("listen", ":9090", "the address to serve the job API on")
+	grpcListen := fs.String("grpc-listen", "", "an address to also serve the gRPC job service on")
+	allowHooks := fs.Bool("allow-hooks", false, "let submitted jobs run hook commands on this host")
+	var defaults JobConfig
+	fs.StringVar(&defaults.AWSSecret, "aws-secret", "", "an AWS secret key, for jobs that don't specify one")
+	fs.StringVar(&defaults.AWSAccess, "aws-access", "", "an AWS access key, for jobs that don't specify one")
+	fs.StringVar(&defaults.AWSRegion, "aws-region", aws.USEast.Name, "an AWS region string, for jobs that don't specify one")
+	var prof profiling
+	prof.register(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: taring server [flags]\n\n")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	stopProfiling := prof.start()
+
+	srv := newJobServer(defaults)
+	srv.allowHooks = *allowHooks
+
+	if *grpcListen != "" {
+		l, err := net.Listen("tcp", *grpcListen)
+		if err != nil {
+			fatalf("listening on %q, %v", *grpcListen, err)
+		}
+		go func() {
+			infof("serving gRPC job service on %q", *grpcListen)
+			if err := newGRPCServer(srv).Serve(l); err != nil {
+				fatalf("serving gRPC on %q, %v", *grpcListen, err)
+			}
+		}()
+	}
+
+	stopNotify := notifySystemd(srv.statusLine)
+	defer stopNotify()
+
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		fatalf("listening on %q, %v", *listen, err)
+	}
+	go func() {
+		if err := http.Serve(l, srv); err != nil {
+			fatalf("serving on %q, %v", *listen, err)
+		}
+	}()
+	srv.health.setReady()
+	infof("serving job API on %q", *listen)
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+	<-sigc
+	infof("stopping, waiting for running jobs")
+	srv.drain()
+	stopProfiling()
+}
+
+// Job states.
+const (
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+// JobStatus is what the API reports about a job.
+type JobStatus struct {
+	ID       string                  `json:"id"`
+	Config   JobConfig               `json:"config"`
+	State    string                  `json:"state"`
+	Started  time.Time               `json:"started"`
+	Ended    *time.Time              `json:"ended,omitempty"`
+	Error    string                  `json:"error,omitempty"`
+	Progress taring.ProgressSnapshot `json:"progress"`
+}
+
+type serverJob struct {
+	id       string
+	cfg      JobConfig
+	progress *taring.JobProgress
+	cancel   context.CancelFunc
+	done     chan struct{}
+
+	mu      sync.Mutex
+	state   string
+	started time.Time
+	ended   time.Time
+	err     error
+}
+
+func (j *serverJob) status() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	cfg := j.cfg
+	// never hand credentials back
+	cfg.AWSAccess, cfg.AWSSecret, cfg.MFAToken, cfg.GCSToken, cfg.AzureKey = "", "", "", "", ""
+	cfg.WebhookSecret, cfg.SMTPPassword, cfg.SlackWebhook = "", "", ""
+	st := JobStatus{
+		ID:       j.id,
+		Config:   cfg,
+		State:    j.state,
+		Started:  j.started,
+		Progress: j.progress.Snapshot(),
+	}
+	if !j.ended.IsZero() {
+		ended := j.ended
+		st.Ended = &ended
+	}
+	if j.err != nil {
+		st.Error = j.err.Error()
+	}
+	return st
+}
+
+// endedJobsKept is how many ended jobs are remembered, the oldest being
+// forgotten past that.
+const endedJobsKept = 100
+
+// errDraining is returned for jobs submitted while the server stops.
+var errDraining = errors.New("server is shutting down")
+
+// jobServer runs archive jobs submitted over HTTP:
+//
+//	POST   /jobs       submit a job, the body is a JSON JobConfig
+//	GET    /jobs       list all jobs
+//	GET    /jobs/<id>  status of a job
+//	DELETE /jobs/<id>  cancel a job
+//	GET    /healthz    liveness probe
+//	GET    /readyz     readiness probe, failing while the server stops
+type jobServer struct {
+	defaults   JobConfig
+	allowHooks bool
+	mux        *http.ServeMux
+	health     *health
+
+	mu       sync.Mutex
+	nextID   int
+	jobs     map[string]*serverJob
+	draining bool
+}
+
+func newJobServer(defaults JobConfig) *jobServer {
+	srv := &jobServer{
+		defaults: defaults,
+		mux:      http.NewServeMux(),
+		health:   newHealth(0),
+		jobs:     make(map[string]*serverJob),
+	}
+	srv.mux.HandleFunc("/jobs", srv.handleJobs)
+	srv.mux.HandleFunc("/jobs/", srv.handleJob)
+	srv.health.register(srv.mux)
+	return srv
+}
+
+// drain refuses new jobs and waits for the running ones to end.
+func (s *jobServer) drain() {
+	s.health.setNotReady("shutting down")
+	s.mu.Lock()
+	s.draining = true
+	jobs := make([]*serverJob, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job)
+	}
+	s.mu.Unlock()
+	for _, job := range jobs {
+		<-job.done
+	}
+}
+
+func (s *jobServer) ServeHTTP(w http.ResponseWriter, r *http.Request) { s.mux.ServeHTTP(w, r) }
+
+func (s *jobServer) handleJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, s.list())
+	case "POST":
+		var cfg JobConfig
+		if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("decoding job, %v", err))
+			return
+		}
+		job, err := s.submit(cfg)
+		if err == errDraining {
+			writeError(w, http.StatusServiceUnavailable, err)
+			return
+		} else if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, job.status())
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+func (s *jobServer) handleJob(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/jobs/")
+	job, ok := s.get(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no job %q", id))
+		return
+	}
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, job.status())
+	case "DELETE":
+		job.cancel()
+		<-job.done
+		writeJSON(w, http.StatusOK, job.status())
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+// submit validates a job and starts it right away.
+func (s *jobServer) submit(cfg JobConfig) (*serverJob, error) {
+	if cfg.AWSAccess == "" && cfg.AWSSecret == "" {
+		cfg.AWSAccess, cfg.AWSSecret = s.defaults.AWSAccess, s.defaults.AWSSecret
+	}
+	if cfg.AWSRegion == "" {
+		cfg.AWSRegion = s.defaults.AWSRegion
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if (cfg.PreCmd != "" || cfg.PostCmd != "") && !s.allowHooks {
+		return nil, errors.New("hook commands aren't allowed on this server")
+	}
+
+	s.mu.Lock()
+	if s.draining {
+		s.mu.Unlock()
+		return nil, errDraining
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.nextID++
+	job := &serverJob{
+		id:       strconv.Itoa(s.nextID),
+		cfg:      cfg,
+		progress: new(taring.JobProgress),
+		cancel:   cancel,
+		done:     make(chan struct{}),
+		state:    jobRunning,
+		started:  time.Now(),
+	}
+	s.jobs[job.id] = job
+	s.mu.Unlock()
+
+	go func() {
+		defer close(job.done)
+		defer cancel()
+		infof("job %s: archiving %q to %q", job.id, cfg.Source, cfg.Destination)
+		_, err := runJob(ctx, cfg, job.progress)
+
+		job.mu.Lock()
+		job.ended = time.Now()
+		job.err = err
+		switch {
+		case err == nil:
+			job.state = jobSucceeded
+			infof("job %s: done in %v", job.id, job.ended.Sub(job.started))
+		case ctx.Err() != nil:
+			job.state = jobCancelled
+			infof("job %s: cancelled", job.id)
+		default:
+			job.state = jobFailed
+			errorf("job %s: %v", job.id, err)
+		}
+		job.mu.Unlock()
+		s.forgetEnded()
+	}()
+	return job, nil
+}
+
+// forgetEnded drops the jobs that ended longest ago, past endedJobsKept.
+func (s *jobServer) forgetEnded() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ended []*serverJob
+	endedAt := make(map[*serverJob]time.Time)
+	for _, job := range s.jobs {
+		job.mu.Lock()
+		if !job.ended.IsZero() {
+			ended = append(ended, job)
+			endedAt[job] = job.ended
+		}
+		job.mu.Unlock()
+	}
+	if len(ended) <= endedJobsKept {
+		return
+	}
+	sort.Slice(ended, func(i, j int) bool { return endedAt[ended[i]].Before(endedAt[ended[j]]) })
+	for _, job := range ended[:len(ended)-endedJobsKept] {
+		delete(s.jobs, job.id)
+	}
+}
+
+// statusLine summarizes the jobs for the service manager.
+func (s *jobServer) statusLine() string {
+	var running int
+	for _, st := range s.list() {
+		if st.State == jobRunning {
+			running++
+		}
+	}
+	return fmt.Sprintf("%d jobs running", running)
+}
+
+func (s *jobServer) get(id string) (*serverJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	return job, ok
+}
+
+func (s *jobServer) list() []JobStatus {
+	s.mu.Lock()
+	jobs := make([]*serverJob, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job)
+	}
+	s.mu.Unlock()
+
+	statuses := make([]JobStatus, len(jobs))
+	for i, job := range jobs {
+		statuses[i] = job.status()
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Started.Before(statuses[j].Started) })
+	return statuses
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		errorf("writing response, %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestJobServerForgetsEndedJobs(t *testing.T) {
+	srv := newJobServer(JobConfig{})
+	start := time.Now()
+	for i := 0; i < endedJobsKept+5; i++ {
+		id := strconv.Itoa(i)
+		srv.jobs[id] = &serverJob{id: id, state: jobSucceeded, ended: start.Add(time.Duration(i) * time.Second)}
+	}
+	srv.jobs["running"] = &serverJob{id: "running", state: jobRunning}
+	srv.forgetEnded()
+
+	if len(srv.jobs) != endedJobsKept+1 {
+		t.Errorf("want %d jobs kept, got %d", endedJobsKept+1, len(srv.jobs))
+	}
+	if _, ok := srv.get("running"); !ok {
+		t.Error("want the running job kept")
+	}
+	for i := 0; i < 5; i++ {
+		if _, ok := srv.get(strconv.Itoa(i)); ok {
+			t.Errorf("want job %d, which ended first, forgotten", i)
+		}
+	}
+	if _, ok := srv.get(strconv.Itoa(endedJobsKept + 4)); !ok {
+		t.Error("want the last job to end kept")
+	}
+}
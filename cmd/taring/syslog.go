@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"github.com/coreos/go-systemd/v22/journal"
+	"log/syslog"
+	"sort"
+	"strings"
+)
+
+var (
+	toSyslog     bool
+	syslogWriter *syslog.Writer
+
+	syslogFacility, syslogTag, syslogAddr string
+)
+
+var syslogFacilities = map[string]syslog.Priority{
+	"kern": syslog.LOG_KERN, "user": syslog.LOG_USER, "mail": syslog.LOG_MAIL,
+	"daemon": syslog.LOG_DAEMON, "auth": syslog.LOG_AUTH, "syslog": syslog.LOG_SYSLOG,
+	"lpr": syslog.LOG_LPR, "news": syslog.LOG_NEWS, "uucp": syslog.LOG_UUCP,
+	"cron": syslog.LOG_CRON, "authpriv": syslog.LOG_AUTHPRIV, "ftp": syslog.LOG_FTP,
+	"local0": syslog.LOG_LOCAL0, "local1": syslog.LOG_LOCAL1, "local2": syslog.LOG_LOCAL2,
+	"local3": syslog.LOG_LOCAL3, "local4": syslog.LOG_LOCAL4, "local5": syslog.LOG_LOCAL5,
+	"local6": syslog.LOG_LOCAL6, "local7": syslog.LOG_LOCAL7,
+}
+
+// openSyslog connects to the local syslog daemon, or to the one listening
+// on UDP at syslogAddr.
+func openSyslog() error {
+	facility, ok := syslogFacilities[syslogFacility]
+	if !ok {
+		names := make([]string, 0, len(syslogFacilities))
+		for name := range syslogFacilities {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown syslog facility %q, known ones: %s", syslogFacility, strings.Join(names, ", "))
+	}
+	network := ""
+	if syslogAddr != "" {
+		network = "udp"
+	}
+	w, err := syslog.Dial(network, syslogAddr, facility|syslog.LOG_INFO, syslogTag)
+	if err != nil {
+		return fmt.Errorf("connecting to syslog, %v", err)
+	}
+	syslogWriter, toSyslog = w, true
+	return nil
+}
+
+// logSystem sends a log line to syslog if asked to, or else to the journal.
+func logSystem(pri journal.Priority, format string, args ...interface{}) {
+	if !toSyslog {
+		logJournal(pri, format, args...)
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	var err error
+	switch pri {
+	case journal.PriEmerg, journal.PriAlert, journal.PriCrit:
+		err = syslogWriter.Crit(msg)
+	case journal.PriErr:
+		err = syslogWriter.Err(msg)
+	case journal.PriWarning:
+		err = syslogWriter.Warning(msg)
+	case journal.PriNotice:
+		err = syslogWriter.Notice(msg)
+	case journal.PriDebug:
+		err = syslogWriter.Debug(msg)
+	default:
+		err = syslogWriter.Info(msg)
+	}
+	if err != nil {
+		elog.Printf("[syslog] "+format, args...)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"github.com/coreos/go-systemd/v22/daemon"
+	"github.com/coreos/go-systemd/v22/journal"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statusInterval is how often the service status is refreshed when
+// systemd doesn't ask for watchdog pings.
+const statusInterval = 10 * time.Second
+
+// notifySystemd tells systemd the service is ready, then keeps its status
+// line up to date and pings its watchdog, if any. It does nothing when not
+// started by systemd. The returned func tells systemd the service stops.
+func notifySystemd(status func() string) (stop func()) {
+	if os.Getenv("NOTIFY_SOCKET") == "" {
+		return func() {}
+	}
+	send := func(states ...string) {
+		if _, err := daemon.SdNotify(false, strings.Join(states, "\n")); err != nil {
+			errorf("notifying systemd, %v", err)
+		}
+	}
+	send(daemon.SdNotifyReady, "STATUS="+status())
+
+	interval := statusInterval
+	watchdog, err := daemon.SdWatchdogEnabled(false)
+	if err != nil {
+		errorf("checking systemd watchdog, %v", err)
+	}
+	if watchdog > 0 && watchdog/2 < interval {
+		interval = watchdog / 2
+	}
+
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if watchdog > 0 {
+					send(daemon.SdNotifyWatchdog, "STATUS="+status())
+				} else {
+					send("STATUS=" + status())
+				}
+			case <-done:
+				send(daemon.SdNotifyStopping)
+				return
+			}
+		}
+	}()
+	// callers exit right after, so stopping waits for systemd to be told
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// When stderr goes to the journal, log lines are sent to it directly with
+// their priority and these fields, instead of as colored text.
+var (
+	toJournal = os.Getenv("JOURNAL_STREAM") != "" && journal.Enabled()
+
+	journalMu     sync.Mutex
+	journalFields = map[string]string{}
+)
+
+// setJournalField attaches a field to every log line sent to the journal
+// from now on. Names must be uppercase, e.g. TARING_JOB.
+func setJournalField(name, value string) {
+	journalMu.Lock()
+	journalFields[name] = value
+	journalMu.Unlock()
+}
+
+func logJournal(pri journal.Priority, format string, args ...interface{}) {
+	journalMu.Lock()
+	fields := make(map[string]string, len(journalFields))
+	for k, v := range journalFields {
+		fields[k] = v
+	}
+	journalMu.Unlock()
+	if err := journal.Send(fmt.Sprintf(format, args...), pri, fields); err != nil {
+		elog.Printf("[journal] "+format, args...)
+	}
+}
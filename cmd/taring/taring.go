@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/aybabtme/color/brush"
+	"github.com/aybabtme/taring"
+	"github.com/coreos/go-systemd/v22/journal"
+	"github.com/dustin/go-humanize"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	filePerms = os.FileMode(os.ModePerm & 0644)
+	elog      = log.New(os.Stderr, "", log.Flags())
+)
+
+func fatalFlag(format string, args ...interface{}) {
+	fatalFlagSet(flag.CommandLine, format, args...)
+}
+
+func fatalFlagSet(fs *flag.FlagSet, format string, args ...interface{}) {
+	if toJournal || toSyslog {
+		logSystem(journal.PriCrit, format, args...)
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+	elog.Printf(paint(brush.Red("[flags] "))+paint(brush.LightGray(format)), args...)
+	fs.PrintDefaults()
+	os.Exit(2)
+}
+
+func errorf(format string, args ...interface{}) {
+	if toJSON {
+		logJSON("error", format, args...)
+		return
+	}
+	if toJournal || toSyslog {
+		logSystem(journal.PriErr, format, args...)
+		return
+	}
+	elog.Printf(paint(brush.Yellow("[error] "))+paint(brush.LightGray(format)), args...)
+}
+
+func fatalf(format string, args ...interface{}) {
+	if toJSON {
+		logJSON("fatal", format, args...)
+		os.Exit(2)
+	}
+	if toJournal || toSyslog {
+		logSystem(journal.PriCrit, format, args...)
+		os.Exit(2)
+	}
+	elog.Printf(paint(brush.Red("[fatal] "))+paint(brush.LightGray(format)), args...)
+	os.Exit(2)
+}
+
+func infof(format string, args ...interface{}) {
+	if logLevel < levelInfo {
+		return
+	}
+	if toJSON {
+		logJSON("info", format, args...)
+		return
+	}
+	if toJournal || toSyslog {
+		logSystem(journal.PriInfo, format, args...)
+		return
+	}
+	colorFmt := paint(brush.Blue("[info] ")) + paint(brush.LightGray(format))
+	log.Printf(colorFmt, args...)
+}
+
+// verbosef logs details only wanted with `-verbose`, like every key.
+func verbosef(format string, args ...interface{}) {
+	if logLevel < levelVerbose {
+		return
+	}
+	if toJSON {
+		logJSON("verbose", format, args...)
+		return
+	}
+	if toJournal || toSyslog {
+		logSystem(journal.PriInfo, format, args...)
+		return
+	}
+	log.Printf(paint(brush.Green("[verbose] "))+paint(brush.LightGray(format)), args...)
+}
+
+func debugf(format string, args ...interface{}) {
+	if logLevel < levelDebug {
+		return
+	}
+	if toJSON {
+		logJSON("debug", format, args...)
+		return
+	}
+	if toJournal || toSyslog {
+		logSystem(journal.PriDebug, format, args...)
+		return
+	}
+	log.Printf(paint(brush.DarkGray("[debug] "))+paint(brush.DarkGray(format)), args...)
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			cmd(os.Args[2:])
+			return
+		}
+	}
+
+	var cfg JobConfig
+	cfg.register(flag.CommandLine)
+	var opts runOptions
+	opts.register(flag.CommandLine)
+	registerLogFlags(flag.CommandLine)
+	flag.Parse()
+	if err := applyEnv(flag.CommandLine); err != nil {
+		fatalFlag("%v.\n", err)
+	}
+	applyLogFlags()
+
+	if err := cfg.validate(); err != nil {
+		fatalFlag("%v.\n", err)
+	}
+	runMain(cfg, opts)
+}
+
+// runOptions are how a job run in the foreground is reported, rather than
+// what it does.
+type runOptions struct {
+	tui       bool
+	events    string
+	heartbeat time.Duration
+	progress  bool
+	prof      profiling
+}
+
+func (o *runOptions) register(fs *flag.FlagSet) {
+	fs.BoolVar(&o.tui, "tui", false, "show a dashboard of the run instead of logging it")
+	fs.StringVar(&o.events, "events", "", "where to write NDJSON events of the run: `fd:3`, `unix:/path/to.sock` or a file")
+	fs.BoolVar(&o.progress, "progress", true, "on a terminal, show a progress bar with throughput and ETA; when false, log heartbeat lines as elsewhere")
+	fs.DurationVar(&o.heartbeat, "heartbeat", 10*time.Second, "when not on a terminal, how often to log a progress line, 0 to never")
+	o.prof.register(fs)
+}
+
+// runMain runs a job in the foreground, exiting if it fails.
+func runMain(cfg JobConfig, opts runOptions) {
+	progress := new(taring.JobProgress)
+	var (
+		events *eventWriter
+		hooks  []taring.Option
+	)
+	if toJSON {
+		hooks = append(hooks, jsonLogHooks()...)
+	}
+	if opts.events != "" {
+		w, err := openEvents(opts.events)
+		if err != nil {
+			fatalf("opening events %q, %v", opts.events, err)
+		}
+		defer w.Close()
+		events = newEventWriter(w)
+		hooks = append(hooks, events.hooks()...)
+	}
+	if cfg.Destination == stdoutPath && isTerminal(os.Stdout) {
+		fatalf("not writing a compressed archive to a terminal, pipe or redirect stdout")
+	}
+	stopProfiling := opts.prof.start()
+	setJournalField("TARING_JOB", cfg.Job)
+	setJournalField("TARING_SOURCE", cfg.Source)
+
+	stopNotify := notifySystemd(func() string { return progress.Snapshot().String() })
+	var stopReport func()
+	if opts.tui {
+		stopReport = runDashboard(cfg, progress)
+	} else {
+		var report taring.Option
+		report, stopReport = reportProgress(opts.heartbeat, opts.progress)
+		hooks = append(hooks, report)
+	}
+	sum, err := runJob(context.Background(), cfg, progress, hooks...)
+	events.emit(Event{Type: eventRunSummary, Summary: sum})
+	stopReport()
+	stopNotify()
+	stopProfiling()
+	if err != nil {
+		fatalf("%v.", err)
+	}
+	if sum.Status == taring.StatusDryRun {
+		printDryRun(os.Stdout, sum)
+	}
+}
+
+// printDryRun tells what a dry run would have archived.
+func printDryRun(w io.Writer, sum *taring.RunSummary) {
+	fmt.Fprintf(w, "would archive %d objects (%s) from %s\n", sum.Objects, humanize.Bytes(uint64(sum.Bytes)), sum.Source)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "PREFIX\tOBJECTS\tSIZE")
+	for _, p := range sum.Prefixes {
+		prefix := p.Prefix
+		if prefix == "" {
+			prefix = "(top level)"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", prefix, p.Objects, humanize.Bytes(uint64(p.Bytes)))
+	}
+	_ = tw.Flush()
+}
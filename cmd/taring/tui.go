@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/aybabtme/taring"
+	"github.com/dustin/go-humanize"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// tuiInterval is how often the dashboard is redrawn.
+	tuiInterval = 500 * time.Millisecond
+	// tuiFetchRows and tuiLogRows bound how much of the fetches and of
+	// the log the dashboard shows.
+	tuiFetchRows = 20
+	tuiLogRows   = 8
+)
+
+// runDashboard takes over the terminal to show how a job goes: where the
+// pipeline stands, every object being fetched with its speed, and the last
+// log lines, which no longer go to stderr. It stops when the returned func
+// is called, printing the log lines it held unless they went to a file.
+func runDashboard(cfg JobConfig, progress *taring.JobProgress) (stop func()) {
+	logs := &logTail{max: tuiLogRows}
+	var w io.Writer = logs
+	if logOutput != os.Stderr {
+		w = io.MultiWriter(logs, logOutput)
+	}
+	log.SetOutput(w)
+	elog.SetOutput(w)
+
+	started := time.Now()
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(tuiInterval)
+		defer ticker.Stop()
+		rate := new(rateMeter)
+		for {
+			select {
+			case <-ticker.C:
+				snap := progress.Snapshot()
+				rate.add(time.Now(), snap.BytesFetched)
+				buf := new(bytes.Buffer)
+				drawDashboard(buf, cfg, snap, progress.Active(), rate.perSecond(), time.Since(started), logs.lines())
+				// home the cursor and clear the screen, then draw at once
+				fmt.Fprint(os.Stderr, "\033[H\033[2J"+buf.String())
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		fmt.Fprint(os.Stderr, "\033[H\033[2J")
+		log.SetOutput(logOutput)
+		elog.SetOutput(logOutput)
+		if logOutput != os.Stderr {
+			return
+		}
+		for _, line := range logs.lines() {
+			fmt.Fprint(os.Stderr, line)
+		}
+	}
+}
+
+func drawDashboard(w io.Writer, cfg JobConfig, snap taring.ProgressSnapshot, active []taring.ActiveFetch, rate float64, elapsed time.Duration, logs []string) {
+	fmt.Fprintf(w, "taring %s  %s -> %s\n", cfg.Job, cfg.Source, snap.Archive)
+	phase := snap.Phase
+	if phase == "" {
+		phase = "starting"
+	}
+	fmt.Fprintf(w, "phase %s, for %v\n\n", phase, elapsed.Round(time.Second))
+
+	queued := snap.KeysTotal - snap.KeysFetched - len(active)
+	if queued < 0 {
+		queued = 0
+	}
+	fmt.Fprintf(w, "listed %d   queued %d   fetching %d   fetched %d, waiting for the archive\n",
+		snap.KeysListed, queued, len(active), snap.KeysFetched)
+	fmt.Fprintf(w, "%s\n\n", progressLine(snap, rate, true))
+
+	fmt.Fprintf(w, "%-50s %10s %6s %12s %8s\n", "FETCHING", "SIZE", "DONE", "SPEED", "FOR")
+	now := time.Now()
+	for i, f := range active {
+		if i == tuiFetchRows {
+			fmt.Fprintf(w, "... and %d more\n", len(active)-i)
+			break
+		}
+		var pct float64
+		if f.Size > 0 {
+			pct = float64(f.Read) / float64(f.Size) * 100
+		}
+		var speed float64
+		if took := now.Sub(f.Started).Seconds(); took > 0 {
+			speed = float64(f.Read) / took
+		}
+		fmt.Fprintf(w, "%-50s %10s %5.0f%% %10s/s %8v\n",
+			ellipsize(f.Key, 50), humanize.Bytes(uint64(f.Size)), pct,
+			humanize.Bytes(uint64(speed)), now.Sub(f.Started).Round(time.Second))
+	}
+
+	fmt.Fprintf(w, "\nLOG\n")
+	for _, line := range logs {
+		fmt.Fprint(w, line)
+	}
+}
+
+// ellipsize shortens s to n characters, keeping its end.
+func ellipsize(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return "..." + s[len(s)-n+3:]
+}
+
+// logTail keeps the last lines written to it.
+type logTail struct {
+	max int
+
+	mu   sync.Mutex
+	tail []string
+}
+
+func (l *logTail) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range strings.SplitAfter(string(p), "\n") {
+		if line != "" {
+			l.tail = append(l.tail, line)
+		}
+	}
+	if len(l.tail) > l.max {
+		l.tail = l.tail[len(l.tail)-l.max:]
+	}
+	return len(p), nil
+}
+
+func (l *logTail) lines() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.tail...)
+}
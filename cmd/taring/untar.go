@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/aybabtme/taring"
+	"github.com/crowdmob/goamz/aws"
+	"github.com/dustin/go-humanize"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func untarCmd(args []string) {
+	fs := flag.NewFlagSet("untar", flag.ExitOnError)
+	var cfg JobConfig
+	fs.StringVar(&cfg.AWSAccess, "aws-access", "", "an AWS access key")
+	fs.StringVar(&cfg.AWSSecret, "aws-secret", "", "an AWS secret key")
+	fs.StringVar(&cfg.AWSRegion, "aws-region", aws.USEast.Name, "an AWS region string")
+	fs.StringVar(&cfg.S3Endpoint, "s3-endpoint", "", "the `URL` of an S3-compatible server to use instead of AWS")
+	fs.BoolVar(&cfg.S3PathStyle, "s3-path-style", false, "address buckets as `endpoint/bucket` rather than `bucket.endpoint`")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: taring untar [flags] <archive.tar.gz> s3://bucket/prefix/\n\n")
+		fmt.Fprintf(os.Stderr, "Every file of the archive is written as an object under the prefix, keeping its modification time in the %q metadata.\n\n", taring.MetaModTime)
+		fs.PrintDefaults()
+	}
+	pos := parseInterspersed(fs, args)
+	if len(pos) != 2 {
+		fatalFlagSet(fs, "need an archive and an S3 path to untar it into.\n")
+	}
+	archive, dst := pos[0], pos[1]
+	u, err := url.Parse(dst)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		fatalFlagSet(fs, "need an S3 path of the form `s3://bucket/prefix/`, got %q.\n", dst)
+	}
+	_, regionOk := aws.Regions[cfg.AWSRegion]
+	switch {
+	case cfg.AWSAccess != "" && cfg.AWSSecret == "":
+		fatalFlagSet(fs, "need an AWS secret key.\n")
+	case cfg.AWSAccess == "" && cfg.AWSSecret != "":
+		fatalFlagSet(fs, "need an AWS access key.\n")
+	case !regionOk && cfg.S3Endpoint == "":
+		fatalFlagSet(fs, "need a valid AWS region, %q is not a valid one.\n", cfg.AWSRegion)
+	}
+	prefix := strings.TrimPrefix(u.Path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	f, err := os.Open(archive)
+	if err != nil {
+		fatalf("opening archive, %v", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigc
+		infof("stopping, the objects written so far are left in place")
+		cancel()
+	}()
+
+	auth, err := cfg.awsAuth(ctx)
+	if err != nil {
+		fatalf("no AWS keys given, %v", err)
+	}
+	store := taring.NewS3Store(auth, cfg.region(), u.Host)
+	infof("untarring %q into %q", archive, "s3://"+u.Host+"/"+prefix)
+	start := time.Now()
+	written, err := taring.Untar(ctx, f, store, prefix)
+	var size int64
+	for _, obj := range written {
+		size += obj.Size
+	}
+	infof("wrote %d objects (%s) in %v", len(written), humanize.Bytes(uint64(size)), time.Since(start))
+	if err != nil {
+		fatalf("untarring %q, %v", archive, err)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/aybabtme/taring"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+)
+
+func verifyCmd(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	var cfg JobConfig
+	cfg.register(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: taring verify [flags]\n\n")
+		fmt.Fprintf(os.Stderr, "Lists `s3-path` again, with the same filters as when archiving, and checks that the archive at `tar-path` has every object with the same size and checksum, or only the same size for objects encrypted with KMS or a customer key. Missing, extra and mismatched objects are printed, and taring exits with 1 if there are any, or with 2 if it can't verify the archive.\n\n")
+		fs.PrintDefaults()
+	}
+	if pos := parseInterspersed(fs, args); len(pos) != 0 {
+		fatalFlagSet(fs, "need no arguments, got %q.\n", pos)
+	}
+	if err := cfg.validate(); err != nil {
+		fatalFlagSet(fs, "%v.\n", err)
+	}
+	if cfg.Destination == stdoutPath || strings.HasPrefix(cfg.Destination, "s3://") {
+		fatalFlagSet(fs, "need a local archive to verify, got %q.\n", cfg.Destination)
+	}
+	if cfg.Format == "zip" {
+		fatalFlagSet(fs, "need a tar archive, zip archives can't be verified.\n")
+	}
+
+	archive, err := taring.OpenVolumes(cfg.Destination, 0)
+	if err != nil {
+		fatalf("opening archive, %v", err)
+	}
+	defer func() { _ = archive.Close() }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigc
+		cancel()
+	}()
+
+	v, err := cfg.archiver(new(taring.JobProgress)).Verify(ctx, archive)
+	if err != nil {
+		fatalf("verifying %q, %v", cfg.Destination, err)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	if !v.OK() {
+		fmt.Fprintln(tw, "PROBLEM\tKEY\tDETAIL")
+	}
+	for _, key := range v.Missing {
+		fmt.Fprintf(tw, "missing\t%s\tnot in the archive\n", key)
+	}
+	for _, key := range v.Extra {
+		fmt.Fprintf(tw, "extra\t%s\tnot in the source\n", key)
+	}
+	for _, m := range v.Mismatched {
+		fmt.Fprintf(tw, "mismatched\t%s\t%s\n", m.Key, m.Reason)
+	}
+	if err := tw.Flush(); err != nil {
+		fatalf("printing problems, %v", err)
+	}
+	if !v.OK() {
+		// 1 tells a bad archive apart from a verification that couldn't
+		// run, which fatalf exits with 2 for
+		errorf("%q doesn't match %q: %d missing, %d extra and %d mismatched of %d objects", cfg.Destination, cfg.Source, len(v.Missing), len(v.Extra), len(v.Mismatched), v.Objects)
+		os.Exit(1)
+	}
+	infof("%q matches the %d objects of %q", cfg.Destination, v.Objects, cfg.Source)
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/aybabtme/taring"
+	"github.com/crowdmob/goamz/aws"
+	"github.com/crowdmob/goamz/s3"
+	"github.com/crowdmob/goamz/sqs"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// watchStall is how long the watcher can go without polling its queue
+// before its liveness probe fails.
+const watchStall = 10 * time.Minute
+
+func watchCmd(args []string) {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	var cfg JobConfig
+	fs.StringVar(&cfg.AWSSecret, "aws-secret", "", "an AWS secret key")
+	fs.StringVar(&cfg.AWSAccess, "aws-access", "", "an AWS access key")
+	fs.StringVar(&cfg.AWSRegion, "aws-region", aws.USEast.Name, "an AWS region string")
+	fs.StringVar(&cfg.Source, "s3-path", "", "a URL of the form `s3://bucketname/path/to/files`, objects created elsewhere are ignored")
+	fs.StringVar(&cfg.Destination, "tar-path", "bucket-{time}.tar.gz", "a path to save the rolling archives to, `{time}` is replaced by the time an archive is started")
+	fs.StringVar(&cfg.Job, "job", "", "a name for this archiving job, used in `tar-path`")
+	queueName := fs.String("sqs-queue", "", "the name of the SQS queue receiving the bucket's ObjectCreated notifications")
+	roll := fs.String("roll", "day", "how often to start a new archive, `hour` or `day`")
+	flushEvery := fs.Duration("flush", time.Minute, "how often to flush the current archive to disk")
+	listen := fs.String("listen", "", "an address to serve `/healthz`, `/readyz` and `/jobs` on")
+	var prof profiling
+	prof.register(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: taring watch [flags]\n\n")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+
+	periods := map[string]time.Duration{"hour": time.Hour, "day": 24 * time.Hour}
+	period, periodOk := periods[*roll]
+	switch {
+	case *queueName == "":
+		fatalFlagSet(fs, "need an SQS queue to read notifications from.\n")
+	case !periodOk:
+		fatalFlagSet(fs, "need `roll` to be `hour` or `day`, not %q.\n", *roll)
+	case !strings.Contains(cfg.Destination, taring.TimePlaceholder):
+		fatalFlagSet(fs, "need a %s placeholder in `tar-path` to roll archives.\n", taring.TimePlaceholder)
+	}
+	if err := cfg.validate(); err != nil {
+		fatalFlagSet(fs, "%v.\n", err)
+	}
+
+	auth, err := cfg.awsAuth(context.Background())
+	if err != nil {
+		fatalf("no AWS keys given, %v", err)
+	}
+	region := cfg.region()
+	queue, err := sqs.New(auth, region).GetQueue(*queueName)
+	if err != nil {
+		fatalf("finding queue %q, %v", *queueName, err)
+	}
+	srcURL, err := url.Parse(cfg.Source)
+	if err != nil {
+		fatalf("not a URL, %v", err)
+	}
+
+	w := &watcher{
+		queue:      queue,
+		s3c:        s3.New(auth, region),
+		bucket:     srcURL.Host,
+		prefix:     strings.TrimPrefix(srcURL.Path, "/"),
+		flushEvery: *flushEvery,
+		arch:       &rollingArchive{tmpl: cfg.Destination, job: cfg.Job, period: period, source: cfg.Source},
+		health:     newHealth(watchStall),
+		status:     WatchStatus{Source: cfg.Source, Queue: *queueName},
+	}
+
+	if *listen != "" {
+		mux := http.NewServeMux()
+		w.health.register(mux)
+		mux.HandleFunc("/jobs", w.handleStatus)
+		go func() {
+			infof("serving health and status on %q", *listen)
+			if err := http.ListenAndServe(*listen, mux); err != nil {
+				fatalf("serving on %q, %v", *listen, err)
+			}
+		}()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigc
+		infof("stopping, closing the current archive")
+		w.health.setNotReady("shutting down")
+		cancel()
+	}()
+
+	stopProfiling := prof.start()
+	infof("watching queue %q for objects created in %q", *queueName, cfg.Source)
+	err = w.run(ctx)
+	stopProfiling()
+	if err != nil {
+		fatalf("%v", err)
+	}
+}
+
+// s3Event is the part of an S3 event notification taring cares about.
+type s3Event struct {
+	Records []struct {
+		EventName string    `json:"eventName"`
+		EventTime time.Time `json:"eventTime"`
+		S3        struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key  string `json:"key"`
+				Size int64  `json:"size"`
+				ETag string `json:"eTag"`
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+}
+
+// WatchStatus is what the watcher reports at `/jobs`.
+type WatchStatus struct {
+	Source    string    `json:"source"`
+	Queue     string    `json:"queue"`
+	Archive   string    `json:"archive,omitempty"`
+	Objects   int       `json:"objects"`
+	Pending   int       `json:"pending_messages"`
+	LastFlush time.Time `json:"last_flush"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// watcher appends objects to rolling archives as notifications of their
+// creation arrive. A notification is only deleted from the queue once its
+// objects are flushed to an archive, so none are lost if taring stops.
+type watcher struct {
+	queue      *sqs.Queue
+	s3c        *s3.S3
+	bucket     string
+	prefix     string
+	flushEvery time.Duration
+	arch       *rollingArchive
+	health     *health
+
+	pending   []sqs.Message
+	lastFlush time.Time
+
+	mu     sync.Mutex
+	status WatchStatus
+}
+
+// publish updates the status served at `/jobs`; the watcher's own state
+// is only touched by its loop.
+func (w *watcher) publish(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.status.Archive = ""
+	w.status.Objects = 0
+	if w.arch.file != nil {
+		w.status.Archive = w.arch.path
+		w.status.Objects = len(w.arch.objects)
+	}
+	w.status.Pending = len(w.pending)
+	w.status.LastFlush = w.lastFlush
+	w.status.Error = ""
+	if err != nil {
+		w.status.Error = err.Error()
+	}
+}
+
+func (w *watcher) handleStatus(rw http.ResponseWriter, r *http.Request) {
+	w.mu.Lock()
+	st := w.status
+	w.mu.Unlock()
+	writeJSON(rw, http.StatusOK, st)
+}
+
+func (w *watcher) run(ctx context.Context) error {
+	defer func() {
+		if err := w.flush(); err != nil {
+			errorf("%v", err)
+		}
+		if err := w.arch.Close(); err != nil {
+			errorf("closing archive, %v", err)
+		}
+	}()
+	w.lastFlush = time.Now()
+	for ctx.Err() == nil {
+		w.health.heartbeat()
+		resp, err := w.queue.ReceiveMessageWithParameters(map[string]string{
+			"MaxNumberOfMessages": "10",
+			"WaitTimeSeconds":     "20",
+		})
+		if err != nil {
+			errorf("receiving from queue, %v", err)
+			w.health.setNotReady(fmt.Sprintf("receiving from queue, %v", err))
+			w.publish(err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		if ctx.Err() == nil {
+			w.health.setReady()
+		}
+		for _, msg := range resp.Messages {
+			if err := w.handle(msg); err != nil {
+				// left in the queue, it'll be redelivered
+				errorf("handling message %s, %v", msg.MessageId, err)
+				continue
+			}
+			w.pending = append(w.pending, msg)
+		}
+
+		if err := w.arch.roll(time.Now()); err != nil {
+			return err
+		}
+		if time.Since(w.lastFlush) >= w.flushEvery {
+			if err := w.flush(); err != nil {
+				return err
+			}
+		}
+		w.publish(nil)
+	}
+	return nil
+}
+
+// underPrefix tells if key is under the folder prefix, which is all of a
+// bucket when empty: `data` holds `data/x` but not `database/x`.
+func underPrefix(key, prefix string) bool {
+	if prefix == "" {
+		return true
+	}
+	return strings.HasPrefix(key, strings.TrimSuffix(prefix, "/")+"/")
+}
+
+func (w *watcher) handle(msg sqs.Message) error {
+	var ev s3Event
+	if err := json.Unmarshal([]byte(msg.Body), &ev); err != nil {
+		return fmt.Errorf("decoding event, %v", err)
+	}
+	for _, rec := range ev.Records {
+		if !strings.HasPrefix(rec.EventName, "ObjectCreated:") || rec.S3.Bucket.Name != w.bucket {
+			continue
+		}
+		key, err := url.QueryUnescape(rec.S3.Object.Key)
+		if err != nil {
+			return fmt.Errorf("decoding key %q, %v", rec.S3.Object.Key, err)
+		}
+		if !underPrefix(key, w.prefix) {
+			continue
+		}
+		relPath, err := filepath.Rel(w.prefix, key)
+		if err != nil {
+			return fmt.Errorf("failed to find relative path for %q: %v", key, err)
+		}
+
+		start := time.Now()
+		data, err := w.s3c.Bucket(w.bucket).Get(key)
+		if err != nil {
+			return fmt.Errorf("failed fetch of %q: %s", key, taring.DescribeS3Error(err))
+		}
+		object := taring.S3Content{
+			Key:     key,
+			ETag:    strings.Trim(rec.S3.Object.ETag, `"`),
+			Name:    relPath,
+			LastMod: rec.EventTime,
+			Size:    int64(len(data)),
+			Data:    *bytes.NewBuffer(data),
+		}
+		if err := w.arch.append(time.Now(), object); err != nil {
+			return err
+		}
+		infof("\t(%v) appended %q to %q", time.Since(start), key, w.arch.path)
+	}
+	return nil
+}
+
+// flush makes sure the appended objects are on disk, then acknowledges the
+// notifications they came from.
+func (w *watcher) flush() error {
+	if err := w.arch.flush(); err != nil {
+		return err
+	}
+	for i := range w.pending {
+		if _, err := w.queue.DeleteMessage(&w.pending[i]); err != nil {
+			errorf("deleting message %s, %v", w.pending[i].MessageId, err)
+		}
+	}
+	w.pending = w.pending[:0]
+	w.lastFlush = time.Now()
+	return nil
+}
+
+// rollingArchive is an archive that's closed and replaced by a new one
+// every period.
+type rollingArchive struct {
+	tmpl   string
+	job    string
+	source string
+	period time.Duration
+
+	window  time.Time
+	path    string
+	file    *os.File
+	gzw     *gzip.Writer
+	tarw    *tar.Writer
+	objects []taring.ManifestObject
+}
+
+func (r *rollingArchive) append(now time.Time, object taring.S3Content) error {
+	if err := r.roll(now); err != nil {
+		return err
+	}
+	if r.file == nil {
+		if err := r.open(now); err != nil {
+			return err
+		}
+	}
+	if err := r.tarw.WriteHeader(object.TarHeader()); err != nil {
+		return fmt.Errorf("writing header of %q, %v", object.Name, err)
+	}
+	if _, err := r.tarw.Write(object.Data.Bytes()); err != nil {
+		return fmt.Errorf("writing content of %q, %v", object.Name, err)
+	}
+	r.objects = append(r.objects, taring.ManifestObject{
+		Key:          object.Key,
+		Name:         object.Name,
+		Size:         object.Size,
+		LastModified: object.LastMod,
+		ETag:         object.ETag,
+	})
+	return nil
+}
+
+// roll closes the current archive if its period is over.
+func (r *rollingArchive) roll(now time.Time) error {
+	if r.file == nil || now.UTC().Truncate(r.period).Equal(r.window) {
+		return nil
+	}
+	infof("rolling over archive %q", r.path)
+	return r.Close()
+}
+
+func (r *rollingArchive) open(now time.Time) error {
+	// named after the time it's opened rather than its window, so a restart
+	// within a window doesn't overwrite the archive of that window
+	r.path = taring.ExpandArchivePath(r.tmpl, r.job, now)
+	r.window = now.UTC().Truncate(r.period)
+	file, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, filePerms)
+	if err != nil {
+		return fmt.Errorf("creating archive, %v", err)
+	}
+	infof("started archive %q", r.path)
+	r.file = file
+	r.gzw = gzip.NewWriter(file)
+	r.tarw = tar.NewWriter(r.gzw)
+	r.objects = nil
+	return nil
+}
+
+func (r *rollingArchive) flush() error {
+	if r.file == nil {
+		return nil
+	}
+	if err := r.tarw.Flush(); err != nil {
+		return fmt.Errorf("flushing tar stream of %q, %v", r.path, err)
+	}
+	if err := r.gzw.Flush(); err != nil {
+		return fmt.Errorf("flushing gzip stream of %q, %v", r.path, err)
+	}
+	return r.file.Sync()
+}
+
+// Close completes the current archive and writes its manifest.
+func (r *rollingArchive) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	defer func() { r.file = nil }()
+	if err := r.tarw.Close(); err != nil {
+		return fmt.Errorf("closing tar stream of %q, %v", r.path, err)
+	}
+	if err := r.gzw.Close(); err != nil {
+		return fmt.Errorf("closing gzip stream of %q, %v", r.path, err)
+	}
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	infof("completed archive %q with %d objects", r.path, len(r.objects))
+	return taring.WriteManifest(taring.ManifestPath(r.path), &taring.Manifest{
+		Source:  r.source,
+		Archive: r.path,
+		Created: time.Now(),
+		Objects: r.objects,
+	})
+}
@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestUnderPrefix(t *testing.T) {
+	for _, c := range []struct {
+		key, prefix string
+		want        bool
+	}{
+		{"data/x", "data", true},
+		{"data/x", "data/", true},
+		{"data/logs/x", "data", true},
+		{"database/x", "data", false},
+		{"data", "data", false},
+		{"other/x", "data/", false},
+		{"anything", "", true},
+	} {
+		if got := underPrefix(c.key, c.prefix); got != c.want {
+			t.Errorf("%q under %q: want %v, got %v", c.key, c.prefix, c.want, got)
+		}
+	}
+}
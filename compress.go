@@ -0,0 +1,180 @@
+package taring
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+	"io"
+	"io/ioutil"
+)
+
+// Compressor compresses archives.
+type Compressor interface {
+	// NewWriter compresses what's written to it into w. What's written is
+	// only complete once it's closed.
+	NewWriter(w io.Writer) io.WriteCloser
+	// Extension is the file extension of what it writes, e.g. `.gz`.
+	Extension() string
+	// ContentType is the MIME type of what it writes.
+	ContentType() string
+}
+
+// flusher is a compressing writer that can end a block early, so that
+// what's been written so far can be told apart.
+type flusher interface {
+	Flush() error
+}
+
+// Uncompressed leaves archives as they are, such as zip archives, which
+// compress their entries themselves.
+type Uncompressed struct{}
+
+// NewWriter writes to w as it is.
+func (Uncompressed) NewWriter(w io.Writer) io.WriteCloser { return nopFlushCloser{w} }
+
+// Extension is empty.
+func (Uncompressed) Extension() string { return "" }
+
+// ContentType is `application/octet-stream`, as it depends on the archive.
+func (Uncompressed) ContentType() string { return "application/octet-stream" }
+
+type nopFlushCloser struct{ io.Writer }
+
+func (nopFlushCloser) Flush() error { return nil }
+func (nopFlushCloser) Close() error { return nil }
+
+// Gzip compresses with gzip.
+type Gzip struct {
+	// Level is a compress/gzip level, from 1 (fastest) to 9 (smallest).
+	// Zero, or a level out of range, is the default level.
+	Level int
+	// Workers compress blocks of the stream in parallel when there are
+	// more than one, which still makes a single gzip stream.
+	Workers int
+}
+
+// pgzipBlockSize is how much each worker compresses at a time.
+const pgzipBlockSize = 1 << 20
+
+// NewWriter compresses into w.
+func (g Gzip) NewWriter(w io.Writer) io.WriteCloser {
+	if g.Workers > 1 {
+		level := g.Level
+		if level == 0 {
+			level = pgzip.DefaultCompression
+		}
+		pw, err := pgzip.NewWriterLevel(w, level)
+		if err != nil {
+			pw = pgzip.NewWriter(w)
+		}
+		// only a block size too small fails
+		_ = pw.SetConcurrency(pgzipBlockSize, g.Workers)
+		return pw
+	}
+	gw, err := gzip.NewWriterLevel(w, g.Level)
+	if g.Level == 0 || err != nil {
+		return gzip.NewWriter(w)
+	}
+	return gw
+}
+
+// Extension is `.gz`.
+func (Gzip) Extension() string { return ".gz" }
+
+// ContentType is `application/gzip`.
+func (Gzip) ContentType() string { return "application/gzip" }
+
+// Zstd compresses with Zstandard, much faster than gzip at a similar
+// ratio.
+type Zstd struct {
+	// Level is a zstd level, from 1 (fastest) to 22 (smallest), which is
+	// mapped to the closest level the encoder has. Zero is the default.
+	Level int
+}
+
+// NewWriter compresses into w.
+func (z Zstd) NewWriter(w io.Writer) io.WriteCloser {
+	level := zstd.SpeedDefault
+	if z.Level != 0 {
+		level = zstd.EncoderLevelFromZstd(z.Level)
+	}
+	// only invalid options fail
+	zw, _ := zstd.NewWriter(w, zstd.WithEncoderLevel(level))
+	return zw
+}
+
+// Extension is `.zst`.
+func (Zstd) Extension() string { return ".zst" }
+
+// ContentType is `application/zstd`.
+func (Zstd) ContentType() string { return "application/zstd" }
+
+// Xz compresses with xz, slowly but smaller than the others, for archives
+// that go to cold storage. Its writers can't flush, so it can't keep
+// entry ratios.
+type Xz struct{}
+
+// NewWriter compresses into w.
+func (Xz) NewWriter(w io.Writer) io.WriteCloser {
+	// only an invalid config fails
+	xw, _ := xz.NewWriter(w)
+	return xw
+}
+
+// Extension is `.xz`.
+func (Xz) Extension() string { return ".xz" }
+
+// ContentType is `application/x-xz`.
+func (Xz) ContentType() string { return "application/x-xz" }
+
+// Lz4 compresses with LZ4, faster than the others but not as small, for
+// transfers within a datacenter.
+type Lz4 struct{}
+
+// NewWriter compresses into w.
+func (Lz4) NewWriter(w io.Writer) io.WriteCloser { return lz4.NewWriter(w) }
+
+// Extension is `.lz4`.
+func (Lz4) Extension() string { return ".lz4" }
+
+// ContentType is `application/x-lz4`.
+func (Lz4) ContentType() string { return "application/x-lz4" }
+
+// Magic numbers starting what compressors write.
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	xzMagic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	lz4Magic  = []byte{0x04, 0x22, 0x4d, 0x18}
+)
+
+// decompress reads what one of the compressors of taring wrote, telling
+// which from its first bytes.
+func decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(8)
+	switch {
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	case bytes.HasPrefix(magic, xzMagic):
+		xr, err := xz.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(xr), nil
+	case bytes.HasPrefix(magic, lz4Magic):
+		return ioutil.NopCloser(lz4.NewReader(br)), nil
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+	}
+	return nil, errors.New("not compressed with a known compressor")
+}
@@ -0,0 +1,59 @@
+package taring_test
+
+import (
+	"context"
+	"github.com/aybabtme/taring"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// checkCompressed archives the fixture compressed with c, and reads it
+// back.
+func checkCompressed(t *testing.T, c taring.Compressor) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	archive := filepath.Join(dir, "archive.tar"+c.Extension())
+	_, err = taring.New("s3://bucket/data/", archive, taring.WithStore(newStore()), taring.WithCompressor(c)).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	arch, err := taring.OpenArchiveAt(archive, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer arch.Close()
+	n := 0
+	for ; ; n++ {
+		hdr, err := arch.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(arch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key := hdr.PAXRecords[taring.PAXKey]; string(data) != fixture[key] {
+			t.Errorf("want %q in %q, got %q", fixture[key], key, data)
+		}
+	}
+	if want := len(fixture) - 1; n != want {
+		t.Errorf("want %d entries, got %d", want, n)
+	}
+}
+
+func TestRunZstd(t *testing.T) { checkCompressed(t, taring.Zstd{}) }
+
+func TestRunXz(t *testing.T) { checkCompressed(t, taring.Xz{}) }
+
+func TestRunLz4(t *testing.T) { checkCompressed(t, taring.Lz4{}) }
+
+func TestRunGzipWorkers(t *testing.T) { checkCompressed(t, taring.Gzip{Workers: 4}) }
@@ -0,0 +1,113 @@
+package taring
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// EntryHeader describes an entry of an archive, whatever its format.
+type EntryHeader struct {
+	Name    string
+	Size    int64
+	Mode    os.FileMode
+	ModTime time.Time
+	// Metadata are records kept along the entry, such as PAXKey.
+	Metadata map[string]string
+}
+
+// ArchiveWriter writes entries into an archive container.
+type ArchiveWriter interface {
+	// WriteEntry writes an entry with the content read from r, which must
+	// be hdr.Size long. The entry is complete when it returns.
+	WriteEntry(hdr EntryHeader, r io.Reader) error
+	// Close ends the archive, without closing what it's written to.
+	Close() error
+}
+
+// tarWriter writes a tar stream.
+type tarWriter struct {
+	tw *tar.Writer
+}
+
+// NewTarWriter writes a tar stream to w. Metadata are kept as PAX records.
+func NewTarWriter(w io.Writer) ArchiveWriter {
+	return &tarWriter{tw: tar.NewWriter(w)}
+}
+
+func (t *tarWriter) WriteEntry(hdr EntryHeader, r io.Reader) error {
+	if err := t.tw.WriteHeader(tarHeader(hdr)); err != nil {
+		return err
+	}
+	if _, err := io.Copy(t.tw, r); err != nil {
+		return err
+	}
+	// pad the entry now rather than before the next one
+	return t.tw.Flush()
+}
+
+func (t *tarWriter) Close() error { return t.tw.Close() }
+
+// zipWriter writes a zip archive.
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+// NewZipWriter writes a zip archive to w, deflating every entry. Metadata
+// are kept in the comment of the entry, a `key=value` per line. Zip
+// compresses its entries itself, so the archive is best left Uncompressed.
+func NewZipWriter(w io.Writer) ArchiveWriter {
+	return &zipWriter{zw: zip.NewWriter(w)}
+}
+
+func (z *zipWriter) WriteEntry(hdr EntryHeader, r io.Reader) error {
+	keys := make([]string, 0, len(hdr.Metadata))
+	for k := range hdr.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	lines := make([]string, len(keys))
+	for i, k := range keys {
+		lines[i] = k + "=" + hdr.Metadata[k]
+	}
+	fh := &zip.FileHeader{
+		Name:     hdr.Name,
+		Method:   zip.Deflate,
+		Modified: hdr.ModTime,
+		Comment:  strings.Join(lines, "\n"),
+	}
+	fh.SetMode(hdr.Mode)
+	w, err := z.zw.CreateHeader(fh)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		return err
+	}
+	// hand what's buffered of the entry on now, so it's counted with this
+	// entry. The entry only ends as the next one starts: the rest of its
+	// deflated data and its data descriptor are counted with that one.
+	return z.zw.Flush()
+}
+
+func (z *zipWriter) Close() error { return z.zw.Close() }
+
+// tarHeader is how an entry is described in a tar stream.
+func tarHeader(hdr EntryHeader) *tar.Header {
+	return &tar.Header{
+		Name:       hdr.Name,
+		Size:       hdr.Size,
+		Mode:       int64(hdr.Mode),
+		AccessTime: time.Now(),
+		ChangeTime: hdr.ModTime,
+		ModTime:    hdr.ModTime,
+		Typeflag:   tar.TypeReg,
+		Uid:        os.Getuid(),
+		Gid:        os.Getgid(),
+		PAXRecords: hdr.Metadata,
+	}
+}
@@ -0,0 +1,226 @@
+package taring
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/crowdmob/goamz/aws"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Where instance and task roles hand out their credentials.
+const (
+	ec2MetadataURL   = "http://169.254.169.254/latest"
+	ecsCredentialsIP = "http://169.254.170.2"
+	metadataTimeout  = 5 * time.Second
+)
+
+// credentialsMargin is how long before they expire credentials are got
+// anew, so that no request is signed with credentials about to expire.
+const credentialsMargin = 5 * time.Minute
+
+// sharedSessionRecheck is how long a session token from the shared
+// credentials file is kept before the file is read again. The file doesn't
+// say when it expires, but STS sessions last 15 minutes at the least, so
+// the tools writing them in the file renew them at least that often.
+const sharedSessionRecheck = 15 * time.Minute
+
+// renewingAuth gets credentials from fn and keeps them until they're about
+// to expire, to get them anew then. Credentials that don't expire are kept
+// for good.
+func renewingAuth(fn func(ctx context.Context) (aws.Auth, error)) func(ctx context.Context) (aws.Auth, error) {
+	var (
+		mu   sync.Mutex
+		kept *aws.Auth
+	)
+	return func(ctx context.Context) (aws.Auth, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if kept != nil {
+			if exp := kept.Expiration(); exp.IsZero() || time.Until(exp) > credentialsMargin {
+				return *kept, nil
+			}
+		}
+		auth, err := fn(ctx)
+		if err != nil {
+			return aws.Auth{}, fmt.Errorf("getting AWS credentials, %v", err)
+		}
+		if err := checkExpiry(auth); err != nil {
+			return aws.Auth{}, err
+		}
+		kept = &auth
+		return auth, nil
+	}
+}
+
+// checkExpiry fails once auth has expired: goamz would otherwise sign with
+// whatever credentials the instance or the environment has.
+func checkExpiry(auth aws.Auth) error {
+	if exp := auth.Expiration(); !exp.IsZero() && !time.Now().Before(exp) {
+		return fmt.Errorf("AWS credentials expired at %s", exp.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// InstanceCredentials gets the temporary credentials of the role the
+// process runs as: that of its ECS task when there's one, or else that of
+// its EC2 instance. They're good for hours, and goamz renews those of an
+// instance as they expire; give it WithCredentials for every run to start
+// with fresh ones. Requests are made with client, or one that gives up
+// after metadataTimeout when it's nil, as there's no answer off AWS.
+func InstanceCredentials(client *http.Client) func(ctx context.Context) (aws.Auth, error) {
+	if client == nil {
+		client = &http.Client{Timeout: metadataTimeout}
+	}
+	return func(ctx context.Context) (aws.Auth, error) {
+		if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+			return fetchCredentials(ctx, client, ecsCredentialsIP+uri, nil)
+		}
+		if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI"); uri != "" {
+			var header http.Header
+			if token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"); token != "" {
+				header = http.Header{"Authorization": {token}}
+			}
+			return fetchCredentials(ctx, client, uri, header)
+		}
+		return ec2Credentials(ctx, client)
+	}
+}
+
+// ec2Credentials gets the credentials of the instance's role, with a
+// session token of IMDSv2 when the instance gives one.
+func ec2Credentials(ctx context.Context, client *http.Client) (aws.Auth, error) {
+	header := http.Header{}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, ec2MetadataURL+"/api/token", nil)
+	if err != nil {
+		return aws.Auth{}, err
+	}
+	req.Header.Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "21600")
+	if resp, err := client.Do(req); err == nil {
+		token, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<12))
+		_ = resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			header.Set("X-Aws-Ec2-Metadata-Token", string(token))
+		}
+	}
+
+	rolesURL := ec2MetadataURL + "/meta-data/iam/security-credentials/"
+	resp, err := getMetadata(ctx, client, rolesURL, header)
+	if err != nil {
+		return aws.Auth{}, fmt.Errorf("finding the role of the instance, %v", err)
+	}
+	defer resp.Body.Close()
+	sc := bufio.NewScanner(resp.Body)
+	if !sc.Scan() || strings.TrimSpace(sc.Text()) == "" {
+		return aws.Auth{}, fmt.Errorf("finding the role of the instance, none is attached")
+	}
+	return fetchCredentials(ctx, client, rolesURL+strings.TrimSpace(sc.Text()), header)
+}
+
+// fetchCredentials gets credentials as the metadata services of EC2 and
+// ECS hand them out.
+func fetchCredentials(ctx context.Context, client *http.Client, url string, header http.Header) (aws.Auth, error) {
+	resp, err := getMetadata(ctx, client, url, header)
+	if err != nil {
+		return aws.Auth{}, fmt.Errorf("getting credentials, %v", err)
+	}
+	defer resp.Body.Close()
+	var creds struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string
+		Token           string
+		Expiration      time.Time
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&creds); err != nil {
+		return aws.Auth{}, fmt.Errorf("decoding credentials, %v", err)
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return aws.Auth{}, fmt.Errorf("getting credentials, %s handed out none", url)
+	}
+	return *aws.NewAuth(creds.AccessKeyID, creds.SecretAccessKey, creds.Token, creds.Expiration), nil
+}
+
+func getMetadata(ctx context.Context, client *http.Client, url string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+	return resp, nil
+}
+
+// SharedCredentials gets the keys of profile from the shared credentials
+// file the AWS CLI writes, at path or, when it's empty, where
+// AWS_SHARED_CREDENTIALS_FILE says or in ~/.aws/credentials. The file is
+// read on every call, so keys rotated in it are picked up by the next run.
+// Keys without a session token don't expire; those with one are read again
+// every 15 minutes during a run.
+func SharedCredentials(path, profile string) func(ctx context.Context) (aws.Auth, error) {
+	return func(context.Context) (aws.Auth, error) {
+		file := path
+		if file == "" {
+			file = os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+		}
+		if file == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return aws.Auth{}, fmt.Errorf("finding the shared credentials file, %v", err)
+			}
+			file = filepath.Join(home, ".aws", "credentials")
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return aws.Auth{}, fmt.Errorf("reading shared credentials, %v", err)
+		}
+		defer f.Close()
+
+		keys := make(map[string]string)
+		var section string
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			line := strings.TrimSpace(sc.Text())
+			switch {
+			case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+			case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+				section = strings.TrimSpace(line[1 : len(line)-1])
+			case section == profile:
+				if i := strings.Index(line, "="); i > 0 {
+					keys[strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+				}
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return aws.Auth{}, fmt.Errorf("reading shared credentials %q, %v", file, err)
+		}
+		access, secret := keys["aws_access_key_id"], keys["aws_secret_access_key"]
+		if access == "" || secret == "" {
+			return aws.Auth{}, fmt.Errorf("no keys for profile %q in %q", profile, file)
+		}
+		token := keys["aws_session_token"]
+		if token == "" {
+			return aws.Auth{AccessKey: access, SecretKey: secret}, nil
+		}
+		// when a session token's expiration is past, goamz swaps in the
+		// instance's credentials; the file doesn't tell it, so it's set to
+		// when the file is read again
+		return *aws.NewAuth(access, secret, token, time.Now().Add(sharedSessionRecheck)), nil
+	}
+}
@@ -0,0 +1,235 @@
+package taring_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/aybabtme/taring"
+	"github.com/aybabtme/taring/taringtest"
+	"github.com/crowdmob/goamz/aws"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestInstanceCredentialsECS(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "task-token" {
+			t.Errorf("want the task's authorization token, got %q", got)
+		}
+		_, _ = w.Write([]byte(`{
+			"AccessKeyId": "AKID",
+			"SecretAccessKey": "SECRET",
+			"Token": "SESSION",
+			"Expiration": "2016-03-01T18:00:00Z"
+		}`))
+	}))
+	defer srv.Close()
+	os.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", srv.URL+"/creds")
+	os.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN", "task-token")
+	defer os.Unsetenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	defer os.Unsetenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+
+	auth, err := taring.InstanceCredentials(srv.Client())(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.AccessKey != "AKID" || auth.SecretKey != "SECRET" {
+		t.Errorf("want the task's keys, got %q and %q", auth.AccessKey, auth.SecretKey)
+	}
+	if auth.Token() != "SESSION" {
+		t.Errorf("want session token %q, got %q", "SESSION", auth.Token())
+	}
+	if want := time.Date(2016, 3, 1, 18, 0, 0, 0, time.UTC); !auth.Expiration().Equal(want) {
+		t.Errorf("want expiration %v, got %v", want, auth.Expiration())
+	}
+}
+
+func TestAssumeRole(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("Action") != "AssumeRole" || q.Get("RoleArn") != "arn:aws:iam::123456789012:role/archiver" {
+			t.Errorf("want to assume the role, got %v", q)
+		}
+		if q.Get("ExternalId") != "ext" || q.Get("RoleSessionName") != "taring" {
+			t.Errorf("want the external ID and default session name, got %v", q)
+		}
+		_, _ = w.Write([]byte(`<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+			<AssumeRoleResult>
+				<Credentials>
+					<AccessKeyId>ASIA</AccessKeyId>
+					<SecretAccessKey>SECRET</SecretAccessKey>
+					<SessionToken>SESSION</SessionToken>
+					<Expiration>2016-03-01T18:00:00Z</Expiration>
+				</Credentials>
+			</AssumeRoleResult>
+		</AssumeRoleResponse>`))
+	}))
+	defer srv.Close()
+
+	base := func(context.Context) (aws.Auth, error) {
+		return aws.Auth{AccessKey: "AKID", SecretKey: "KEY"}, nil
+	}
+	role := taring.Role{ARN: "arn:aws:iam::123456789012:role/archiver", ExternalID: "ext"}
+	region := aws.Region{Name: "us-east-1", STSEndpoint: srv.URL}
+	auth, err := taring.AssumeRole(role, base, region, srv.Client())(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.AccessKey != "ASIA" || auth.SecretKey != "SECRET" || auth.Token() != "SESSION" {
+		t.Errorf("want the role's credentials, got %q, %q and %q", auth.AccessKey, auth.SecretKey, auth.Token())
+	}
+}
+
+func TestMFASession(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		q := r.URL.Query()
+		if q.Get("Action") != "GetSessionToken" || q.Get("SerialNumber") != "arn:aws:iam::123456789012:mfa/me" || q.Get("TokenCode") != "123456" {
+			t.Errorf("want a session of the device with its code, got %v", q)
+		}
+		expires := time.Now().Add(12 * time.Hour).UTC().Format(time.RFC3339)
+		_, _ = w.Write([]byte(`<GetSessionTokenResponse>
+			<GetSessionTokenResult>
+				<Credentials>
+					<AccessKeyId>ASIA</AccessKeyId>
+					<SecretAccessKey>SECRET</SecretAccessKey>
+					<SessionToken>SESSION</SessionToken>
+					<Expiration>` + expires + `</Expiration>
+				</Credentials>
+			</GetSessionTokenResult>
+		</GetSessionTokenResponse>`))
+	}))
+	defer srv.Close()
+
+	var asked int
+	token := func() (string, error) {
+		asked++
+		return "123456", nil
+	}
+	base := func(context.Context) (aws.Auth, error) {
+		return aws.Auth{AccessKey: "AKID", SecretKey: "KEY"}, nil
+	}
+	region := aws.Region{Name: "us-east-1", STSEndpoint: srv.URL}
+	session := taring.MFASession("arn:aws:iam::123456789012:mfa/me", token, base, region, srv.Client())
+	for i := 0; i < 3; i++ {
+		auth, err := session(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if auth.AccessKey != "ASIA" || auth.Token() != "SESSION" {
+			t.Errorf("want the session's credentials, got %q and %q", auth.AccessKey, auth.Token())
+		}
+	}
+	if asked != 1 || calls != 1 {
+		t.Errorf("want a single code used for the whole session, asked %d times and called STS %d times", asked, calls)
+	}
+}
+
+func TestSharedCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "credentials")
+	err = ioutil.WriteFile(path, []byte(`[default]
+aws_access_key_id = AKIDDEFAULT
+aws_secret_access_key = DEFAULT
+
+# the archiving account
+[archiver]
+aws_access_key_id = AKID
+aws_secret_access_key = SECRET
+aws_session_token = SESSION
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth, err := taring.SharedCredentials(path, "archiver")(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.AccessKey != "AKID" || auth.SecretKey != "SECRET" || auth.Token() != "SESSION" {
+		t.Errorf("want the profile's keys, got %q, %q and %q", auth.AccessKey, auth.SecretKey, auth.Token())
+	}
+	if exp := auth.Expiration(); exp.IsZero() || time.Until(exp) > time.Hour {
+		t.Errorf("want the session read again within the hour, got it expiring at %v", exp)
+	}
+	auth, err = taring.SharedCredentials(path, "default")(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.AccessKey != "AKIDDEFAULT" || !auth.Expiration().IsZero() {
+		t.Errorf("want the default profile's keys for good, got %q expiring at %v", auth.AccessKey, auth.Expiration())
+	}
+	if _, err := taring.SharedCredentials(path, "missing")(context.Background()); err == nil {
+		t.Error("want an error for a profile that isn't in the file")
+	}
+}
+
+func TestWithCredentialsRenewed(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		tokens = make(map[string]bool)
+	)
+	s3srv := taringtest.S3Handler("bucket", newStore())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		tokens[r.Header.Get("X-Amz-Security-Token")] = true
+		mu.Unlock()
+		s3srv.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// every session is about to expire, so each request needs another
+	var sessions int32
+	creds := func(context.Context) (aws.Auth, error) {
+		n := atomic.AddInt32(&sessions, 1)
+		return *aws.NewAuth("ASIA", "SECRET", fmt.Sprintf("session-%d", n), time.Now().Add(time.Minute)), nil
+	}
+	_, err = taring.New("s3://bucket/data/", filepath.Join(dir, "archive.tar.gz"),
+		taring.WithAuth(aws.Auth{}, taring.CustomS3Endpoint(aws.USEast, srv.URL, true)),
+		taring.WithCredentials(creds),
+	).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens[""] {
+		t.Error("want every request signed with a session, some had none")
+	}
+	if len(tokens) < 2 {
+		t.Errorf("want the session renewed during the run, got %d sessions", len(tokens))
+	}
+}
+
+func TestWithCredentialsExpired(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	creds := func(context.Context) (aws.Auth, error) {
+		return *aws.NewAuth("ASIA", "SECRET", "SESSION", time.Now().Add(-time.Minute)), nil
+	}
+	_, err = taring.New("s3://bucket/data/", filepath.Join(dir, "archive.tar.gz"),
+		taring.WithCredentials(creds),
+	).Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "AWS credentials expired") {
+		t.Errorf("want the run to fail for expired credentials, got %v", err)
+	}
+}
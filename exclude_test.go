@@ -0,0 +1,44 @@
+package taring_test
+
+import (
+	"context"
+	"github.com/aybabtme/taring"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRunExcludeManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := newStore()
+	full := filepath.Join(dir, "full.tar.gz")
+	if _, err := taring.New("s3://bucket/data/", full, taring.WithStore(store)).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	lastMod := time.Date(2016, 3, 2, 12, 0, 0, 0, time.UTC)
+	store.Put("data/readme.txt", []byte("hello again"), lastMod)
+	store.Put("data/new", []byte("new"), lastMod)
+
+	incremental := filepath.Join(dir, "incremental.tar.gz")
+	_, err = taring.New("s3://bucket/data/", incremental,
+		taring.WithStore(store),
+		taring.WithExcludeManifest(taring.ManifestPath(full)),
+	).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := taring.ReadManifest(taring.ManifestPath(incremental))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Objects) != 2 || m.Objects[0].Key != "data/new" || m.Objects[1].Key != "data/readme.txt" {
+		t.Errorf("want only what's new or changed since the full archive, got %+v", m.Objects)
+	}
+}
@@ -0,0 +1,4 @@
+package taring
+
+// SlowGrace lets the tests judge the speed of fetches sooner.
+var SlowGrace = &slowGrace
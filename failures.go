@@ -0,0 +1,227 @@
+package taring
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/crowdmob/goamz/s3"
+	"io"
+	"net"
+	"sort"
+	"strings"
+)
+
+// Failure classes.
+const (
+	failThrottled = "throttled"
+	failNotFound  = "not_found"
+	failForbidden = "forbidden"
+	failTimeout   = "timeout"
+	failNetwork   = "network"
+	failChecksum  = "checksum"
+	failOther     = "other"
+)
+
+// What was being done to a key when it failed.
+const (
+	OpFetch    = "fetch"
+	OpCheckTag = "check tags of"
+	OpName     = "name"
+	OpUpload   = "upload"
+	OpTag      = "tag"
+)
+
+// KeyError is why an operation on a key failed.
+type KeyError struct {
+	Key string
+	// Op is what was being done to the key, one of the Op constants.
+	Op  string
+	Err error
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("failed to %s %q: %s", e.Op, e.Key, DescribeS3Error(e.Err))
+}
+
+func (e *KeyError) Unwrap() error { return e.Err }
+
+// KeyErrors are the failures of several keys, sorted by key. errors.Is and
+// errors.As look through all of them.
+type KeyErrors []*KeyError
+
+func (e KeyErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d errors: %s", len(e), strings.Join(msgs, ", "))
+}
+
+func (e KeyErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// failureExamples is how many keys are kept as examples of a class.
+const failureExamples = 5
+
+// FailureClass is a kind of failure, how many keys failed that way, and a
+// few of them.
+type FailureClass struct {
+	Class    string      `json:"class"`
+	Count    int         `json:"count"`
+	Examples []FailedKey `json:"examples"`
+}
+
+// FailedKey is a key that couldn't be fetched, with the IDs AWS support
+// needs to look into the failed request, when S3 answered.
+type FailedKey struct {
+	Key       string `json:"key"`
+	Error     string `json:"error"`
+	RequestID string `json:"request_id,omitempty"`
+	HostID    string `json:"host_id,omitempty"`
+}
+
+// DescribeS3Error is an error's message, along with the S3 error code and
+// the request IDs when it comes from S3, or wraps an error that does.
+func DescribeS3Error(err error) string {
+	var s3err *s3.Error
+	if !errors.As(err, &s3err) {
+		return err.Error()
+	}
+	ids := requestIDs(s3err.RequestId, s3err.HostId)
+	if err != error(s3err) {
+		// what wraps it may already describe it
+		msg := err.Error()
+		if !strings.Contains(msg, ids) {
+			msg += ids
+		}
+		return msg
+	}
+	msg := s3err.Message
+	if s3err.Code != "" {
+		msg = s3err.Code + ": " + msg
+	}
+	return msg + ids
+}
+
+// requestIDs formats the x-amz-request-id and x-amz-id-2 of a request.
+func requestIDs(requestID, hostID string) string {
+	if requestID == "" && hostID == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (request id %s, host id %s)", requestID, hostID)
+}
+
+// classifyFailure tells what kind of failure an error from a store is, or
+// the error it wraps.
+func classifyFailure(err error) string {
+	var (
+		checksumErr *ChecksumError
+		s3err       *s3.Error
+		gcsErr      *GCSError
+		azErr       *AzureError
+		pluginErr   *PluginError
+		netErr      net.Error
+	)
+	if errors.As(err, &checksumErr) {
+		return failChecksum
+	}
+	if errors.As(err, &s3err) {
+		switch {
+		case s3err.StatusCode == 429 || s3err.StatusCode == 503 ||
+			s3err.Code == "SlowDown" || s3err.Code == "Throttling" || s3err.Code == "RequestLimitExceeded":
+			return failThrottled
+		case s3err.StatusCode == 404 || s3err.Code == "NoSuchKey":
+			return failNotFound
+		case s3err.StatusCode == 403 || s3err.Code == "AccessDenied":
+			return failForbidden
+		case s3err.Code == "RequestTimeout":
+			return failTimeout
+		}
+		return failOther
+	}
+	if errors.As(err, &gcsErr) {
+		switch gcsErr.StatusCode {
+		case 429, 503:
+			return failThrottled
+		case 404:
+			return failNotFound
+		case 401, 403:
+			return failForbidden
+		case 408:
+			return failTimeout
+		}
+		return failOther
+	}
+	if errors.As(err, &azErr) {
+		switch {
+		case azErr.StatusCode == 429 || azErr.StatusCode == 503 || azErr.Code == "ServerBusy":
+			return failThrottled
+		case azErr.StatusCode == 404:
+			return failNotFound
+		case azErr.StatusCode == 401 || azErr.StatusCode == 403:
+			return failForbidden
+		case azErr.StatusCode == 408 || azErr.Code == "OperationTimedOut":
+			return failTimeout
+		}
+		return failOther
+	}
+	if errors.As(err, &pluginErr) {
+		switch pluginErr.Code {
+		case failThrottled, failNotFound, failForbidden, failTimeout, failNetwork:
+			return pluginErr.Code
+		}
+		return failOther
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return failTimeout
+	}
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return failTimeout
+		}
+		return failNetwork
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return failNetwork
+	}
+	return failOther
+}
+
+// recordFailure counts a failed key in its class. It must be called with
+// p.mu held.
+func (p *JobProgress) recordFailure(key string, err error) {
+	if p.failures == nil {
+		p.failures = make(map[string]*FailureClass)
+	}
+	class := classifyFailure(err)
+	fc, ok := p.failures[class]
+	if !ok {
+		fc = &FailureClass{Class: class}
+		p.failures[class] = fc
+	}
+	fc.Count++
+	if len(fc.Examples) < failureExamples {
+		failed := FailedKey{Key: key, Error: DescribeS3Error(err)}
+		var s3err *s3.Error
+		if errors.As(err, &s3err) {
+			failed.RequestID, failed.HostID = s3err.RequestId, s3err.HostId
+		}
+		fc.Examples = append(fc.Examples, failed)
+	}
+}
+
+// failureClasses returns the classes of failures, most frequent first. It
+// must be called with p.mu held.
+func (p *JobProgress) failureClasses() []FailureClass {
+	classes := make([]FailureClass, 0, len(p.failures))
+	for _, fc := range p.failures {
+		classes = append(classes, *fc)
+	}
+	sort.Slice(classes, func(i, j int) bool { return classes[i].Count > classes[j].Count })
+	return classes
+}
@@ -0,0 +1,86 @@
+package taring
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Glob is a pattern of object names, relative to the archived prefix. It's
+// matched segment by segment as with path.Match, where a `**` segment
+// matches any number of them. A pattern without a slash, like `*.tmp`, is
+// matched against the last segment only.
+type Glob struct {
+	pattern  string
+	segments []string
+}
+
+// ParseGlob reads a pattern of object names.
+func ParseGlob(pattern string) (Glob, error) {
+	if pattern == "" {
+		return Glob{}, fmt.Errorf("glob can't be empty")
+	}
+	segments := strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+	for _, seg := range segments {
+		if _, err := path.Match(seg, ""); err != nil {
+			return Glob{}, fmt.Errorf("glob %q, %v", pattern, err)
+		}
+	}
+	return Glob{pattern: pattern, segments: segments}, nil
+}
+
+func (g Glob) String() string { return g.pattern }
+
+// Match tells if name, relative to the archived prefix, matches the
+// pattern.
+func (g Glob) Match(name string) bool {
+	if len(g.segments) == 1 && g.segments[0] != "**" {
+		ok, _ := path.Match(g.segments[0], path.Base(name))
+		return ok
+	}
+	return matchSegments(g.segments, strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// selects tells if name, relative to the archived prefix, passes the
+// include and exclude globs.
+func (a *Archiver) selects(name string) bool {
+	if len(a.include) > 0 {
+		included := false
+		for _, g := range a.include {
+			if g.Match(name) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	for _, g := range a.exclude {
+		if g.Match(name) {
+			return false
+		}
+	}
+	return true
+}
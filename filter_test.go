@@ -0,0 +1,80 @@
+package taring_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"github.com/aybabtme/taring"
+	"io"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"*.tmp", "a.tmp", true},
+		{"*.tmp", "deep/down/a.tmp", true},
+		{"*.tmp", "a.tmp/b", false},
+		{"2024/*.json", "2024/a.json", true},
+		{"2024/*.json", "2024/01/a.json", false},
+		{"2024/**/*.json", "2024/a.json", true},
+		{"2024/**/*.json", "2024/01/02/a.json", true},
+		{"2024/**/*.json", "2023/01/a.json", false},
+		{"**", "any/thing", true},
+		{"logs/**", "logs", true},
+	}
+	for _, tt := range tests {
+		g, err := taring.ParseGlob(tt.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := g.Match(tt.name); got != tt.want {
+			t.Errorf("%q matching %q: want %v, got %v", tt.pattern, tt.name, tt.want, got)
+		}
+	}
+	if _, err := taring.ParseGlob("a/[b"); err == nil {
+		t.Error("want an error for a malformed glob")
+	}
+}
+
+func TestArchiveGlobs(t *testing.T) {
+	include, _ := taring.ParseGlob("logs/**")
+	exclude, _ := taring.ParseGlob("2016/02/*")
+	excludeIndex, _ := taring.ParseGlob("index")
+	buf := bytes.NewBuffer(nil)
+	err := taring.Archive(context.Background(), newStore(), "data/", buf,
+		taring.WithInclude(include),
+		taring.WithExclude(exclude, excludeIndex),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gzr, err := gzip.NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	tr := tar.NewReader(gzr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.PAXRecords[taring.PAXKey])
+	}
+	sort.Strings(names)
+	// 2016/02/* isn't relative to the prefix, so it excludes nothing
+	want := []string{"data/logs/2016/01/app.log", "data/logs/2016/02/app.log"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("want %q, got %q", want, names)
+	}
+}
@@ -0,0 +1,195 @@
+package taring
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// gcsEndpoint is the JSON API of Google Cloud Storage.
+const gcsEndpoint = "https://storage.googleapis.com/storage/v1"
+
+// gcsListMax is how many objects are asked for per page.
+const gcsListMax = 1000
+
+// GCSStore is a bucket of Google Cloud Storage, read through its JSON API.
+// Requests are authorized by the client's transport, if at all.
+type GCSStore struct {
+	// Endpoint is the URL of the JSON API, Google's unless it's set, e.g.
+	// to an emulator.
+	Endpoint string
+
+	client *http.Client
+	bucket string
+}
+
+// NewGCSStore uses a GCS bucket as a store, making requests with client,
+// or the default client when it's nil.
+func NewGCSStore(client *http.Client, bucket string) *GCSStore {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &GCSStore{Endpoint: gcsEndpoint, client: client, bucket: bucket}
+}
+
+// GCSError is an error GCS answered with.
+type GCSError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *GCSError) Error() string {
+	return fmt.Sprintf("gcs: %d %s", e.StatusCode, e.Message)
+}
+
+type gcsObject struct {
+	Name    string    `json:"name"`
+	Size    string    `json:"size"`
+	ETag    string    `json:"etag"`
+	Updated time.Time `json:"updated"`
+}
+
+func (o gcsObject) info() (ObjectInfo, error) {
+	size, err := strconv.ParseInt(o.Size, 10, 64)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("failed to parse size of %q: %v", o.Name, err)
+	}
+	return ObjectInfo{Key: o.Name, Size: size, ETag: o.ETag, LastModified: o.Updated}, nil
+}
+
+// ListPage lists what's directly under prefix, after marker, which is a
+// page token of GCS.
+func (s *GCSStore) ListPage(ctx context.Context, prefix, marker string) (ListPage, error) {
+	q := url.Values{
+		"prefix":     {prefix},
+		"delimiter":  {"/"},
+		"maxResults": {strconv.Itoa(gcsListMax)},
+	}
+	if marker != "" {
+		q.Set("pageToken", marker)
+	}
+	var list struct {
+		Items         []gcsObject `json:"items"`
+		Prefixes      []string    `json:"prefixes"`
+		NextPageToken string      `json:"nextPageToken"`
+	}
+	if err := s.getJSON(ctx, s.bucketURL()+"/o?"+q.Encode(), &list); err != nil {
+		return ListPage{}, err
+	}
+	page := ListPage{
+		Objects: make([]ObjectInfo, len(list.Items)),
+		Folders: list.Prefixes,
+		Next:    list.NextPageToken,
+	}
+	for i, item := range list.Items {
+		info, err := item.info()
+		if err != nil {
+			return ListPage{}, err
+		}
+		page.Objects[i] = info
+	}
+	return page, nil
+}
+
+// Open reads the content of an object.
+func (s *GCSStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	// GCS decompresses an object stored gzipped unless the request takes
+	// gzip, and the transport decompresses what comes gzipped unless the
+	// request asked for it: taking gzip explicitly gets the object as
+	// stored, the size it's listed at.
+	resp, err := s.get(ctx, s.objectURL(key)+"?alt=media", "gzip")
+	if err != nil {
+		return nil, err
+	}
+	meta := headerObjectMeta(resp.Header, "X-Goog-Meta-")
+	// the generation of an object is its version
+	meta.VersionID = resp.Header.Get("X-Goog-Generation")
+	body := resp.Body
+	if stored, ok := resp.Header["X-Goog-Stored-Content-Encoding"]; ok {
+		meta.ContentEncoding = stored[0]
+		// gzipped only on the way, for an object that isn't stored so
+		if resp.Header.Get("Content-Encoding") == "gzip" && stored[0] != "gzip" {
+			zr, err := gzip.NewReader(resp.Body)
+			if err != nil {
+				resp.Body.Close()
+				return nil, fmt.Errorf("decompressing %q from GCS, %v", key, err)
+			}
+			body = struct {
+				io.Reader
+				io.Closer
+			}{zr, resp.Body}
+		}
+	}
+	return &metaReadCloser{ReadCloser: body, meta: meta}, nil
+}
+
+// Stat describes an object from its metadata.
+func (s *GCSStore) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	var obj gcsObject
+	if err := s.getJSON(ctx, s.objectURL(key), &obj); err != nil {
+		return ObjectInfo{}, err
+	}
+	return obj.info()
+}
+
+func (s *GCSStore) bucketURL() string {
+	return s.Endpoint + "/b/" + url.PathEscape(s.bucket)
+}
+
+func (s *GCSStore) objectURL(key string) string {
+	return s.bucketURL() + "/o/" + url.PathEscape(key)
+}
+
+func (s *GCSStore) getJSON(ctx context.Context, u string, v interface{}) error {
+	resp, err := s.get(ctx, u, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decoding answer of GCS, %v", err)
+	}
+	return nil
+}
+
+// get makes a request, turning an answer that isn't a success into a
+// GCSError. An acceptEncoding other than empty is asked for as is, and
+// what's answered left encoded.
+func (s *GCSStore) get(ctx context.Context, u, acceptEncoding string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	gcsErr := &GCSError{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+	if json.Unmarshal(data, &body) == nil && body.Error.Message != "" {
+		gcsErr.Message = body.Error.Message
+	}
+	return nil, gcsErr
+}
@@ -0,0 +1,232 @@
+package taring_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/aybabtme/taring"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeGCS serves the objects of a bucket over the JSON API of GCS, a page
+// of one object or folder at a time. Objects named .gz are stored gzipped,
+// and decompressed for requests that don't take gzip; others are gzipped
+// on the way for requests that do.
+func fakeGCS(t *testing.T, bucket string, objects map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		base := "/b/" + bucket + "/o"
+		path := r.URL.EscapedPath()
+		switch {
+		case path == base:
+			prefix, token := r.URL.Query().Get("prefix"), r.URL.Query().Get("pageToken")
+			var entries []string
+			seen := make(map[string]bool)
+			for key := range objects {
+				if !strings.HasPrefix(key, prefix) {
+					continue
+				}
+				entry := key
+				if i := strings.Index(key[len(prefix):], "/"); i >= 0 {
+					entry = key[:len(prefix)+i+1]
+				}
+				if !seen[entry] {
+					seen[entry] = true
+					entries = append(entries, entry)
+				}
+			}
+			sort.Strings(entries)
+			i := 0
+			if token != "" {
+				i, _ = strconv.Atoi(token)
+			}
+			page := map[string]interface{}{}
+			if i < len(entries) {
+				if entry := entries[i]; strings.HasSuffix(entry, "/") {
+					page["prefixes"] = []string{entry}
+				} else {
+					page["items"] = []map[string]string{{
+						"name":    entry,
+						"size":    strconv.Itoa(len(objects[entry])),
+						"etag":    "etag-" + entry,
+						"updated": "2016-03-01T12:00:00Z",
+					}}
+				}
+			}
+			if i+1 < len(entries) {
+				page["nextPageToken"] = strconv.Itoa(i + 1)
+			}
+			_ = json.NewEncoder(w).Encode(page)
+		case strings.HasPrefix(path, base+"/") && r.URL.Query().Get("alt") == "media":
+			key, _ := url.PathUnescape(strings.TrimPrefix(path, base+"/"))
+			data, ok := objects[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = io.WriteString(w, `{"error": {"message": "No such object"}}`)
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("X-Goog-Meta-Owner", "tests")
+			stored := "identity"
+			if strings.HasSuffix(key, ".gz") {
+				stored = "gzip"
+			}
+			w.Header().Set("X-Goog-Stored-Content-Encoding", stored)
+			takesGzip := strings.Contains(r.Header.Get("Accept-Encoding"), "gzip")
+			switch {
+			case stored == "gzip" && !takesGzip:
+				zr, err := gzip.NewReader(strings.NewReader(data))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				_, _ = io.Copy(w, zr)
+			case stored == "gzip" || takesGzip:
+				w.Header().Set("Content-Encoding", "gzip")
+				if stored == "gzip" {
+					_, _ = io.WriteString(w, data)
+					return
+				}
+				zw := gzip.NewWriter(w)
+				_, _ = io.WriteString(zw, data)
+				_ = zw.Close()
+			default:
+				_, _ = io.WriteString(w, data)
+			}
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+}
+
+func TestGCSStore(t *testing.T) {
+	srv := fakeGCS(t, "bucket", fixture)
+	defer srv.Close()
+	store := taring.NewGCSStore(srv.Client(), "bucket")
+	store.Endpoint = srv.URL
+
+	buf := bytes.NewBuffer(nil)
+	if err := taring.Archive(context.Background(), store, "data/", buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]string)
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[hdr.PAXRecords[taring.PAXKey]] = string(data)
+	}
+	for key, data := range fixture {
+		if !strings.HasPrefix(key, "data/") {
+			continue
+		}
+		if got[key] != data {
+			t.Errorf("want %q in %q, got %q", data, key, got[key])
+		}
+		delete(got, key)
+	}
+	for key := range got {
+		t.Errorf("unexpected %q in the archive", key)
+	}
+
+	_, err = store.Open(context.Background(), "data/missing")
+	var gcsErr *taring.GCSError
+	if !errors.As(err, &gcsErr) || gcsErr.StatusCode != http.StatusNotFound {
+		t.Errorf("want a 404 from GCS, got %v", err)
+	}
+}
+
+func TestGCSStoreEncoded(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	zw := gzip.NewWriter(buf)
+	_, _ = io.WriteString(zw, "stored gzipped")
+	_ = zw.Close()
+	stored := buf.String()
+	srv := fakeGCS(t, "bucket", map[string]string{"logs/a.gz": stored, "logs/b": "stored as is"})
+	defer srv.Close()
+	store := taring.NewGCSStore(srv.Client(), "bucket")
+	store.Endpoint = srv.URL
+
+	for key, want := range map[string]struct{ data, encoding string }{
+		"logs/a.gz": {stored, "gzip"},
+		"logs/b":    {"stored as is", "identity"},
+	} {
+		rc, err := store.Open(context.Background(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want.data {
+			t.Errorf("want %q as stored, got %q", key, data)
+		}
+		mr, ok := rc.(taring.MetaReader)
+		if !ok {
+			t.Fatalf("want %q opened with its metadata", key)
+		}
+		meta := mr.Meta()
+		if meta.ContentType != "text/plain" || meta.ContentEncoding != want.encoding || meta.User["owner"] != "tests" {
+			t.Errorf("want the metadata of %q, got %+v", key, meta)
+		}
+	}
+}
+
+func TestGCSCatalog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db := filepath.Join(dir, "taring.db")
+	_, err = taring.New("gs://bucket/data/", filepath.Join(dir, "archive.tar.gz"),
+		taring.WithStore(newStore()),
+		taring.WithCatalog(db),
+	).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog, err := taring.OpenCatalog(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer catalog.Close()
+	entries, err := catalog.Lookup("gs://bucket/data/readme.txt", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Member != "readme.txt" {
+		t.Errorf("want the object catalogued under its gs:// URL, got %+v", entries)
+	}
+	if entries, _ := catalog.Lookup("s3://bucket/data/readme.txt", ""); len(entries) != 0 {
+		t.Errorf("want nothing catalogued under s3://, got %+v", entries)
+	}
+}
@@ -0,0 +1,33 @@
+package taring
+
+// hooks are told what happens during a run, as it happens.
+type hooks struct {
+	objectStart []func(f ActiveFetch)
+	objectDone  []func(f ActiveFetch)
+	objectError []func(f ActiveFetch, err error)
+	progress    []func(snap ProgressSnapshot)
+}
+
+// WithOnObjectStart calls fn as the fetch of an object starts. Like every
+// hook, it's called from the goroutine doing the work, so it must be safe
+// for concurrent use, and quick.
+func WithOnObjectStart(fn func(f ActiveFetch)) Option {
+	return func(a *Archiver) { a.hooks.objectStart = append(a.hooks.objectStart, fn) }
+}
+
+// WithOnObjectDone calls fn once an object is fetched, with how much of it
+// was read.
+func WithOnObjectDone(fn func(f ActiveFetch)) Option {
+	return func(a *Archiver) { a.hooks.objectDone = append(a.hooks.objectDone, fn) }
+}
+
+// WithOnObjectError calls fn when the fetch of an object fails, which
+// includes slow fetches aborted to start over.
+func WithOnObjectError(fn func(f ActiveFetch, err error)) Option {
+	return func(a *Archiver) { a.hooks.objectError = append(a.hooks.objectError, fn) }
+}
+
+// WithOnProgress calls fn every time the progress of the run changes.
+func WithOnProgress(fn func(snap ProgressSnapshot)) Option {
+	return func(a *Archiver) { a.hooks.progress = append(a.hooks.progress, fn) }
+}
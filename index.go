@@ -0,0 +1,312 @@
+package taring
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// IndexEntry locates an entry of an archive.
+type IndexEntry struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	// Offset is where the entry's header starts in the uncompressed tar stream.
+	Offset int64
+	// Compressed is where the gzip member starting with the entry starts
+	// in the file, when one does, as with WithSeekableEntries; zero
+	// otherwise.
+	Compressed int64
+}
+
+// ArchiveIndex lists the entries of an archive so they can be read without
+// scanning the whole archive again.
+type ArchiveIndex struct {
+	Path    string
+	Entries []IndexEntry
+	byName  map[string]int
+}
+
+// IndexArchive scans the archive at path once and records where every
+// regular file in it starts, and which start a gzip member of their own.
+func IndexArchive(path string) (*ArchiveIndex, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+	var (
+		br      = bufio.NewReader(file)
+		members *gzipMembers
+		dr      io.ReadCloser
+	)
+	if magic, _ := br.Peek(len(gzipMagic)); bytes.Equal(magic, gzipMagic) {
+		members, err = newGzipMembers(br)
+		dr = ioutil.NopCloser(members)
+	} else {
+		dr, err = decompress(br)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decompressing %q, %v", path, err)
+	}
+	defer func() { _ = dr.Close() }()
+
+	cr := &countReader{r: dr}
+	tr := tar.NewReader(cr)
+	idx := &ArchiveIndex{Path: path, byName: make(map[string]int)}
+	for {
+		// drain the current entry so the count sits right after its data,
+		// then skip its padding to find where the next header starts
+		if _, err := io.Copy(ioutil.Discard, tr); err != nil {
+			return nil, fmt.Errorf("reading archive %q, %v", path, err)
+		}
+		offset := (cr.n + blockSize - 1) / blockSize * blockSize
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading archive %q, %v", path, err)
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		entry := IndexEntry{
+			Name:    hdr.Name,
+			Size:    hdr.Size,
+			ModTime: hdr.ModTime,
+			Offset:  offset,
+		}
+		if members != nil && members.plainStart == offset {
+			entry.Compressed = members.start
+		}
+		idx.Entries = append(idx.Entries, entry)
+	}
+	sort.Sort(byEntryName(idx.Entries))
+	for i, entry := range idx.Entries {
+		idx.byName[entry.Name] = i
+	}
+	return idx, nil
+}
+
+// Lookup finds an entry by name.
+func (a *ArchiveIndex) Lookup(name string) (IndexEntry, bool) {
+	i, ok := a.byName[name]
+	if !ok {
+		return IndexEntry{}, false
+	}
+	return a.Entries[i], true
+}
+
+// Open returns a reader over the content of an entry.
+func (a *ArchiveIndex) Open(entry IndexEntry) *EntryReader {
+	return &EntryReader{path: a.Path, entry: entry}
+}
+
+const blockSize = 512
+
+type byEntryName []IndexEntry
+
+func (b byEntryName) Len() int           { return len(b) }
+func (b byEntryName) Less(i, j int) bool { return b[i].Name < b[j].Name }
+func (b byEntryName) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// gzipMembers decompresses a gzip stream a member at a time, keeping where
+// the current member starts in the file and in what's decompressed, to
+// tell entries that start a member.
+type gzipMembers struct {
+	r  *byteCounter
+	zr *gzip.Reader
+	n  int64
+
+	start, plainStart int64
+}
+
+func newGzipMembers(r *bufio.Reader) (*gzipMembers, error) {
+	bc := &byteCounter{r: r}
+	zr, err := gzip.NewReader(bc)
+	if err != nil {
+		return nil, err
+	}
+	zr.Multistream(false)
+	return &gzipMembers{r: bc, zr: zr}, nil
+}
+
+func (m *gzipMembers) Read(p []byte) (int, error) {
+	for {
+		n, err := m.zr.Read(p)
+		m.n += int64(n)
+		if err != io.EOF {
+			return n, err
+		}
+		// on to the next member, if there's one
+		m.start, m.plainStart = m.r.n, m.n
+		if err := m.zr.Reset(m.r); err != nil {
+			return n, err
+		}
+		m.zr.Multistream(false)
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// byteCounter counts what's read of r. Being an io.ByteReader, gzip reads
+// no more of it than a member takes.
+type byteCounter struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (b *byteCounter) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+func (b *byteCounter) ReadByte() (byte, error) {
+	c, err := b.r.ReadByte()
+	if err == nil {
+		b.n++
+	}
+	return c, err
+}
+
+// EntryReader lazily reads the content of an archive entry. The archive is
+// only opened on the first read. Reading forward is cheap; going back
+// reopens the archive, since gzip can't seek, but from the entry's own
+// gzip member when it starts one.
+type EntryReader struct {
+	path  string
+	entry IndexEntry
+
+	mu   sync.Mutex
+	arch *ArchiveReader
+	pos  int64 // position of arch in the entry's content
+	off  int64 // position for Read and Seek
+}
+
+// Size is the size of the entry's content.
+func (e *EntryReader) Size() int64 { return e.entry.Size }
+
+// Entry is the entry being read.
+func (e *EntryReader) Entry() IndexEntry { return e.entry }
+
+// Read implements io.Reader.
+func (e *EntryReader) Read(p []byte) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	n, err := e.readAt(p, e.off)
+	e.off += int64(n)
+	return n, err
+}
+
+// ReadAt implements io.ReaderAt.
+func (e *EntryReader) ReadAt(p []byte, off int64) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var n int
+	for n < len(p) {
+		m, err := e.readAt(p[n:], off+int64(n))
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Seek implements io.Seeker.
+func (e *EntryReader) Seek(offset int64, whence int) (int64, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += e.off
+	case io.SeekEnd:
+		offset += e.entry.Size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position %d", offset)
+	}
+	e.off = offset
+	return offset, nil
+}
+
+// Close releases the archive, if it was opened.
+func (e *EntryReader) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.arch == nil {
+		return nil
+	}
+	err := e.arch.Close()
+	e.arch = nil
+	return err
+}
+
+func (e *EntryReader) readAt(p []byte, off int64) (int, error) {
+	if off >= e.entry.Size {
+		return 0, io.EOF
+	}
+	if e.arch == nil || off < e.pos {
+		if err := e.reopen(); err != nil {
+			return 0, err
+		}
+	}
+	if off > e.pos {
+		n, err := io.CopyN(ioutil.Discard, e.arch, off-e.pos)
+		e.pos += n
+		if err != nil {
+			return 0, fmt.Errorf("seeking to %d in %q, %v", off, e.entry.Name, err)
+		}
+	}
+	n, err := e.arch.Read(p)
+	e.pos += int64(n)
+	return n, err
+}
+
+func (e *EntryReader) reopen() error {
+	if e.arch != nil {
+		_ = e.arch.Close()
+		e.arch = nil
+	}
+	arch, err := OpenArchiveAt(e.path, e.entry.Offset, e.entry.Compressed)
+	if err != nil {
+		return err
+	}
+	hdr, err := arch.Next()
+	if err != nil {
+		_ = arch.Close()
+		return fmt.Errorf("reading header of %q, %v", e.entry.Name, err)
+	}
+	if hdr.Name != e.entry.Name {
+		_ = arch.Close()
+		return fmt.Errorf("expected %q at offset %d, found %q", e.entry.Name, e.entry.Offset, hdr.Name)
+	}
+	e.arch = arch
+	e.pos = 0
+	return nil
+}
@@ -0,0 +1,385 @@
+package taring
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/crowdmob/goamz/s3"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	manifestSuffix    = ".manifest.json"
+	manifestCSVSuffix = ".manifest.csv"
+)
+
+// ManifestVersion is the version of the manifests written. It's only
+// bumped when a change would break readers of the previous version; fields
+// are added without bumping it, and readers must ignore those they don't
+// know. The schema of each version is in schema/.
+const ManifestVersion = 1
+
+// manifestMigrations bring a decoded manifest from the version at their
+// index to the next one.
+var manifestMigrations = []func(m map[string]interface{}){
+	// manifests had no version before 1
+	0: func(m map[string]interface{}) {},
+}
+
+// Manifest describes the content of an archive. It's saved next to the
+// archive.
+type Manifest struct {
+	// Version is the ManifestVersion the manifest was written with.
+	Version int       `json:"version"`
+	Source  string    `json:"source"`
+	Archive string    `json:"archive"`
+	Created time.Time `json:"created"`
+	// Fingerprint identifies the listing the archive was made from; it
+	// changes whenever a key is added, removed or modified.
+	Fingerprint string           `json:"fingerprint"`
+	Objects     []ManifestObject `json:"objects"`
+}
+
+// ManifestObject is an object that went into an archive.
+type ManifestObject struct {
+	Key          string    `json:"key"`
+	Name         string    `json:"name"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+	ETag         string    `json:"etag"`
+	// ContentType and ContentEncoding are those of the object, when the
+	// store keeps them.
+	ContentType     string `json:"content_type,omitempty"`
+	ContentEncoding string `json:"content_encoding,omitempty"`
+	// CompressedSize is how many bytes the object's entry takes in the
+	// archive, when asked for.
+	CompressedSize int64 `json:"compressed_size,omitempty"`
+}
+
+func newManifest(source, archive, fingerprint string, objects []S3Content) *Manifest {
+	m := &Manifest{
+		Version:     ManifestVersion,
+		Source:      source,
+		Archive:     archive,
+		Created:     time.Now(),
+		Fingerprint: fingerprint,
+		Objects:     make([]ManifestObject, len(objects)),
+	}
+	for i, object := range objects {
+		m.Objects[i] = ManifestObject{
+			Key:             object.Key,
+			Name:            object.Name,
+			Size:            object.Size,
+			LastModified:    object.LastMod,
+			ETag:            object.ETag,
+			ContentType:     object.Meta.ContentType,
+			ContentEncoding: object.Meta.ContentEncoding,
+		}
+	}
+	return m
+}
+
+// ManifestPath is where the manifest of an archive is saved.
+func ManifestPath(archive string) string { return archive + manifestSuffix }
+
+// ManifestCSVPath is where the manifest of an archive is saved as CSV,
+// when asked for.
+func ManifestCSVPath(archive string) string { return archive + manifestCSVSuffix }
+
+// WriteCSV writes the objects of the manifest to w as CSV, one per row
+// after a header, for what indexes archives without reading JSON.
+func (m *Manifest) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"key", "name", "size", "last_modified", "etag", "compressed_size", "content_type", "content_encoding"}); err != nil {
+		return err
+	}
+	for _, obj := range m.Objects {
+		err := cw.Write([]string{
+			obj.Key,
+			obj.Name,
+			strconv.FormatInt(obj.Size, 10),
+			obj.LastModified.UTC().Format(time.RFC3339),
+			obj.ETag,
+			strconv.FormatInt(obj.CompressedSize, 10),
+			obj.ContentType,
+			obj.ContentEncoding,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteManifest saves a manifest to path, as the current version if it has
+// none.
+func WriteManifest(path string, m *Manifest) error {
+	data, err := encodeManifest(path, m)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, data, filePerms); err != nil {
+		return fmt.Errorf("writing manifest %q, %v", path, err)
+	}
+	return nil
+}
+
+// saveManifest saves a manifest to a local path or an `s3://` URL, the way
+// WriteManifest does.
+func (r *run) saveManifest(ctx context.Context, location string, m *Manifest) error {
+	if !strings.HasPrefix(location, "s3://") {
+		return WriteManifest(location, m)
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return err
+	}
+	data, err := encodeManifest(location, m)
+	if err != nil {
+		return err
+	}
+	return r.upload(ctx, u, data, "application/json")
+}
+
+// saveManifestCSV saves a manifest as CSV to a local path or an `s3://`
+// URL.
+func (r *run) saveManifestCSV(ctx context.Context, location string, m *Manifest) error {
+	buf := bytes.NewBuffer(nil)
+	if err := m.WriteCSV(buf); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(location, "s3://") {
+		return ioutil.WriteFile(location, buf.Bytes(), filePerms)
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return err
+	}
+	return r.upload(ctx, u, buf.Bytes(), "text/csv")
+}
+
+// upload puts data at the `s3://` URL u, as a file next to the archive.
+func (r *run) upload(ctx context.Context, u *url.URL, data []byte, contentType string) error {
+	err := r.retry(ctx, "uploading "+u.String(), func() error {
+		bkt, err := r.bucket(ctx, u.Host)
+		if err != nil {
+			return err
+		}
+		return inContext(ctx, func() error {
+			return bkt.Put(strings.TrimPrefix(u.Path, "/"), data, contentType, s3.Private, s3.Options{})
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("uploading %q, %s", u.String(), DescribeS3Error(err))
+	}
+	return nil
+}
+
+func encodeManifest(path string, m *Manifest) ([]byte, error) {
+	if m.Version == 0 {
+		m.Version = ManifestVersion
+	}
+	if err := m.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid manifest %q, %v", path, err)
+	}
+	return json.MarshalIndent(m, "", "  ")
+}
+
+// ReadManifest reads the manifest saved at path.
+func ReadManifest(path string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeManifest(path, data)
+}
+
+// LoadManifest reads a manifest from a local path or an `s3://` URL.
+func LoadManifest(ctx context.Context, location string, s3c *s3.S3) (*Manifest, error) {
+	if !strings.HasPrefix(location, "s3://") {
+		return ReadManifest(location)
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	err = inContext(ctx, func() (err error) {
+		data, err = s3c.Bucket(u.Host).Get(strings.TrimPrefix(u.Path, "/"))
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fetching manifest %q, %s", location, DescribeS3Error(err))
+	}
+	return decodeManifest(location, data)
+}
+
+// decodeManifest decodes a manifest of any version, migrated to the
+// current one.
+func decodeManifest(path string, data []byte) (*Manifest, error) {
+	data, err := migrateManifest(data)
+	if err != nil {
+		return nil, fmt.Errorf("migrating manifest %q, %v", path, err)
+	}
+	if err := requireManifestFields(data); err != nil {
+		return nil, fmt.Errorf("invalid manifest %q, %v", path, err)
+	}
+	m := new(Manifest)
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("decoding manifest %q, %v", path, err)
+	}
+	if err := m.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid manifest %q, %v", path, err)
+	}
+	return m, nil
+}
+
+// migrateManifest brings an encoded manifest to the current version.
+func migrateManifest(data []byte) ([]byte, error) {
+	var versioned struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &versioned); err != nil {
+		return nil, err
+	}
+	switch {
+	case versioned.Version == ManifestVersion:
+		return data, nil
+	case versioned.Version > ManifestVersion:
+		return nil, fmt.Errorf("version %d is newer than %d, the latest this build knows", versioned.Version, ManifestVersion)
+	case versioned.Version < 0:
+		return nil, fmt.Errorf("not a version, %d", versioned.Version)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	for v := versioned.Version; v < ManifestVersion; v++ {
+		manifestMigrations[v](m)
+	}
+	m["version"] = ManifestVersion
+	return json.Marshal(m)
+}
+
+// requireManifestFields checks that an encoded manifest of the current
+// version has the fields its schema requires, which decoding it can't tell
+// from fields left at their zero value.
+func requireManifestFields(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	for _, field := range []string{"version", "source", "archive", "created", "objects"} {
+		if _, ok := fields[field]; !ok {
+			return fmt.Errorf("need a %s", field)
+		}
+	}
+	var objects []map[string]json.RawMessage
+	if err := json.Unmarshal(fields["objects"], &objects); err != nil {
+		return fmt.Errorf("decoding objects, %v", err)
+	}
+	for i, object := range objects {
+		for _, field := range []string{"key", "name", "size", "last_modified", "etag"} {
+			if _, ok := object[field]; !ok {
+				return fmt.Errorf("need a %s for object %d", field, i)
+			}
+		}
+	}
+	return nil
+}
+
+// Validate checks that a manifest of the current version has what its
+// schema requires, short of the fields being there at all.
+func (m *Manifest) Validate() error {
+	switch {
+	case m.Version != ManifestVersion:
+		return fmt.Errorf("need version %d, got %d", ManifestVersion, m.Version)
+	case m.Source == "":
+		return errors.New("need a source")
+	case m.Archive == "":
+		return errors.New("need an archive")
+	}
+	for i, object := range m.Objects {
+		switch {
+		case object.Key == "":
+			return fmt.Errorf("need a key for object %d", i)
+		case object.Name == "":
+			return fmt.Errorf("need a name for %q", object.Key)
+		case object.Size < 0 || object.CompressedSize < 0:
+			return fmt.Errorf("need positive sizes for %q", object.Key)
+		}
+	}
+	return nil
+}
+
+// previousManifest finds the manifest of the last archive written from the
+// same archive path template, or nil if there's none.
+func previousManifest(tmpl, job, current string) (*Manifest, error) {
+	archive := current
+	if strings.Contains(tmpl, TimePlaceholder) {
+		archives, err := jobArchives(tmpl, job)
+		if err != nil {
+			return nil, err
+		}
+		archive = ""
+		for _, a := range archives {
+			if a.path != current {
+				archive = a.path
+				break
+			}
+		}
+	}
+	if archive == "" {
+		return nil, nil
+	}
+	m, err := ReadManifest(ManifestPath(archive))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return m, err
+}
+
+// excludeArchived drops the keys that are already in the manifest with the
+// same ETag.
+func excludeArchived(keys []ObjectInfo, m *Manifest) []ObjectInfo {
+	archived := make(map[string]string, len(m.Objects))
+	for _, object := range m.Objects {
+		archived[object.Key] = object.ETag
+	}
+	kept := make([]ObjectInfo, 0, len(keys))
+	for _, key := range keys {
+		if etag, ok := archived[key.Key]; ok && etag == key.ETag {
+			continue
+		}
+		kept = append(kept, key)
+	}
+	return kept
+}
+
+// fingerprint hashes the keys and ETags of a listing, regardless of the
+// order in which they were listed.
+func fingerprint(keys []ObjectInfo) string {
+	lines := make([]string, len(keys))
+	for i, key := range keys {
+		lines[i] = key.Key + "\x00" + key.ETag
+	}
+	sort.Strings(lines)
+	h := sha256.New()
+	for _, line := range lines {
+		_, _ = h.Write([]byte(line + "\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
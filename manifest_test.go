@@ -0,0 +1,221 @@
+package taring_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/aybabtme/taring"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReadManifestMigrates(t *testing.T) {
+	old, err := taring.ReadManifest(filepath.Join("testdata", "manifest-v0.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.Version != taring.ManifestVersion {
+		t.Errorf("want version %d, got %d", taring.ManifestVersion, old.Version)
+	}
+	cur, err := taring.ReadManifest(filepath.Join("testdata", "golden", "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(old.Objects) != len(cur.Objects) || old.Fingerprint != cur.Fingerprint {
+		t.Errorf("want the same manifest once migrated, got %+v", old)
+	}
+}
+
+func TestWriteManifestValidates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = taring.WriteManifest(filepath.Join(dir, "m.json"), &taring.Manifest{
+		Source:  "s3://bucket/",
+		Archive: "archive.tar.gz",
+		Objects: []taring.ManifestObject{{Key: "key"}},
+	})
+	if err == nil {
+		t.Fatal("want an object without a name to be refused")
+	}
+}
+
+func TestManifestWriteCSV(t *testing.T) {
+	m, err := taring.ReadManifest(filepath.Join("testdata", "golden", "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := m.WriteCSV(buf); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != len(m.Objects)+1 || rows[0][0] != "key" {
+		t.Fatalf("want a header and %d rows, got %q", len(m.Objects), rows)
+	}
+	for i, obj := range m.Objects {
+		if row := rows[i+1]; row[0] != obj.Key || row[1] != obj.Name || row[4] != obj.ETag {
+			t.Errorf("want object %q, got %q", obj.Key, row)
+		}
+	}
+}
+
+// checkSchema checks v against schema, for the keywords the schema of
+// manifests uses.
+func checkSchema(schema map[string]interface{}, v interface{}, at string) error {
+	if c, ok := schema["const"]; ok && !reflect.DeepEqual(c, v) {
+		return fmt.Errorf("%s: want %v, got %v", at, c, v)
+	}
+	if typ, ok := schema["type"]; ok {
+		types, ok := typ.([]interface{})
+		if !ok {
+			types = []interface{}{typ}
+		}
+		matched := false
+		for _, t := range types {
+			matched = matched || t == schemaType(v) || t == "number" && schemaType(v) == "integer"
+		}
+		if !matched {
+			return fmt.Errorf("%s: want a %v, got %v", at, typ, v)
+		}
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := v[name.(string)]; !ok {
+				return fmt.Errorf("%s: need %v", at, name)
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		for name, sub := range properties {
+			if field, ok := v[name]; ok {
+				if err := checkSchema(sub.(map[string]interface{}), field, at+"."+name); err != nil {
+					return err
+				}
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				if err := checkSchema(items, item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		if min, ok := schema["minLength"].(float64); ok && float64(len(v)) < min {
+			return fmt.Errorf("%s: want at least %v characters", at, min)
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, v); err != nil {
+				return fmt.Errorf("%s: %v", at, err)
+			}
+		}
+	case float64:
+		if min, ok := schema["minimum"].(float64); ok && v < min {
+			return fmt.Errorf("%s: want at least %v", at, min)
+		}
+	}
+	return nil
+}
+
+func schemaType(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	}
+	return "object"
+}
+
+func TestManifestSchema(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var schema map[string]interface{}
+	data, err := ioutil.ReadFile(filepath.Join("schema", "manifest-v1.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatal(err)
+	}
+	golden, err := ioutil.ReadFile(filepath.Join("testdata", "golden", "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, change := range map[string]func(m map[string]interface{}){
+		"golden":                     func(m map[string]interface{}) {},
+		"no objects":                 func(m map[string]interface{}) { m["objects"] = nil },
+		"newer version":              func(m map[string]interface{}) { m["version"] = 2 },
+		"no created":                 func(m map[string]interface{}) { delete(m, "created") },
+		"created not a date-time":    func(m map[string]interface{}) { m["created"] = "yesterday" },
+		"empty source":               func(m map[string]interface{}) { m["source"] = "" },
+		"no etag":                    func(m map[string]interface{}) { delete(object(m), "etag") },
+		"no size":                    func(m map[string]interface{}) { delete(object(m), "size") },
+		"negative size":              func(m map[string]interface{}) { object(m)["size"] = -1 },
+		"fractional size":            func(m map[string]interface{}) { object(m)["size"] = 1.5 },
+		"modified not a date-time":   func(m map[string]interface{}) { object(m)["last_modified"] = "2016-03-01" },
+		"negative compressed size":   func(m map[string]interface{}) { object(m)["compressed_size"] = -1 },
+		"unknown fields are ignored": func(m map[string]interface{}) { m["comment"] = "added later" },
+	} {
+		var m map[string]interface{}
+		if err := json.Unmarshal(golden, &m); err != nil {
+			t.Fatal(err)
+		}
+		change(m)
+		data, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// compared as decoded JSON, numbers and all
+		var decoded interface{}
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		schemaErr := checkSchema(schema, decoded, "manifest")
+		path := filepath.Join(dir, "manifest.json")
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, readErr := taring.ReadManifest(path)
+		if (schemaErr == nil) != (readErr == nil) {
+			t.Errorf("%s: want the schema and taring to agree, got %v from the schema and %v from taring", name, schemaErr, readErr)
+		}
+		if name == "golden" && schemaErr != nil {
+			t.Errorf("want the golden manifest valid, got %v", schemaErr)
+		}
+	}
+}
+
+// object is the first object of an encoded manifest.
+func object(m map[string]interface{}) map[string]interface{} {
+	return m["objects"].([]interface{})[0].(map[string]interface{})
+}
@@ -0,0 +1,118 @@
+package taring
+
+import (
+	"bytes"
+	"context"
+	"github.com/crowdmob/goamz/s3"
+)
+
+// S3 takes at most 10000 parts of at least 5MB but for the last one, so
+// parts start at 16MB and double every 1000 of them: enough for archives up
+// to S3's limit of 5TB, with only small archives uploaded in small parts.
+const (
+	multipartPartSize     = 16 << 20
+	multipartPartsPerSize = 1000
+)
+
+// multipartWriter uploads what's written to it as the parts of a multipart
+// upload, holding a single part in memory. The object only appears once
+// the writer is closed. Every request is made of the bucket as bucket gives
+// it then, so that an upload outlasts the credentials it started with.
+type multipartWriter struct {
+	ctx    context.Context
+	a      *Archiver
+	bucket func(ctx context.Context) (*s3.Bucket, error)
+	multi  *s3.Multi
+	buf    []byte
+	parts  []s3.Part
+}
+
+func (a *Archiver) newMultipartWriter(ctx context.Context, bucket func(ctx context.Context) (*s3.Bucket, error), key string) (*multipartWriter, error) {
+	var multi *s3.Multi
+	err := a.retry(ctx, "starting upload", func() error {
+		bkt, err := bucket(ctx)
+		if err != nil {
+			return err
+		}
+		return inContext(ctx, func() (err error) {
+			multi, err = bkt.InitMulti(key, "application/octet-stream", s3.Private, s3.Options{})
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &multipartWriter{ctx: ctx, a: a, bucket: bucket, multi: multi}, nil
+}
+
+// renew makes the next request of the upload with the credentials of now.
+func (w *multipartWriter) renew(ctx context.Context) error {
+	bkt, err := w.bucket(ctx)
+	if err != nil {
+		return err
+	}
+	w.multi.Bucket = bkt
+	return nil
+}
+
+func (w *multipartWriter) partSize() int {
+	return multipartPartSize << uint(len(w.parts)/multipartPartsPerSize)
+}
+
+func (w *multipartWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for size := w.partSize(); len(w.buf) >= size; size = w.partSize() {
+		// a part may still be read by an upload given up on, so it's
+		// never written to again
+		part := w.buf[:size:size]
+		w.buf = append([]byte(nil), w.buf[size:]...)
+		if err := w.upload(part); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *multipartWriter) upload(data []byte) error {
+	n := len(w.parts) + 1
+	var part s3.Part
+	err := w.a.retry(w.ctx, "uploading part", func() error {
+		if err := w.renew(w.ctx); err != nil {
+			return err
+		}
+		return inContext(w.ctx, func() (err error) {
+			part, err = w.multi.PutPart(n, bytes.NewReader(data))
+			return err
+		})
+	})
+	if err != nil {
+		return err
+	}
+	w.parts = append(w.parts, part)
+	return nil
+}
+
+// Close uploads what's left as the last part, then completes the upload.
+func (w *multipartWriter) Close() error {
+	if len(w.buf) > 0 || len(w.parts) == 0 {
+		if err := w.upload(w.buf); err != nil {
+			return err
+		}
+		w.buf = nil
+	}
+	return w.a.retry(w.ctx, "completing upload", func() error {
+		if err := w.renew(w.ctx); err != nil {
+			return err
+		}
+		return inContext(w.ctx, func() error { return w.multi.Complete(w.parts) })
+	})
+}
+
+// Abort gives up on the upload, so S3 doesn't keep the parts around.
+func (w *multipartWriter) Abort() error {
+	// the run may be given up on for its context, which the upload isn't
+	if err := w.renew(context.Background()); err != nil {
+		return err
+	}
+	return w.multi.Abort()
+}
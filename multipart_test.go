@@ -0,0 +1,119 @@
+package taring_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"github.com/aybabtme/taring"
+	"github.com/aybabtme/taring/taringtest"
+	"github.com/crowdmob/goamz/aws"
+	"github.com/crowdmob/goamz/s3"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newLargeStore is the fixture along with an object that doesn't compress,
+// so that its archive takes more than a part.
+func newLargeStore() *taringtest.Store {
+	store := newStore()
+	data := make([]byte, 17<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	store.Put("data/a-large", data, time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC))
+	return store
+}
+
+func readObject(t *testing.T, store *taringtest.Store, key string) []byte {
+	rc, err := store.Open(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestRunMultipartUpload(t *testing.T) {
+	store := newLargeStore()
+	srv := httptest.NewServer(taringtest.S3Handler("bucket", store))
+	defer srv.Close()
+
+	sum, err := taring.New("s3://bucket/data/", "s3://bucket/archives/archive.tar.gz",
+		taring.WithAuth(aws.Auth{AccessKey: "access", SecretKey: "secret"}, taring.CustomS3Endpoint(aws.USEast, srv.URL, true)),
+	).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uploads := store.Uploads()
+	if len(uploads) != 1 || uploads[0].Key != "archives/archive.tar.gz" || !uploads[0].Completed {
+		t.Fatalf("want one completed upload of the archive, got %+v", uploads)
+	}
+	// the first part is as large as parts start, the last what's left
+	parts := uploads[0].Parts
+	if len(parts) != 2 || parts[0] != 16<<20 || int64(parts[0]+parts[1]) != sum.ArchiveBytes {
+		t.Errorf("want a part of %d bytes and one of the rest of %d, got %v", 16<<20, sum.ArchiveBytes, parts)
+	}
+
+	m := new(taring.Manifest)
+	if err := json.Unmarshal(readObject(t, store, "archives/archive.tar.gz.manifest.json"), m); err != nil {
+		t.Fatal(err)
+	}
+	// what's under data/ in the fixture, and the large object
+	if want := len(fixture) - 1 + 1; len(m.Objects) != want {
+		t.Fatalf("want %d objects in the manifest, got %d", want, len(m.Objects))
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(readObject(t, store, "archives/archive.tar.gz")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(zr)
+	for _, obj := range m.Objects {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := io.Copy(ioutil.Discard, tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.PAXRecords[taring.PAXKey] != obj.Key || n != obj.Size {
+			t.Errorf("want %q of %d bytes, got %q of %d", obj.Key, obj.Size, hdr.PAXRecords[taring.PAXKey], n)
+		}
+	}
+}
+
+func TestRunMultipartUploadAborted(t *testing.T) {
+	store := newLargeStore()
+	// the fixture comes after the large object, once a part is uploaded
+	store.FailOpen("data/readme.txt", &s3.Error{StatusCode: 404, Code: "NoSuchKey"})
+	srv := httptest.NewServer(taringtest.S3Handler("bucket", store))
+	defer srv.Close()
+
+	_, err := taring.New("s3://bucket/data/", "s3://bucket/archives/archive.tar.gz",
+		taring.WithAuth(aws.Auth{AccessKey: "access", SecretKey: "secret"}, taring.CustomS3Endpoint(aws.USEast, srv.URL, true)),
+		taring.WithConcurrency(1),
+	).Run(context.Background())
+	if err == nil {
+		t.Fatal("want the run to fail")
+	}
+
+	uploads := store.Uploads()
+	if len(uploads) != 1 || !uploads[0].Aborted || uploads[0].Completed {
+		t.Fatalf("want the upload of the archive aborted, got %+v", uploads)
+	}
+	if len(uploads[0].Parts) == 0 {
+		t.Error("want a part uploaded before the run failed")
+	}
+	if _, err := store.Stat(context.Background(), "archives/archive.tar.gz"); err == nil {
+		t.Error("want no archive once the upload is aborted")
+	}
+}
@@ -0,0 +1,254 @@
+package taring
+
+import (
+	"context"
+	"github.com/crowdmob/goamz/aws"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Option configures an Archiver.
+type Option func(a *Archiver)
+
+// New makes an archiver of src, of the form `s3://bucketname/path`,
+// `gs://bucketname/path` for Google Cloud Storage, or `az://container/path`
+// and `wasb://container@account.blob.core.windows.net/path` for Azure Blob
+// Storage, to the archive at dst, which can hold TimePlaceholder and
+// JobPlaceholder. By default, the archive is a gzipped tar, fetched from
+// S3 in us-east-1 with no credentials, and nothing is logged.
+func New(src, dst string, opts ...Option) *Archiver {
+	a := &Archiver{
+		source:      src,
+		destination: dst,
+		region:      aws.USEast,
+		container:   NewTarWriter,
+		compressor:  Gzip{},
+		progress:    new(JobProgress),
+		log:         nopLogger{},
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// WithAuth reads from S3 with these credentials, in that region.
+func WithAuth(auth aws.Auth, region aws.Region) Option {
+	return func(a *Archiver) { a.auth, a.region = auth, region }
+}
+
+// WithCredentials gets the AWS credentials of every run from fn as it
+// starts, e.g. InstanceCredentials, rather than those given WithAuth, and
+// again whenever they're about to expire during the run.
+func WithCredentials(fn func(ctx context.Context) (aws.Auth, error)) Option {
+	return func(a *Archiver) { a.credentials = fn }
+}
+
+// WithAzure reads from Azure Blob Storage as this storage account, with
+// its base64 key, or none for a public container. The account of a
+// `wasb://` source takes precedence.
+func WithAzure(account, key string) Option {
+	return func(a *Archiver) { a.azureAccount, a.azureKey = account, key }
+}
+
+// WithStore reads from the store instead of the bucket of the source, of
+// which only the path is used then.
+func WithStore(store Store) Option {
+	return func(a *Archiver) { a.store = store }
+}
+
+// WithJob names the job the archive is for.
+func WithJob(name string) Option {
+	return func(a *Archiver) { a.job = name }
+}
+
+// WithRetention prunes the job's older archives once one is written,
+// keeping the last ones, or those younger than days. Zero keeps all. The
+// job needs a name, and the archive path both placeholders, so that the
+// archives of other jobs are never pruned.
+func WithRetention(last, days int) Option {
+	return func(a *Archiver) { a.keepLast, a.keepDays = last, days }
+}
+
+// WithTagArchived tags every archived object. With skipTagged, objects
+// that already have the tag are left out.
+func WithTagArchived(tag Tag, skipTagged bool) Option {
+	return func(a *Archiver) { a.tagArchived, a.skipTagged = tag, skipTagged }
+}
+
+// WithCatalog records the archive in the catalog at path.
+func WithCatalog(path string) Option {
+	return func(a *Archiver) { a.catalog = path }
+}
+
+// WithExcludeManifest leaves out the objects in the manifest of an earlier
+// archive, at a local path or an `s3://` URL.
+func WithExcludeManifest(location string) Option {
+	return func(a *Archiver) { a.excludeManifest = location }
+}
+
+// WithSkipUnchanged doesn't archive anything when the listing is the same
+// as the job's previous archive.
+func WithSkipUnchanged() Option {
+	return func(a *Archiver) { a.skipUnchanged = true }
+}
+
+// WithDryRun only lists and filters what's to be archived when running,
+// summarizing it by top-level prefix without fetching anything. Nothing is
+// written, and what's to be done before listing isn't.
+func WithDryRun() Option {
+	return func(a *Archiver) { a.dryRun = true }
+}
+
+// WithSplitSize splits the archive in volumes of at most size bytes,
+// named with VolumePath. The manifest still goes next to the archive's
+// path, where nothing else is then, so the archive can't be pruned. The
+// catalog records it by that path, along with the volume of each entry.
+func WithSplitSize(size int64) Option {
+	return func(a *Archiver) { a.splitSize = size }
+}
+
+// WithFilter only archives the objects keep is true for. With several
+// filters, an object must pass them all.
+func WithFilter(keep func(ObjectInfo) bool) Option {
+	return func(a *Archiver) { a.filters = append(a.filters, keep) }
+}
+
+// WithInclude only archives the objects matching one of the globs.
+func WithInclude(globs ...Glob) Option {
+	return func(a *Archiver) { a.include = append(a.include, globs...) }
+}
+
+// WithExclude doesn't archive the objects matching any of the globs, even
+// when they're included.
+func WithExclude(globs ...Glob) Option {
+	return func(a *Archiver) { a.exclude = append(a.exclude, globs...) }
+}
+
+// WithConcurrency fetches at most n objects at a time, which is also how
+// many objects of up to 8MB are held in memory while they wait to be
+// archived. Zero fetches 16 at a time.
+func WithConcurrency(n int) Option {
+	return func(a *Archiver) { a.concurrency = n }
+}
+
+// WithContainer writes the archive with the writers it makes instead of
+// as a tar. Only tar archives can be indexed and cataloged.
+func WithContainer(container func(w io.Writer) ArchiveWriter) Option {
+	return func(a *Archiver) { a.container = container }
+}
+
+// WithCompressor compresses the archive with c instead of gzip.
+func WithCompressor(c Compressor) Option {
+	return func(a *Archiver) { a.compressor = c }
+}
+
+// WithEntryRatios records the compressed size of every object in the
+// manifest. The compressor's writers must then have a Flush method. In a
+// zip, the end of an entry is written as the next one starts, so it's
+// counted with that one.
+func WithEntryRatios() Option {
+	return func(a *Archiver) { a.entryRatios = true }
+}
+
+// WithSeekableEntries compresses every entry of the archive as a gzip
+// member of its own, so that reading one from the catalog or an index
+// seeks to it rather than decompressing all that comes before. Entries
+// then compress a little worse, not sharing a dictionary. The compressor
+// must be Gzip.
+func WithSeekableEntries() Option {
+	return func(a *Archiver) { a.seekable = true }
+}
+
+// WithManifestCSV also saves the manifest as CSV next to the archive, at
+// ManifestCSVPath.
+func WithManifestCSV() Option {
+	return func(a *Archiver) { a.manifestCSV = true }
+}
+
+// WithETagChecks checks that every object fetched has the MD5 its ETag
+// says, when it's one, retrying those that don't as failures of the
+// network. ETags of objects uploaded in several parts aren't MD5s and
+// aren't checked. Neither are objects S3 says are encrypted with KMS or a
+// customer key, whose ETags look like MD5s but aren't.
+func WithETagChecks() Option {
+	return func(a *Archiver) { a.checkETags = true }
+}
+
+// WithTopLargest reports the n largest objects.
+func WithTopLargest(n int) Option {
+	return func(a *Archiver) { a.topLargest = n }
+}
+
+// WithSlowFetches deems slow the fetches under a speed, in bytes per
+// second, and starts them over up to restarts times.
+func WithSlowFetches(below float64, restarts int) Option {
+	return func(a *Archiver) { a.slowBelow, a.slowRestarts = below, restarts }
+}
+
+// WithRetries tries listing a page or fetching an object up to attempts
+// times when it fails in a way that may not last, e.g. S3 answering 500 or
+// throttling, or the network failing. Retries wait a random time up to
+// backoff, doubling with every retry. Zero keeps the default of 4 attempts
+// backing off from 250ms; one attempt never retries.
+func WithRetries(attempts int, backoff time.Duration) Option {
+	return func(a *Archiver) { a.retryAttempts, a.retryBackoff = attempts, backoff }
+}
+
+// WithBeforeListing calls fn with the path of the archive before anything
+// is listed. An error from it stops the run.
+func WithBeforeListing(fn func(ctx context.Context, archive string) error) Option {
+	return func(a *Archiver) { a.beforeListing = fn }
+}
+
+// WithProgress tracks the run with p.
+func WithProgress(p *JobProgress) Option {
+	return func(a *Archiver) { a.progress = p }
+}
+
+// WithLogger tells log what the run is doing.
+func WithLogger(log Logger) Option {
+	return func(a *Archiver) { a.log = log }
+}
+
+// WithTransportMiddleware wraps the transport of the requests made to list,
+// fetch and tag objects of S3, GCS and Azure, to add auth, caching,
+// logging or faults. With several middlewares, the first one given sees
+// requests first. What's written to S3, archives and manifests, goes
+// through goamz's own client instead, and a store given WithStore has its
+// own transport as well.
+func WithTransportMiddleware(wrap func(next http.RoundTripper) http.RoundTripper) Option {
+	return func(a *Archiver) { a.transport = append(a.transport, wrap) }
+}
+
+// httpClient is the client requests to the source are made with.
+func (a *Archiver) httpClient() *http.Client {
+	if len(a.transport) == 0 {
+		return http.DefaultClient
+	}
+	rt := http.DefaultTransport
+	for i := len(a.transport) - 1; i >= 0; i-- {
+		rt = a.transport[i](rt)
+	}
+	return &http.Client{Transport: rt}
+}
+
+// filter keeps the keys under base that pass every filter and glob.
+func (a *Archiver) filter(base string, keys []ObjectInfo) []ObjectInfo {
+	kept := keys[:0]
+next:
+	for _, key := range keys {
+		if !a.selects(strings.TrimPrefix(strings.TrimPrefix(key.Key, base), "/")) {
+			continue
+		}
+		for _, keep := range a.filters {
+			if !keep(key) {
+				continue next
+			}
+		}
+		kept = append(kept, key)
+	}
+	return kept
+}
@@ -0,0 +1,239 @@
+package taring
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// A plugin is a command serving a store on its stdin and stdout, one JSON
+// request or response per line. Requests are sent one at a time, each with
+// a new id that its responses repeat:
+//
+//	{"id": 1, "op": "list", "prefix": "a/path/", "marker": ""}
+//	{"id": 1, "page": {"objects": [...], "folders": ["a/path/sub/"], "next": ""}}
+//
+//	{"id": 2, "op": "stat", "key": "a/path/file"}
+//	{"id": 2, "object": {"key": "a/path/file", "size": 3, "etag": "...", "last_modified": "2016-01-02T15:04:05Z"}}
+//
+//	{"id": 3, "op": "open", "key": "a/path/file"}
+//	{"id": 3, "data": "Zm9v"}
+//	{"id": 3, "eof": true}
+//
+// Listing works like S3 with a `/` delimiter: folders are the prefixes
+// right under the listed one, and a non-empty next is the marker to list
+// the rest from. The content of an object comes base64 encoded over as many
+// data responses as the plugin likes, until one says eof. Any request can
+// be answered with an error instead:
+//
+//	{"id": 3, "error": {"code": "not_found", "message": "no such file"}}
+//
+// where the code is one of the failure classes, or empty.
+
+// PluginError is an error a plugin answered with.
+type PluginError struct {
+	// Code is the failure class of the error, if any.
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *PluginError) Error() string {
+	if e.Code == "" {
+		return e.Message
+	}
+	return e.Code + ": " + e.Message
+}
+
+type pluginRequest struct {
+	ID     int64  `json:"id"`
+	Op     string `json:"op"`
+	Prefix string `json:"prefix,omitempty"`
+	Marker string `json:"marker,omitempty"`
+	Key    string `json:"key,omitempty"`
+}
+
+type pluginResponse struct {
+	ID     int64         `json:"id"`
+	Error  *PluginError  `json:"error,omitempty"`
+	Page   *pluginPage   `json:"page,omitempty"`
+	Object *pluginObject `json:"object,omitempty"`
+	Data   []byte        `json:"data,omitempty"`
+	EOF    bool          `json:"eof,omitempty"`
+}
+
+type pluginPage struct {
+	Objects []pluginObject `json:"objects"`
+	Folders []string       `json:"folders"`
+	Next    string         `json:"next"`
+}
+
+type pluginObject struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+func (o pluginObject) info() ObjectInfo {
+	return ObjectInfo{Key: o.Key, Size: o.Size, ETag: o.ETag, LastModified: o.LastModified}
+}
+
+// PluginStore is a store served by a plugin. The plugin is started on the
+// first request and runs until the store is closed.
+//
+// Requests are answered one at a time, and an object is read whole before
+// Open returns. When a context is done, the call returns while the plugin
+// is left to finish answering.
+type PluginStore struct {
+	cmd *exec.Cmd
+
+	mu     sync.Mutex
+	stdin  io.WriteCloser
+	enc    *json.Encoder
+	dec    *json.Decoder
+	lastID int64
+	// err is why the plugin can't be talked to anymore
+	err error
+}
+
+// NewPluginStore uses the plugin that cmd runs as a store. The stdin and
+// stdout of cmd are taken over, its other settings are left as is.
+func NewPluginStore(cmd *exec.Cmd) *PluginStore {
+	return &PluginStore{cmd: cmd}
+}
+
+// ListPage lists what's directly under prefix, after marker.
+func (p *PluginStore) ListPage(ctx context.Context, prefix, marker string) (ListPage, error) {
+	var page ListPage
+	err := inContext(ctx, func() error {
+		return p.do(pluginRequest{Op: "list", Prefix: prefix, Marker: marker}, func(resp *pluginResponse) (bool, error) {
+			if resp.Page == nil {
+				return false, errors.New("plugin answered a list without a page")
+			}
+			page.Folders = resp.Page.Folders
+			page.Next = resp.Page.Next
+			page.Objects = make([]ObjectInfo, len(resp.Page.Objects))
+			for i, obj := range resp.Page.Objects {
+				page.Objects[i] = obj.info()
+			}
+			return true, nil
+		})
+	})
+	return page, err
+}
+
+// Open reads the content of an object.
+func (p *PluginStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	data := bytes.NewBuffer(nil)
+	err := inContext(ctx, func() error {
+		return p.do(pluginRequest{Op: "open", Key: key}, func(resp *pluginResponse) (bool, error) {
+			data.Write(resp.Data)
+			return resp.EOF, nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(data), nil
+}
+
+// Stat describes an object.
+func (p *PluginStore) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	var info ObjectInfo
+	err := inContext(ctx, func() error {
+		return p.do(pluginRequest{Op: "stat", Key: key}, func(resp *pluginResponse) (bool, error) {
+			if resp.Object == nil {
+				return false, errors.New("plugin answered a stat without an object")
+			}
+			info = resp.Object.info()
+			return true, nil
+		})
+	})
+	return info, err
+}
+
+// Close stops the plugin, by closing its stdin, and waits for it to exit.
+func (p *PluginStore) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stdin == nil {
+		return nil
+	}
+	_ = p.stdin.Close()
+	p.stdin = nil
+	if p.err == nil {
+		p.err = errors.New("plugin is closed")
+	}
+	return p.cmd.Wait()
+}
+
+// do sends a request and hands its responses to handle, until it says the
+// request is answered.
+func (p *PluginStore) do(req pluginRequest, handle func(resp *pluginResponse) (bool, error)) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	if p.stdin == nil {
+		if err := p.start(); err != nil {
+			p.err = fmt.Errorf("starting plugin, %v", err)
+			return p.err
+		}
+	}
+
+	p.lastID++
+	req.ID = p.lastID
+	if err := p.enc.Encode(req); err != nil {
+		p.err = fmt.Errorf("sending %s request to plugin, %v", req.Op, err)
+		return p.err
+	}
+	for {
+		resp := new(pluginResponse)
+		if err := p.dec.Decode(resp); err != nil {
+			p.err = fmt.Errorf("reading plugin response to %s, %v", req.Op, err)
+			return p.err
+		}
+		if resp.ID != req.ID {
+			p.err = fmt.Errorf("plugin answered request %d while waiting on %d", resp.ID, req.ID)
+			return p.err
+		}
+		if resp.Error != nil {
+			return resp.Error
+		}
+		done, err := handle(resp)
+		if err != nil {
+			p.err = err
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+func (p *PluginStore) start() error {
+	stdin, err := p.cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := p.cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := p.cmd.Start(); err != nil {
+		return err
+	}
+	p.stdin = stdin
+	p.enc = json.NewEncoder(stdin)
+	p.dec = json.NewDecoder(bufio.NewReader(stdout))
+	return nil
+}
@@ -0,0 +1,70 @@
+package taring_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/aybabtme/taring"
+	"github.com/aybabtme/taring/taringtest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestPluginProcess isn't a test: it's the plugin the tests start, serving
+// the fixture.
+func TestPluginProcess(t *testing.T) {
+	if os.Getenv("TARING_TEST_PLUGIN") == "" {
+		return
+	}
+	if err := taringtest.ServePlugin(newStore(), os.Stdin, os.Stdout); err != nil {
+		t.Fatal(err)
+	}
+	os.Exit(0)
+}
+
+func newPluginStore() *taring.PluginStore {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestPluginProcess$")
+	cmd.Env = append(os.Environ(), "TARING_TEST_PLUGIN=1")
+	return taring.NewPluginStore(cmd)
+}
+
+func TestPluginStore(t *testing.T) {
+	store := newPluginStore()
+	defer store.Close()
+
+	buf := bytes.NewBuffer(nil)
+	if err := taring.Archive(context.Background(), store, "data/", buf); err != nil {
+		t.Fatal(err)
+	}
+	want, err := taring.ReadManifest(filepath.Join("testdata", "golden", "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkArchive(t, buf, want)
+
+	info, err := store.Stat(context.Background(), "data/readme.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Key != "data/readme.txt" || info.Size != int64(len(fixture["data/readme.txt"])) {
+		t.Errorf("want %q of %d bytes, got %+v", "data/readme.txt", len(fixture["data/readme.txt"]), info)
+	}
+
+	// a failure is answered, and the plugin keeps answering after it
+	var pluginErr *taring.PluginError
+	if _, err := store.Open(context.Background(), "data/missing"); !errors.As(err, &pluginErr) || pluginErr.Code != "not_found" {
+		t.Errorf("want a not_found plugin error, got %v", err)
+	}
+	if _, err := store.Stat(context.Background(), "data/empty"); err != nil {
+		t.Errorf("want the plugin to answer after a failure, got %v", err)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Errorf("want the plugin to exit cleanly, got %v", err)
+	}
+	if _, err := store.Stat(context.Background(), "data/empty"); err == nil {
+		t.Error("want a closed plugin to fail")
+	}
+}
@@ -0,0 +1,335 @@
+package taring
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ProgressSnapshot is the state of a job at some point in time.
+// KeysTotal and BytesTotal are what's to be fetched, once known. What's
+// written is what went in the archive, before compression.
+type ProgressSnapshot struct {
+	Phase        string `json:"phase"`
+	KeysListed   int    `json:"keys_listed"`
+	PagesListed  int    `json:"pages_listed"`
+	KeysTotal    int    `json:"keys_total"`
+	KeysFetched  int    `json:"keys_fetched"`
+	KeysWritten  int    `json:"keys_written"`
+	BytesTotal   int64  `json:"bytes_total"`
+	BytesFetched int64  `json:"bytes_fetched"`
+	BytesWritten int64  `json:"bytes_written"`
+	// Retries is how many fetches failed and were tried again, and
+	// SlowRestarts how many were started over for being slow.
+	Retries      int    `json:"retries"`
+	SlowRestarts int    `json:"slow_restarts"`
+	Archive      string `json:"archive,omitempty"`
+}
+
+func (s ProgressSnapshot) String() string {
+	switch s.Phase {
+	case "":
+		return "starting"
+	case "listing":
+		return fmt.Sprintf("listing, %d keys so far in %d pages", s.KeysListed, s.PagesListed)
+	}
+	str := fmt.Sprintf("%s, %d/%d keys fetched (%s/%s)", s.Phase,
+		s.KeysFetched, s.KeysTotal,
+		humanize.Bytes(uint64(s.BytesFetched)), humanize.Bytes(uint64(s.BytesTotal)))
+	if s.Retries+s.SlowRestarts > 0 {
+		str += fmt.Sprintf(", %d retries and %d slow restarts", s.Retries, s.SlowRestarts)
+	}
+	return str
+}
+
+func totalSize(keys []ObjectInfo) int64 {
+	var size int64
+	for _, key := range keys {
+		size += key.Size
+	}
+	return size
+}
+
+// ActiveFetch is an object being fetched.
+type ActiveFetch struct {
+	Key     string
+	Prefix  string
+	Size    int64
+	Read    int64
+	Started time.Time
+}
+
+// JobProgress tracks a running job. A nil *JobProgress tracks nothing.
+type JobProgress struct {
+	mu         sync.Mutex
+	hooks      hooks
+	snap       ProgressSnapshot
+	active     map[*ActiveFetch]struct{}
+	stages     []StageTiming
+	stageStart time.Time
+	rates      []float64
+	failed     int
+	prefixes   map[string]*PrefixStats
+	slow       map[string]SlowObject
+	failures   map[string]*FailureClass
+	// recent are how many bytes were read at a few recent times
+	recent []rateSample
+}
+
+func (p *JobProgress) update(fn func(s *ProgressSnapshot)) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	prev, prevBytes := p.snap.Phase, p.snap.BytesFetched
+	fn(&p.snap)
+	if p.snap.Phase != prev {
+		p.endStage(prev)
+	}
+	if p.snap.BytesFetched != prevBytes {
+		p.sampleRate(time.Now())
+	}
+	snap, hooks := p.snap, p.hooks
+	p.mu.Unlock()
+	for _, hook := range hooks.progress {
+		hook(snap)
+	}
+}
+
+// setHooks has the hooks called as the job goes, from then on.
+func (p *JobProgress) setHooks(h hooks) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.hooks = h
+	p.mu.Unlock()
+}
+
+// endStage records the time spent in a phase that's over. It must be
+// called with p.mu held.
+func (p *JobProgress) endStage(phase string) {
+	now := time.Now()
+	if phase != "" && !p.stageStart.IsZero() {
+		took := now.Sub(p.stageStart)
+		p.stages = append(p.stages, StageTiming{Stage: phase, Duration: took.String(), Seconds: took.Seconds()})
+	}
+	p.stageStart = now
+}
+
+// Summarize adds to a run's summary what was tracked during the run.
+func (p *JobProgress) Summarize(sum *RunSummary) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.snap.Phase != "done" {
+		p.endStage(p.snap.Phase)
+	}
+	sum.Stages = append([]StageTiming(nil), p.stages...)
+	sum.Errors = p.failed
+	sum.Retries, sum.SlowRestarts = p.snap.Retries, p.snap.SlowRestarts
+	sum.Throughput = newThroughput(p.rates)
+	// a dry run breaks down what it listed instead
+	if len(p.prefixes) > 0 {
+		sum.Prefixes = sum.Prefixes[:0]
+		for _, stats := range p.prefixes {
+			st := *stats
+			st.Duration = time.Duration(st.Seconds * float64(time.Second)).String()
+			sum.Prefixes = append(sum.Prefixes, st)
+		}
+		sort.Slice(sum.Prefixes, func(i, j int) bool { return sum.Prefixes[i].Bytes > sum.Prefixes[j].Bytes })
+	}
+	sum.Slow = p.slowest(slowReported)
+	sum.Failures = p.failureClasses()
+}
+
+// SetPhase moves the job to a new phase.
+func (p *JobProgress) SetPhase(name string) {
+	p.update(func(s *ProgressSnapshot) { s.Phase = name })
+}
+
+// Snapshot returns the current state of the job.
+func (p *JobProgress) Snapshot() ProgressSnapshot {
+	if p == nil {
+		return ProgressSnapshot{}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.snap
+}
+
+func (p *JobProgress) startFetch(key, prefix string, size int64) *ActiveFetch {
+	f := &ActiveFetch{Key: key, Prefix: prefix, Size: size, Started: time.Now()}
+	if p == nil {
+		return f
+	}
+	p.mu.Lock()
+	if p.active == nil {
+		p.active = make(map[*ActiveFetch]struct{})
+	}
+	p.active[f] = struct{}{}
+	hooks := p.hooks
+	p.mu.Unlock()
+	for _, hook := range hooks.objectStart {
+		hook(*f)
+	}
+	return f
+}
+
+func (p *JobProgress) endFetch(f *ActiveFetch, err error) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	delete(p.active, f)
+	read := atomic.LoadInt64(&f.Read)
+	took := time.Since(f.Started)
+	fetch := *f
+	fetch.Read = read
+	hooks := p.hooks
+	var retried *retrying
+	if errors.As(err, &retried) || errors.Is(err, errSlowAborted) {
+		// started over, it'll be counted then
+		if retried != nil {
+			p.snap.Retries++
+		} else {
+			p.snap.SlowRestarts++
+		}
+		p.mu.Unlock()
+		for _, hook := range hooks.objectError {
+			hook(fetch, err)
+		}
+		return
+	}
+	if p.prefixes == nil {
+		p.prefixes = make(map[string]*PrefixStats)
+	}
+	stats, ok := p.prefixes[f.Prefix]
+	if !ok {
+		stats = &PrefixStats{Prefix: f.Prefix}
+		p.prefixes[f.Prefix] = stats
+	}
+	stats.Seconds += took.Seconds()
+	if err != nil {
+		p.failed++
+		stats.Errors++
+		p.recordFailure(f.Key, err)
+	} else {
+		stats.Objects++
+		stats.Bytes += read
+		if took > 0 {
+			p.rates = append(p.rates, float64(read)/took.Seconds())
+		}
+	}
+	p.mu.Unlock()
+
+	if err != nil {
+		for _, hook := range hooks.objectError {
+			hook(fetch, err)
+		}
+		return
+	}
+	for _, hook := range hooks.objectDone {
+		hook(fetch)
+	}
+}
+
+// Active returns the objects being fetched, oldest first.
+func (p *JobProgress) Active() []ActiveFetch {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	active := make([]ActiveFetch, 0, len(p.active))
+	for f := range p.active {
+		fetch := *f
+		fetch.Read = atomic.LoadInt64(&f.Read)
+		active = append(active, fetch)
+	}
+	p.mu.Unlock()
+	sort.Slice(active, func(i, j int) bool { return active[i].Started.Before(active[j].Started) })
+	return active
+}
+
+// fetchReader counts what's read of an object being fetched.
+type fetchReader struct {
+	r     io.Reader
+	fetch *ActiveFetch
+}
+
+func (r *fetchReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	atomic.AddInt64(&r.fetch.Read, int64(n))
+	return n, err
+}
+
+// rateWindow is how far back the current rate of a job is measured.
+const rateWindow = 5 * time.Second
+
+type rateSample struct {
+	at    time.Time
+	bytes int64
+}
+
+// Stats are counters of a job, as it runs.
+type Stats struct {
+	Phase          string
+	ObjectsListed  int
+	ObjectsFetched int
+	ObjectsWritten int
+	BytesFetched   int64
+	BytesWritten   int64
+	// Errors is how many objects couldn't be fetched.
+	Errors int
+	// Rate is how many bytes were fetched per second over the last few
+	// seconds, including what's read of the objects still being fetched.
+	Rate float64
+}
+
+// Stats returns the counters of the job. It's safe to call while the job
+// runs, from any goroutine.
+func (p *JobProgress) Stats() Stats {
+	if p == nil {
+		return Stats{}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	p.sampleRate(now)
+	stats := Stats{
+		Phase:          p.snap.Phase,
+		ObjectsListed:  p.snap.KeysListed,
+		ObjectsFetched: p.snap.KeysFetched,
+		ObjectsWritten: p.snap.KeysWritten,
+		BytesFetched:   p.snap.BytesFetched,
+		BytesWritten:   p.snap.BytesWritten,
+		Errors:         p.failed,
+	}
+	if first, last := p.recent[0], p.recent[len(p.recent)-1]; last.at.After(first.at) {
+		stats.Rate = float64(last.bytes-first.bytes) / last.at.Sub(first.at).Seconds()
+	}
+	return stats
+}
+
+// sampleRate records how many bytes were read by now, forgetting what's
+// out of the rate window. It must be called with p.mu held.
+func (p *JobProgress) sampleRate(now time.Time) {
+	read := p.snap.BytesFetched
+	for f := range p.active {
+		read += atomic.LoadInt64(&f.Read)
+	}
+	p.recent = append(p.recent, rateSample{at: now, bytes: read})
+	// keep the last sample before the window, to measure from its start
+	i := 0
+	for i+1 < len(p.recent) && now.Sub(p.recent[i+1].at) >= rateWindow {
+		i++
+	}
+	p.recent = p.recent[i:]
+}
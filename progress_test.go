@@ -0,0 +1,80 @@
+package taring_test
+
+import (
+	"context"
+	"github.com/aybabtme/taring"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestNilProgress(t *testing.T) {
+	var p *taring.JobProgress
+	p.SetPhase("listing")
+	sum := &taring.RunSummary{Job: "job"}
+	p.Summarize(sum)
+	if sum.Job != "job" || len(sum.Stages) != 0 {
+		t.Errorf("want the summary left alone, got %+v", sum)
+	}
+	if snap := p.Snapshot(); snap != (taring.ProgressSnapshot{}) {
+		t.Errorf("want an empty snapshot, got %+v", snap)
+	}
+	if active := p.Active(); len(active) != 0 {
+		t.Errorf("want nothing active, got %+v", active)
+	}
+}
+
+func TestRunStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var a *taring.Archiver
+	var polled int64
+	a = taring.New("s3://bucket/data/", filepath.Join(dir, "archive.tar.gz"),
+		taring.WithStore(newStore()),
+		// polled from the goroutines of the run while it goes
+		taring.WithOnObjectDone(func(taring.ActiveFetch) { atomic.AddInt64(&polled, int64(a.Stats().ObjectsFetched)) }),
+	)
+	if _, err := a.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if polled == 0 {
+		t.Error("want objects fetched counted while the run goes")
+	}
+	stats := a.Stats()
+	var size int64
+	for key, data := range fixture {
+		if strings.HasPrefix(key, "data/") {
+			size += int64(len(data))
+		}
+	}
+	if stats.ObjectsFetched != 5 || stats.ObjectsWritten != 5 || stats.BytesFetched != size || stats.Errors != 0 {
+		t.Errorf("want 5 objects of %d bytes fetched and written, got %+v", size, stats)
+	}
+}
+
+func TestRunWithoutProgress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := taring.New("s3://bucket/data/", filepath.Join(dir, "archive.tar.gz"),
+		taring.WithStore(newStore()),
+		taring.WithProgress(nil),
+		taring.WithOnObjectDone(func(taring.ActiveFetch) {}),
+	)
+	if _, err := a.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if stats := a.Stats(); stats != (taring.Stats{}) {
+		t.Errorf("want nothing tracked, got %+v", stats)
+	}
+}
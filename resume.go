@@ -0,0 +1,225 @@
+package taring
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// stateVersion is the version of the state snapshots are taken of.
+const stateVersion = 1
+
+// jobState is what's kept of a run to resume it: where it's archiving to,
+// what it listed once it's done listing, and how many of the keys it
+// listed it wrote to the archive since.
+type jobState struct {
+	Version int          `json:"version"`
+	Source  string       `json:"source"`
+	Archive string       `json:"archive,omitempty"`
+	Started time.Time    `json:"started"`
+	Listed  bool         `json:"listed"`
+	Keys    []stateEntry `json:"keys,omitempty"`
+	Written int          `json:"written"`
+}
+
+type stateEntry struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// Snapshot returns the state of the run under way, for ResumeFrom to pick
+// it up after a restart. It's safe to call from any goroutine. A resumed
+// run keeps to the same archive, skips the listing if it got that far, and
+// carries over the objects the partial archive on disk already holds whole,
+// fetching only the others. Archives uploaded to S3 or split in volumes
+// are started over. Once a run succeeds, there's nothing left to resume.
+func (a *Archiver) Snapshot() ([]byte, error) {
+	a.stateMu.Lock()
+	defer a.stateMu.Unlock()
+	a.snapshotted = true
+	state := a.state
+	state.Version = stateVersion
+	state.Source = a.source
+	return json.Marshal(state)
+}
+
+// ResumeFrom makes the next run carry on from a snapshot of a run of the
+// same source.
+func (a *Archiver) ResumeFrom(snapshot []byte) error {
+	var state jobState
+	if err := json.Unmarshal(snapshot, &state); err != nil {
+		return fmt.Errorf("decoding snapshot, %v", err)
+	}
+	switch {
+	case state.Version != stateVersion:
+		return fmt.Errorf("can't resume from a version %d snapshot, only %d", state.Version, stateVersion)
+	case state.Source != a.source:
+		return fmt.Errorf("can't resume from a snapshot of %q while archiving %q", state.Source, a.source)
+	}
+	a.stateMu.Lock()
+	defer a.stateMu.Unlock()
+	a.state = state
+	return nil
+}
+
+// resuming tells if the next run carries on from a snapshot.
+func (a *Archiver) resuming() bool {
+	a.stateMu.Lock()
+	defer a.stateMu.Unlock()
+	return a.state.Archive != ""
+}
+
+// wasSnapshotted tells if the run was snapshotted since it started.
+func (a *Archiver) wasSnapshotted() bool {
+	a.stateMu.Lock()
+	defer a.stateMu.Unlock()
+	return a.snapshotted
+}
+
+// resumeArchive returns the archive the resumed run was writing to, or
+// starts keeping the state of a new run to the archive at tarDst.
+func (a *Archiver) resumeArchive(tarDst string, started time.Time) string {
+	a.stateMu.Lock()
+	defer a.stateMu.Unlock()
+	a.snapshotted = false
+	if a.state.Archive != "" {
+		return a.state.Archive
+	}
+	a.state = jobState{Archive: tarDst, Started: started}
+	return tarDst
+}
+
+// resumedKeys returns what the resumed run listed, if it got that far.
+func (a *Archiver) resumedKeys() ([]ObjectInfo, bool) {
+	a.stateMu.Lock()
+	defer a.stateMu.Unlock()
+	if !a.state.Listed {
+		return nil, false
+	}
+	keys := make([]ObjectInfo, len(a.state.Keys))
+	for i, e := range a.state.Keys {
+		keys[i] = ObjectInfo{Key: e.Key, Size: e.Size, ETag: e.ETag, LastModified: e.LastModified}
+	}
+	return keys, true
+}
+
+// saveKeys records that the run listed the keys.
+func (a *Archiver) saveKeys(keys []ObjectInfo) {
+	entries := make([]stateEntry, len(keys))
+	for i, key := range keys {
+		entries[i] = stateEntry{Key: key.Key, Size: key.Size, ETag: key.ETag, LastModified: key.LastModified}
+	}
+	a.stateMu.Lock()
+	defer a.stateMu.Unlock()
+	a.state.Listed = true
+	a.state.Keys = entries
+}
+
+// saveWritten records that the run wrote the first n keys it listed to the
+// archive, though not all may have made it to disk yet.
+func (a *Archiver) saveWritten(n int) {
+	a.stateMu.Lock()
+	defer a.stateMu.Unlock()
+	a.state.Written = n
+}
+
+// resumedSuffix marks the partial archive of a run being resumed, set
+// aside while a new one is written from it.
+const resumedSuffix = ".resumed"
+
+// wholeEntries is how many of the keys the partial archive at path starts
+// with, whole, in order, and with the checksum of their ETag when checked.
+// Where a crash cut it short, the last entries are missing or cut short.
+func (r *run) wholeEntries(path string, keys []ObjectInfo) int {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	dr, err := decompress(f)
+	if err != nil {
+		return 0
+	}
+	defer dr.Close()
+	tr := tar.NewReader(dr)
+	for n, key := range keys {
+		hdr, err := tr.Next()
+		if err != nil || hdr.PAXRecords[PAXKey] != key.Key || hdr.PAXRecords[PAXETag] != key.ETag || hdr.Size != key.Size {
+			return n
+		}
+		sum := md5.New()
+		if _, err := io.Copy(sum, tr); err != nil {
+			return n
+		}
+		if r.checkETags && checkMD5(key, sum.Sum(nil)) != nil {
+			return n
+		}
+	}
+	return len(keys)
+}
+
+// carryOver writes the objects the partial archive at path holds whole
+// into the stream, so that they aren't fetched again, and returns them.
+// The keys start with them. No more are trusted than the resumed run
+// recorded writing.
+func (r *run) carryOver(ctx context.Context, stream *archiveStream, path string, keys []ObjectInfo) ([]S3Content, error) {
+	r.stateMu.Lock()
+	written := r.state.Written
+	r.stateMu.Unlock()
+	if written < len(keys) {
+		keys = keys[:written]
+	}
+	n := r.wholeEntries(path, keys)
+	if n == 0 {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	dr, err := decompress(f)
+	if err != nil {
+		return nil, fmt.Errorf("decompressing %q, %v", path, err)
+	}
+	defer dr.Close()
+	tr := tar.NewReader(dr)
+	contents := make([]S3Content, 0, n)
+	for _, key := range keys[:n] {
+		hdr, err := tr.Next()
+		if err != nil {
+			return nil, fmt.Errorf("reading %q, %v", path, err)
+		}
+		content := S3Content{
+			Key:     key.Key,
+			ETag:    key.ETag,
+			Name:    hdr.Name,
+			LastMod: key.LastModified,
+			Size:    hdr.Size,
+			Meta:    paxObjectMeta(hdr.PAXRecords),
+			r:       tr,
+		}
+		if err := stream.add(ctx, &content); err != nil {
+			return nil, err
+		}
+		content.r = nil
+		contents = append(contents, content)
+	}
+	r.saveWritten(n)
+	r.log.Infof("carried over %d objects from %q", n, path)
+	return contents, nil
+}
+
+// resetState forgets about a run that's over.
+func (a *Archiver) resetState() {
+	a.stateMu.Lock()
+	defer a.stateMu.Unlock()
+	a.state = jobState{}
+}
@@ -0,0 +1,271 @@
+package taring
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	JobPlaceholder  = "{job}"
+	TimePlaceholder = "{time}"
+	// archiveTimeFormat sorts lexically and is safe in file names.
+	archiveTimeFormat = "20060102T150405Z"
+)
+
+// ExpandArchivePath fills the placeholders of an archive path template.
+func ExpandArchivePath(tmpl, job string, t time.Time) string {
+	path := strings.Replace(tmpl, JobPlaceholder, job, -1)
+	return strings.Replace(path, TimePlaceholder, t.UTC().Format(archiveTimeFormat), -1)
+}
+
+// jobArchive is an archive of a job, found from the archive path template.
+type jobArchive struct {
+	path string
+	// created is when the run that wrote it started, from its name
+	created  time.Time
+	modified time.Time
+}
+
+// archivePattern matches the paths the archive path template expands to
+// for job, capturing the time. It only matches a time in the format it's
+// written in, so that the archives of a job whose name extends job's
+// aren't taken for job's.
+func archivePattern(tmpl, job string) *regexp.Regexp {
+	parts := strings.Split(strings.Replace(tmpl, JobPlaceholder, job, -1), TimePlaceholder)
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, `(\d{8}T\d{6}Z)`) + "$")
+}
+
+// matchArchive tells when the archive at path was created, if it's one
+// the archive path pattern matches.
+func matchArchive(pattern *regexp.Regexp, path string) (time.Time, bool) {
+	m := pattern.FindStringSubmatch(path)
+	if m == nil {
+		return time.Time{}, false
+	}
+	var created time.Time
+	for _, stamp := range m[1:] {
+		t, err := time.Parse(archiveTimeFormat, stamp)
+		if err != nil || !created.IsZero() && !t.Equal(created) {
+			return time.Time{}, false
+		}
+		created = t
+	}
+	return created, true
+}
+
+// sortArchives sorts archives most recent first.
+func sortArchives(archives []jobArchive) {
+	sort.Slice(archives, func(i, j int) bool { return archives[i].created.After(archives[j].created) })
+}
+
+// jobArchives finds the local archives previously produced from the same
+// archive path template by the same job, most recent first.
+func jobArchives(tmpl, job string) ([]jobArchive, error) {
+	glob := strings.Replace(tmpl, JobPlaceholder, job, -1)
+	glob = strings.Replace(glob, TimePlaceholder, "*", -1)
+	matches, err := filepath.Glob(glob)
+	if err != nil {
+		return nil, err
+	}
+	pattern := archivePattern(tmpl, job)
+	var archives []jobArchive
+	for _, match := range matches {
+		created, ok := matchArchive(pattern, match)
+		if !ok {
+			continue
+		}
+		fi, err := os.Stat(match)
+		if err != nil {
+			return nil, err
+		}
+		archives = append(archives, jobArchive{path: match, created: created, modified: fi.ModTime()})
+	}
+	sortArchives(archives)
+	return archives, nil
+}
+
+// s3JobArchives is jobArchives for an `s3://` archive path template,
+// listing the bucket under the part of the template before its
+// placeholders.
+func (r *run) s3JobArchives(ctx context.Context, tmpl string) ([]jobArchive, error) {
+	u, err := url.Parse(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.TrimPrefix(u.Path, "/")
+	if i := strings.Index(prefix, "{"); i >= 0 {
+		prefix = prefix[:i]
+	}
+	store := newS3Store(r.creds, r.region, u.Host, r.httpClient())
+	pattern := archivePattern(tmpl, r.job)
+	var archives []jobArchive
+	err = r.walk(ctx, store, prefix, func(obj ObjectInfo) {
+		path := "s3://" + u.Host + "/" + obj.Key
+		if created, ok := matchArchive(pattern, path); ok {
+			archives = append(archives, jobArchive{path: path, created: created, modified: obj.LastModified})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortArchives(archives)
+	return archives, nil
+}
+
+// walk hands every object under prefix to fn, descending into folders.
+func (r *run) walk(ctx context.Context, store Lister, prefix string, fn func(obj ObjectInfo)) error {
+	for marker := ""; ; {
+		var page ListPage
+		err := r.retry(ctx, fmt.Sprintf("listing %q", prefix), func() (err error) {
+			page, err = store.ListPage(ctx, prefix, marker)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("listing %q, %s", prefix, DescribeS3Error(err))
+		}
+		for _, obj := range page.Objects {
+			fn(obj)
+		}
+		for _, folder := range page.Folders {
+			if err := r.walk(ctx, store, folder, fn); err != nil {
+				return err
+			}
+		}
+		if page.Next == "" {
+			return nil
+		}
+		marker = page.Next
+	}
+}
+
+// checkRetention tells if the archives written to the archive path
+// template tmpl by job can be pruned: only those of the job, told apart by
+// the time of their run, at a local path or in S3.
+func checkRetention(tmpl, job string) error {
+	switch {
+	case !strings.Contains(tmpl, TimePlaceholder):
+		return fmt.Errorf("archive path %q needs a %s placeholder for archives of successive runs to be kept apart", tmpl, TimePlaceholder)
+	case !strings.Contains(tmpl, JobPlaceholder):
+		return fmt.Errorf("archive path %q needs a %s placeholder for the archives of other jobs not to be pruned", tmpl, JobPlaceholder)
+	case job == "":
+		return errors.New("need a job name to find the job's archives to prune")
+	case strings.Contains(tmpl, "://") && !strings.HasPrefix(tmpl, "s3://"):
+		return fmt.Errorf("archives can only be pruned at a local path or in S3, not at %q", tmpl)
+	}
+	return nil
+}
+
+// prune removes the archives of the job that fall outside the retention
+// policy: beyond the keepLast most recent ones, or older than keepDays.
+// Zero disables either rule. The current archive is always kept, and the
+// files kept next to an archive go with it, as do its catalog entries.
+func (r *run) prune(ctx context.Context) ([]string, error) {
+	var (
+		archives []jobArchive
+		remove   = removeArchive
+		err      error
+	)
+	if strings.HasPrefix(r.destination, "s3://") {
+		archives, err = r.s3JobArchives(ctx, r.destination)
+		remove = func(archive string) error { return r.removeS3Archive(ctx, archive) }
+	} else {
+		archives, err = jobArchives(r.destination, r.job)
+	}
+	if err != nil {
+		return nil, err
+	}
+	pruned, err := pruneArchives(archives, r.tarDst, r.keepLast, r.keepDays, remove)
+	if r.catalog != "" && len(pruned) > 0 {
+		if ferr := forgetArchives(r.catalog, pruned); ferr != nil && err == nil {
+			err = fmt.Errorf("dropping pruned archives from catalog, %v", ferr)
+		}
+	}
+	return pruned, err
+}
+
+// forgetArchives drops the archives from the catalog at path.
+func forgetArchives(path string, archives []string) error {
+	catalog, err := OpenCatalog(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = catalog.Close() }()
+	for _, archive := range archives {
+		if err := catalog.Forget(catalogArchivePath(archive)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneArchives removes with remove the archives, most recent first, that
+// fall outside the retention policy, but for the current one.
+func pruneArchives(archives []jobArchive, current string, keepLast, keepDays int, remove func(archive string) error) ([]string, error) {
+	cutoff := time.Now().AddDate(0, 0, -keepDays)
+	var pruned []string
+	for i, archive := range archives {
+		if archive.path == current {
+			continue
+		}
+		expired := keepLast > 0 && i >= keepLast || keepDays > 0 && archive.modified.Before(cutoff)
+		if !expired {
+			continue
+		}
+		if err := remove(archive.path); err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, archive.path)
+	}
+	return pruned, nil
+}
+
+// removeArchive removes a local archive and the files kept next to it.
+func removeArchive(archive string) error {
+	if err := os.Remove(archive); err != nil {
+		return fmt.Errorf("removing %q, %v", archive, err)
+	}
+	if err := os.Remove(ManifestPath(archive)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing manifest of %q, %v", archive, err)
+	}
+	if err := os.Remove(ManifestCSVPath(archive)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing CSV manifest of %q, %v", archive, err)
+	}
+	if err := os.Remove(SummaryPath(archive)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing summary of %q, %v", archive, err)
+	}
+	return nil
+}
+
+// removeS3Archive deletes an archive from S3 along with its manifests and
+// summary. Deleting what isn't there succeeds.
+func (r *run) removeS3Archive(ctx context.Context, archive string) error {
+	u, err := url.Parse(archive)
+	if err != nil {
+		return err
+	}
+	for _, path := range []string{archive, ManifestPath(archive), ManifestCSVPath(archive), SummaryPath(archive)} {
+		key := strings.TrimPrefix(path, "s3://"+u.Host+"/")
+		err := r.retry(ctx, "deleting "+path, func() error {
+			bkt, err := r.bucket(ctx, u.Host)
+			if err != nil {
+				return err
+			}
+			return inContext(ctx, func() error { return bkt.Del(key) })
+		})
+		if err != nil {
+			return fmt.Errorf("deleting %q, %s", path, DescribeS3Error(err))
+		}
+	}
+	return nil
+}
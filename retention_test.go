@@ -0,0 +1,225 @@
+package taring_test
+
+import (
+	"context"
+	"github.com/aybabtme/taring"
+	"github.com/aybabtme/taring/taringtest"
+	"github.com/crowdmob/goamz/aws"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunRetention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// without an extension, the template matches what's next to the
+	// archives too
+	tmpl := filepath.Join(dir, "{job}-{time}")
+
+	// archives of earlier runs, a day apart, each with its manifests, and
+	// one of another job
+	now := time.Now()
+	var old []string
+	for days := 1; days <= 3; days++ {
+		created := now.AddDate(0, 0, -days)
+		archive := taring.ExpandArchivePath(tmpl, "nightly", created)
+		for _, path := range []string{archive, taring.ManifestPath(archive), taring.ManifestCSVPath(archive), taring.SummaryPath(archive)} {
+			if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(path, created, created); err != nil {
+				t.Fatal(err)
+			}
+		}
+		old = append(old, archive)
+	}
+	other := taring.ExpandArchivePath(tmpl, "weekly", now.AddDate(0, 0, -30))
+	if err := ioutil.WriteFile(other, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// keeping the last 3 prunes the oldest, and keeping 2 days the one
+	// before
+	sum, err := taring.New("s3://bucket/data/", tmpl,
+		taring.WithStore(newStore()),
+		taring.WithJob("nightly"),
+		taring.WithManifestCSV(),
+		taring.WithRetention(3, 2),
+	).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want []string
+	for _, archive := range []string{sum.Archive, old[0]} {
+		want = append(want, archive, taring.ManifestPath(archive), taring.ManifestCSVPath(archive))
+	}
+	want = append(want, taring.SummaryPath(old[0]), other)
+	sort.Strings(want)
+	got, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	if len(got) != len(want) {
+		t.Fatalf("want %q left, got %q", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("want %q left, got %q", want, got)
+		}
+	}
+}
+
+func TestRunRetentionOtherJobs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tmpl := filepath.Join(dir, "{job}-{time}.tar.gz")
+
+	// a job whose name extends this one's, and a name that isn't a time
+	others := []string{
+		taring.ExpandArchivePath(tmpl, "logs-eu", time.Now().AddDate(0, 0, -1)),
+		filepath.Join(dir, "logs-latest.tar.gz"),
+	}
+	for _, other := range others {
+		if err := ioutil.WriteFile(other, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sum, err := taring.New("s3://bucket/data/", tmpl,
+		taring.WithStore(newStore()),
+		taring.WithJob("logs"),
+		taring.WithRetention(1, 0),
+	).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range append(others, sum.Archive) {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("want %q kept, got %v", path, err)
+		}
+	}
+}
+
+func TestRunRetentionS3(t *testing.T) {
+	store := newStore()
+	srv := httptest.NewServer(taringtest.S3Handler("bucket", store))
+	defer srv.Close()
+	tmpl := "s3://bucket/backups/{job}/{time}.tar.gz"
+
+	// archives of earlier runs a day apart, most recent first, and one of
+	// another job
+	now := time.Now()
+	var old []string
+	for days := 1; days <= 3; days++ {
+		created := now.AddDate(0, 0, -days)
+		archive := taring.ExpandArchivePath(tmpl, "nightly", created)
+		for _, path := range []string{archive, taring.ManifestPath(archive), taring.SummaryPath(archive)} {
+			store.Put(strings.TrimPrefix(path, "s3://bucket/"), nil, created)
+		}
+		old = append(old, archive)
+	}
+	other := taring.ExpandArchivePath(tmpl, "nightly-eu", now.AddDate(0, 0, -30))
+	store.Put(strings.TrimPrefix(other, "s3://bucket/"), nil, now.AddDate(0, 0, -30))
+
+	sum, err := taring.New("s3://bucket/data/", tmpl,
+		taring.WithAuth(aws.Auth{AccessKey: "access", SecretKey: "secret"}, taring.CustomS3Endpoint(aws.USEast, srv.URL, true)),
+		taring.WithJob("nightly"),
+		taring.WithRetention(3, 2),
+	).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exists := func(path string) bool {
+		_, err := store.Stat(context.Background(), strings.TrimPrefix(path, "s3://bucket/"))
+		return err == nil
+	}
+	for _, path := range []string{sum.Archive, taring.ManifestPath(sum.Archive), old[0], taring.ManifestPath(old[0]), other} {
+		if !exists(path) {
+			t.Errorf("want %q kept", path)
+		}
+	}
+	for _, archive := range old[1:] {
+		for _, path := range []string{archive, taring.ManifestPath(archive), taring.SummaryPath(archive)} {
+			if exists(path) {
+				t.Errorf("want %q pruned", path)
+			}
+		}
+	}
+}
+
+func TestRunRetentionNeedsJob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, c := range []struct{ tmpl, job string }{
+		{filepath.Join(dir, "{time}.tar.gz"), "nightly"},
+		{filepath.Join(dir, "{job}-{time}.tar.gz"), ""},
+		{"gs://bucket/{job}-{time}.tar.gz", "nightly"},
+	} {
+		_, err := taring.New("s3://bucket/data/", c.tmpl,
+			taring.WithStore(newStore()),
+			taring.WithJob(c.job),
+			taring.WithRetention(1, 0),
+		).Run(context.Background())
+		if err == nil {
+			t.Errorf("%q of job %q: want an error pruning, got none", c.tmpl, c.job)
+		}
+	}
+}
+
+func TestRunRetentionCatalog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tmpl := filepath.Join(dir, "{job}-{time}.tar.gz")
+	db := filepath.Join(dir, "taring.db")
+
+	// an archive of the job from yesterday, in the catalog
+	old := taring.ExpandArchivePath(tmpl, "nightly", time.Now().AddDate(0, 0, -1))
+	if _, err := taring.New("s3://bucket/data/", old, taring.WithStore(newStore()), taring.WithCatalog(db)).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := taring.New("s3://bucket/data/", tmpl,
+		taring.WithStore(newStore()),
+		taring.WithJob("nightly"),
+		taring.WithCatalog(db),
+		taring.WithRetention(1, 0),
+	).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Fatalf("want %q pruned, got %v", old, err)
+	}
+
+	catalog, err := taring.OpenCatalog(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer catalog.Close()
+	entries, err := catalog.Lookup("s3://bucket/data/readme.txt", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Archive != sum.Archive {
+		t.Errorf("want the object only in %q, got %+v", sum.Archive, entries)
+	}
+}
@@ -0,0 +1,94 @@
+package taring
+
+import (
+	"context"
+	"errors"
+	"github.com/crowdmob/goamz/s3"
+	"math/rand"
+	"time"
+)
+
+const (
+	// defaultAttempts is how many times a request is tried by default.
+	defaultAttempts = 4
+	// defaultBackoff is how long the first retry waits at most, by default.
+	defaultBackoff = 250 * time.Millisecond
+	// maxBackoff is the longest a retry ever waits.
+	maxBackoff = 20 * time.Second
+)
+
+// retrying is the error of a fetch that's started over.
+type retrying struct{ err error }
+
+func (r *retrying) Error() string { return r.err.Error() + ", retrying" }
+func (r *retrying) Unwrap() error { return r.err }
+
+// retryable tells if a request that failed with err may succeed if tried
+// again: S3 failed on its side, throttled it, or the network failed or
+// corrupted what it fetched.
+func retryable(err error) bool {
+	var (
+		s3err  *s3.Error
+		gcsErr *GCSError
+		azErr  *AzureError
+	)
+	if errors.As(err, &s3err) && s3err.StatusCode >= 500 {
+		return true
+	}
+	if errors.As(err, &gcsErr) && gcsErr.StatusCode >= 500 {
+		return true
+	}
+	if errors.As(err, &azErr) && azErr.StatusCode >= 500 {
+		return true
+	}
+	switch classifyFailure(err) {
+	case failThrottled, failTimeout, failNetwork, failChecksum:
+		return true
+	}
+	return false
+}
+
+// attempts is how many times a request is tried.
+func (a *Archiver) attempts() int {
+	if a.retryAttempts > 0 {
+		return a.retryAttempts
+	}
+	return defaultAttempts
+}
+
+// retry calls fn until it succeeds, fails for good or runs out of
+// attempts, backing off in between. What is what fn does, for the logs.
+func (a *Archiver) retry(ctx context.Context, what string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= a.attempts() || !retryable(err) || ctx.Err() != nil {
+			return err
+		}
+		a.log.Infof("%s failed (%s), retrying (%d/%d)", what, DescribeS3Error(err), attempt, a.attempts()-1)
+		if err := a.backoff(ctx, attempt); err != nil {
+			return err
+		}
+	}
+}
+
+// backoff waits before the retry following an attempt: a random time up
+// to a delay doubling with every attempt, so that retries don't come all
+// at once.
+func (a *Archiver) backoff(ctx context.Context, attempt int) error {
+	base := a.retryBackoff
+	if base <= 0 {
+		base = defaultBackoff
+	}
+	d := maxBackoff
+	if attempt < 32 && base<<uint(attempt-1) < maxBackoff {
+		d = base << uint(attempt-1)
+	}
+	t := time.NewTimer(time.Duration(rand.Int63n(int64(d))) + 1)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
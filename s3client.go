@@ -0,0 +1,107 @@
+package taring
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"github.com/crowdmob/goamz/aws"
+	"github.com/crowdmob/goamz/s3"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// s3Client makes requests to a bucket of S3, signed by hand so that they
+// go through client, and the transport middlewares with it: goamz makes
+// its own client. Every request is signed with the credentials auth gives
+// at the time.
+type s3Client struct {
+	auth   func(ctx context.Context) (aws.Auth, error)
+	region aws.Region
+	bucket string
+	client *http.Client
+}
+
+// staticAuth always gives auth, until it expires.
+func staticAuth(auth aws.Auth) func(ctx context.Context) (aws.Auth, error) {
+	return func(context.Context) (aws.Auth, error) { return auth, checkExpiry(auth) }
+}
+
+// objectURL addresses key in the bucket the way goamz does: as
+// bucket.endpoint when the region has a bucket endpoint, endpoint/bucket
+// otherwise.
+func (c *s3Client) objectURL(key string, query url.Values) string {
+	base := c.region.S3Endpoint + "/" + c.bucket
+	if c.region.S3BucketEndpoint != "" {
+		base = strings.Replace(c.region.S3BucketEndpoint, "${bucket}", c.bucket, -1)
+	}
+	u := base + (&url.URL{Path: "/" + key}).EscapedPath()
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+// do makes a request of key with the headers of header, failing with an
+// *s3.Error when S3 answers with one. The body of the response must be
+// closed.
+func (c *s3Client) do(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	auth, err := c.auth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.objectURL(key, query), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		req.Body, req.ContentLength = nil, 0
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	// so that the transport leaves objects stored gzipped as they are
+	req.Header.Set("Accept-Encoding", "identity")
+	payloadSum := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadSum[:]))
+	if token := auth.Token(); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+	aws.NewV4Signer(auth, "s3", c.region).Sign(req)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	s3err := &s3.Error{
+		StatusCode: resp.StatusCode,
+		BucketName: c.bucket,
+		RequestId:  resp.Header.Get("X-Amz-Request-Id"),
+		HostId:     resp.Header.Get("X-Amz-Id-2"),
+	}
+	var answer struct {
+		Code      string
+		Message   string
+		RequestId string
+		HostId    string
+	}
+	if xml.Unmarshal(data, &answer) == nil && answer.Code != "" {
+		s3err.Code, s3err.Message = answer.Code, answer.Message
+		if answer.RequestId != "" {
+			s3err.RequestId, s3err.HostId = answer.RequestId, answer.HostId
+		}
+	}
+	if s3err.Message == "" {
+		s3err.Message = resp.Status
+	}
+	return nil, s3err
+}
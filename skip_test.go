@@ -0,0 +1,39 @@
+package taring_test
+
+import (
+	"context"
+	"github.com/aybabtme/taring"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRunSkipUnchanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := newStore()
+	archive := filepath.Join(dir, "archive.tar.gz")
+	run := func() *taring.RunSummary {
+		sum, err := taring.New("s3://bucket/data/", archive, taring.WithStore(store), taring.WithSkipUnchanged()).Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sum
+	}
+	if sum := run(); sum.Status != taring.StatusSucceeded {
+		t.Fatalf("want the first run to archive, got %q", sum.Status)
+	}
+	if sum := run(); sum.Status != taring.StatusUnchanged || store.Opens("data/readme.txt") != 1 {
+		t.Fatalf("want nothing fetched when nothing changed, got %q and %d opens", sum.Status, store.Opens("data/readme.txt"))
+	}
+	store.Put("data/readme.txt", []byte("hello again"), time.Date(2016, 3, 2, 12, 0, 0, 0, time.UTC))
+	if sum := run(); sum.Status != taring.StatusSucceeded || store.Opens("data/readme.txt") != 2 {
+		t.Errorf("want a change archived, got %q and %d opens", sum.Status, store.Opens("data/readme.txt"))
+	}
+}
@@ -0,0 +1,155 @@
+package taring
+
+import (
+	"context"
+	"errors"
+	"github.com/dustin/go-humanize"
+	"io/ioutil"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// slowReported is how many of the slowest objects the summary lists.
+const slowReported = 10
+
+// slowGrace is how long a fetch goes before its speed is judged.
+var slowGrace = 10 * time.Second
+
+// SlowObject is an object that was slow to fetch.
+type SlowObject struct {
+	Key  string `json:"key"`
+	Size int64  `json:"size"`
+	// BytesPerSec is the speed of its slowest fetch.
+	BytesPerSec float64 `json:"bytes_per_sec"`
+	Restarts    int     `json:"restarts"`
+}
+
+var errSlowAborted = errors.New("aborted, too slow")
+
+// fetchObject fetches an object, starting over when it's slow as many
+// times as allowed, and when it fails in a way that may not last, backing
+// off, as many times as there are attempts.
+func (a *Archiver) fetchObject(ctx context.Context, store Fetcher, key ObjectInfo, prefix string) ([]byte, ObjectMeta, *ActiveFetch, error) {
+	progress := a.progress
+	for restarts, attempt := 0, 1; ; {
+		fetch := progress.startFetch(key.Key, prefix, key.Size)
+		abort := restarts < a.slowRestarts
+		data, meta, slow, err := getObject(ctx, store, key, &fetchReader{fetch: fetch}, a.slowBelow, abort, a.checkETags)
+		retry := err != nil && err != errSlowAborted && attempt < a.attempts() && retryable(err) && ctx.Err() == nil
+		if retry {
+			progress.endFetch(fetch, &retrying{err: err})
+		} else {
+			progress.endFetch(fetch, err)
+		}
+		if slow {
+			rate := fetch.rate()
+			progress.recordSlow(SlowObject{Key: key.Key, Size: key.Size, BytesPerSec: rate, Restarts: restarts})
+			if err == errSlowAborted {
+				a.log.Infof("fetch of %q is slow (%s/s), starting over (%d/%d)", key.Key, humanize.Bytes(uint64(rate)), restarts+1, a.slowRestarts)
+				restarts++
+				continue
+			}
+			a.log.Infof("fetch of %q was slow (%s/s)", key.Key, humanize.Bytes(uint64(rate)))
+		}
+		if retry {
+			a.log.Infof("fetch of %q failed (%s), retrying (%d/%d)", key.Key, DescribeS3Error(err), attempt, a.attempts()-1)
+			if err := a.backoff(ctx, attempt); err != nil {
+				return nil, ObjectMeta{}, fetch, err
+			}
+			attempt++
+			continue
+		}
+		return data, meta, fetch, err
+	}
+}
+
+// getObject reads an object through r, whose reader it sets, along with
+// its metadata when the store keeps any. With slowBelow, the fetch is
+// watched and reported slow if its speed falls under it; with abortSlow, a
+// slow fetch is also aborted. With checkETags, what's read is checked
+// against the ETag of the object when it can be.
+func getObject(ctx context.Context, store Fetcher, key ObjectInfo, r *fetchReader, slowBelow float64, abortSlow, checkETags bool) (data []byte, meta ObjectMeta, slow bool, err error) {
+	rc, err := store.Open(ctx, key.Key)
+	if err != nil {
+		return nil, meta, false, err
+	}
+	defer rc.Close()
+	r.r = rc
+	if mr, ok := rc.(MetaReader); ok {
+		meta = mr.Meta()
+	}
+
+	var flagged, aborted int32
+	done := make(chan struct{})
+	if slowBelow > 0 {
+		go func() {
+			ticker := time.NewTicker(time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+				}
+				if time.Since(r.fetch.Started) < slowGrace || r.fetch.rate() >= slowBelow {
+					continue
+				}
+				atomic.StoreInt32(&flagged, 1)
+				if abortSlow {
+					atomic.StoreInt32(&aborted, 1)
+					_ = rc.Close() // unblocks the read
+				}
+				return
+			}
+		}()
+	}
+	data, err = ioutil.ReadAll(r)
+	close(done)
+	if atomic.LoadInt32(&aborted) == 1 {
+		return nil, meta, true, errSlowAborted
+	}
+	if err == nil && checkETags && etagCheckable(rc) {
+		err = checkETag(key, data)
+	}
+	return data, meta, atomic.LoadInt32(&flagged) == 1, err
+}
+
+// rate is the speed of the fetch so far, in bytes per second.
+func (f *ActiveFetch) rate() float64 {
+	took := time.Since(f.Started).Seconds()
+	if took <= 0 {
+		return 0
+	}
+	return float64(atomic.LoadInt64(&f.Read)) / took
+}
+
+// recordSlow remembers a slow object, keeping its slowest fetch.
+func (p *JobProgress) recordSlow(obj SlowObject) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.slow == nil {
+		p.slow = make(map[string]SlowObject)
+	}
+	if prev, ok := p.slow[obj.Key]; ok && prev.BytesPerSec < obj.BytesPerSec {
+		obj.BytesPerSec = prev.BytesPerSec
+	}
+	p.slow[obj.Key] = obj
+}
+
+// slowest returns the slowest objects, slowest first. It must be called
+// with p.mu held.
+func (p *JobProgress) slowest(n int) []SlowObject {
+	objs := make([]SlowObject, 0, len(p.slow))
+	for _, obj := range p.slow {
+		objs = append(objs, obj)
+	}
+	sort.Slice(objs, func(i, j int) bool { return objs[i].BytesPerSec < objs[j].BytesPerSec })
+	if len(objs) > n {
+		objs = objs[:n]
+	}
+	return objs
+}
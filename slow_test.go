@@ -0,0 +1,84 @@
+package taring_test
+
+import (
+	"context"
+	"github.com/aybabtme/taring"
+	"github.com/aybabtme/taring/taringtest"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stallingStore holds up the first bytes of an object for a while, or
+// until the read is abandoned.
+type stallingStore struct {
+	*taringtest.Store
+	key   string
+	stall time.Duration
+}
+
+func (s *stallingStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	rc, err := s.Store.Open(ctx, key)
+	if err != nil || key != s.key {
+		return rc, err
+	}
+	return &stallingReader{ReadCloser: rc, stall: time.After(s.stall), closed: make(chan struct{})}, nil
+}
+
+type stallingReader struct {
+	io.ReadCloser
+	stall   <-chan time.Time
+	stalled bool
+	once    sync.Once
+	closed  chan struct{}
+}
+
+func (r *stallingReader) Read(p []byte) (int, error) {
+	if !r.stalled {
+		select {
+		case <-r.stall:
+			r.stalled = true
+		case <-r.closed:
+			return 0, io.ErrClosedPipe
+		}
+	}
+	return r.ReadCloser.Read(p)
+}
+
+func (r *stallingReader) Close() error {
+	r.once.Do(func() { close(r.closed) })
+	return r.ReadCloser.Close()
+}
+
+func TestRunSlowFetches(t *testing.T) {
+	defer func(grace time.Duration) { *taring.SlowGrace = grace }(*taring.SlowGrace)
+	*taring.SlowGrace = 0
+
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, restarts := range []int{0, 1} {
+		store := &stallingStore{Store: newStore(), key: "data/readme.txt", stall: 1500 * time.Millisecond}
+		sum, err := taring.New("s3://bucket/data/", filepath.Join(dir, "archive.tar.gz"),
+			taring.WithStore(store),
+			taring.WithSlowFetches(1<<30, restarts),
+		).Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sum.Slow) != 1 || sum.Slow[0].Key != store.key || sum.Slow[0].Restarts != restarts {
+			t.Errorf("want %q reported slow after %d restarts, got %+v", store.key, restarts, sum.Slow)
+		}
+		// a slow fetch that's started over is fetched again, and still archived
+		if sum.SlowRestarts != restarts || store.Opens(store.key) != restarts+1 || sum.Objects != 5 {
+			t.Errorf("want %d restarts and every object archived, got %d restarts, %d opens and %d objects", restarts, sum.SlowRestarts, store.Opens(store.key), sum.Objects)
+		}
+	}
+}
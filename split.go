@@ -0,0 +1,90 @@
+package taring
+
+import (
+	"fmt"
+	"io"
+)
+
+// VolumePath is where the nth volume, counting from 1, of an archive split
+// in volumes goes: .001, .002, ... appended to the archive's path. Joined
+// back in order, the volumes are the archive.
+func VolumePath(archive string, n int) string {
+	return fmt.Sprintf("%s.%03d", archive, n)
+}
+
+// volumeWriter writes an archive as volumes of at most size bytes each.
+// Every volume is opened the way a whole archive would be, and they're only
+// all committed once the archive is done.
+type volumeWriter struct {
+	archive string
+	size    int64
+	open    func(name string) (io.Writer, func() error, func(), error)
+
+	w       io.Writer
+	left    int64
+	names   []string
+	commits []func() error
+	aborts  []func()
+}
+
+func (v *volumeWriter) next() error {
+	name := VolumePath(v.archive, len(v.names)+1)
+	w, commit, abort, err := v.open(name)
+	if err != nil {
+		return fmt.Errorf("starting volume %q, %v", name, err)
+	}
+	v.w, v.left = w, v.size
+	v.names = append(v.names, name)
+	v.commits = append(v.commits, commit)
+	v.aborts = append(v.aborts, abort)
+	return nil
+}
+
+func (v *volumeWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if v.w == nil || v.left == 0 {
+			if err := v.next(); err != nil {
+				return written, err
+			}
+		}
+		chunk := p
+		if int64(len(chunk)) > v.left {
+			chunk = chunk[:v.left]
+		}
+		n, err := v.w.Write(chunk)
+		written += n
+		v.left -= int64(n)
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// commit commits every volume in order. Should one fail, those after it
+// are aborted.
+func (v *volumeWriter) commit() error {
+	if v.w == nil {
+		// an empty archive is still one empty volume
+		if err := v.next(); err != nil {
+			return err
+		}
+	}
+	for i, commit := range v.commits {
+		if err := commit(); err != nil {
+			for _, abort := range v.aborts[i+1:] {
+				abort()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *volumeWriter) abort() {
+	for _, abort := range v.aborts {
+		abort()
+	}
+}
@@ -0,0 +1,323 @@
+package taring
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"github.com/crowdmob/goamz/aws"
+	"github.com/crowdmob/goamz/s3"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ObjectInfo describes an object of a store.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ETag         string
+	LastModified time.Time
+}
+
+// ListPage is part of what's directly under a prefix.
+type ListPage struct {
+	Objects []ObjectInfo
+	// Folders are the prefixes right under the listed one.
+	Folders []string
+	// Next is the marker to list the rest from, empty on the last page.
+	Next string
+}
+
+// Lister lists the objects of a store, one level at a time.
+type Lister interface {
+	// ListPage lists what's directly under prefix, after marker.
+	ListPage(ctx context.Context, prefix, marker string) (ListPage, error)
+}
+
+// Fetcher reads the objects of a store. Once the context of Open is done,
+// reading what it opened fails.
+type Fetcher interface {
+	// Open reads the content of an object. The reader must be closed.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Stat describes an object.
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+}
+
+// ObjectMeta is what a store keeps along the content of an object.
+type ObjectMeta struct {
+	ContentType     string
+	ContentEncoding string
+	// User is the user metadata of the object by name, such as what's in
+	// the x-amz-meta-* headers of S3.
+	User map[string]string
+	// VersionID is the version of the object that was read, for stores
+	// keeping several, such as a versioned bucket of S3. Uploads don't
+	// take it.
+	VersionID string
+}
+
+// headerObjectMeta is the metadata of an object in the headers of h, with
+// its user metadata named with prefix.
+func headerObjectMeta(h http.Header, prefix string) ObjectMeta {
+	return ObjectMeta{
+		ContentType:     h.Get("Content-Type"),
+		ContentEncoding: h.Get("Content-Encoding"),
+		User:            headerMeta(h, prefix),
+	}
+}
+
+// MetaReader is the content of an object along with its metadata, which
+// Open returns for stores keeping any.
+type MetaReader interface {
+	io.ReadCloser
+	Meta() ObjectMeta
+}
+
+type metaReadCloser struct {
+	io.ReadCloser
+	meta ObjectMeta
+	// encrypted is whether the object's ETag isn't its MD5 for how it's
+	// encrypted
+	encrypted bool
+}
+
+func (m *metaReadCloser) Meta() ObjectMeta { return m.meta }
+
+// headerMeta is the user metadata in the headers of h named with prefix,
+// such as `X-Amz-Meta-`, by their name after it in lower case.
+func headerMeta(h http.Header, prefix string) map[string]string {
+	var meta map[string]string
+	for name, values := range h {
+		if !strings.HasPrefix(name, prefix) || len(values) == 0 {
+			continue
+		}
+		if meta == nil {
+			meta = make(map[string]string)
+		}
+		meta[strings.ToLower(strings.TrimPrefix(name, prefix))] = values[0]
+	}
+	return meta
+}
+
+// Store is where objects are archived from.
+type Store interface {
+	Lister
+	Fetcher
+}
+
+// s3ListMax is how many keys are asked for per page.
+const s3ListMax = 10000
+
+// S3Store is a bucket of S3. Objects are listed and read with requests
+// signed by hand, which the context cancels; they're uploaded with goamz,
+// which can't cancel its requests, so once a context is done an upload
+// returns while its request is left to finish.
+type S3Store struct {
+	c *s3Client
+}
+
+// NewS3Store uses an S3 bucket as a store.
+func NewS3Store(auth aws.Auth, region aws.Region, bucket string) *S3Store {
+	return newS3Store(staticAuth(auth), region, bucket, http.DefaultClient)
+}
+
+func newS3Store(auth func(ctx context.Context) (aws.Auth, error), region aws.Region, bucket string, client *http.Client) *S3Store {
+	return &S3Store{c: &s3Client{auth: auth, region: region, bucket: bucket, client: client}}
+}
+
+// CustomS3Endpoint is region with S3 at endpoint instead, e.g. a MinIO or
+// Ceph RGW server. With pathStyle, buckets are addressed as
+// endpoint/bucket rather than bucket.endpoint, which most such servers
+// need unless their DNS is set up for it.
+func CustomS3Endpoint(region aws.Region, endpoint string, pathStyle bool) aws.Region {
+	region.S3Endpoint = strings.TrimSuffix(endpoint, "/")
+	region.S3BucketEndpoint = ""
+	if u, err := url.Parse(region.S3Endpoint); err == nil && u.Host != "" && !pathStyle {
+		region.S3BucketEndpoint = u.Scheme + "://${bucket}." + u.Host + u.Path
+	}
+	return region
+}
+
+// s3ListResult is a page of ListObjects.
+type s3ListResult struct {
+	IsTruncated bool
+	NextMarker  string
+	Contents    []struct {
+		Key          string
+		LastModified string
+		Size         int64
+		ETag         string
+	}
+	CommonPrefixes []string `xml:"CommonPrefixes>Prefix"`
+}
+
+// ListPage lists what's directly under prefix, after marker.
+func (s *S3Store) ListPage(ctx context.Context, prefix, marker string) (ListPage, error) {
+	q := url.Values{
+		"prefix":    {prefix},
+		"delimiter": {"/"},
+		"max-keys":  {strconv.Itoa(s3ListMax)},
+	}
+	if marker != "" {
+		q.Set("marker", marker)
+	}
+	resp, err := s.c.do(ctx, http.MethodGet, "", q, nil, nil)
+	if err != nil {
+		return ListPage{}, err
+	}
+	defer resp.Body.Close()
+	var list s3ListResult
+	if err := xml.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return ListPage{}, fmt.Errorf("decoding listing of %q, %v", prefix, err)
+	}
+	page := ListPage{
+		Objects: make([]ObjectInfo, len(list.Contents)),
+		Folders: list.CommonPrefixes,
+	}
+	for i, key := range list.Contents {
+		lastMod, err := time.Parse(time.RFC3339Nano, key.LastModified)
+		if err != nil {
+			return ListPage{}, fmt.Errorf("failed to parse time of %q: %v", key.LastModified, err)
+		}
+		page.Objects[i] = ObjectInfo{
+			Key:          key.Key,
+			Size:         key.Size,
+			ETag:         key.ETag,
+			LastModified: lastMod,
+		}
+	}
+	if list.IsTruncated {
+		page.Next = list.NextMarker
+		// without a next marker, the page ends at its last key or folder,
+		// whichever comes last
+		if page.Next == "" && len(list.Contents) > 0 {
+			page.Next = list.Contents[len(list.Contents)-1].Key
+		}
+		if n := len(list.CommonPrefixes); list.NextMarker == "" && n > 0 && list.CommonPrefixes[n-1] > page.Next {
+			page.Next = list.CommonPrefixes[n-1]
+		}
+	}
+	return page, nil
+}
+
+// Open reads the content of an object, along with its metadata.
+func (s *S3Store) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.c.do(ctx, http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	meta := headerObjectMeta(resp.Header, "X-Amz-Meta-")
+	meta.VersionID = resp.Header.Get("X-Amz-Version-Id")
+	return &metaReadCloser{
+		ReadCloser: closeOnDone(ctx, resp.Body),
+		meta:       meta,
+		encrypted:  encryptedHeaders(resp.Header),
+	}, nil
+}
+
+// Stat describes an object from its headers.
+func (s *S3Store) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	resp, err := s.c.do(ctx, http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer resp.Body.Close()
+	info := ObjectInfo{
+		Key:  key,
+		Size: resp.ContentLength,
+		ETag: resp.Header.Get("ETag"),
+	}
+	if lastMod := resp.Header.Get("Last-Modified"); lastMod != "" {
+		if info.LastModified, err = http.ParseTime(lastMod); err != nil {
+			return ObjectInfo{}, fmt.Errorf("failed to parse time of %q: %v", lastMod, err)
+		}
+	}
+	return info, nil
+}
+
+// encrypted tells from its headers if key is encrypted with KMS or a
+// customer key, which a listing doesn't say.
+func (s *S3Store) encrypted(ctx context.Context, key string) (bool, error) {
+	resp, err := s.c.do(ctx, http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	return encryptedHeaders(resp.Header), nil
+}
+
+// Upload writes the size bytes of r to key, along with its metadata. It's
+// `application/octet-stream` without a content type.
+func (s *S3Store) Upload(ctx context.Context, key string, r io.Reader, size int64, meta ObjectMeta) error {
+	auth, err := s.c.auth(ctx)
+	if err != nil {
+		return err
+	}
+	opts := s3.Options{Meta: make(map[string][]string, len(meta.User)), ContentEncoding: meta.ContentEncoding}
+	for k, v := range meta.User {
+		opts.Meta[k] = []string{v}
+	}
+	contentType := meta.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	bkt := s3.New(auth, s.c.region).Bucket(s.c.bucket)
+	return inContext(ctx, func() error {
+		return bkt.PutReader(key, r, size, contentType, s3.Private, opts)
+	})
+}
+
+// inContext runs fn, returning early with the context's error once it's
+// done. fn is then left to finish on its own.
+func inContext(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- fn() }()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ctxReadCloser closes what it reads once its context is done, which
+// aborts a read in flight.
+type ctxReadCloser struct {
+	io.ReadCloser
+	ctx  context.Context
+	done chan struct{}
+	once sync.Once
+}
+
+func closeOnDone(ctx context.Context, rc io.ReadCloser) io.ReadCloser {
+	r := &ctxReadCloser{ReadCloser: rc, ctx: ctx, done: make(chan struct{})}
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = rc.Close()
+		case <-r.done:
+		}
+	}()
+	return r
+}
+
+func (r *ctxReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != nil && r.ctx.Err() != nil {
+		// rather than whatever reading a closed body says
+		err = r.ctx.Err()
+	}
+	return n, err
+}
+
+func (r *ctxReadCloser) Close() error {
+	r.once.Do(func() { close(r.done) })
+	return r.ReadCloser.Close()
+}
@@ -0,0 +1,95 @@
+package taring_test
+
+import (
+	"context"
+	"github.com/aybabtme/taring"
+	"github.com/aybabtme/taring/taringtest"
+	"github.com/crowdmob/goamz/aws"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestS3StoreTransportMiddleware(t *testing.T) {
+	srv := httptest.NewServer(taringtest.S3Handler("bucket", newStore()))
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		mu    sync.Mutex
+		lists int
+		gets  = make(map[string]bool)
+	)
+	observe := func(next http.RoundTripper) http.RoundTripper {
+		return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			mu.Lock()
+			if req.URL.Query().Get("delimiter") != "" {
+				lists++
+			} else if req.Method == http.MethodGet {
+				gets[req.URL.Path] = true
+			}
+			mu.Unlock()
+			return next.RoundTrip(req)
+		})
+	}
+	archive := filepath.Join(dir, "archive.tar.gz")
+	_, err = taring.New("s3://bucket/data/", archive,
+		taring.WithAuth(aws.Auth{AccessKey: "access", SecretKey: "secret"}, taring.CustomS3Endpoint(aws.USEast, srv.URL, true)),
+		taring.WithTransportMiddleware(observe),
+	).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lists == 0 {
+		t.Error("want the listing to go through the middleware")
+	}
+	for key := range fixture {
+		if want := filepath.Dir(key) != "." && key != "elsewhere/ignored"; gets["/bucket/"+key] != want {
+			t.Errorf("%q: want a fetch through the middleware %v, got %v", key, want, gets["/bucket/"+key])
+		}
+	}
+
+	f, err := os.Open(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	m, err := taring.ReadManifest(taring.ManifestPath(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkArchive(t, f, m)
+}
+
+func TestCustomS3Endpoint(t *testing.T) {
+	for _, tt := range []struct {
+		endpoint  string
+		pathStyle bool
+		want      string
+		wantHost  string
+	}{
+		{"https://minio.local:9000/", true, "https://minio.local:9000", ""},
+		{"https://minio.local:9000", false, "https://minio.local:9000", "https://${bucket}.minio.local:9000"},
+		{"http://rgw.local/s3", false, "http://rgw.local/s3", "http://${bucket}.rgw.local/s3"},
+	} {
+		region := taring.CustomS3Endpoint(aws.USEast, tt.endpoint, tt.pathStyle)
+		if region.Name != aws.USEast.Name {
+			t.Errorf("%q: want the region kept, got %q", tt.endpoint, region.Name)
+		}
+		if region.S3Endpoint != tt.want || region.S3BucketEndpoint != tt.wantHost {
+			t.Errorf("%q, path style %v: want %q and %q, got %q and %q", tt.endpoint, tt.pathStyle, tt.want, tt.wantHost, region.S3Endpoint, region.S3BucketEndpoint)
+		}
+	}
+}
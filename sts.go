@@ -0,0 +1,170 @@
+package taring
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"github.com/crowdmob/goamz/aws"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// stsEndpoint is the global endpoint of STS, signed for us-east-1.
+	stsEndpoint = "https://sts.amazonaws.com"
+	// roleSessionDuration is how long assumed credentials last, at most
+	// what a role assumed from another role's credentials is allowed.
+	roleSessionDuration = time.Hour
+	// mfaSessionDuration is how long credentials got with an MFA code
+	// last, the default of STS.
+	mfaSessionDuration = 12 * time.Hour
+	// mfaSessionMargin is how long before its session expires a code is
+	// asked for anew, so no run starts with credentials about to expire.
+	mfaSessionMargin = 15 * time.Minute
+)
+
+// Role is an IAM role to assume.
+type Role struct {
+	ARN string
+	// ExternalID is what the role's trust policy may require, if anything.
+	ExternalID string
+	// SessionName tells the session apart in CloudTrail, `taring` when
+	// empty.
+	SessionName string
+}
+
+// AssumeRole gets temporary credentials of role from STS in region, or its
+// global endpoint when region has none, as base credentials. Give it
+// WithCredentials to assume the role for every run, and again every hour
+// or so as the credentials expire. Requests are made with client, or the
+// default client when it's nil.
+func AssumeRole(role Role, base func(ctx context.Context) (aws.Auth, error), region aws.Region, client *http.Client) func(ctx context.Context) (aws.Auth, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	sessionName := role.SessionName
+	if sessionName == "" {
+		sessionName = "taring"
+	}
+	return func(ctx context.Context) (aws.Auth, error) {
+		auth, err := base(ctx)
+		if err != nil {
+			return aws.Auth{}, err
+		}
+		what := fmt.Sprintf("assuming role %q", role.ARN)
+		q := url.Values{
+			"Action":          {"AssumeRole"},
+			"Version":         {"2011-06-15"},
+			"RoleArn":         {role.ARN},
+			"RoleSessionName": {sessionName},
+			"DurationSeconds": {strconv.Itoa(int(roleSessionDuration / time.Second))},
+		}
+		if role.ExternalID != "" {
+			q.Set("ExternalId", role.ExternalID)
+		}
+		return callSTS(ctx, client, region, auth, q, what)
+	}
+}
+
+// MFASession gets temporary credentials from STS in region, as base
+// credentials along with a code of the MFA device serial gives, so that
+// what requires MFA can be done with them. Codes can't be used twice: the
+// credentials are kept for every later call until they're about to expire
+// after 12 hours, and only then is token asked for another code. Requests
+// are made with client, or the default client when it's nil.
+func MFASession(serial string, token func() (string, error), base func(ctx context.Context) (aws.Auth, error), region aws.Region, client *http.Client) func(ctx context.Context) (aws.Auth, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	var (
+		mu      sync.Mutex
+		session *aws.Auth
+	)
+	return func(ctx context.Context) (aws.Auth, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if session != nil && time.Until(session.Expiration()) > mfaSessionMargin {
+			return *session, nil
+		}
+		auth, err := base(ctx)
+		if err != nil {
+			return aws.Auth{}, err
+		}
+		code, err := token()
+		if err != nil {
+			return aws.Auth{}, fmt.Errorf("getting a code of MFA device %q, %v", serial, err)
+		}
+		q := url.Values{
+			"Action":          {"GetSessionToken"},
+			"Version":         {"2011-06-15"},
+			"SerialNumber":    {serial},
+			"TokenCode":       {code},
+			"DurationSeconds": {strconv.Itoa(int(mfaSessionDuration / time.Second))},
+		}
+		auth, err = callSTS(ctx, client, region, auth, q, fmt.Sprintf("authenticating with MFA device %q", serial))
+		if err != nil {
+			return aws.Auth{}, err
+		}
+		session = &auth
+		return auth, nil
+	}
+}
+
+// callSTS makes the request of q to STS in region, or its global endpoint
+// when region has none, and gets the credentials it answers with.
+func callSTS(ctx context.Context, client *http.Client, region aws.Region, auth aws.Auth, q url.Values, what string) (aws.Auth, error) {
+	endpoint := region.STSEndpoint
+	if endpoint == "" {
+		endpoint, region = stsEndpoint, aws.USEast
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"/?"+q.Encode(), nil)
+	if err != nil {
+		return aws.Auth{}, err
+	}
+	aws.NewV4Signer(auth, "sts", region).Sign(req)
+	resp, err := client.Do(req)
+	if err != nil {
+		return aws.Auth{}, fmt.Errorf("%s, %v", what, err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err != nil {
+		return aws.Auth{}, fmt.Errorf("%s, %v", what, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var stsErr struct {
+			Code    string `xml:"Error>Code"`
+			Message string `xml:"Error>Message"`
+		}
+		if xml.Unmarshal(data, &stsErr) != nil || stsErr.Code == "" {
+			return aws.Auth{}, fmt.Errorf("%s, STS answered %s", what, resp.Status)
+		}
+		return aws.Auth{}, fmt.Errorf("%s, %s: %s", what, stsErr.Code, stsErr.Message)
+	}
+	type credentials struct {
+		AccessKeyID     string    `xml:"AccessKeyId"`
+		SecretAccessKey string    `xml:"SecretAccessKey"`
+		SessionToken    string    `xml:"SessionToken"`
+		Expiration      time.Time `xml:"Expiration"`
+	}
+	var result struct {
+		Role    credentials `xml:"AssumeRoleResult>Credentials"`
+		Session credentials `xml:"GetSessionTokenResult>Credentials"`
+	}
+	if err := xml.Unmarshal(data, &result); err != nil {
+		return aws.Auth{}, fmt.Errorf("%s, decoding credentials, %v", what, err)
+	}
+	creds := result.Role
+	if creds.AccessKeyID == "" {
+		creds = result.Session
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return aws.Auth{}, fmt.Errorf("%s, STS handed out no credentials", what)
+	}
+	return *aws.NewAuth(creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken, creds.Expiration), nil
+}
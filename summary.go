@@ -0,0 +1,207 @@
+package taring
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Run outcomes.
+const (
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusUnchanged = "unchanged"
+	// StatusDryRun is a run that only listed what it would archive.
+	StatusDryRun = "dry-run"
+)
+
+// summarySuffix is appended to an archive's path to name its summary.
+const summarySuffix = ".summary.json"
+
+// RunSummary describes how a run went.
+type RunSummary struct {
+	Job          string    `json:"job,omitempty"`
+	Source       string    `json:"source"`
+	Archive      string    `json:"archive,omitempty"`
+	Status       string    `json:"status"`
+	Error        string    `json:"error,omitempty"`
+	Started      time.Time `json:"started"`
+	Ended        time.Time `json:"ended"`
+	Duration     string    `json:"duration"`
+	Objects      int       `json:"objects"`
+	Bytes        int64     `json:"bytes"`
+	ArchiveBytes int64     `json:"archive_bytes"`
+	// Volumes are the volumes the archive was split in, if it was.
+	Volumes []string `json:"volumes,omitempty"`
+
+	// CompressionRatio is Bytes over ArchiveBytes.
+	CompressionRatio float64 `json:"compression_ratio,omitempty"`
+	// Errors is how many objects couldn't be fetched.
+	Errors int `json:"errors"`
+	// Retries is how many fetches were tried again after failing, and
+	// SlowRestarts how many were started over for being slow.
+	Retries      int           `json:"retries"`
+	SlowRestarts int           `json:"slow_restarts"`
+	Stages       []StageTiming `json:"stages,omitempty"`
+	Throughput   *Throughput   `json:"throughput,omitempty"`
+	// Prefixes breaks the run down by top-level prefix under the source,
+	// largest first.
+	Prefixes []PrefixStats `json:"prefixes,omitempty"`
+	// Largest are the largest objects to archive, largest first.
+	Largest []KeySize `json:"largest,omitempty"`
+	// Slow are the objects fetched slower than asked for, slowest first.
+	Slow []SlowObject `json:"slow,omitempty"`
+	// Failures groups the objects that couldn't be fetched by why.
+	Failures []FailureClass `json:"failures,omitempty"`
+}
+
+// KeySize is an object and its size.
+type KeySize struct {
+	Key  string `json:"key"`
+	Size int64  `json:"size"`
+}
+
+// largestKeys returns the n largest of the keys, largest first.
+func largestKeys(keys []ObjectInfo, n int) []KeySize {
+	sorted := make([]KeySize, len(keys))
+	for i, key := range keys {
+		sorted[i] = KeySize{Key: key.Key, Size: key.Size}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Size > sorted[j].Size })
+	if len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
+
+// PrefixStats is what was fetched under a top-level prefix, e.g. `logs/`.
+// Objects right under the source have an empty prefix. Time is the sum of
+// the time spent fetching each object, which overlap.
+type PrefixStats struct {
+	Prefix   string  `json:"prefix"`
+	Objects  int     `json:"objects"`
+	Bytes    int64   `json:"bytes"`
+	Errors   int     `json:"errors"`
+	Duration string  `json:"duration"`
+	Seconds  float64 `json:"seconds"`
+}
+
+// topPrefix is the first path segment of a key under base, with its
+// trailing slash, or "" if the key is right under base.
+func topPrefix(base, key string) string {
+	rel := strings.TrimPrefix(strings.TrimPrefix(key, base), "/")
+	if i := strings.Index(rel, "/"); i >= 0 {
+		return rel[:i+1]
+	}
+	return ""
+}
+
+// listedPrefixes breaks the keys under base down by top-level prefix,
+// largest first, as if they had all been fetched.
+func listedPrefixes(base string, keys []ObjectInfo) []PrefixStats {
+	byPrefix := make(map[string]*PrefixStats)
+	var prefixes []PrefixStats
+	for _, key := range keys {
+		prefix := topPrefix(base, key.Key)
+		stats, ok := byPrefix[prefix]
+		if !ok {
+			stats = &PrefixStats{Prefix: prefix}
+			byPrefix[prefix] = stats
+		}
+		stats.Objects++
+		stats.Bytes += key.Size
+	}
+	for _, stats := range byPrefix {
+		prefixes = append(prefixes, *stats)
+	}
+	sort.Slice(prefixes, func(i, j int) bool { return prefixes[i].Bytes > prefixes[j].Bytes })
+	return prefixes
+}
+
+// StageTiming is how long a run spent in one of its phases.
+type StageTiming struct {
+	Stage    string  `json:"stage"`
+	Duration string  `json:"duration"`
+	Seconds  float64 `json:"seconds"`
+}
+
+// Throughput is the distribution of the speeds, in bytes per second, at
+// which objects were fetched.
+type Throughput struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+}
+
+func newThroughput(rates []float64) *Throughput {
+	if len(rates) == 0 {
+		return nil
+	}
+	sorted := append([]float64(nil), rates...)
+	sort.Float64s(sorted)
+	at := func(p float64) float64 {
+		return sorted[int(math.Ceil(p*float64(len(sorted))))-1]
+	}
+	return &Throughput{P50: at(0.50), P90: at(0.90), P99: at(0.99)}
+}
+
+// Finish records how the run ended. It can be called again if the run goes
+// on, e.g. with hooks of its own.
+func (s *RunSummary) Finish(err error) {
+	s.Ended = time.Now()
+	s.Duration = s.Ended.Sub(s.Started).String()
+	switch {
+	case err != nil:
+		s.Status = StatusFailed
+		s.Error = err.Error()
+	case s.Status == "":
+		s.Status = StatusSucceeded
+	}
+	if s.ArchiveBytes > 0 {
+		s.CompressionRatio = float64(s.Bytes) / float64(s.ArchiveBytes)
+	}
+}
+
+// SummaryPath is where the summary of an archive's run is saved.
+func SummaryPath(archive string) string { return archive + summarySuffix }
+
+// WriteSummary saves a summary to path.
+func WriteSummary(path string, sum *RunSummary) error {
+	data, err := json.MarshalIndent(sum, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), filePerms)
+}
+
+// SaveSummary saves the summary of a run at the SummaryPath of its archive,
+// whether the run succeeded or not: in a local file, or uploaded to S3 next
+// to the archive's manifests. Nothing is saved for a run without an
+// archive, such as one written to a stream.
+func (a *Archiver) SaveSummary(ctx context.Context, sum *RunSummary) error {
+	if sum.Archive == "" {
+		return nil
+	}
+	location := SummaryPath(sum.Archive)
+	if !strings.HasPrefix(location, "s3://") {
+		return WriteSummary(location, sum)
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(sum, "", "  ")
+	if err != nil {
+		return err
+	}
+	r, err := a.newRun(ctx, &RunSummary{Job: a.job, Source: a.source, Started: time.Now()}, "")
+	if err != nil {
+		return err
+	}
+	return r.upload(ctx, u, append(data, '\n'), "application/json")
+}
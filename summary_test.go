@@ -0,0 +1,180 @@
+package taring_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/aybabtme/taring"
+	"github.com/aybabtme/taring/taringtest"
+	"github.com/crowdmob/goamz/aws"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// runSummary archives the fixture with opts, returning how the run went
+// and the archive's path.
+func runSummary(t *testing.T, dir string, opts ...taring.Option) (*taring.RunSummary, string) {
+	archive := filepath.Join(dir, "archive.tar.gz")
+	sum, err := taring.New("s3://bucket/data/", archive, append([]taring.Option{taring.WithStore(newStore())}, opts...)...).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sum, archive
+}
+
+func TestRunSummary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sum, archive := runSummary(t, dir)
+	info, err := os.Stat(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	switch {
+	case sum.Status != taring.StatusSucceeded || sum.Error != "":
+		t.Errorf("want a run that succeeded, got %q, %q", sum.Status, sum.Error)
+	case sum.Source != "s3://bucket/data/" || sum.Archive != archive:
+		t.Errorf("want the run's source and archive, got %q and %q", sum.Source, sum.Archive)
+	case sum.Objects != 5 || sum.Bytes != 45 || sum.ArchiveBytes != info.Size():
+		t.Errorf("want 5 objects of 45 bytes in %d archive bytes, got %d of %d in %d", info.Size(), sum.Objects, sum.Bytes, sum.ArchiveBytes)
+	case sum.Ended.Before(sum.Started) || len(sum.Stages) == 0:
+		t.Errorf("want the run timed by stage, got %+v", sum)
+	}
+
+	path := taring.SummaryPath(archive)
+	if err := taring.WriteSummary(path, sum); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved taring.RunSummary
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Status != sum.Status || saved.Objects != sum.Objects || !saved.Started.Equal(sum.Started) {
+		t.Errorf("want the summary saved as is, got %+v", saved)
+	}
+}
+
+func TestSaveSummary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a failed run is summarized next to the archive it didn't write
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	a := taring.New("s3://bucket/data/", filepath.Join(dir, "archive.tar.gz"), taring.WithStore(newStore()))
+	sum, err := a.Run(ctx)
+	if err == nil {
+		t.Fatal("want the cancelled run to fail")
+	}
+	if err := a.SaveSummary(context.Background(), sum); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(taring.SummaryPath(sum.Archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved taring.RunSummary
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Status != taring.StatusFailed || saved.Error == "" {
+		t.Errorf("want the failure saved, got %q, %q", saved.Status, saved.Error)
+	}
+
+	// one in S3 is uploaded next to it
+	store := newStore()
+	srv := httptest.NewServer(taringtest.S3Handler("bucket", store))
+	defer srv.Close()
+	a = taring.New("s3://bucket/data/", "s3://bucket/backups/archive.tar.gz",
+		taring.WithAuth(aws.Auth{AccessKey: "access", SecretKey: "secret"}, taring.CustomS3Endpoint(aws.USEast, srv.URL, true)),
+	)
+	sum, err = a.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.SaveSummary(context.Background(), sum); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Stat(context.Background(), taring.SummaryPath("backups/archive.tar.gz")); err != nil {
+		t.Errorf("want the summary uploaded, got %v", err)
+	}
+}
+
+func TestRunSummaryPrefixes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sum, _ := runSummary(t, dir)
+	var got []taring.PrefixStats
+	for _, stats := range sum.Prefixes {
+		got = append(got, taring.PrefixStats{Prefix: stats.Prefix, Objects: stats.Objects, Bytes: stats.Bytes, Errors: stats.Errors})
+	}
+	// largest first, what's right under the source without a prefix
+	want := []taring.PrefixStats{
+		{Prefix: "logs/", Objects: 3, Bytes: 40},
+		{Prefix: "", Objects: 2, Bytes: 5},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want prefixes %+v, got %+v", want, got)
+	}
+}
+
+func TestRunEntryRatios(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sum, archive := runSummary(t, dir, taring.WithEntryRatios())
+	m, err := taring.ReadManifest(taring.ManifestPath(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every byte of the archive is counted with one entry or another
+	var total int64
+	for _, obj := range m.Objects {
+		if obj.CompressedSize == 0 {
+			t.Errorf("want the compressed size of %q", obj.Key)
+		}
+		total += obj.CompressedSize
+	}
+	if total != sum.ArchiveBytes {
+		t.Errorf("want the entries to add up to the %d bytes of the archive, got %d", sum.ArchiveBytes, total)
+	}
+}
+
+func TestRunTopLargest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sum, _ := runSummary(t, dir, taring.WithTopLargest(3))
+	if len(sum.Largest) != 3 {
+		t.Fatalf("want the 3 largest objects, got %+v", sum.Largest)
+	}
+	for i, want := range []int64{16, 16, 8} {
+		if got := sum.Largest[i]; got.Size != want || int64(len(fixture[got.Key])) != want {
+			t.Errorf("want an object of %d bytes at %d, got %+v", want, i, got)
+		}
+	}
+}
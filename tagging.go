@@ -0,0 +1,153 @@
+package taring
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Tag is a single S3 object tag.
+type Tag struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
+
+// ParseTag reads a tag of the form `key=value`.
+func ParseTag(s string) (Tag, error) {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return Tag{}, fmt.Errorf("tag %q must be of the form `key=value`", s)
+	}
+	return Tag{Key: s[:i], Value: s[i+1:]}, nil
+}
+
+func (t Tag) String() string { return t.Key + "=" + t.Value }
+
+type tagging struct {
+	XMLName xml.Name `xml:"Tagging"`
+	TagSet  []Tag    `xml:"TagSet>Tag"`
+}
+
+// objectTagger reads and writes the tag set of objects in a bucket. goamz
+// doesn't know about object tagging, so the requests are signed by hand.
+type objectTagger struct {
+	c *s3Client
+}
+
+func newObjectTagger(c *s3Client) *objectTagger {
+	return &objectTagger{c: c}
+}
+
+func (o *objectTagger) do(ctx context.Context, method, key string, body []byte) ([]byte, error) {
+	var header http.Header
+	if body != nil {
+		md5Sum := md5.Sum(body)
+		header = http.Header{
+			"Content-Md5":  {base64.StdEncoding.EncodeToString(md5Sum[:])},
+			"Content-Type": {"application/xml"},
+		}
+	}
+	resp, err := o.c.do(ctx, method, key, url.Values{"tagging": {""}}, header, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+// Tags returns the tag set of an object.
+func (o *objectTagger) Tags(ctx context.Context, key string) ([]Tag, error) {
+	data, err := o.do(ctx, "GET", key, nil)
+	if err != nil {
+		return nil, err
+	}
+	var t tagging
+	if err := xml.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("decoding tags of %q, %v", key, err)
+	}
+	return t.TagSet, nil
+}
+
+// HasTag tells if the object already carries the exact tag.
+func (o *objectTagger) HasTag(ctx context.Context, key string, tag Tag) (bool, error) {
+	tags, err := o.Tags(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	for _, t := range tags {
+		if t == tag {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// AddTag sets the tag on the object, keeping the other tags it already had.
+func (o *objectTagger) AddTag(ctx context.Context, key string, tag Tag) error {
+	tags, err := o.Tags(ctx, key)
+	if err != nil {
+		return err
+	}
+	merged := []Tag{tag}
+	for _, t := range tags {
+		if t.Key != tag.Key {
+			merged = append(merged, t)
+		}
+	}
+	body, err := xml.Marshal(tagging{TagSet: merged})
+	if err != nil {
+		return err
+	}
+	_, err = o.do(ctx, "PUT", key, body)
+	return err
+}
+
+// tagAll applies the tag to every object, a window of them at a time,
+// retrying the way fetches are. It fails with the KeyErrors of the objects
+// that couldn't be tagged.
+func (a *Archiver) tagAll(ctx context.Context, o *objectTagger, tag Tag, objects []S3Content) error {
+	todo := make(chan string)
+	go func() {
+		defer close(todo)
+		for _, object := range objects {
+			todo <- object.Key
+		}
+	}()
+
+	var (
+		mu   sync.Mutex
+		errs KeyErrors
+		wg   sync.WaitGroup
+	)
+	for w := 0; w < a.window(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range todo {
+				err := a.retry(ctx, fmt.Sprintf("tagging %q", key), func() error { return o.AddTag(ctx, key, tag) })
+				if err != nil {
+					mu.Lock()
+					errs = append(errs, &KeyError{Key: key, Op: OpTag, Err: err})
+					mu.Unlock()
+					continue
+				}
+				a.log.Verbosef("\ttagged %q with %q", key, tag)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(errs) != 0 {
+		sort.Slice(errs, func(i, j int) bool { return errs[i].Key < errs[j].Key })
+		return errs
+	}
+	return nil
+}
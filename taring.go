@@ -1,253 +1,561 @@
-package main
+// Package taring archives what's under an S3 path into a tar/gzip file.
+package taring
 
 import (
 	"archive/tar"
 	"bytes"
-	"compress/gzip"
-	"flag"
+	"context"
+	"crypto/md5"
+	"errors"
 	"fmt"
-	"github.com/aybabtme/color/brush"
-	"github.com/crowdmob/goamz/aws"
-	"github.com/crowdmob/goamz/s3"
 	"github.com/dustin/go-humanize"
 	"io"
-	"io/ioutil"
-	"log"
 	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
-	"sync"
 	"time"
 )
 
-var (
-	filePerms = os.FileMode(os.ModePerm & 0644)
-	elog      = log.New(os.Stderr, "", log.Flags())
-)
-
-func fatalFlag(format string, args ...interface{}) {
-	elog.Printf(brush.Red("[flags] ").String()+brush.LightGray(format).String(), args...)
-	flag.PrintDefaults()
-	os.Exit(2)
-}
-
-func errorf(format string, args ...interface{}) {
-	elog.Printf(brush.Yellow("[error] ").String()+brush.LightGray(format).String(), args...)
-}
-
-func fatalf(format string, args ...interface{}) {
-	elog.Printf(brush.Red("[fatal] ").String()+brush.LightGray(format).String(), args...)
-	os.Exit(2)
-}
+var filePerms = os.FileMode(os.ModePerm & 0644)
 
-func infof(format string, args ...interface{}) {
-	colorFmt := brush.Blue("[info] ").String() + brush.LightGray(format).String()
-	log.Printf(colorFmt, args...)
+// Logger is told what an Archiver is doing, by order of importance. It must
+// be safe to use from several goroutines.
+type Logger interface {
+	Errorf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Verbosef(format string, args ...interface{})
+	Debugf(format string, args ...interface{})
 }
 
-func must(u *url.URL, err error) *url.URL {
-	if err != nil {
-		fatalf("not a URL, %v", err)
+type nopLogger struct{}
+
+func (nopLogger) Errorf(string, ...interface{})   {}
+func (nopLogger) Infof(string, ...interface{})    {}
+func (nopLogger) Verbosef(string, ...interface{}) {}
+func (nopLogger) Debugf(string, ...interface{})   {}
+
+// list returns the keys under a path, page after page, descending into its
+// folders.
+func (a *Archiver) list(ctx context.Context, store Lister, bktPath string) ([]ObjectInfo, error) {
+	var (
+		keys    []ObjectInfo
+		folders []string
+		marker  string
+	)
+	for {
+		var list ListPage
+		err := a.retry(ctx, fmt.Sprintf("listing %q", bktPath), func() (err error) {
+			list, err = store.ListPage(ctx, bktPath, marker)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("couldn't list bucket at path %q: %s", bktPath, DescribeS3Error(err))
+		}
+		a.progress.update(func(s *ProgressSnapshot) {
+			s.KeysListed += len(list.Objects)
+			s.PagesListed++
+		})
+		a.log.Debugf("listed %d keys and %d folders under %q after %q", len(list.Objects), len(list.Folders), bktPath, marker)
+		for _, key := range list.Objects {
+			a.log.Verbosef("\t(%s) key %q", humanize.Bytes(uint64(key.Size)), key.Key)
+		}
+		keys = append(keys, list.Objects...)
+		folders = append(folders, list.Folders...)
+		if list.Next == "" {
+			break
+		}
+		marker = list.Next
 	}
-	return u
-}
-
-func main() {
-	awsSecret := flag.String("aws-secret", "", "an AWS secret key")
-	awsAccess := flag.String("aws-access", "", "an AWS access key")
-	awsRegion := flag.String("aws-region", aws.USEast.Name, "an AWS region string")
-	bucketSrc := flag.String("s3-path", "", "a URL of the form `s3://bucketname/path/to/files`")
-	tarDst := flag.String("tar-path", "bucket.tar.gz", "a path to save the TAR of what's at `s3-path`")
-	flag.Parse()
-	region, regionOk := aws.Regions[*awsRegion]
 
-	switch {
-	case *awsAccess == "":
-		fatalFlag("need an AWS access key.\n")
-	case *awsSecret == "":
-		fatalFlag("need an AWS secret key.\n")
-	case !regionOk:
-		fatalFlag("need a valid AWS region, %q is not a valid one.\n", *awsRegion)
-	case *bucketSrc == "":
-		fatalFlag("need bucket path to read from.\n")
-	case *tarDst == "":
-		fatalFlag("need filepath to write TAR archive to.\n")
+	for _, folder := range folders {
+		newKeys, err := a.list(ctx, store, folder)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, newKeys...)
 	}
 
-	auth := aws.Auth{
-		AccessKey: *awsAccess,
-		SecretKey: *awsSecret,
-	}
+	return keys, nil
+}
 
-	bktURL := must(url.Parse(*bucketSrc))
-	bktRoot := must(bktURL.Parse("/"))
+// defaultWindow is how many objects are fetched at a time when the
+// concurrency isn't set.
+const defaultWindow = 16
 
-	bktName := bktRoot.Host
-	bktPath := bktURL.Path[1:]
+// window is how many objects can be fetched, or held while they wait to be
+// written, at a time.
+func (a *Archiver) window() int {
+	if a.concurrency > 0 {
+		return a.concurrency
+	}
+	return defaultWindow
+}
 
-	bkt := s3.New(auth, region).Bucket(bktName)
+// streamAbove is the size above which objects aren't read ahead but
+// streamed into the archive when their turn comes, so that only small
+// objects are ever held in memory.
+const streamAbove = 8 << 20
+
+// fetched is how the fetch of an object went.
+type fetched struct {
+	content S3Content
+	// stream is an object yet to be fetched, as it's written
+	stream  bool
+	skipped bool
+	err     *KeyError
+}
 
-	infof("Listing bucket %q.", bktName)
+// fetchAll fetches the keys a window at a time and hands each object to
+// write in the order of the keys, letting go of its data once it's written;
+// so only the window of objects is ever in memory. Objects above
+// streamAbove are only fetched as they're written, read from the store as
+// write reads them. It returns what was written, without the data. Once an
+// object fails, the others are still fetched, to tell all that fail, but no
+// longer written.
+func (a *Archiver) fetchAll(ctx context.Context, store Fetcher, base string, keys []ObjectInfo, skip func(string) (bool, error), write func(content *S3Content) error) ([]S3Content, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	doFetch := func(k ObjectInfo) fetched {
+		if err := ctx.Err(); err != nil {
+			return fetched{err: &KeyError{Key: k.Key, Op: OpFetch, Err: err}}
+		}
 
-	contents, err := fetchPath(bkt, "", bktPath, bktPath)
-	if err != nil {
-		fatalf("couldn't fetch %q: %v.", bktPath, err)
-	}
+		if skip != nil {
+			skipped, err := skip(k.Key)
+			if err != nil {
+				return fetched{err: &KeyError{Key: k.Key, Op: OpCheckTag, Err: err}}
+			}
+			if skipped {
+				a.log.Verbosef("\tskipping %q", k.Key)
+				a.progress.update(func(s *ProgressSnapshot) {
+					s.KeysTotal--
+					s.BytesTotal -= k.Size
+				})
+				return fetched{skipped: true}
+			}
+		}
 
-	tarArch := bytes.NewBuffer(nil)
-	infof("writing %d objects into tar buffer", len(contents))
-	if err := tarify(tarArch, contents); err != nil {
-		fatalf("tarifying content, %v.", err)
-	}
+		relPath, err := filepath.Rel(base, k.Key)
+		if err != nil {
+			return fetched{err: &KeyError{Key: k.Key, Op: OpName, Err: err}}
+		}
+		if k.Size > streamAbove {
+			return fetched{stream: true, content: S3Content{
+				Key:     k.Key,
+				ETag:    k.ETag,
+				Name:    relPath,
+				Size:    k.Size,
+				LastMod: k.LastModified,
+			}}
+		}
 
-	infof("gzipping...")
-	gzipArch := bytes.NewBuffer(nil)
-	gw := gzip.NewWriter(gzipArch)
-	if _, err := io.Copy(gw, tarArch); err != nil {
-		fatalf("writing tared objects to gzip buffer, %v", err)
-	}
-	if err := gw.Close(); err != nil {
-		fatalf("closing gzip buffer, %v", err)
+		data, meta, fetch, err := a.fetchObject(ctx, store, k, topPrefix(base, k.Key))
+		if err != nil {
+			return fetched{err: &KeyError{Key: k.Key, Op: OpFetch, Err: err}}
+		}
+		a.log.Verbosef("\t(%v) %q from %q", time.Since(fetch.Started), relPath, k.Key)
+
+		a.progress.update(func(s *ProgressSnapshot) {
+			s.KeysFetched++
+			s.BytesFetched += int64(len(data))
+		})
+		return fetched{content: S3Content{
+			Key:     k.Key,
+			ETag:    k.ETag,
+			Name:    relPath,
+			Size:    int64(len(data)),
+			Data:    *bytes.NewBuffer(data),
+			LastMod: k.LastModified,
+			Meta:    meta,
+		}}
 	}
 
-	if err := ioutil.WriteFile(*tarDst, gzipArch.Bytes(), filePerms); err != nil {
-		fatalf("writing tar/gzip buffer to %q, %v", *tarDst, err)
+	// a pool of workers fetches the keys; a slot of the window is taken as
+	// a key is handed to them, and given back once its object is written
+	window := make(chan struct{}, a.window())
+	results := make([]chan fetched, len(keys))
+	for i := range results {
+		results[i] = make(chan fetched, 1)
 	}
-	infof("saved tar/gzip of %q to %q", bktURL.String(), *tarDst)
-}
-
-func fetchPath(bkt *s3.Bucket, prfx string, root, bktPath string) ([]S3Content, error) {
-	infof("%spath %q", prfx, bktPath)
-	list, err := bkt.List(bktPath, "/", "", 10000)
-	if err != nil {
-		return nil, fmt.Errorf("couldn't list bucket at path %q: %v", bktPath, err)
+	todo := make(chan int)
+	go func() {
+		defer close(todo)
+		for i := range keys {
+			window <- struct{}{}
+			todo <- i
+		}
+	}()
+	for w := 0; w < a.window(); w++ {
+		go func() {
+			for i := range todo {
+				results[i] <- doFetch(keys[i])
+			}
+		}()
 	}
 
-	infof("%s%d keys", prfx, len(list.Contents))
-	var sumKey uint64
-	for _, key := range list.Contents {
-		infof("%s\t(%s) key %q", prfx, humanize.Bytes(uint64(key.Size)), key.Key)
-		sumKey += uint64(key.Size)
+	var (
+		contents = make([]S3Content, 0, len(keys))
+		errs     KeyErrors
+		writeErr error
+	)
+	for _, result := range results {
+		f := <-result
+		switch {
+		case f.err != nil:
+			errs = append(errs, f.err)
+		case f.skipped, len(errs) != 0, writeErr != nil:
+		case f.stream:
+			var kerr *KeyError
+			if kerr, writeErr = a.streamObject(ctx, store, &f.content, topPrefix(base, f.content.Key), write); kerr != nil {
+				errs = append(errs, kerr)
+			} else if writeErr != nil {
+				cancel()
+			} else {
+				contents = append(contents, f.content)
+			}
+		default:
+			writeErr = write(&f.content)
+			if writeErr != nil {
+				// what's left can't be written anymore
+				cancel()
+			} else {
+				f.content.Data = bytes.Buffer{}
+				contents = append(contents, f.content)
+			}
+		}
+		<-window
 	}
-	infof("%s\ttotal %s", prfx, humanize.Bytes(sumKey))
 
-	contents, err := fetchAll(bkt, prfx, root, list.Contents)
-	if err != nil {
-		return nil, fmt.Errorf("fetching content of keys at %q, %v", bktPath, err)
+	if writeErr != nil {
+		return nil, writeErr
 	}
-
-	infof("%s%d folders", prfx, len(list.CommonPrefixes))
-	for _, folder := range list.CommonPrefixes {
-		infof("\t%q", folder)
+	if len(errs) != 0 {
+		sort.Slice(errs, func(i, j int) bool { return errs[i].Key < errs[j].Key })
+		return nil, errs
 	}
+	return contents, nil
+}
 
-	for _, folder := range list.CommonPrefixes {
-		newContent, err := fetchPath(bkt, prfx+"\t", root, folder)
+// streamObject fetches a large object as write writes it. Opening it is
+// retried like any fetch, but once its content is read into the archive
+// there's no going back: failing to read it fails the archive, which is
+// why it's never started over for being slow. The content is still
+// checked against its ETag once written, and failing that is a KeyError.
+func (a *Archiver) streamObject(ctx context.Context, store Fetcher, content *S3Content, prefix string, write func(content *S3Content) error) (*KeyError, error) {
+	key := ObjectInfo{Key: content.Key, ETag: content.ETag, Size: content.Size}
+	for attempt := 1; ; attempt++ {
+		fetch := a.progress.startFetch(key.Key, prefix, key.Size)
+		rc, err := store.Open(ctx, key.Key)
 		if err != nil {
+			if attempt < a.attempts() && retryable(err) && ctx.Err() == nil {
+				a.progress.endFetch(fetch, &retrying{err: err})
+				a.log.Infof("fetch of %q failed (%s), retrying (%d/%d)", key.Key, DescribeS3Error(err), attempt, a.attempts()-1)
+				if err := a.backoff(ctx, attempt); err != nil {
+					return &KeyError{Key: key.Key, Op: OpFetch, Err: err}, nil
+				}
+				continue
+			}
+			a.progress.endFetch(fetch, err)
+			return &KeyError{Key: key.Key, Op: OpFetch, Err: err}, nil
+		}
+		if mr, ok := rc.(MetaReader); ok {
+			content.Meta = mr.Meta()
+		}
+		sum := md5.New()
+		content.r = io.TeeReader(&fetchReader{r: rc, fetch: fetch}, sum)
+		err = write(content)
+		content.r = nil
+		_ = rc.Close()
+		if err != nil {
+			a.progress.endFetch(fetch, err)
 			return nil, err
 		}
-		contents = append(contents, newContent...)
+		if a.checkETags && etagCheckable(rc) {
+			if err := checkMD5(key, sum.Sum(nil)); err != nil {
+				a.progress.endFetch(fetch, err)
+				return &KeyError{Key: key.Key, Op: OpFetch, Err: err}, nil
+			}
+		}
+		a.progress.endFetch(fetch, nil)
+		a.log.Verbosef("\t(%v) %q from %q, streamed", time.Since(fetch.Started), content.Name, key.Key)
+		a.progress.update(func(s *ProgressSnapshot) {
+			s.KeysFetched++
+			s.BytesFetched += key.Size
+		})
+		return nil, nil
 	}
-
-	return contents, nil
 }
 
-func fetchAll(bkt *s3.Bucket, prfx, base string, keys []s3.Key) ([]S3Content, error) {
-	contentC := make(chan S3Content, len(keys))
-
-	doFetch := func(w *sync.WaitGroup, k s3.Key, errc chan<- error) {
-		defer w.Done()
-
-		lastMod, err := time.Parse(time.RFC3339Nano, k.LastModified)
-		if err != nil {
+// archiveStream writes objects through a container and a compressor as
+// they come, keeping the offset at which each object's entry starts in the
+// container and, with entry ratios, the compressed size of each entry.
+//
+// The compressor is flushed at the offset of every entry, so the compressed
+// size of each can be told apart. An entry's size covers its header and
+// padding, the last one's the end of the archive. With seekable entries,
+// every entry is compressed on its own instead, and where it starts in
+// the compressed stream is kept too.
+type archiveStream struct {
+	compressed *countWriter
+	c          Compressor
+	cw         io.WriteCloser
+	flush      flusher
+	plain      *countWriter
+	aw         ArchiveWriter
+	progress   *JobProgress
+
+	offsets []int64
+	sizes   []int64
+	// members are where the compressed member of every entry starts, with
+	// seekable entries
+	members  []int64
+	seekable bool
+	ratios   bool
+	// entryStart is where the current entry starts in the compressed stream
+	entryStart int64
+}
 
-			errc <- fmt.Errorf("failed to parse time of %q: %v", k.LastModified, err)
-			return
-		}
-		relPath, err := filepath.Rel(base, k.Key)
-		if err != nil {
-			errc <- fmt.Errorf("failed to find relative path for %q: %v", k.Key, err)
-			return
+func newArchiveStream(w io.Writer, container func(io.Writer) ArchiveWriter, c Compressor, entryRatios, seekable bool, progress *JobProgress) (*archiveStream, error) {
+	s := &archiveStream{compressed: &countWriter{w: w}, c: c, seekable: seekable, ratios: entryRatios, progress: progress}
+	s.cw = c.NewWriter(s.compressed)
+	if entryRatios && !seekable {
+		fw, ok := s.cw.(flusher)
+		if !ok {
+			return nil, errors.New("compressor can't flush, so entries can't be told apart")
 		}
+		s.flush = fw
+	}
+	s.plain = &countWriter{w: s.cw}
+	s.aw = container(s.plain)
+	return s, nil
+}
 
-		start := time.Now()
-		data, err := bkt.Get(k.Key)
-		if err != nil {
-			errc <- fmt.Errorf("failed fetch of %q: %v", k.Key, err)
-			return
+// add writes an object into the archive.
+func (s *archiveStream) add(ctx context.Context, object *S3Content) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(s.offsets) > 0 {
+		if err := s.endEntry(); err != nil {
+			return err
 		}
+	}
+	if s.seekable {
+		s.members = append(s.members, s.compressed.n)
+	}
+	s.offsets = append(s.offsets, s.plain.n)
+	var r io.Reader = bytes.NewReader(object.Data.Bytes())
+	if object.r != nil {
+		r = object.r
+	}
+	if err := s.aw.WriteEntry(object.Entry(), &ctxReader{ctx: ctx, r: r}); err != nil {
+		return fmt.Errorf("writing %q, %v", object.Name, err)
+	}
+	size := object.Size
+	s.progress.update(func(s *ProgressSnapshot) {
+		s.KeysWritten++
+		s.BytesWritten += size
+	})
+	return nil
+}
 
-		infof("%s\t(%v) %q from %q ", prfx, time.Since(start), relPath, k.Key)
-		contentC <- S3Content{
-			Name:    relPath,
-			Data:    *bytes.NewBuffer(data),
-			LastMod: lastMod,
+// endEntry ends what's compressed of the last entry: its member is closed
+// and another started with seekable entries, or else it's flushed with
+// entry ratios.
+func (s *archiveStream) endEntry() error {
+	switch {
+	case s.seekable:
+		if err := s.cw.Close(); err != nil {
+			return fmt.Errorf("closing compressor, %v", err)
+		}
+		s.cw = s.c.NewWriter(s.compressed)
+		s.plain.w = s.cw
+	case s.flush != nil:
+		if err := s.flush.Flush(); err != nil {
+			return fmt.Errorf("flushing compressor, %v", err)
 		}
 	}
-
-	errc := make(chan error, len(keys))
-	infof("%sfetching ... ", prfx)
-	wg := sync.WaitGroup{}
-	for _, key := range keys {
-		wg.Add(1)
-		go doFetch(&wg, key, errc)
+	if s.ratios {
+		s.sizes = append(s.sizes, s.compressed.n-s.entryStart)
+		s.entryStart = s.compressed.n
 	}
-	wg.Wait()
-	close(contentC)
-	close(errc)
+	return nil
+}
 
-	contents := make([]S3Content, 0, len(contentC))
-	for content := range contentC {
-		contents = append(contents, content)
+// Close ends the archive.
+func (s *archiveStream) Close() error {
+	if err := s.aw.Close(); err != nil {
+		return fmt.Errorf("closing archive, %v", err)
 	}
-
-	var errs []string
-	for err := range errc {
-		errs = append(errs, err.Error())
+	if err := s.cw.Close(); err != nil {
+		return fmt.Errorf("closing compressor, %v", err)
 	}
-	if len(errs) != 0 {
-		return nil, fmt.Errorf("%d errors: %s", len(errs), strings.Join(errs, ","))
+	if s.ratios && len(s.offsets) > 0 {
+		s.sizes = append(s.sizes, s.compressed.n-s.entryStart)
 	}
+	return nil
+}
 
-	return contents, nil
+// abandon ends the compressed stream of an archive that failed, so that
+// the entries written whole so far can be read back.
+func (s *archiveStream) abandon() {
+	_ = s.cw.Close()
+}
+
+// ctxReader fails to read once its context is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
 
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
 }
 
-func tarify(w io.Writer, objects []S3Content) error {
-	tarw := tar.NewWriter(w)
-	infof("taring...")
-	for _, object := range objects {
-		if err := tarw.WriteHeader(object.TarHeader()); err != nil {
-			return fmt.Errorf("writing header of %q, %v", object.Name, err)
+// catalogArchivePath is the path the catalog knows archive by: archives are
+// looked up later from anywhere, so local ones are known by where they
+// really are.
+func catalogArchivePath(archive string) string {
+	if strings.Contains(archive, "://") {
+		return archive
+	}
+	if abs, err := filepath.Abs(archive); err == nil {
+		return abs
+	}
+	return archive
+}
+
+// catalogArchive records the objects of the archive in the catalog at path.
+// An archive split in volumes of splitSize bytes is recorded by its path
+// all the same, each entry with the volume it starts in.
+func catalogArchive(path string, src *url.URL, archive string, volumes int, splitSize int64, objects []S3Content, stream *archiveStream) error {
+	catalog, err := OpenCatalog(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = catalog.Close() }()
+
+	archive = catalogArchivePath(archive)
+
+	entries := make([]CatalogEntry, len(objects))
+	for i, object := range objects {
+		entries[i] = CatalogEntry{
+			Source:  objectSource(src, object.Key),
+			Key:     object.Key,
+			ETag:    object.ETag,
+			Size:    object.Size,
+			LastMod: object.LastMod,
+			// listings don't say the version, the fetch does
+			VersionID: object.Meta.VersionID,
+			Archive:   archive,
+			Volume:    1,
+			Member:    object.Name,
+			Offset:    stream.offsets[i],
 		}
-		if _, err := tarw.Write(object.Data.Bytes()); err != nil {
-			return fmt.Errorf("writing content of %q, %v", object.Name, err)
+		if stream.members != nil {
+			entries[i].Compressed = stream.members[i]
+			if splitSize > 0 {
+				entries[i].Volume += int(stream.members[i] / splitSize)
+			}
 		}
 	}
-	if err := tarw.Close(); err != nil {
-		return fmt.Errorf("closing tar buffer, %v", err)
-	}
-	return nil
+	return catalog.Record(ArchiveRecord{
+		Path:    archive,
+		Source:  src.String(),
+		Created: time.Now(),
+		Objects: len(objects),
+		Volumes: volumes,
+	}, entries)
 }
 
+// PAX records keeping track of where an entry comes from.
+const (
+	PAXKey  = "TARING.key"
+	PAXETag = "TARING.etag"
+	// PAXUserMeta is the prefix of the PAX records keeping the user
+	// metadata of an object, as extended attributes GNU tar restores.
+	PAXUserMeta = "SCHILY.xattr.user.s3."
+	// PAXContentType and PAXContentEncoding keep the content type and
+	// encoding of an object, the type as the extended attribute
+	// freedesktop.org names it in.
+	PAXContentType     = "SCHILY.xattr.user.mime_type"
+	PAXContentEncoding = "SCHILY.xattr.user.content_encoding"
+)
+
+// S3Content is an object fetched from S3, named as it goes in the archive.
 type S3Content struct {
+	Key     string
+	ETag    string
 	Name    string
 	LastMod time.Time
-	Data    bytes.Buffer
+	// Size is the size of the object, which is still known once its data
+	// is let go.
+	Size int64
+	Data bytes.Buffer
+	Meta ObjectMeta
+	// r is read rather than Data, Size bytes of it, for objects streamed
+	// from their store as they're archived.
+	r io.Reader
 }
 
-func (s *S3Content) TarHeader() *tar.Header {
+// paxObjectMeta is the metadata of an object kept in the PAX records of its
+// entry.
+func paxObjectMeta(records map[string]string) ObjectMeta {
+	meta := ObjectMeta{
+		ContentType:     records[PAXContentType],
+		ContentEncoding: records[PAXContentEncoding],
+	}
+	for record, value := range records {
+		if strings.HasPrefix(record, PAXUserMeta) {
+			if meta.User == nil {
+				meta.User = make(map[string]string)
+			}
+			meta.User[strings.TrimPrefix(record, PAXUserMeta)] = value
+		}
+	}
+	return meta
+}
 
-	return &tar.Header{
-		Name:       s.Name,
-		Size:       int64(s.Data.Len()),
-		Mode:       int64(filePerms),
-		AccessTime: time.Now(),
-		ChangeTime: s.LastMod,
-		ModTime:    s.LastMod,
-		Typeflag:   tar.TypeReg,
-		Uid:        os.Getuid(),
-		Gid:        os.Getgid(),
+// Entry describes the object as an entry of an archive.
+func (s *S3Content) Entry() EntryHeader {
+	metadata := map[string]string{
+		PAXKey:  s.Key,
+		PAXETag: s.ETag,
+	}
+	for name, value := range s.Meta.User {
+		metadata[PAXUserMeta+name] = value
+	}
+	if s.Meta.ContentType != "" {
+		metadata[PAXContentType] = s.Meta.ContentType
+	}
+	if s.Meta.ContentEncoding != "" {
+		metadata[PAXContentEncoding] = s.Meta.ContentEncoding
+	}
+	return EntryHeader{
+		Name:     s.Name,
+		Size:     s.Size,
+		Mode:     filePerms,
+		ModTime:  s.LastMod,
+		Metadata: metadata,
 	}
 }
+
+// TarHeader describes the object as an entry of a tar stream.
+func (s *S3Content) TarHeader() *tar.Header { return tarHeader(s.Entry()) }
@@ -0,0 +1,123 @@
+package taringtest
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/crowdmob/goamz/s3"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// pluginChunk is how much of an object a data answer carries at most, so
+// that most objects take several.
+const pluginChunk = 4
+
+type pluginRequest struct {
+	ID     int64  `json:"id"`
+	Op     string `json:"op"`
+	Prefix string `json:"prefix,omitempty"`
+	Marker string `json:"marker,omitempty"`
+	Key    string `json:"key,omitempty"`
+}
+
+type pluginObject struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+type pluginPage struct {
+	Objects []pluginObject `json:"objects"`
+	Folders []string       `json:"folders"`
+	Next    string         `json:"next"`
+}
+
+type pluginError struct {
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message"`
+}
+
+type pluginResponse struct {
+	ID     int64         `json:"id"`
+	Error  *pluginError  `json:"error,omitempty"`
+	Page   *pluginPage   `json:"page,omitempty"`
+	Object *pluginObject `json:"object,omitempty"`
+	Data   []byte        `json:"data,omitempty"`
+	EOF    bool          `json:"eof,omitempty"`
+}
+
+// ServePlugin serves the store the way a plugin does, answering the
+// requests read from r on w until r is done. Run it from a command that
+// taring.NewPluginStore starts, such as the test binary itself.
+func ServePlugin(s *Store, r io.Reader, w io.Writer) error {
+	ctx := context.Background()
+	dec := json.NewDecoder(bufio.NewReader(r))
+	enc := json.NewEncoder(w)
+	for {
+		var req pluginRequest
+		if err := dec.Decode(&req); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		for _, resp := range s.answer(ctx, req) {
+			resp.ID = req.ID
+			if err := enc.Encode(resp); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// answer is what the store answers a plugin request with.
+func (s *Store) answer(ctx context.Context, req pluginRequest) []pluginResponse {
+	switch req.Op {
+	case "list":
+		page, err := s.ListPage(ctx, req.Prefix, req.Marker)
+		if err != nil {
+			return []pluginResponse{{Error: toPluginError(err)}}
+		}
+		resp := &pluginPage{Folders: page.Folders, Next: page.Next}
+		for _, obj := range page.Objects {
+			resp.Objects = append(resp.Objects, pluginObject{Key: obj.Key, Size: obj.Size, ETag: obj.ETag, LastModified: obj.LastModified})
+		}
+		return []pluginResponse{{Page: resp}}
+	case "stat":
+		obj, err := s.Stat(ctx, req.Key)
+		if err != nil {
+			return []pluginResponse{{Error: toPluginError(err)}}
+		}
+		return []pluginResponse{{Object: &pluginObject{Key: obj.Key, Size: obj.Size, ETag: obj.ETag, LastModified: obj.LastModified}}}
+	case "open":
+		rc, err := s.Open(ctx, req.Key)
+		if err != nil {
+			return []pluginResponse{{Error: toPluginError(err)}}
+		}
+		data, err := ioutil.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil {
+			return []pluginResponse{{Error: toPluginError(err)}}
+		}
+		var resps []pluginResponse
+		for len(data) > pluginChunk {
+			resps = append(resps, pluginResponse{Data: data[:pluginChunk]})
+			data = data[pluginChunk:]
+		}
+		return append(resps, pluginResponse{Data: data, EOF: true})
+	}
+	return []pluginResponse{{Error: &pluginError{Message: "unknown op " + req.Op}}}
+}
+
+// toPluginError answers an error of the store, with the failure class of
+// what's missing.
+func toPluginError(err error) *pluginError {
+	var s3err *s3.Error
+	if errors.As(err, &s3err) && s3err.StatusCode == 404 {
+		return &pluginError{Code: "not_found", Message: s3err.Message}
+	}
+	return &pluginError{Message: err.Error()}
+}
@@ -0,0 +1,275 @@
+package taringtest
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"github.com/aybabtme/taring"
+	"github.com/crowdmob/goamz/s3"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Handler serves the store as the bucket of an S3 server addressed as
+// endpoint/bucket, for what lists, gets and heads objects with requests of
+// its own: ListObjects, GetObject and HeadObject, and for what puts
+// objects, whole or in multipart uploads, and deletes them, without
+// checking signatures. Give taring.CustomS3Endpoint the
+// URL of a server running it.
+func S3Handler(bucket string, s *Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		path := strings.TrimPrefix(req.URL.Path, "/")
+		if path != bucket && !strings.HasPrefix(path, bucket+"/") {
+			writeS3Error(w, &s3.Error{StatusCode: http.StatusNotFound, Code: "NoSuchBucket", Message: "The specified bucket does not exist."})
+			return
+		}
+		key := strings.TrimPrefix(strings.TrimPrefix(path, bucket), "/")
+		q := req.URL.Query()
+		_, initUpload := q["uploads"]
+		switch {
+		case key != "" && req.Method == http.MethodPost && initUpload:
+			s.serveInitUpload(w, key)
+		case key != "" && q.Get("uploadId") != "":
+			s.serveUpload(w, req, key, q.Get("uploadId"))
+		case key != "" && req.Method == http.MethodPut:
+			s.servePut(w, req, key)
+		case key != "" && req.Method == http.MethodDelete:
+			s.Delete(key)
+			w.WriteHeader(http.StatusNoContent)
+		case key == "" && req.Method == http.MethodGet:
+			s.serveList(w, req)
+		case key != "" && (req.Method == http.MethodGet || req.Method == http.MethodHead):
+			s.serveObject(w, req, key)
+		default:
+			writeS3Error(w, &s3.Error{StatusCode: http.StatusNotImplemented, Code: "NotImplemented", Message: "taringtest doesn't do that."})
+		}
+	})
+}
+
+func (s *Store) serveList(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	if q.Get("delimiter") != "/" {
+		writeS3Error(w, &s3.Error{StatusCode: http.StatusNotImplemented, Code: "NotImplemented", Message: "taringtest only lists with a `/` delimiter."})
+		return
+	}
+	page, err := s.ListPage(req.Context(), q.Get("prefix"), q.Get("marker"))
+	if err != nil {
+		writeS3Error(w, err)
+		return
+	}
+	type content struct {
+		Key          string
+		LastModified string
+		Size         int64
+		ETag         string
+	}
+	result := struct {
+		XMLName        xml.Name `xml:"ListBucketResult"`
+		Prefix         string
+		Marker         string
+		NextMarker     string `xml:",omitempty"`
+		IsTruncated    bool
+		Contents       []content
+		CommonPrefixes []string `xml:"CommonPrefixes>Prefix"`
+	}{
+		Prefix:         q.Get("prefix"),
+		Marker:         q.Get("marker"),
+		NextMarker:     page.Next,
+		IsTruncated:    page.Next != "",
+		CommonPrefixes: page.Folders,
+	}
+	for _, obj := range page.Objects {
+		result.Contents = append(result.Contents, content{
+			Key:          obj.Key,
+			LastModified: obj.LastModified.UTC().Format(time.RFC3339Nano),
+			Size:         obj.Size,
+			ETag:         obj.ETag,
+		})
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+func (s *Store) serveObject(w http.ResponseWriter, req *http.Request, key string) {
+	var (
+		rc  io.ReadCloser
+		err error
+	)
+	if req.Method == http.MethodGet {
+		rc, err = s.Open(req.Context(), key)
+	} else {
+		_, err = s.Stat(req.Context(), key)
+	}
+	if err != nil {
+		writeS3Error(w, err)
+		return
+	}
+	info, _ := s.Stat(req.Context(), key)
+	h := w.Header()
+	s.mu.Lock()
+	if enc := s.objects[key].encryption; enc[0] != "" {
+		h.Set(enc[0], enc[1])
+	}
+	s.mu.Unlock()
+	h.Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	h.Set("ETag", info.ETag)
+	h.Set("Last-Modified", info.LastModified.UTC().Format(http.TimeFormat))
+	if rc == nil {
+		return
+	}
+	defer rc.Close()
+	if mr, ok := rc.(taring.MetaReader); ok {
+		meta := mr.Meta()
+		if meta.ContentType != "" {
+			h.Set("Content-Type", meta.ContentType)
+		}
+		if meta.ContentEncoding != "" {
+			h.Set("Content-Encoding", meta.ContentEncoding)
+		}
+		for name, value := range meta.User {
+			h.Set("X-Amz-Meta-"+name, value)
+		}
+		if meta.VersionID != "" {
+			h.Set("X-Amz-Version-Id", meta.VersionID)
+		}
+	}
+	_, _ = io.Copy(w, rc)
+}
+
+func (s *Store) servePut(w http.ResponseWriter, req *http.Request, key string) {
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		writeS3Error(w, err)
+		return
+	}
+	s.Put(key, data, time.Now())
+	info, _ := s.Stat(req.Context(), key)
+	w.Header().Set("ETag", info.ETag)
+}
+
+// Upload is a multipart upload made to the store through S3Handler.
+type Upload struct {
+	Key string
+	// Parts are the sizes of the parts uploaded, in order.
+	Parts              []int
+	Completed, Aborted bool
+}
+
+type upload struct {
+	key                string
+	parts              map[int][]byte
+	completed, aborted bool
+}
+
+// Uploads are the multipart uploads started, in order.
+func (s *Store) Uploads() []Upload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uploads := make([]Upload, len(s.uploads))
+	for i, u := range s.uploads {
+		uploads[i] = Upload{Key: u.key, Completed: u.completed, Aborted: u.aborted}
+		for _, n := range partNumbers(u.parts) {
+			uploads[i].Parts = append(uploads[i].Parts, len(u.parts[n]))
+		}
+	}
+	return uploads
+}
+
+func partNumbers(parts map[int][]byte) []int {
+	numbers := make([]int, 0, len(parts))
+	for n := range parts {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	return numbers
+}
+
+func (s *Store) serveInitUpload(w http.ResponseWriter, key string) {
+	s.mu.Lock()
+	s.uploads = append(s.uploads, &upload{key: key, parts: make(map[int][]byte)})
+	id := strconv.Itoa(len(s.uploads))
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Key      string
+		UploadId string
+	}{Key: key, UploadId: id})
+}
+
+// serveUpload uploads a part, completes or aborts the upload of id.
+func (s *Store) serveUpload(w http.ResponseWriter, req *http.Request, key, id string) {
+	s.mu.Lock()
+	var u *upload
+	if i, err := strconv.Atoi(id); err == nil && i > 0 && i <= len(s.uploads) && s.uploads[i-1].key == key {
+		u = s.uploads[i-1]
+	}
+	gone := u == nil || u.completed || u.aborted
+	s.mu.Unlock()
+	if gone {
+		writeS3Error(w, &s3.Error{StatusCode: http.StatusNotFound, Code: "NoSuchUpload", Message: "The specified upload does not exist."})
+		return
+	}
+
+	switch req.Method {
+	case http.MethodPut:
+		n, err := strconv.Atoi(req.URL.Query().Get("partNumber"))
+		if err != nil || n < 1 {
+			writeS3Error(w, &s3.Error{StatusCode: http.StatusBadRequest, Code: "InvalidArgument", Message: "Part number must be a positive integer."})
+			return
+		}
+		data, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			writeS3Error(w, err)
+			return
+		}
+		sum := md5.Sum(data)
+		s.mu.Lock()
+		u.parts[n] = data
+		s.mu.Unlock()
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	case http.MethodPost:
+		var data []byte
+		s.mu.Lock()
+		for _, n := range partNumbers(u.parts) {
+			data = append(data, u.parts[n]...)
+		}
+		u.completed = true
+		s.mu.Unlock()
+		s.Put(key, data, time.Now())
+		w.Header().Set("Content-Type", "application/xml")
+		_ = xml.NewEncoder(w).Encode(struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+			Key     string
+		}{Key: key})
+	case http.MethodDelete:
+		s.mu.Lock()
+		u.aborted = true
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeS3Error(w, &s3.Error{StatusCode: http.StatusNotImplemented, Code: "NotImplemented", Message: "taringtest doesn't do that."})
+	}
+}
+
+func writeS3Error(w http.ResponseWriter, err error) {
+	var s3err *s3.Error
+	if !errors.As(err, &s3err) {
+		s3err = &s3.Error{StatusCode: http.StatusInternalServerError, Code: "InternalError", Message: err.Error()}
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("X-Amz-Request-Id", s3err.RequestId)
+	w.WriteHeader(s3err.StatusCode)
+	_ = xml.NewEncoder(w).Encode(struct {
+		XMLName   xml.Name `xml:"Error"`
+		Code      string
+		Message   string
+		RequestId string
+	}{Code: s3err.Code, Message: s3err.Message, RequestId: s3err.RequestId})
+}
@@ -0,0 +1,255 @@
+// Package taringtest provides a store kept in memory, to exercise taring
+// without S3.
+package taringtest
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"github.com/aybabtme/taring"
+	"github.com/crowdmob/goamz/s3"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store is a bucket kept in memory. Its zero value is an empty bucket that
+// lists everything in one page. It's safe for concurrent use.
+type Store struct {
+	// PageSize is how many objects and folders a page lists at most. Zero
+	// lists them all at once.
+	PageSize int
+
+	mu      sync.Mutex
+	objects map[string]object
+	fails   map[string][]error
+	lists   int
+	opens   map[string]int
+	uploads []*upload
+}
+
+type object struct {
+	data    []byte
+	etag    string
+	lastMod time.Time
+	meta    map[string]string
+
+	contentType, contentEncoding string
+	version                      string
+	// encryption is the header S3Handler says how it's encrypted with
+	encryption [2]string
+}
+
+// Put stores an object, with an ETag made the way S3 makes it for a
+// simple upload.
+func (s *Store) Put(key string, data []byte, lastMod time.Time) {
+	sum := md5.Sum(data)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.objects == nil {
+		s.objects = make(map[string]object)
+	}
+	s.objects[key] = object{
+		data:    append([]byte(nil), data...),
+		etag:    `"` + hex.EncodeToString(sum[:]) + `"`,
+		lastMod: lastMod.UTC(),
+	}
+}
+
+// Upload stores the object that's read from r, last modified now.
+func (s *Store) Upload(ctx context.Context, key string, r io.Reader, size int64, meta taring.ObjectMeta) error {
+	data, err := ioutil.ReadAll(io.LimitReader(r, size))
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) != size {
+		return io.ErrUnexpectedEOF
+	}
+	s.Put(key, data, time.Now())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj := s.objects[key]
+	obj.meta = make(map[string]string, len(meta.User))
+	for k, v := range meta.User {
+		obj.meta[k] = v
+	}
+	obj.contentType, obj.contentEncoding = meta.ContentType, meta.ContentEncoding
+	s.objects[key] = obj
+	return nil
+}
+
+// Encrypt makes S3Handler serve key as S3 does an object encrypted
+// server-side, with the header set to value, such as
+// `X-Amz-Server-Side-Encryption: aws:kms`. Like S3 does for objects
+// encrypted with KMS, its ETag isn't its MD5 anymore.
+func (s *Store) Encrypt(key, header, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj := s.objects[key]
+	sum := md5.Sum(append([]byte(value), obj.data...))
+	obj.etag = `"` + hex.EncodeToString(sum[:]) + `"`
+	obj.encryption = [2]string{header, value}
+	s.objects[key] = obj
+}
+
+// Version gives key the version id, which it's read with as S3 reads an
+// object of a versioned bucket.
+func (s *Store) Version(key, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj := s.objects[key]
+	obj.version = id
+	s.objects[key] = obj
+}
+
+// Delete removes an object, if it's there.
+func (s *Store) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+}
+
+// Meta is the user metadata of an object.
+func (s *Store) Meta(key string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.objects[key].meta
+}
+
+// FailOpen makes the next opens of key fail, one per error, in order.
+func (s *Store) FailOpen(key string, errs ...error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fails == nil {
+		s.fails = make(map[string][]error)
+	}
+	s.fails[key] = append(s.fails[key], errs...)
+}
+
+// Lists is how many pages were listed.
+func (s *Store) Lists() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lists
+}
+
+// Opens is how many times key was opened, successfully or not.
+func (s *Store) Opens(key string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.opens[key]
+}
+
+// ListPage lists what's directly under prefix, after marker, like S3 does
+// with a `/` delimiter.
+func (s *Store) ListPage(ctx context.Context, prefix, marker string) (taring.ListPage, error) {
+	if err := ctx.Err(); err != nil {
+		return taring.ListPage{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lists++
+
+	// objects and folders come in one sorted list, as they do from S3
+	seen := make(map[string]bool)
+	var names []string
+	for key := range s.objects {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		name := key
+		if i := strings.Index(key[len(prefix):], "/"); i >= 0 {
+			name = key[:len(prefix)+i+1]
+		}
+		if name > marker && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var page taring.ListPage
+	if s.PageSize > 0 && len(names) > s.PageSize {
+		names = names[:s.PageSize]
+		page.Next = names[len(names)-1]
+	}
+	for _, name := range names {
+		if strings.HasSuffix(name, "/") && name != prefix {
+			page.Folders = append(page.Folders, name)
+			continue
+		}
+		obj := s.objects[name]
+		page.Objects = append(page.Objects, taring.ObjectInfo{
+			Key:          name,
+			Size:         int64(len(obj.data)),
+			ETag:         obj.etag,
+			LastModified: obj.lastMod,
+		})
+	}
+	return page, nil
+}
+
+// Open reads the content of an object, along with the metadata it was
+// uploaded with. A missing one fails like it does on S3.
+func (s *Store) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.opens == nil {
+		s.opens = make(map[string]int)
+	}
+	s.opens[key]++
+	if errs := s.fails[key]; len(errs) > 0 {
+		s.fails[key] = errs[1:]
+		return nil, errs[0]
+	}
+	obj, ok := s.objects[key]
+	if !ok {
+		return nil, errNotFound()
+	}
+	return &metaReader{
+		ReadCloser: ioutil.NopCloser(bytes.NewReader(obj.data)),
+		meta:       taring.ObjectMeta{ContentType: obj.contentType, ContentEncoding: obj.contentEncoding, User: obj.meta, VersionID: obj.version},
+	}, nil
+}
+
+type metaReader struct {
+	io.ReadCloser
+	meta taring.ObjectMeta
+}
+
+func (m *metaReader) Meta() taring.ObjectMeta { return m.meta }
+
+// Stat describes an object.
+func (s *Store) Stat(ctx context.Context, key string) (taring.ObjectInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return taring.ObjectInfo{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[key]
+	if !ok {
+		return taring.ObjectInfo{}, errNotFound()
+	}
+	return taring.ObjectInfo{
+		Key:          key,
+		Size:         int64(len(obj.data)),
+		ETag:         obj.etag,
+		LastModified: obj.lastMod,
+	}, nil
+}
+
+func errNotFound() error {
+	return &s3.Error{
+		StatusCode: 404,
+		Code:       "NoSuchKey",
+		Message:    "The specified key does not exist.",
+		RequestId:  "taringtest",
+	}
+}
@@ -0,0 +1,67 @@
+package taringtest
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStoreListPage(t *testing.T) {
+	store := &Store{PageSize: 2}
+	for _, key := range []string{"a/1", "a/b/2", "a/c/3", "a/4", "z"} {
+		store.Put(key, []byte(key), time.Time{})
+	}
+
+	var (
+		objects []string
+		folders []string
+		marker  string
+	)
+	for {
+		page, err := store.ListPage(context.Background(), "a/", marker)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, obj := range page.Objects {
+			objects = append(objects, obj.Key)
+		}
+		folders = append(folders, page.Folders...)
+		if page.Next == "" {
+			break
+		}
+		marker = page.Next
+	}
+
+	if want := []string{"a/1", "a/4"}; !reflect.DeepEqual(objects, want) {
+		t.Errorf("want objects %q, got %q", want, objects)
+	}
+	if want := []string{"a/b/", "a/c/"}; !reflect.DeepEqual(folders, want) {
+		t.Errorf("want folders %q, got %q", want, folders)
+	}
+	if want, got := 2, store.Lists(); want != got {
+		t.Errorf("want %d pages listed, got %d", want, got)
+	}
+}
+
+func TestStoreFailOpen(t *testing.T) {
+	store := new(Store)
+	store.Put("key", []byte("data"), time.Time{})
+	store.FailOpen("key", context.DeadlineExceeded)
+
+	ctx := context.Background()
+	if _, err := store.Open(ctx, "key"); err != context.DeadlineExceeded {
+		t.Fatalf("want the queued error, got %v", err)
+	}
+	rc, err := store.Open(ctx, "key")
+	if err != nil {
+		t.Fatalf("want the object once the errors are out, got %v", err)
+	}
+	_ = rc.Close()
+	if _, err := store.Open(ctx, "missing"); err == nil {
+		t.Fatal("want missing objects to fail")
+	}
+	if want, got := 2, store.Opens("key"); want != got {
+		t.Errorf("want %d opens, got %d", want, got)
+	}
+}
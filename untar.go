@@ -0,0 +1,63 @@
+package taring
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// MetaModTime is the user metadata an untarred object keeps its
+// modification time in, as seconds since the epoch the way rclone does.
+const MetaModTime = "mtime"
+
+// Uploader writes objects to a store.
+type Uploader interface {
+	// Upload writes the size bytes of r to key, along with its metadata.
+	Upload(ctx context.Context, key string, r io.Reader, size int64, meta ObjectMeta) error
+}
+
+// Untar writes every file of a compressed tar archive to dst, as the object
+// named as the entry under prefix, which keeps the entry's modification
+// time in MetaModTime, and the content type, encoding and user metadata it
+// was archived with. It returns the objects written, in the order of the
+// archive.
+func Untar(ctx context.Context, archive io.Reader, dst Uploader, prefix string) ([]ObjectInfo, error) {
+	dr, err := decompress(archive)
+	if err != nil {
+		return nil, fmt.Errorf("decompressing archive, %v", err)
+	}
+	defer dr.Close()
+
+	var written []ObjectInfo
+	tr := tar.NewReader(dr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, fmt.Errorf("reading archive, %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		name := path.Clean(hdr.Name)
+		if path.IsAbs(hdr.Name) || name == ".." || strings.HasPrefix(name, "../") {
+			return written, fmt.Errorf("entry %q isn't under the prefix", hdr.Name)
+		}
+		key := prefix + name
+		meta := paxObjectMeta(hdr.PAXRecords)
+		if meta.User == nil {
+			meta.User = make(map[string]string)
+		}
+		meta.User[MetaModTime] = strconv.FormatFloat(float64(hdr.ModTime.UnixNano())/1e9, 'f', -1, 64)
+		if err := dst.Upload(ctx, key, tr, hdr.Size, meta); err != nil {
+			return written, &KeyError{Key: key, Op: OpUpload, Err: err}
+		}
+		written = append(written, ObjectInfo{Key: key, Size: hdr.Size, LastModified: hdr.ModTime})
+	}
+}
@@ -0,0 +1,128 @@
+package taring
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"time"
+)
+
+// Verification is how an archive compares to what's at its source now.
+type Verification struct {
+	// Objects is how many objects the source has, once filtered.
+	Objects int `json:"objects"`
+	// Entries is how many files the archive has.
+	Entries int `json:"entries"`
+	// Missing are the keys of the source the archive doesn't have.
+	Missing []string `json:"missing,omitempty"`
+	// Extra are the keys the archive has that the source doesn't.
+	Extra []string `json:"extra,omitempty"`
+	// Mismatched are the objects the archive has other content of.
+	Mismatched []Mismatch `json:"mismatched,omitempty"`
+}
+
+// Mismatch is an object the archive has other content of than the source.
+type Mismatch struct {
+	Key    string `json:"key"`
+	Reason string `json:"reason"`
+}
+
+// OK tells if the archive has every object of the source and nothing
+// else, with the same content.
+func (v *Verification) OK() bool {
+	return len(v.Missing)+len(v.Extra)+len(v.Mismatched) == 0
+}
+
+// Verify lists the source again, with the filters of the archiver, and
+// reads the compressed tar archive to check it has every object with the
+// same size and, when its ETag is an MD5, the same checksum. Objects
+// uploaded in several parts are only checked by size, and so are those the
+// store says are encrypted with KMS or a customer key, whose ETags look
+// like MD5s but aren't: as the listing doesn't say, an object whose
+// checksum differs is asked about before it's deemed mismatched. Nothing
+// is fetched, and nothing about the run is kept.
+func (a *Archiver) Verify(ctx context.Context, archive io.Reader) (*Verification, error) {
+	r, err := a.newRun(ctx, &RunSummary{Job: a.job, Source: a.source, Started: time.Now()}, "")
+	if err != nil {
+		return nil, err
+	}
+	a.log.Infof("Listing bucket %q.", r.bktName)
+	keys, err := r.list(ctx, r.store, r.bktPath)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list %q: %v", r.bktPath, err)
+	}
+	if len(r.filters)+len(r.include)+len(r.exclude) > 0 {
+		keys = r.filter(r.bktPath, keys)
+	}
+	source := make(map[string]ObjectInfo, len(keys))
+	for _, key := range keys {
+		source[key.Key] = key
+	}
+
+	dr, err := decompress(archive)
+	if err != nil {
+		return nil, fmt.Errorf("decompressing archive, %v", err)
+	}
+	defer dr.Close()
+
+	v := &Verification{Objects: len(keys)}
+	seen := make(map[string]bool, len(keys))
+	tr := tar.NewReader(dr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading archive, %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		v.Entries++
+		key, ok := hdr.PAXRecords[PAXKey]
+		if !ok {
+			key = path.Join(r.bktPath, hdr.Name)
+		}
+		sum := md5.New()
+		n, err := io.Copy(sum, tr)
+		if err != nil {
+			return nil, fmt.Errorf("reading %q from archive, %v", hdr.Name, err)
+		}
+		obj, ok := source[key]
+		if !ok {
+			v.Extra = append(v.Extra, key)
+			continue
+		}
+		seen[key] = true
+		if n != obj.Size {
+			v.Mismatched = append(v.Mismatched, Mismatch{Key: key, Reason: fmt.Sprintf("%d bytes archived, %d in the source", n, obj.Size)})
+		} else if want, ok := etagMD5(obj.ETag); ok {
+			if got := hex.EncodeToString(sum.Sum(nil)); got != want {
+				encrypted, err := storeEncrypted(ctx, r.store, key)
+				if err != nil {
+					return nil, fmt.Errorf("couldn't describe %q: %v", key, err)
+				}
+				if !encrypted {
+					v.Mismatched = append(v.Mismatched, Mismatch{Key: key, Reason: fmt.Sprintf("MD5 %s archived, %s in the source", got, want)})
+				}
+			}
+		}
+	}
+	for _, key := range keys {
+		if !seen[key.Key] {
+			v.Missing = append(v.Missing, key.Key)
+		}
+	}
+	sort.Strings(v.Missing)
+	sort.Strings(v.Extra)
+	return v, nil
+}
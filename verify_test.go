@@ -0,0 +1,93 @@
+package taring_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/aybabtme/taring"
+	"github.com/aybabtme/taring/taringtest"
+	"github.com/crowdmob/goamz/aws"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	a := taring.New("s3://bucket/data/", "", taring.WithStore(newStore()))
+	if _, err := a.RunTo(context.Background(), buf); err != nil {
+		t.Fatal(err)
+	}
+	archived := buf.Bytes()
+
+	v, err := a.Verify(context.Background(), bytes.NewReader(archived))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.OK() || v.Entries != len(fixture)-1 {
+		t.Errorf("want the archive to match its source, got %+v", v)
+	}
+
+	// since archived, one object was removed, one added and one rewritten
+	// with as many bytes
+	changed := &taringtest.Store{}
+	for key, data := range fixture {
+		switch key {
+		case "data/readme.txt":
+			continue
+		case "data/logs/index":
+			data = "2016/01\n2016/03\n"
+		}
+		changed.Put(key, []byte(data), time.Now())
+	}
+	changed.Put("data/new.txt", []byte("new"), time.Now())
+	v, err = taring.New("s3://bucket/data/", "", taring.WithStore(changed)).Verify(context.Background(), bytes.NewReader(archived))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"data/new.txt"}; !reflect.DeepEqual(v.Missing, want) {
+		t.Errorf("want missing %q, got %q", want, v.Missing)
+	}
+	if want := []string{"data/readme.txt"}; !reflect.DeepEqual(v.Extra, want) {
+		t.Errorf("want extra %q, got %q", want, v.Extra)
+	}
+	if len(v.Mismatched) != 1 || v.Mismatched[0].Key != "data/logs/index" {
+		t.Errorf("want data/logs/index mismatched, got %+v", v.Mismatched)
+	}
+}
+
+func TestVerifyEncrypted(t *testing.T) {
+	store := newStore()
+	buf := bytes.NewBuffer(nil)
+	if _, err := taring.New("s3://bucket/data/", "", taring.WithStore(store)).RunTo(context.Background(), buf); err != nil {
+		t.Fatal(err)
+	}
+	archived := buf.Bytes()
+
+	// encrypted with KMS, the ETag of the same content isn't its MD5
+	// anymore, and only a HEAD of the object tells
+	store.Encrypt("data/readme.txt", "X-Amz-Server-Side-Encryption", "aws:kms")
+	srv := httptest.NewServer(taringtest.S3Handler("bucket", store))
+	defer srv.Close()
+	a := taring.New("s3://bucket/data/", "",
+		taring.WithAuth(aws.Auth{AccessKey: "access", SecretKey: "secret"}, taring.CustomS3Endpoint(aws.USEast, srv.URL, true)),
+	)
+	v, err := a.Verify(context.Background(), bytes.NewReader(archived))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.OK() {
+		t.Errorf("want the encrypted object checked by size only, got %+v", v)
+	}
+
+	// encrypted with S3's own keys, the ETag is still an MD5, and one
+	// that differs is a mismatch
+	store.Encrypt("data/readme.txt", "X-Amz-Server-Side-Encryption", "AES256")
+	v, err = a.Verify(context.Background(), bytes.NewReader(archived))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Mismatched) != 1 || v.Mismatched[0].Key != "data/readme.txt" {
+		t.Errorf("want data/readme.txt mismatched, got %+v", v.Mismatched)
+	}
+}